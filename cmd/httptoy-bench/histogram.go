@@ -0,0 +1,157 @@
+package main
+
+import (
+	"math"
+	"math/bits"
+	"time"
+)
+
+// subBits 决定直方图的精度
+const subBits = 7
+
+const (
+	subCount    = 1 << subBits // 精确记录的值的个数
+	halfCount   = subCount / 2 // 每段的桶数
+	bucketCount = subCount + (64-subBits)*halfCount
+)
+
+// histogram 是 HDR 风格的延迟直方图，以微秒为单位记录
+// 小于 2^subBits 的值精确记录，更大的值按 2 的幂分段，每段再等分为 2^(subBits-1) 个桶，
+// 因此任意值的相对误差不超过 1/2^(subBits-1)，E.g. subBits 为 7 时不超过 1.6%
+// 桶的数量固定，记录不会分配内存
+type histogram struct {
+	counts [bucketCount]int64
+	total  int64
+	min    int64
+	max    int64
+	sum    float64
+	sumSq  float64 // 用于计算标准差
+}
+
+func newHistogram() *histogram {
+	return &histogram{min: math.MaxInt64}
+}
+
+// bucketIndex 返回 v 所在的桶
+func bucketIndex(v int64) int {
+	if v < subCount {
+		return int(v)
+	}
+
+	// v 的最高 subBits 位决定段内的桶，E.g. v>>shift 落在 [halfCount, subCount)
+	shift := bits.Len64(uint64(v)) - subBits
+	return subCount + (shift-1)*halfCount + int(v>>shift) - halfCount
+}
+
+// bucketHigh 返回桶 i 能表示的最大值
+func bucketHigh(i int) int64 {
+	if i < subCount {
+		return int64(i)
+	}
+
+	shift := (i-subCount)/halfCount + 1
+	sub := int64((i-subCount)%halfCount + halfCount)
+	return (sub+1)<<shift - 1
+}
+
+// Record 记录一次延迟
+func (h *histogram) Record(d time.Duration) {
+	v := d.Microseconds()
+	if v < 0 {
+		v = 0
+	}
+
+	h.counts[bucketIndex(v)]++
+	h.total++
+	h.sum += float64(v)
+	h.sumSq += float64(v) * float64(v)
+	if v < h.min {
+		h.min = v
+	}
+	if v > h.max {
+		h.max = v
+	}
+}
+
+// Merge 将 o 的记录合并到 h
+func (h *histogram) Merge(o *histogram) {
+	for i, c := range o.counts {
+		h.counts[i] += c
+	}
+	h.total += o.total
+	h.sum += o.sum
+	h.sumSq += o.sumSq
+	if o.min < h.min {
+		h.min = o.min
+	}
+	if o.max > h.max {
+		h.max = o.max
+	}
+}
+
+// Count 返回记录的次数
+func (h *histogram) Count() int64 {
+	return h.total
+}
+
+// Min 返回最小延迟
+func (h *histogram) Min() time.Duration {
+	if h.total == 0 {
+		return 0
+	}
+	return time.Duration(h.min) * time.Microsecond
+}
+
+// Max 返回最大延迟
+func (h *histogram) Max() time.Duration {
+	return time.Duration(h.max) * time.Microsecond
+}
+
+// Mean 返回平均延迟
+func (h *histogram) Mean() time.Duration {
+	if h.total == 0 {
+		return 0
+	}
+	return time.Duration(h.sum/float64(h.total)) * time.Microsecond
+}
+
+// StdDev 返回延迟的标准差
+func (h *histogram) StdDev() time.Duration {
+	if h.total == 0 {
+		return 0
+	}
+
+	mean := h.sum / float64(h.total)
+	variance := h.sumSq/float64(h.total) - mean*mean
+	if variance < 0 {
+		variance = 0
+	}
+	return time.Duration(math.Sqrt(variance)) * time.Microsecond
+}
+
+// Percentile 返回第 q 百分位的延迟，E.g. q 为 99.9
+// 返回值为所在桶的上界，并且不会超过记录的最大值
+func (h *histogram) Percentile(q float64) time.Duration {
+	if h.total == 0 {
+		return 0
+	}
+
+	rank := int64(math.Ceil(q / 100 * float64(h.total)))
+	if rank < 1 {
+		rank = 1
+	}
+
+	var seen int64
+	for i, c := range h.counts {
+		seen += c
+		if seen >= rank {
+			v := bucketHigh(i)
+			if v > h.max {
+				v = h.max
+			}
+			return time.Duration(v) * time.Microsecond
+		}
+	}
+
+	return h.Max()
+}
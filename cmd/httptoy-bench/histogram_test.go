@@ -0,0 +1,60 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestBucketIndex(t *testing.T) {
+	// 每个值都落在上界不小于它的桶中，并且相对误差不超过 1/halfCount
+	prev := -1
+	for _, v := range []int64{0, 1, 127, 128, 129, 255, 256, 1000, 1 << 20, 1<<40 + 12345, 1<<62 + 1} {
+		i := bucketIndex(v)
+		if i < prev || i >= bucketCount {
+			t.Fatalf("bucketIndex(%d) = %d, previous %d", v, i, prev)
+		}
+		prev = i
+
+		high := bucketHigh(i)
+		if high < v || float64(high-v) > float64(v)/halfCount {
+			t.Errorf("bucketHigh(bucketIndex(%d)) = %d", v, high)
+		}
+		if i > 0 && bucketHigh(i-1) >= v {
+			t.Errorf("%d also fits bucket %d", v, i-1)
+		}
+	}
+}
+
+func TestHistogram(t *testing.T) {
+	h := newHistogram()
+	for i := 1; i <= 1000; i++ {
+		h.Record(time.Duration(i) * time.Microsecond)
+	}
+	o := newHistogram()
+	o.Record(time.Second)
+	h.Merge(o)
+
+	if h.Count() != 1001 || h.Min() != time.Microsecond || h.Max() != time.Second {
+		t.Fatalf("count %d, min %v, max %v", h.Count(), h.Min(), h.Max())
+	}
+
+	tests := []struct {
+		q    float64
+		want time.Duration
+	}{
+		{50, 501 * time.Microsecond},
+		{90, 901 * time.Microsecond},
+		{99.9, 1000 * time.Microsecond},
+		{100, time.Second},
+	}
+	for _, tt := range tests {
+		got := h.Percentile(tt.q)
+		if got < tt.want || got > tt.want+tt.want/halfCount {
+			t.Errorf("Percentile(%v) = %v, want about %v", tt.q, got, tt.want)
+		}
+	}
+
+	if empty := newHistogram(); empty.Percentile(99) != 0 || empty.Mean() != 0 || empty.Min() != 0 {
+		t.Errorf("empty histogram should report zero")
+	}
+}
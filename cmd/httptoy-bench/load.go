@@ -0,0 +1,242 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/tls"
+	"io"
+	"net"
+	"net/http"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// config 是一次压测的参数
+type config struct {
+	addr     string      // 连接的地址 host:port
+	tls      *tls.Config // 非空时使用 TLS
+	raw      []byte      // 一次请求的原始报文
+	method   string
+	conns    int
+	duration time.Duration
+	requests int64   // 请求总数，0 表示不限制
+	rate     float64 // 每秒请求数，0 表示收到响应之后立即发送下一批
+	pipeline int
+	timeout  time.Duration
+}
+
+// result 是一次压测的结果，由各个 worker 的结果合并
+type result struct {
+	hist        *histogram
+	statuses    map[int]int64
+	bytesRead   int64
+	connectErrs int64
+	ioErrs      int64
+	reconnects  int64
+	elapsed     time.Duration
+}
+
+func newResult() *result {
+	return &result{hist: newHistogram(), statuses: make(map[int]int64)}
+}
+
+func (r *result) merge(o *result) {
+	r.hist.Merge(o.hist)
+	for code, n := range o.statuses {
+		r.statuses[code] += n
+	}
+	r.bytesRead += o.bytesRead
+	r.connectErrs += o.connectErrs
+	r.ioErrs += o.ioErrs
+	r.reconnects += o.reconnects
+}
+
+// countingReader 统计从连接读取的字节数
+type countingReader struct {
+	r io.Reader
+	n *int64
+}
+
+func (cr countingReader) Read(p []byte) (int, error) {
+	n, err := cr.r.Read(p)
+	*cr.n += int64(n)
+	return n, err
+}
+
+// worker 在一个长连接上循环发送请求，连接断开时重新连接
+type worker struct {
+	cfg      *config
+	budget   *int64 // 剩余的请求数，cfg.requests 为 0 时不使用
+	deadline time.Time
+
+	conn  net.Conn
+	bufr  *bufio.Reader
+	batch []byte        // pipeline 个请求拼接成的报文
+	req   *http.Request // 用于 http.ReadResponse 判断响应有无报文主体，E.g. HEAD
+	res   *result
+
+	interval time.Duration // 固定速率时，每批请求之间的间隔
+	next     time.Time     // 固定速率时，下一批请求计划发送的时间
+}
+
+// run 按照 cfg 执行压测，所有 worker 结束后返回合并的结果
+func run(cfg *config) *result {
+	budget := cfg.requests
+	start := time.Now()
+	deadline := start.Add(cfg.duration)
+	if cfg.requests > 0 && cfg.duration <= 0 {
+		deadline = start.Add(24 * time.Hour)
+	}
+
+	workers := make([]*worker, cfg.conns)
+	var wg sync.WaitGroup
+	for i := range workers {
+		w := &worker{
+			cfg:      cfg,
+			budget:   &budget,
+			deadline: deadline,
+			batch:    bytes.Repeat(cfg.raw, cfg.pipeline),
+			req:      &http.Request{Method: cfg.method},
+			res:      newResult(),
+		}
+		if cfg.rate > 0 {
+			// 总速率平均分配到每个连接，各个连接的起始时间错开，避免同时发送
+			w.interval = time.Duration(float64(time.Second) * float64(cfg.conns*cfg.pipeline) / cfg.rate)
+			w.next = start.Add(w.interval * time.Duration(i) / time.Duration(cfg.conns))
+		}
+		workers[i] = w
+
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			w.run()
+		}()
+	}
+	wg.Wait()
+
+	res := newResult()
+	for _, w := range workers {
+		res.merge(w.res)
+	}
+	res.elapsed = time.Since(start)
+
+	return res
+}
+
+// claim 从剩余的请求数中申请至多 n 个，返回 0 表示请求已经发送完毕
+func (w *worker) claim(n int) int {
+	if w.cfg.requests <= 0 {
+		return n
+	}
+
+	for {
+		left := atomic.LoadInt64(w.budget)
+		if left <= 0 {
+			return 0
+		}
+		if int64(n) > left {
+			n = int(left)
+		}
+		if atomic.CompareAndSwapInt64(w.budget, left, left-int64(n)) {
+			return n
+		}
+	}
+}
+
+func (w *worker) run() {
+	defer w.close()
+
+	for time.Now().Before(w.deadline) {
+		// 固定速率时等待计划的发送时间，延迟从计划时间开始计算，
+		// 这样服务端变慢导致的排队也会体现在延迟中 (coordinated omission)
+		start := time.Now()
+		if w.interval > 0 {
+			if w.next.After(w.deadline) {
+				return
+			}
+			time.Sleep(time.Until(w.next))
+			start = w.next
+			w.next = w.next.Add(w.interval)
+		}
+
+		n := w.claim(w.cfg.pipeline)
+		if n == 0 {
+			return
+		}
+		if !w.roundTrip(n, start) && w.conn == nil {
+			// 连接失败时稍等再重试，避免空转
+			time.Sleep(10 * time.Millisecond)
+		}
+	}
+}
+
+// roundTrip 在连接上流水线发送 n 个请求并读取全部响应，返回是否全部成功
+func (w *worker) roundTrip(n int, start time.Time) bool {
+	if w.conn == nil && !w.dial() {
+		return false
+	}
+
+	w.conn.SetDeadline(time.Now().Add(w.cfg.timeout))
+	if _, err := w.conn.Write(w.batch[:n*len(w.cfg.raw)]); err != nil {
+		w.res.ioErrs++
+		w.close()
+		return false
+	}
+
+	for i := 0; i < n; i++ {
+		resp, err := http.ReadResponse(w.bufr, w.req)
+		if err == nil {
+			_, err = io.Copy(io.Discard, resp.Body)
+			resp.Body.Close()
+		}
+		if err != nil {
+			// 连接上剩余的请求不会再有响应
+			w.res.ioErrs += int64(n - i)
+			w.close()
+			return false
+		}
+
+		w.res.hist.Record(time.Since(start))
+		w.res.statuses[resp.StatusCode]++
+
+		// 服务端要求关闭连接时，之后的请求需要新的连接
+		if resp.Close {
+			w.res.ioErrs += int64(n - i - 1)
+			w.close()
+			return i == n-1
+		}
+	}
+
+	return true
+}
+
+func (w *worker) dial() bool {
+	conn, err := net.DialTimeout("tcp", w.cfg.addr, w.cfg.timeout)
+	if err == nil && w.cfg.tls != nil {
+		tc := tls.Client(conn, w.cfg.tls)
+		tc.SetDeadline(time.Now().Add(w.cfg.timeout))
+		if err = tc.Handshake(); err != nil {
+			conn.Close()
+		}
+		conn = tc
+	}
+	if err != nil {
+		w.res.connectErrs++
+		return false
+	}
+
+	if w.res.hist.Count() > 0 || w.res.ioErrs > 0 {
+		w.res.reconnects++
+	}
+	w.conn = conn
+	w.bufr = bufio.NewReaderSize(countingReader{conn, &w.res.bytesRead}, 16<<10)
+	return true
+}
+
+func (w *worker) close() {
+	if w.conn != nil {
+		w.conn.Close()
+		w.conn = nil
+	}
+}
@@ -0,0 +1,60 @@
+package main
+
+import (
+	"io"
+	"net/url"
+	"testing"
+	"time"
+
+	"build-HTTP-from-scracth/pkg/httptoy"
+	"build-HTTP-from-scracth/pkg/httptoy/httptoytest"
+)
+
+func TestRun(t *testing.T) {
+	tests := []struct {
+		name        string
+		conns       int
+		maxRequests int // Server.MaxRequestsPerConn，1 表示每个响应都带有 Connection: close
+		ok          int64
+		ioErrs      int64
+		reconnects  int64
+	}{
+		// 10 个请求按照 pipeline 3 分为 3、3、3、1 四批
+		{"keep-alive", 1, 0, 10, 0, 0},
+		// 多个连接共享 -n 的预算，总数不变
+		{"conns", 4, 0, 10, 0, 0},
+		// 每批只有第一个请求有响应，剩余的算作错误，之后的每批都需要重新连接
+		{"close", 1, 1, 4, 6, 3},
+	}
+
+	for _, tt := range tests {
+		ts := httptoytest.NewUnstartedServer(httptoy.HandlerFunc(func(rw httptoy.ResponseWriter, req *httptoy.Request) {
+			io.WriteString(rw, "hello")
+		}))
+		ts.Config.MaxRequestsPerConn = tt.maxRequests
+		ts.Start()
+		defer ts.Close()
+
+		target, _ := url.Parse(ts.URL)
+		cfg := &config{
+			addr:     target.Host,
+			raw:      buildRequest("GET", target, nil, ""),
+			method:   "GET",
+			conns:    tt.conns,
+			requests: 10,
+			pipeline: 3,
+			timeout:  time.Second,
+		}
+		res := run(cfg)
+
+		if res.hist.Count() != tt.ok || res.statuses[200] != tt.ok || res.ioErrs != tt.ioErrs ||
+			res.reconnects != tt.reconnects || res.connectErrs != 0 {
+			t.Errorf("%s: count %d, statuses %v, ioErrs %d, reconnects %d, connectErrs %d", tt.name,
+				res.hist.Count(), res.statuses, res.ioErrs, res.reconnects, res.connectErrs)
+		}
+		// -n 用完之后 worker 立即结束，不会等到 -d
+		if res.elapsed > 5*time.Second {
+			t.Errorf("%s: run took %v", tt.name, res.elapsed)
+		}
+	}
+}
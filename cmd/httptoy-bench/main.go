@@ -0,0 +1,151 @@
+// httptoy-bench 是对 HTTP/1.1 服务端进行压测的命令，用于比较 httptoy 修改前后的性能
+// 每个连接都是长连接，可以流水线发送请求，结束后输出吞吐量以及延迟的百分位
+//
+// E.g.
+//
+//	httptoy-bench -c 64 -d 10s http://127.0.0.1:8080/
+//	httptoy-bench -c 16 -rate 20000 -pipeline 4 http://127.0.0.1:8080/
+//	httptoy-bench -method POST -body-file data.json -H 'Content-Type: application/json' http://127.0.0.1:8080/api
+package main
+
+import (
+	"crypto/tls"
+	"flag"
+	"fmt"
+	"io"
+	"net/url"
+	"os"
+	"sort"
+	"strings"
+	"time"
+)
+
+// headerFlags 收集多次出现的 -H 参数
+type headerFlags []string
+
+func (h *headerFlags) String() string {
+	return strings.Join(*h, ", ")
+}
+
+func (h *headerFlags) Set(v string) error {
+	if !strings.Contains(v, ":") {
+		return fmt.Errorf("header %q should be in the form 'Name: value'", v)
+	}
+	*h = append(*h, v)
+	return nil
+}
+
+func main() {
+	var (
+		cfg      config
+		headers  headerFlags
+		body     string
+		bodyFile string
+	)
+	flag.IntVar(&cfg.conns, "c", 16, "number of keep-alive connections")
+	flag.DurationVar(&cfg.duration, "d", 10*time.Second, "test duration")
+	flag.Int64Var(&cfg.requests, "n", 0, "total number of requests, 0 means until -d elapses")
+	flag.Float64Var(&cfg.rate, "rate", 0, "requests per second across all connections, 0 means closed-loop")
+	flag.IntVar(&cfg.pipeline, "pipeline", 1, "requests sent back-to-back on a connection before reading responses")
+	flag.StringVar(&cfg.method, "method", "GET", "request method")
+	flag.StringVar(&body, "body", "", "request body")
+	flag.StringVar(&bodyFile, "body-file", "", "read request body from file")
+	flag.Var(&headers, "H", "extra request header, may be repeated")
+	flag.DurationVar(&cfg.timeout, "timeout", 5*time.Second, "timeout for each batch of requests")
+	insecure := flag.Bool("k", false, "skip TLS certificate verification")
+	flag.Usage = func() {
+		fmt.Fprintf(flag.CommandLine.Output(), "usage: httptoy-bench [flags] URL\n")
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+
+	if flag.NArg() != 1 {
+		flag.Usage()
+		os.Exit(2)
+	}
+	target, err := url.Parse(flag.Arg(0))
+	if err != nil || (target.Scheme != "http" && target.Scheme != "https") || target.Host == "" {
+		fatalf("invalid URL %q", flag.Arg(0))
+	}
+	if cfg.conns < 1 || cfg.pipeline < 1 || cfg.rate < 0 {
+		fatalf("-c and -pipeline must be positive, -rate must not be negative")
+	}
+
+	if bodyFile != "" {
+		b, err := os.ReadFile(bodyFile)
+		if err != nil {
+			fatalf("%v", err)
+		}
+		body = string(b)
+	}
+
+	cfg.addr = target.Host
+	if target.Port() == "" {
+		cfg.addr += map[string]string{"http": ":80", "https": ":443"}[target.Scheme]
+	}
+	if target.Scheme == "https" {
+		cfg.tls = &tls.Config{ServerName: target.Hostname(), InsecureSkipVerify: *insecure}
+	}
+	cfg.raw = buildRequest(cfg.method, target, headers, body)
+
+	res := run(&cfg)
+	report(os.Stdout, target.String(), &cfg, res)
+}
+
+func fatalf(format string, args ...interface{}) {
+	fmt.Fprintf(os.Stderr, "httptoy-bench: "+format+"\n", args...)
+	os.Exit(1)
+}
+
+// buildRequest 构造一次请求的原始报文，所有请求都发送相同的报文
+func buildRequest(method string, target *url.URL, headers []string, body string) []byte {
+	var sb strings.Builder
+
+	fmt.Fprintf(&sb, "%s %s HTTP/1.1\r\n", method, target.RequestURI())
+	fmt.Fprintf(&sb, "Host: %s\r\n", target.Host)
+	sb.WriteString("User-Agent: httptoy-bench\r\n")
+	for _, h := range headers {
+		name, value, _ := strings.Cut(h, ":")
+		fmt.Fprintf(&sb, "%s: %s\r\n", strings.TrimSpace(name), strings.TrimSpace(value))
+	}
+	if body != "" || method == "POST" || method == "PUT" {
+		fmt.Fprintf(&sb, "Content-Length: %d\r\n", len(body))
+	}
+	sb.WriteString("\r\n")
+	sb.WriteString(body)
+
+	return []byte(sb.String())
+}
+
+// report 输出压测结果
+func report(w io.Writer, target string, cfg *config, res *result) {
+	secs := res.elapsed.Seconds()
+	mode := "closed-loop"
+	if cfg.rate > 0 {
+		mode = fmt.Sprintf("%.0f req/s", cfg.rate)
+	}
+
+	fmt.Fprintf(w, "Target       %s\n", target)
+	fmt.Fprintf(w, "Connections  %d, pipeline %d, %s\n", cfg.conns, cfg.pipeline, mode)
+	fmt.Fprintf(w, "Requests     %d in %.2fs, %.1f req/s, %.2f MB/s read\n",
+		res.hist.Count(), secs, float64(res.hist.Count())/secs, float64(res.bytesRead)/secs/1e6)
+	fmt.Fprintf(w, "Errors       %d (connect %d, read/write %d), reconnects %d\n",
+		res.connectErrs+res.ioErrs, res.connectErrs, res.ioErrs, res.reconnects)
+
+	codes := make([]int, 0, len(res.statuses))
+	for code := range res.statuses {
+		codes = append(codes, code)
+	}
+	sort.Ints(codes)
+	fmt.Fprintf(w, "Status      ")
+	for _, code := range codes {
+		fmt.Fprintf(w, " %d:%d", code, res.statuses[code])
+	}
+	fmt.Fprintln(w)
+
+	h := res.hist
+	fmt.Fprintf(w, "Latency      min %v, mean %v, stddev %v, max %v\n", h.Min(), h.Mean(), h.StdDev(), h.Max())
+	for _, q := range []float64{50, 75, 90, 99, 99.9, 99.99, 100} {
+		fmt.Fprintf(w, "  %7s%%  %v\n", fmt.Sprint(q), h.Percentile(q))
+	}
+}
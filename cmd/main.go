@@ -2,33 +2,24 @@ package main
 
 import (
 	"build-HTTP-from-scracth/pkg/httptoy"
+	"build-HTTP-from-scracth/pkg/httptoy/accesslog"
+	"build-HTTP-from-scracth/pkg/httptoy/requestid"
 	"bytes"
 	"fmt"
 	"io"
+	"os"
 )
 
 type myHandler struct{}
 
-func (*myHandler) ServeHTTP(req *httptoy.Request, res httptoy.ResponseWriter) {
+func (*myHandler) ServeHTTP(res httptoy.ResponseWriter, req *httptoy.Request) {
 
 	// 用户的头部信息保存到buff中
 	buff := &bytes.Buffer{}
-	// 测试Request的解析
-	// fmt.Fprintf(buff, "[query]name=%s\n", req.Query("name"))
-	// fmt.Fprintf(buff, "[query]token=%s\n", req.Query("token"))
-	// fmt.Fprintf(buff, "[cookie]foo1=%s\n", req.Cookie("foo1"))
-	// fmt.Fprintf(buff, "[cookie]foo2=%s\n", req.Cookie("foo2"))
-	// fmt.Fprintf(buff, "[Header]User-Agent=%s\n", req.Header.Get("User-Agent"))
-	// fmt.Fprintf(buff, "[Header]Proto=%s\n", req.Proto)
-	// fmt.Fprintf(buff, "[Header]Method=%s\n", req.Method)
-	// fmt.Fprintf(buff, "[Addr]Addr=%s\n", req.RemoteAddr)
-
 	fmt.Fprintf(buff, "[Request] Header:%v\n", req.Header)
 
-	//手动发送响应报文
-	io.WriteString(res, "HTTP/1.1 200 OK\r\n")
-	io.WriteString(res, fmt.Sprintf("Content-Length: %d\r\n", buff.Len()))
-	io.WriteString(res, "\r\n")
+	// 状态行以及 Content-Length 由 httptoy 生成
+	res.Header().Set("Content-Type", "text/plain; charset=utf-8")
 	io.Copy(res, buff) //将buff缓存数据发送给客户端
 
 }
@@ -36,9 +27,11 @@ func (*myHandler) ServeHTTP(req *httptoy.Request, res httptoy.ResponseWriter) {
 func main() {
 	fmt.Println("localhost:8080")
 
+	// 每个请求分配请求 ID，并以 Combined Log Format 输出访问日志
+	handler := requestid.New(new(myHandler), requestid.Options{})
 	svr := &httptoy.Server{
 		Addr:    "127.0.0.1:8080",
-		Handler: new(myHandler),
+		Handler: accesslog.New(handler, accesslog.Options{Format: accesslog.Combined, Output: os.Stdout}),
 	}
 	panic(svr.ListenAndServe())
 }
@@ -1,3 +1,3 @@
 module build-HTTP-from-scracth
 
-go 1.19
+go 1.21
@@ -0,0 +1,228 @@
+// Package accesslog 提供记录每个请求的访问日志中间件
+// 支持 Common Log Format、Combined Log Format 以及通过 log/slog 输出的 JSON
+//
+// E.g.
+//
+//	handler := accesslog.New(mux, accesslog.Options{Format: accesslog.Combined})
+//	127.0.0.1 - - [18/Oct/2026:10:00:00 +0800] "GET /index HTTP/1.1" 200 12 "-" "curl/8.0" "3f2a" 0.000153
+package accesslog
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log/slog"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"build-HTTP-from-scracth/pkg/httptoy"
+	"build-HTTP-from-scracth/pkg/httptoy/requestid"
+)
+
+// Format 是访问日志的格式
+type Format int
+
+const (
+	// Common 是 Common Log Format
+	// host ident authuser [date] "request-line" status bytes
+	Common Format = iota
+	// Combined 在 Common 之后追加 "referer" "user-agent" "request-id" 以及以秒为单位的处理耗时
+	Combined
+	// JSON 通过 slog.Logger 输出结构化日志，各个字段见 Entry
+	JSON
+)
+
+// RequestIDHeader 是默认读取请求 ID 的首部
+const RequestIDHeader = requestid.Header
+
+// Entry 是一条访问日志
+type Entry struct {
+	Time       time.Time     // 开始处理请求的时间
+	RemoteAddr string        // 客户端地址 E.g. 127.0.0.1:53412
+	Method     string        // 请求方法
+	Target     string        // 请求行中的请求目标 E.g. /index?name=gu
+	Proto      string        // 协议版本 E.g. HTTP/1.1
+	Status     int           // 响应状态码
+	Bytes      int64         // 响应报文主体的字节数
+	Duration   time.Duration // handler 的执行耗时
+	Referer    string
+	UserAgent  string
+	RequestID  string
+}
+
+// Options 是访问日志中间件的配置
+type Options struct {
+	Format Format
+
+	// Output 是 Common 以及 Combined 格式的输出，为空时为 os.Stderr
+	Output io.Writer
+
+	// Logger 是 JSON 格式使用的 slog.Logger，为空时使用写入 Output 的 slog.JSONHandler
+	Logger *slog.Logger
+
+	// RequestID 返回请求 ID，为空时依次读取 requestid 中间件保存的 ID、响应以及请求的 X-Request-Id 首部
+	RequestID func(rw httptoy.ResponseWriter, req *httptoy.Request) string
+}
+
+// now 用于测试时固定时间
+var now = time.Now
+
+// New 返回记录访问日志的 handler，每个请求在 next 返回之后记录一条日志
+func New(next httptoy.Handler, opts Options) httptoy.Handler {
+	if opts.Output == nil {
+		opts.Output = os.Stderr
+	}
+	if opts.Format == JSON && opts.Logger == nil {
+		opts.Logger = slog.New(slog.NewJSONHandler(opts.Output, nil))
+	}
+	if opts.RequestID == nil {
+		opts.RequestID = headerRequestID
+	}
+
+	l := &logger{opts: opts}
+	return httptoy.HandlerFunc(func(rw httptoy.ResponseWriter, req *httptoy.Request) {
+		start := now()
+		// 内层的 requestid 中间件分配的 ID 在 next 返回之后可以从 context 读取
+		req = req.WithContext(requestid.Capture(req.Context()))
+		ww := httptoy.NewWrapWriter(rw)
+
+		// handler panic 时以 500 记录日志，之后继续 panic 交由 conn.serve 处理
+		defer func() {
+			rec := recover()
+			e := &Entry{
+				Time:       start,
+				RemoteAddr: req.RemoteAddr,
+				Method:     req.Method,
+				Target:     req.RemoteURI,
+				Proto:      req.Proto,
+				Status:     ww.Status(),
+				Bytes:      ww.BytesWritten(),
+				Duration:   now().Sub(start),
+				Referer:    req.Header.Get("Referer"),
+				UserAgent:  req.Header.Get("User-Agent"),
+				RequestID:  opts.RequestID(ww, req),
+			}
+			if rec != nil {
+				e.Status = 500
+			}
+			l.log(e)
+
+			if rec != nil {
+				panic(rec)
+			}
+		}()
+
+		next.ServeHTTP(ww, req)
+	})
+}
+
+// headerRequestID 读取 requestid 中间件分配的 ID，中间件在访问日志之内或者之外都可以读取
+// 没有使用 requestid 中间件时依次读取响应以及请求的 X-Request-Id
+func headerRequestID(rw httptoy.ResponseWriter, req *httptoy.Request) string {
+	if id := requestid.FromContext(req.Context()); id != "" {
+		return id
+	}
+	if id := rw.Header().Get(RequestIDHeader); id != "" {
+		return id
+	}
+
+	return req.Header.Get(RequestIDHeader)
+}
+
+type logger struct {
+	opts Options
+	mu   sync.Mutex // 保证并发的请求写入 Output 的日志行不会交错
+	buf  []byte
+}
+
+func (l *logger) log(e *Entry) {
+	if l.opts.Format == JSON {
+		l.opts.Logger.LogAttrs(context.Background(), slog.LevelInfo, "request",
+			slog.Time("time", e.Time),
+			slog.String("remote_addr", e.RemoteAddr),
+			slog.String("method", e.Method),
+			slog.String("target", e.Target),
+			slog.String("proto", e.Proto),
+			slog.Int("status", e.Status),
+			slog.Int64("bytes", e.Bytes),
+			slog.Duration("duration", e.Duration),
+			slog.String("referer", e.Referer),
+			slog.String("user_agent", e.UserAgent),
+			slog.String("request_id", e.RequestID),
+		)
+		return
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	l.buf = appendCommon(l.buf[:0], e)
+	if l.opts.Format == Combined {
+		l.buf = append(l.buf, ' ')
+		l.buf = appendQuoted(l.buf, e.Referer)
+		l.buf = append(l.buf, ' ')
+		l.buf = appendQuoted(l.buf, e.UserAgent)
+		l.buf = append(l.buf, ' ')
+		l.buf = appendQuoted(l.buf, e.RequestID)
+		l.buf = append(l.buf, ' ')
+		l.buf = strconv.AppendFloat(l.buf, e.Duration.Seconds(), 'f', 6, 64)
+	}
+	l.buf = append(l.buf, '\n')
+
+	l.opts.Output.Write(l.buf)
+}
+
+// appendCommon 按照 Common Log Format 写入 e，不包括换行
+// E.g. 127.0.0.1 - - [10/Oct/2000:13:55:36 -0700] "GET /apache_pb.gif HTTP/1.0" 200 2326
+func appendCommon(b []byte, e *Entry) []byte {
+	host := e.RemoteAddr
+	if h, _, err := net.SplitHostPort(host); err == nil {
+		host = h
+	}
+	if host == "" {
+		host = "-"
+	}
+
+	b = append(b, host...)
+	b = append(b, " - - ["...)
+	b = e.Time.AppendFormat(b, "02/Jan/2006:15:04:05 -0700")
+	b = append(b, "] "...)
+	b = appendQuoted(b, strings.Join([]string{e.Method, e.Target, e.Proto}, " "))
+	b = append(b, ' ')
+	b = strconv.AppendInt(b, int64(e.Status), 10)
+	b = append(b, ' ')
+	if e.Bytes == 0 {
+		b = append(b, '-')
+	} else {
+		b = strconv.AppendInt(b, e.Bytes, 10)
+	}
+
+	return b
+}
+
+// appendQuoted 写入带双引号的 s，空串写为 "-"
+// 双引号、反斜杠以及不可见字符需要转义，防止伪造日志行
+func appendQuoted(b []byte, s string) []byte {
+	if s == "" {
+		return append(b, `"-"`...)
+	}
+
+	b = append(b, '"')
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		switch {
+		case c == '"' || c == '\\':
+			b = append(b, '\\', c)
+		case c < 0x20 || c >= 0x7f:
+			b = append(b, fmt.Sprintf(`\x%02x`, c)...)
+		default:
+			b = append(b, c)
+		}
+	}
+
+	return append(b, '"')
+}
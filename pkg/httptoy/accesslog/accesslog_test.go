@@ -0,0 +1,160 @@
+package accesslog
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"log/slog"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"build-HTTP-from-scracth/pkg/httptoy"
+	"build-HTTP-from-scracth/pkg/httptoy/httptoytest"
+	"build-HTTP-from-scracth/pkg/httptoy/requestid"
+)
+
+// fixClock 让 now 依次返回 start 以及 start+d
+func fixClock(t *testing.T, start time.Time, d time.Duration) {
+	calls := 0
+	now = func() time.Time {
+		calls++
+		if calls%2 == 0 {
+			return start.Add(d)
+		}
+		return start
+	}
+	t.Cleanup(func() { now = time.Now })
+}
+
+func serve(h httptoy.Handler, req *httptoy.Request) {
+	h.ServeHTTP(httptoytest.NewRecorder(), req)
+}
+
+func TestTextFormats(t *testing.T) {
+	start := time.Date(2000, 10, 10, 13, 55, 36, 0, time.FixedZone("", -7*3600))
+	fixClock(t, start, 1500*time.Microsecond)
+
+	h := httptoy.HandlerFunc(func(rw httptoy.ResponseWriter, req *httptoy.Request) {
+		rw.Header().Set(RequestIDHeader, "abc")
+		rw.WriteHeader(404)
+		io.WriteString(rw, "not found")
+	})
+	req := httptoytest.NewRequest("GET", "/a?b=1", nil)
+	req.Header.Set("User-Agent", `curl "quoted"`+"\n")
+	req.Header.Set("Referer", "http://example.com/")
+
+	tests := []struct {
+		format Format
+		want   string
+	}{
+		{Common, `192.0.2.1 - - [10/Oct/2000:13:55:36 -0700] "GET /a?b=1 HTTP/1.1" 404 9` + "\n"},
+		{Combined, `192.0.2.1 - - [10/Oct/2000:13:55:36 -0700] "GET /a?b=1 HTTP/1.1" 404 9 "http://example.com/" "curl \"quoted\"\x0a" "abc" 0.001500` + "\n"},
+	}
+	for _, tt := range tests {
+		var buf bytes.Buffer
+		serve(New(h, Options{Format: tt.format, Output: &buf}), req)
+		if buf.String() != tt.want {
+			t.Errorf("format %d:\n got %q\nwant %q", tt.format, buf.String(), tt.want)
+		}
+	}
+}
+
+func TestJSON(t *testing.T) {
+	fixClock(t, time.Unix(0, 0), time.Second)
+
+	var buf bytes.Buffer
+	h := New(httptoy.HandlerFunc(func(rw httptoy.ResponseWriter, req *httptoy.Request) {
+		io.Copy(rw, strings.NewReader("hello"))
+	}), Options{Format: JSON, Logger: slog.New(slog.NewJSONHandler(&buf, nil))})
+
+	req := httptoytest.NewRequest("POST", "/upload", nil)
+	req.Header.Set(RequestIDHeader, "req-1")
+	serve(h, req)
+
+	var got map[string]interface{}
+	if err := json.Unmarshal(buf.Bytes(), &got); err != nil {
+		t.Fatalf("%v: %q", err, buf.String())
+	}
+	want := map[string]interface{}{
+		"msg": "request", "remote_addr": "192.0.2.1:1234", "method": "POST", "target": "/upload", "proto": "HTTP/1.1",
+		"status": 200.0, "bytes": 5.0, "duration": 1e9, "user_agent": "", "request_id": "req-1",
+	}
+	for k, v := range want {
+		if got[k] != v {
+			t.Errorf("%s = %v, want %v", k, got[k], v)
+		}
+	}
+}
+
+func TestRequestIDMiddleware(t *testing.T) {
+	var buf bytes.Buffer
+	ok := httptoy.HandlerFunc(func(rw httptoy.ResponseWriter, req *httptoy.Request) {})
+
+	// 上游的 ID 不合法被替换，日志记录替换之后的 ID，而不是请求中原始的 X-Request-Id
+	for _, header := range []string{requestid.Header, "X-Correlation-Id"} {
+		opts := requestid.Options{Header: header, Generate: func() string { return "generated" }}
+		for _, h := range []httptoy.Handler{
+			New(requestid.New(ok, opts), Options{Format: Combined, Output: &buf}),
+			requestid.New(New(ok, Options{Format: Combined, Output: &buf}), opts),
+		} {
+			buf.Reset()
+			req := httptoytest.NewRequest("GET", "/", nil)
+			if header != RequestIDHeader {
+				req.Header.Set(RequestIDHeader, "raw")
+			}
+			req.Header.Set(header, "bad id")
+			serve(h, req)
+			if !strings.Contains(buf.String(), `"-" "-" "generated"`) {
+				t.Errorf("%s: log = %q", header, buf.String())
+			}
+		}
+	}
+}
+
+// TestHEAD 经过真实的连接，HEAD 请求被丢弃的报文主体不计入字节数
+func TestHEAD(t *testing.T) {
+	var buf bytes.Buffer
+	h := New(httptoy.HandlerFunc(func(rw httptoy.ResponseWriter, req *httptoy.Request) {
+		io.WriteString(rw, "hello")
+	}), Options{Output: &buf})
+	ts := httptoytest.NewServer(h)
+	defer ts.Close()
+
+	for _, method := range []string{"GET", "HEAD"} {
+		buf.Reset()
+		req, _ := http.NewRequest(method, ts.URL+"/", nil)
+		resp, err := ts.Client().Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		io.Copy(io.Discard, resp.Body)
+		resp.Body.Close()
+
+		want := `"GET / HTTP/1.1" 200 5` + "\n"
+		if method == "HEAD" {
+			want = `"HEAD / HTTP/1.1" 200 -` + "\n"
+		}
+		if !strings.HasSuffix(buf.String(), want) {
+			t.Errorf("%s: log = %q, want suffix %q", method, buf.String(), want)
+		}
+	}
+}
+
+func TestPanic(t *testing.T) {
+	var buf bytes.Buffer
+	h := New(httptoy.HandlerFunc(func(rw httptoy.ResponseWriter, req *httptoy.Request) {
+		panic("boom")
+	}), Options{Output: &buf})
+
+	defer func() {
+		if recover() == nil {
+			t.Error("panic should be propagated")
+		}
+		if !strings.Contains(buf.String(), `"GET / HTTP/1.1" 500 -`) {
+			t.Errorf("log = %q", buf.String())
+		}
+	}()
+	serve(h, httptoytest.NewRequest("GET", "/", nil))
+}
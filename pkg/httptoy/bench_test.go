@@ -0,0 +1,96 @@
+package httptoy
+
+import (
+	"bufio"
+	"bytes"
+	"io"
+	"strings"
+	"testing"
+)
+
+// bench_test.go 测量请求解析以及响应写入的耗时和内存分配
+// E.g. go test -run=^$ -bench=. -benchmem ./pkg/httptoy
+
+const benchRequest = "GET /index?name=gu&token=abc HTTP/1.1\r\n" +
+	"Host: 127.0.0.1:8080\r\n" +
+	"User-Agent: Mozilla/5.0 (X11; Linux x86_64) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/120.0 Safari/537.36\r\n" +
+	"Accept: text/html,application/xhtml+xml,application/xml;q=0.9,*/*;q=0.8\r\n" +
+	"Accept-Language: zh-CN,zh;q=0.9,en;q=0.8\r\n" +
+	"Accept-Encoding: gzip, deflate\r\n" +
+	"Cookie: uuid=12314753; tid=1BDB9E9; HOME=1\r\n" +
+	"\r\n"
+
+// benchReadRequests 在一个连接上解析 b.N 个相同的请求
+func benchReadRequests(b *testing.B, raw string) {
+	b.ReportAllocs()
+	b.SetBytes(int64(len(raw)))
+
+	data := []byte(strings.Repeat(raw, b.N))
+	c := newConn(fuzzConn{bytes.NewReader(data)}, &Server{})
+	c.lr.N = int64(len(data))
+	b.ResetTimer()
+
+	for i := 0; i < b.N; i++ {
+		req, err := c.readRequest()
+		if err != nil {
+			b.Fatal(err)
+		}
+		if !req.discardBody() {
+			b.Fatal("body not drained")
+		}
+	}
+}
+
+func BenchmarkReadRequest(b *testing.B) {
+	benchReadRequests(b, benchRequest)
+}
+
+func BenchmarkReadRequestChunked(b *testing.B) {
+	benchReadRequests(b, "POST /upload HTTP/1.1\r\nHost: a\r\nTransfer-Encoding: chunked\r\n\r\n"+
+		string(encodeChunked(bytes.Repeat([]byte("x"), 4<<10), 1<<10)))
+}
+
+func BenchmarkReadHeader(b *testing.B) {
+	_, header, _ := strings.Cut(benchRequest, "\r\n")
+	b.ReportAllocs()
+	b.SetBytes(int64(len(header)))
+
+	r := strings.NewReader(header)
+	bufr := bufio.NewReader(r)
+	for i := 0; i < b.N; i++ {
+		r.Reset(header)
+		bufr.Reset(r)
+		if _, err := readHeader(bufr); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+// benchServe 让 conn.serve 在一个连接上处理 b.N 个流水线请求，响应直接丢弃
+func benchServe(b *testing.B, h Handler) {
+	b.ReportAllocs()
+
+	data := []byte(strings.Repeat(benchRequest, b.N))
+	c := newConn(fuzzConn{bytes.NewReader(data)}, &Server{Handler: h})
+	c.lr.N = int64(len(data))
+	b.ResetTimer()
+
+	c.serve()
+}
+
+func BenchmarkServeSmall(b *testing.B) {
+	benchServe(b, testHandler(func(rw ResponseWriter, req *Request) {
+		rw.Header().Set("Content-Type", "text/plain")
+		io.WriteString(rw, "hello, world")
+	}))
+}
+
+func BenchmarkServeChunked(b *testing.B) {
+	body := bytes.Repeat([]byte("x"), 16<<10)
+	b.SetBytes(int64(len(body)))
+
+	benchServe(b, testHandler(func(rw ResponseWriter, req *Request) {
+		rw.Header().Set("Content-Type", "application/octet-stream")
+		rw.Write(body)
+	}))
+}
@@ -0,0 +1,276 @@
+package httptoy
+
+import (
+	"encoding"
+	"errors"
+	"fmt"
+	"reflect"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// binding.go 负责将 queryString、x-www-form-urlencoded 以及 multipart 表单解码到结构体
+// 结构体字段通过 tag 描述如何绑定：
+/* type SignUp struct {
+ *     Name     string        `form:"name"`
+ *     Age      int           `form:"age" default:"18"`
+ *     Tags     []string      `form:"tag"`
+ *     Birthday time.Time     `form:"birthday" time_format:"2006-01-02"`
+ *     Avatar   *FileHeader   `form:"avatar"`
+ *     Address  Address       `form:"addr"`   // 嵌套结构体的字段名为 addr.city, addr.street
+ *     Internal string        `form:"-"`      // 忽略
+ * }
+ */
+
+// FieldError 记录一个字段绑定失败的原因
+type FieldError struct {
+	Field string // 表单中的字段名，嵌套结构体以 . 连接，E.g. addr.city
+	Value string // 客户端传入的原始值
+	Err   error
+}
+
+func (fe *FieldError) Error() string {
+	return fmt.Sprintf("field %q: invalid value %q: %v", fe.Field, fe.Value, fe.Err)
+}
+
+func (fe *FieldError) Unwrap() error {
+	return fe.Err
+}
+
+// FieldErrors 是绑定过程中所有字段的错误，可以直接作为 400 响应的内容
+type FieldErrors []*FieldError
+
+func (fes FieldErrors) Error() string {
+	msgs := make([]string, len(fes))
+	for i, fe := range fes {
+		msgs[i] = fe.Error()
+	}
+
+	return strings.Join(msgs, "; ")
+}
+
+var (
+	timeType            = reflect.TypeOf(time.Time{})
+	durationType        = reflect.TypeOf(time.Duration(0))
+	fileHeaderType      = reflect.TypeOf((*FileHeader)(nil))
+	textUnmarshalerType = reflect.TypeOf((*encoding.TextUnmarshaler)(nil)).Elem()
+)
+
+// BindForm 解析请求的表单以及 queryString，解码到 v 指向的结构体中
+// 同名字段表单的值优先，字段绑定失败时返回 FieldErrors，其余错误(E.g. 表单格式错误)直接返回
+func (r *Request) BindForm(v interface{}) error {
+	rv := reflect.ValueOf(v)
+	if rv.Kind() != reflect.Pointer || rv.IsNil() || rv.Elem().Kind() != reflect.Struct {
+		return errors.New("httptoy: BindForm requires a non-nil pointer to struct")
+	}
+
+	if !r.hadParsedForm { // lazy-parse
+		r.parseFromErr = r.ParseForm()
+	}
+	// 报文主体不是表单时，依旧可以绑定 queryString
+	if r.parseFromErr != nil && r.parseFromErr != errUnsupportedForm {
+		return r.parseFromErr
+	}
+
+	b := binder{form: r.Form}
+	if r.MultipartForm != nil {
+		b.files = r.MultipartForm.File
+	}
+	b.bindStruct(rv.Elem(), "")
+
+	if len(b.errs) > 0 {
+		return b.errs
+	}
+
+	return nil
+}
+
+// binder 保存一次绑定的数据源以及错误
+type binder struct {
+	form  Values
+	files map[string][]*FileHeader
+	errs  FieldErrors
+}
+
+// bindStruct 绑定结构体的每个导出字段，prefix 为嵌套结构体的字段名前缀
+func (b *binder) bindStruct(sv reflect.Value, prefix string) {
+	st := sv.Type()
+
+	for i := 0; i < st.NumField(); i++ {
+		sf := st.Field(i)
+		tag := sf.Tag.Get("form")
+		if tag == "-" || !sf.IsExported() && !sf.Anonymous {
+			continue
+		}
+
+		name := tag
+		if name == "" {
+			name = sf.Name
+		}
+		name = prefix + name
+
+		fv := sv.Field(i)
+		ft := sf.Type
+
+		// 嵌套结构体：匿名嵌入的字段不加前缀，其余以 name. 作为前缀
+		if ft.Kind() == reflect.Struct && ft != timeType && !reflect.PointerTo(ft).Implements(textUnmarshalerType) {
+			if sf.Anonymous && tag == "" {
+				b.bindStruct(fv, prefix)
+			} else {
+				b.bindStruct(fv, name+".")
+			}
+			continue
+		}
+		if !sf.IsExported() {
+			continue
+		}
+
+		// 文件字段
+		if ft == fileHeaderType {
+			if fhs := b.files[name]; len(fhs) > 0 {
+				fv.Set(reflect.ValueOf(fhs[0]))
+			}
+			continue
+		}
+		if ft.Kind() == reflect.Slice && ft.Elem() == fileHeaderType {
+			if fhs := b.files[name]; len(fhs) > 0 {
+				fv.Set(reflect.ValueOf(fhs))
+			}
+			continue
+		}
+
+		vals, ok := b.form[name]
+		if !ok || len(vals) == 0 {
+			def, hasDef := sf.Tag.Lookup("default")
+			if !hasDef {
+				continue
+			}
+			vals = []string{def}
+			// 切片的默认值以逗号分隔，E.g. default:"a,b"
+			if ft.Kind() == reflect.Slice {
+				vals = strings.Split(def, ",")
+			}
+		}
+
+		b.bindField(fv, sf, name, vals)
+	}
+}
+
+// bindField 将 vals 解码到字段中，切片字段使用全部的值，其余只使用第一个值
+func (b *binder) bindField(fv reflect.Value, sf reflect.StructField, name string, vals []string) {
+	layout := sf.Tag.Get("time_format")
+
+	ft := fv.Type()
+	if ft.Kind() == reflect.Slice && !ft.Implements(textUnmarshalerType) && !reflect.PointerTo(ft).Implements(textUnmarshalerType) {
+		slice := reflect.MakeSlice(ft, len(vals), len(vals))
+		ok := true
+		for i, val := range vals {
+			if err := setValue(slice.Index(i), val, layout); err != nil {
+				b.errs = append(b.errs, &FieldError{Field: name, Value: val, Err: err})
+				ok = false
+			}
+		}
+		if ok {
+			fv.Set(slice)
+		}
+		return
+	}
+
+	if err := setValue(fv, vals[0], layout); err != nil {
+		b.errs = append(b.errs, &FieldError{Field: name, Value: vals[0], Err: err})
+	}
+}
+
+// setValue 根据字段的类型将字符串转换后赋值
+func setValue(fv reflect.Value, val, layout string) error {
+	// 指针字段先分配空间
+	if fv.Kind() == reflect.Pointer {
+		elem := reflect.New(fv.Type().Elem())
+		if err := setValue(elem.Elem(), val, layout); err != nil {
+			return err
+		}
+		fv.Set(elem)
+		return nil
+	}
+
+	if fv.CanAddr() {
+		if u, ok := fv.Addr().Interface().(encoding.TextUnmarshaler); ok && fv.Type() != timeType {
+			return u.UnmarshalText([]byte(val))
+		}
+	}
+
+	switch fv.Type() {
+	case timeType:
+		if val == "" {
+			return nil
+		}
+		if layout == "" {
+			layout = time.RFC3339
+		}
+		t, err := time.Parse(layout, val)
+		if err != nil {
+			return err
+		}
+		fv.Set(reflect.ValueOf(t))
+		return nil
+	case durationType:
+		d, err := time.ParseDuration(val)
+		if err != nil {
+			return err
+		}
+		fv.SetInt(int64(d))
+		return nil
+	}
+
+	switch fv.Kind() {
+	case reflect.String:
+		fv.SetString(val)
+	case reflect.Bool:
+		// 复选框选中时的值为 on
+		switch strings.ToLower(val) {
+		case "on":
+			fv.SetBool(true)
+		case "off", "":
+			fv.SetBool(false)
+		default:
+			bv, err := strconv.ParseBool(val)
+			if err != nil {
+				return numError(err)
+			}
+			fv.SetBool(bv)
+		}
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		n, err := strconv.ParseInt(val, 10, fv.Type().Bits())
+		if err != nil {
+			return numError(err)
+		}
+		fv.SetInt(n)
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		n, err := strconv.ParseUint(val, 10, fv.Type().Bits())
+		if err != nil {
+			return numError(err)
+		}
+		fv.SetUint(n)
+	case reflect.Float32, reflect.Float64:
+		f, err := strconv.ParseFloat(val, fv.Type().Bits())
+		if err != nil {
+			return numError(err)
+		}
+		fv.SetFloat(f)
+	default:
+		return fmt.Errorf("unsupported field type %v", fv.Type())
+	}
+
+	return nil
+}
+
+// numError 去掉 strconv 错误中重复的函数名以及原始值
+func numError(err error) error {
+	var ne *strconv.NumError
+	if errors.As(err, &ne) {
+		return ne.Err
+	}
+
+	return err
+}
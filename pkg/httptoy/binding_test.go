@@ -0,0 +1,159 @@
+package httptoy
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"reflect"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+type bindAddress struct {
+	City   string `form:"city"`
+	Street string `form:"street" default:"main"`
+}
+
+type bindPaging struct {
+	Page int `form:"page" default:"1"`
+	Size int `form:"size" default:"20"`
+}
+
+type bindSignUp struct {
+	bindPaging
+	Name     string        `form:"name"`
+	Age      uint8         `form:"age"`
+	Score    float64       `form:"score"`
+	Agree    bool          `form:"agree"`
+	Tags     []string      `form:"tag"`
+	IDs      []int         `form:"id" default:"7,8"`
+	Birthday time.Time     `form:"birthday" time_format:"2006-01-02"`
+	Timeout  time.Duration `form:"timeout"`
+	Nick     *string       `form:"nick"`
+	Address  bindAddress   `form:"addr"`
+	Avatar   *FileHeader   `form:"avatar"`
+	Photos   []*FileHeader `form:"photo"`
+	Ignored  string        `form:"-"`
+	Untagged string
+}
+
+// bindRequest 将 raw 交给 handler，在 handler 中调用 BindForm
+func bindRequest(t *testing.T, raw string, v interface{}) error {
+	t.Helper()
+
+	var err error
+	serveRaw(t, testHandler(func(rw ResponseWriter, req *Request) {
+		err = req.BindForm(v)
+	}), raw)
+
+	return err
+}
+
+func TestBindFormURLEncoded(t *testing.T) {
+	body := "name=gu+jack&age=22&score=9.5&agree=on&tag=a&tag=b&birthday=2000-01-02&timeout=1m30s" +
+		"&nick=jj&addr.city=%E5%B9%BF%E5%B7%9E&Untagged=u&Ignored=x&size=50"
+	raw := "POST /?tag=q&page=3 HTTP/1.1\r\nConnection: close\r\n" +
+		"Content-Type: application/x-www-form-urlencoded\r\n" +
+		"Content-Length: " + strconv.Itoa(len(body)) + "\r\n\r\n" + body
+
+	var got bindSignUp
+	if err := bindRequest(t, raw, &got); err != nil {
+		t.Fatal(err)
+	}
+
+	nick := "jj"
+	want := bindSignUp{
+		bindPaging: bindPaging{Page: 3, Size: 50},
+		Name:       "gu jack",
+		Age:        22,
+		Score:      9.5,
+		Agree:      true,
+		Tags:       []string{"a", "b", "q"},
+		IDs:        []int{7, 8},
+		Birthday:   time.Date(2000, 1, 2, 0, 0, 0, 0, time.UTC),
+		Timeout:    90 * time.Second,
+		Nick:       &nick,
+		Address:    bindAddress{City: "广州", Street: "main"},
+		Untagged:   "u",
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Fatalf("got  %+v\nwant %+v", got, want)
+	}
+}
+
+func TestBindFormQueryOnly(t *testing.T) {
+	var got bindPaging
+	// 报文主体不是表单，依旧绑定 queryString
+	err := bindRequest(t, "POST /?page=2 HTTP/1.1\r\nConnection: close\r\nContent-Type: application/json\r\n"+
+		"Content-Length: 2\r\n\r\n{}", &got)
+	if err != nil || got != (bindPaging{Page: 2, Size: 20}) {
+		t.Fatalf("got %+v, err %v", got, err)
+	}
+}
+
+func TestBindFormErrors(t *testing.T) {
+	var got bindSignUp
+	err := bindRequest(t, "GET /?age=300&score=x&id=1&id=two&agree=maybe&birthday=2000/01/02 HTTP/1.1\r\nConnection: close\r\n\r\n", &got)
+
+	var fes FieldErrors
+	if !errors.As(err, &fes) {
+		t.Fatalf("want FieldErrors, got %v", err)
+	}
+
+	fields := make([]string, len(fes))
+	for i, fe := range fes {
+		fields[i] = fe.Field + "=" + fe.Value
+	}
+	want := []string{"age=300", "score=x", "agree=maybe", "id=two", "birthday=2000/01/02"}
+	if !reflect.DeepEqual(fields, want) {
+		t.Fatalf("fields = %v, want %v", fields, want)
+	}
+	if !strings.Contains(err.Error(), `field "age": invalid value "300": value out of range`) {
+		t.Fatalf("unexpected message: %v", err)
+	}
+
+	// 绑定失败的切片保持原值
+	if got.IDs != nil {
+		t.Fatalf("IDs = %v, want nil", got.IDs)
+	}
+
+	if err := bindRequest(t, "GET / HTTP/1.1\r\nConnection: close\r\n\r\n", got); err == nil {
+		t.Fatal("non-pointer should fail")
+	}
+}
+
+func TestBindFormMultipart(t *testing.T) {
+	part := func(name, filename, content string) string {
+		cd := fmt.Sprintf(`form-data; name="%s"`, name)
+		if filename != "" {
+			cd += fmt.Sprintf(`; filename="%s"`, filename)
+		}
+		return "--xyz\r\nContent-Disposition: " + cd + "\r\n\r\n" + content + "\r\n"
+	}
+	body := part("name", "", "gu") + part("avatar", "a.png", "AAA") +
+		part("photo", "1.jpg", "one") + part("photo", "2.jpg", "two") + "--xyz--\r\n"
+
+	var got bindSignUp
+	var avatar, photos string
+	serveRaw(t, testHandler(func(rw ResponseWriter, req *Request) {
+		if err := req.BindForm(&got); err != nil {
+			t.Error(err)
+			return
+		}
+
+		// 文件内容需要在 handler 结束之前读取
+		rc, _ := got.Avatar.Open()
+		b, _ := io.ReadAll(rc)
+		avatar = string(b)
+		for _, fh := range got.Photos {
+			photos += fh.Filename
+		}
+	}), "POST / HTTP/1.1\r\nConnection: close\r\nContent-Type: multipart/form-data; boundary=xyz\r\n"+
+		"Content-Length: "+strconv.Itoa(len(body))+"\r\n\r\n"+body)
+
+	if got.Name != "gu" || avatar != "AAA" || photos != "1.jpg2.jpg" {
+		t.Fatalf("got name %q avatar %q photos %q", got.Name, avatar, photos)
+	}
+}
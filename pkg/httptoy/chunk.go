@@ -2,10 +2,12 @@ package httptoy
 
 import (
 	"bufio"
+	"bytes"
 	"errors"
 	"fmt"
 	"io"
 	"net/http"
+	"sort"
 	"strconv"
 )
 
@@ -26,17 +28,25 @@ import (
  * 7\r\n							#chunk size
  * client!\r\n						#chunk data
  * 0\r\n\r\n						#end
+ *
+ * chunk size 之后可以带 ;name=value 形式的扩展，会被忽略
+ * 0 size 之后到空行之间为 trailer 首部
  */
 
 var (
 	crlf = []byte("\r\n")
 )
 
+// maxChunkSize 是单个 chunk 的长度上限，防止十六进制的长度溢出
+const maxChunkSize = 1<<31 - 1
+
 type chunkReader struct {
 	n    int           // 当前处理的块中还有多少字节未读
 	bufr *bufio.Reader // 读取body 的缓冲字节流
 	done bool          // 记录报文读取完毕
 	crlf [2]byte       // 用来读取 \r\n
+
+	trailer *Header // 非空时保存最后一块之后的 trailer 首部
 }
 
 func (cr *chunkReader) discardCRLF() error {
@@ -58,10 +68,25 @@ func (cw *chunkReader) getChunkSize() (int, error) {
 		return chunkSize, err
 	}
 
+	// 去掉 chunk 扩展以及其前面的空白 E.g. 1a ; name=value
+	if p := bytes.IndexByte(line, ';'); p >= 0 {
+		line = bytes.TrimRight(line[:p], " \t")
+	}
+
+	// 空行不是合法的 chunk size
+	if len(line) == 0 {
+		return 0, errors.New("empty chunk size")
+	}
+
 	//将16进制换算成10进制
 	// a b c d e f 补位 10 11 12 13 14 15
 	// 16进位
 	for i := 0; i < len(line); i++ {
+		// 超过 maxChunkSize 时继续乘以 16 会溢出成负数
+		if chunkSize > maxChunkSize>>4 {
+			return 0, errors.New("chunk size too large")
+		}
+
 		// ascii | 0x20 之后 ‘0’之前字符会变大， 非 ‘a' 字符同样有区间
 		b1 := int((line[i] | 0x20))
 		if b1-'0' > -1 && b1-'0' < 10 {
@@ -96,10 +121,16 @@ func (cr *chunkReader) Read(p []byte) (int, error) {
 		if cr.n == 0 {
 			cr.done = true
 
-			// 清理掉最后的CRLF，防止影响下一个http报文的解析
-			err = cr.discardCRLF()
+			// 读取 trailer 直到空行，防止影响下一个http报文的解析
+			trailer, err := readHeader(cr.bufr)
+			if err != nil {
+				return 0, err
+			}
+			if cr.trailer != nil && len(trailer) > 0 {
+				*cr.trailer = trailer
+			}
 
-			return 0, err
+			return 0, io.EOF
 		}
 	}
 
@@ -109,6 +140,10 @@ func (cr *chunkReader) Read(p []byte) (int, error) {
 		n, err = cr.bufr.Read(p)
 
 		cr.n -= n
+		// 正好读完当前块时，同样需要清除后面的 \r\n
+		if cr.n == 0 && err == nil {
+			err = cr.discardCRLF()
+		}
 		return n, err
 	}
 
@@ -128,14 +163,16 @@ type chunkWriter struct {
 
 // Write ...
 func (cw *chunkWriter) Write(p []byte) (n int, err error) {
-	if !cw.wrote {
-		cw.finalizeHeader(p)
-		cw.writeHeader()
-		cw.wrote = true
+	cw.commitHeader(p)
+
+	// HEAD 请求以及不允许报文主体的响应只发送首部，主体数据直接丢弃
+	// 没有数据需要写入时同样直接返回，防止 chunk 编码提前写入 0 size 的终止块
+	if !cw.resp.sendBody() || len(p) == 0 {
+		return len(p), nil
 	}
 
 	isChunked := cw.resp.chunking
-	bufw := cw.resp.c.bufw
+	bufw := cw.resp.out
 
 	if isChunked {
 		// 写入chunk size [十六进制]
@@ -154,32 +191,96 @@ func (cw *chunkWriter) Write(p []byte) (n int, err error) {
 	return n, err
 }
 
+// commitHeader 第一次写入时确定首部并发送，之后的调用不做任何事
+func (cw *chunkWriter) commitHeader(p []byte) {
+	if cw.wrote {
+		return
+	}
+
+	cw.finalizeHeader(p)
+	cw.writeHeader()
+	cw.wrote = true
+}
+
 // finalizeHeader ...
 func (cw *chunkWriter) finalizeHeader(p []byte) {
 	header := cw.resp.header
 
-	// 如果未设置响应报文类型，则检测设置
-	if header.Get("Content-Type") == "" {
-		header.Set("Content-Type", http.DetectContentType(p))
+	// 1xx、204、304 的响应不允许携带报文主体，也就不需要 Content-Length 以及 chunk 编码
+	if !bodyAllowedForStatus(cw.resp.statusCode) {
+		header.Del("Content-Length")
+		header.Del("Transfer-Encoding")
+	} else {
+		// 如果未设置响应报文类型，并且有数据可以检测，则检测设置
+		if header.Get("Content-Type") == "" && len(p) > 0 {
+			header.Set("Content-Type", http.DetectContentType(p))
+		}
+
+		cw.finalizeFraming()
 	}
 
-	// 如果未设置响应传递方式
-	if header.Get("Content-Length") == "" && header.Get("Transfer-Encoding") == "" {
-		// case 1: conn连接已经结束，此时需要chunkWriter确定发送报文，由于缓存大小为4kb，如不连接结束之前没有发送报文，那么在结束之后还有缓存的数据没发送，其小于4kb，并且是第一次发送
-		if cw.resp.handlerDone {
-			buffered := cw.resp.bufw.Buffered()
-			header.Set("Content-Length", strconv.Itoa(buffered))
-		} else {
-			// case 2： conn 没有结束时，需要写入，说明resp的缓存流的数据超过4kb需要发送，可能还有数据需要发送，将编码改成 chunked
+	cw.finalizeConnection()
+}
+
+// finalizeFraming 确定报文主体的传递方式：Content-Length、chunk 编码或者关闭连接
+func (cw *chunkWriter) finalizeFraming() {
+	header := cw.resp.header
+	// HTTP/1.0 不支持 chunk 编码
+	canChunk := cw.resp.req.ProtoAtLeast(1, 1)
+
+	// 如果已经设置了响应传递方式
+	if header.Get("Content-Length") != "" {
+		return
+	}
+	if header.Get("Transfer-Encoding") == "chunked" {
+		if canChunk {
 			cw.resp.chunking = true
-			header.Set("Transfer-Encoding", "chunked")
+			return
 		}
+		header.Del("Transfer-Encoding")
+	}
+	if header.Get("Transfer-Encoding") != "" {
 		return
 	}
 
-	// 如果已经设置了响应传递方式
-	if header.Get("Transfer-Encoding") == "chunked" {
+	// 如果未设置响应传递方式
+	// case 1: conn连接已经结束，此时需要chunkWriter确定发送报文，由于缓存大小为4kb，如不连接结束之前没有发送报文，那么在结束之后还有缓存的数据没发送，其小于4kb，并且是第一次发送
+	if cw.resp.handlerDone {
+		buffered := cw.resp.bufw.Buffered()
+		header.Set("Content-Length", strconv.Itoa(buffered))
+		return
+	}
+
+	// case 2： conn 没有结束时，需要写入，说明resp的缓存流的数据超过4kb需要发送，可能还有数据需要发送，将编码改成 chunked
+	if canChunk {
 		cw.resp.chunking = true
+		header.Set("Transfer-Encoding", "chunked")
+		return
+	}
+
+	// case 3: HTTP/1.0 无法使用 chunk 编码，只能在发送完毕后关闭连接，让客户端读到 EOF 作为报文结束
+	cw.resp.closeAfterReply = true
+}
+
+// finalizeConnection 根据连接是否复用设置 Connection 以及 Keep-Alive 首部
+func (cw *chunkWriter) finalizeConnection() {
+	header := cw.resp.header
+
+	// 回复完毕后会关闭连接，告知客户端不要在该连接上继续发送请求
+	if cw.resp.closeAfterReply {
+		header.Set("Connection", "close")
+		header.Del("Keep-Alive")
+		return
+	}
+
+	// HTTP/1.1 默认复用连接，HTTP/1.0 则需要显式告知客户端
+	if cw.resp.req.ProtoAtLeast(1, 1) {
+		return
+	}
+
+	header.Set("Connection", "keep-alive")
+	if params := cw.resp.keepAliveParams(); params != "" {
+		header.Set("Keep-Alive", params)
 	}
 }
 
@@ -187,21 +288,33 @@ func (cw *chunkWriter) finalizeHeader(p []byte) {
 func (cw *chunkWriter) writeHeader() {
 
 	// 写入状态行
-	bufw := cw.resp.c.bufw
+	bufw := cw.resp.out
 
-	bufw.WriteString(cw.resp.req.Proto)
-	bufw.WriteByte(' ')
+	// HTTP/1.0 的请求以 HTTP/1.0 回复，其余都以 HTTP/1.1 回复
+	if cw.resp.req.ProtoAtLeast(1, 1) {
+		bufw.WriteString("HTTP/1.1 ")
+	} else {
+		bufw.WriteString("HTTP/1.0 ")
+	}
 	bufw.Write(strconv.AppendInt([]byte{}, int64(cw.resp.statusCode), 10))
 	bufw.WriteByte(' ')
 	bufw.WriteString(http.StatusText(cw.resp.statusCode))
 	bufw.Write(crlf)
 
-	// header 写入
-	for k, v := range cw.resp.header {
-		bufw.WriteString(k)
-		bufw.WriteString(": ")
-		bufw.WriteString(v[0])
-		bufw.Write(crlf)
+	// header 按照首部名排序写入，保证每次响应的字节相同
+	keys := make([]string, 0, len(cw.resp.header))
+	for k := range cw.resp.header {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	for _, k := range keys {
+		for _, v := range cw.resp.header[k] {
+			bufw.WriteString(k)
+			bufw.WriteString(": ")
+			bufw.WriteString(v)
+			bufw.Write(crlf)
+		}
 	}
 
 	// 首部字段分隔符
@@ -0,0 +1,179 @@
+package httptoy
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"testing"
+)
+
+// conformance_test.go 以原始报文检查 HTTP/1.1 (RFC 7230) 的解析以及回复，比较完整的响应字节
+
+// conformanceHandler 将解析到的请求写回，便于比较
+func conformanceHandler(rw ResponseWriter, req *Request) {
+	b, err := io.ReadAll(req.Body)
+	rw.Header().Set("Content-Type", "text/plain")
+	fmt.Fprintf(rw, "%s %s %s host=%s body=%q err=%v trailer=%v",
+		req.Method, req.URL.Path, req.Proto, req.Header.Get("Host"), b, err, req.Trailer)
+}
+
+// okResp 是 conformanceHandler 的响应，connection 非空时带有 Connection 首部
+func okResp(proto, connection, body string) string {
+	var sb strings.Builder
+	fmt.Fprintf(&sb, "%s 200 OK\r\n", proto)
+	if connection != "" {
+		fmt.Fprintf(&sb, "Connection: %s\r\n", connection)
+	}
+	fmt.Fprintf(&sb, "Content-Length: %d\r\nContent-Type: text/plain\r\n\r\n%s", len(body), body)
+
+	return sb.String()
+}
+
+// errResp 是 conn.replyError 的响应
+func errResp(code int) string {
+	body := fmt.Sprintf("%d %s", code, http.StatusText(code))
+	return fmt.Sprintf("HTTP/1.1 %s\r\nConnection: close\r\nContent-Length: %d\r\nContent-Type: text/plain; charset=utf-8\r\n\r\n%s", body, len(body), body)
+}
+
+func TestConformance(t *testing.T) {
+	tests := []struct {
+		name string
+		raw  string
+		want string
+	}{
+		// 请求行
+		{
+			"leading empty lines",
+			"\r\n\r\nGET /a HTTP/1.1\r\nConnection: close\r\n\r\n",
+			okResp("HTTP/1.1", "close", `GET /a HTTP/1.1 host= body="" err=<nil> trailer=map[]`),
+		},
+		{
+			"extension method",
+			"PURGE /cache HTTP/1.1\r\nConnection: close\r\n\r\n",
+			okResp("HTTP/1.1", "close", `PURGE /cache HTTP/1.1 host= body="" err=<nil> trailer=map[]`),
+		},
+		{"missing version", "GET /a\r\n\r\n", errResp(400)},
+		{"double space", "GET  /a HTTP/1.1\r\n\r\n", errResp(400)},
+		{"trailing space", "GET /a HTTP/1.1 \r\n\r\n", errResp(400)},
+		{"invalid method", "G(T /a HTTP/1.1\r\n\r\n", errResp(400)},
+		{"lowercase version", "GET /a http/1.1\r\n\r\n", errResp(400)},
+		{"relative target", "GET a HTTP/1.1\r\n\r\n", errResp(400)},
+		{"HTTP/2.0", "GET /a HTTP/2.0\r\n\r\n", errResp(505)},
+		{"HTTP/0.9", "GET /a HTTP/0.9\r\n\r\n", errResp(505)},
+
+		// 请求目标的形式
+		{
+			"absolute-form",
+			"GET http://example.com:8080/a?q=1 HTTP/1.1\r\nHost: other\r\nConnection: close\r\n\r\n",
+			okResp("HTTP/1.1", "close", `GET /a HTTP/1.1 host=example.com:8080 body="" err=<nil> trailer=map[]`),
+		},
+		{
+			"asterisk-form",
+			"OPTIONS * HTTP/1.1\r\nHost: a\r\nConnection: close\r\n\r\n",
+			okResp("HTTP/1.1", "close", `OPTIONS * HTTP/1.1 host=a body="" err=<nil> trailer=map[]`),
+		},
+		{"asterisk-form with GET", "GET * HTTP/1.1\r\n\r\n", errResp(400)},
+
+		// 首部
+		{
+			"obs-fold",
+			"GET /a HTTP/1.1\r\nHost: a\r\n  .b\r\n\t.c\r\nConnection: close\r\n\r\n",
+			okResp("HTTP/1.1", "close", `GET /a HTTP/1.1 host=a .b .c body="" err=<nil> trailer=map[]`),
+		},
+		{
+			"lowercase name and empty value",
+			"GET /a HTTP/1.1\r\nhost:a\r\nX-Empty:\r\nconnection: close\r\n\r\n",
+			okResp("HTTP/1.1", "close", `GET /a HTTP/1.1 host=a body="" err=<nil> trailer=map[]`),
+		},
+		{"fold before first header", "GET /a HTTP/1.1\r\n Host: a\r\n\r\n", errResp(400)},
+		{"space before colon", "GET /a HTTP/1.1\r\nHost : a\r\n\r\n", errResp(400)},
+		{"missing colon", "GET /a HTTP/1.1\r\nHost\r\n\r\n", errResp(400)},
+		{"duplicate Host", "GET /a HTTP/1.1\r\nHost: a\r\nHost: b\r\n\r\n", errResp(400)},
+
+		// 报文主体
+		{
+			"content-length",
+			"POST /a HTTP/1.1\r\nContent-Length: 5\r\nConnection: close\r\n\r\nhello",
+			okResp("HTTP/1.1", "close", `POST /a HTTP/1.1 host= body="hello" err=<nil> trailer=map[]`),
+		},
+		{
+			"body on GET",
+			"GET /a HTTP/1.1\r\nContent-Length: 2\r\n\r\nhiGET /b HTTP/1.1\r\nConnection: close\r\n\r\n",
+			okResp("HTTP/1.1", "", `GET /a HTTP/1.1 host= body="hi" err=<nil> trailer=map[]`) +
+				okResp("HTTP/1.1", "close", `GET /b HTTP/1.1 host= body="" err=<nil> trailer=map[]`),
+		},
+		{
+			"repeated equal content-length",
+			"POST /a HTTP/1.1\r\nContent-Length: 2\r\nContent-Length: 2\r\nConnection: close\r\n\r\nhi",
+			okResp("HTTP/1.1", "close", `POST /a HTTP/1.1 host= body="hi" err=<nil> trailer=map[]`),
+		},
+		{"conflicting content-length", "POST /a HTTP/1.1\r\nContent-Length: 2\r\nContent-Length: 3\r\n\r\nhi", errResp(400)},
+		{"negative content-length", "POST /a HTTP/1.1\r\nContent-Length: -1\r\n\r\n", errResp(400)},
+		{"signed content-length", "POST /a HTTP/1.1\r\nContent-Length: +2\r\n\r\nhi", errResp(400)},
+		{
+			"chunked without content-length",
+			"POST /a HTTP/1.1\r\nTransfer-Encoding: chunked\r\nConnection: close\r\n\r\n5\r\nhello\r\n0\r\n\r\n",
+			okResp("HTTP/1.1", "close", `POST /a HTTP/1.1 host= body="hello" err=<nil> trailer=map[]`),
+		},
+		{
+			"chunked overrides content-length",
+			"POST /a HTTP/1.1\r\nContent-Length: 100\r\nTransfer-Encoding: chunked\r\n\r\n2\r\nhi\r\n0\r\n\r\nGET /b HTTP/1.1\r\nConnection: close\r\n\r\n",
+			okResp("HTTP/1.1", "", `POST /a HTTP/1.1 host= body="hi" err=<nil> trailer=map[]`) +
+				okResp("HTTP/1.1", "close", `GET /b HTTP/1.1 host= body="" err=<nil> trailer=map[]`),
+		},
+		{
+			"chunk extensions and uppercase hex",
+			"POST /a HTTP/1.1\r\nTransfer-Encoding: Chunked\r\nConnection: close\r\n\r\nA;name=value\r\n0123456789\r\n1 ; x\r\n!\r\n0;last\r\n\r\n",
+			okResp("HTTP/1.1", "close", `POST /a HTTP/1.1 host= body="0123456789!" err=<nil> trailer=map[]`),
+		},
+		{
+			"chunked trailer",
+			"POST /a HTTP/1.1\r\nTransfer-Encoding: chunked\r\nConnection: close\r\n\r\n2\r\nhi\r\n0\r\nx-checksum: 1234\r\nX-Other: a\r\n\r\n",
+			okResp("HTTP/1.1", "close", `POST /a HTTP/1.1 host= body="hi" err=<nil> trailer=map[X-Checksum:[1234] X-Other:[a]]`),
+		},
+		{"gzip before chunked", "POST /a HTTP/1.1\r\nTransfer-Encoding: gzip, chunked\r\n\r\n0\r\n\r\n", errResp(501)},
+		{"chunked not final", "POST /a HTTP/1.1\r\nTransfer-Encoding: chunked, gzip\r\n\r\n0\r\n\r\n", errResp(400)},
+		{"unknown coding", "POST /a HTTP/1.1\r\nTransfer-Encoding: identity\r\n\r\n", errResp(400)},
+
+		// Expect
+		{
+			"expect 100-continue",
+			"POST /a HTTP/1.1\r\nExpect: 100-Continue\r\nContent-Length: 2\r\nConnection: close\r\n\r\nhi",
+			"HTTP/1.1 100 Continue\r\n\r\n" + okResp("HTTP/1.1", "close", `POST /a HTTP/1.1 host= body="hi" err=<nil> trailer=map[]`),
+		},
+		{
+			"expect 100-continue on HTTP/1.0",
+			"POST /a HTTP/1.0\r\nExpect: 100-continue\r\nContent-Length: 2\r\n\r\nhi",
+			okResp("HTTP/1.0", "close", `POST /a HTTP/1.0 host= body="hi" err=<nil> trailer=map[]`),
+		},
+		{"unknown expectation", "POST /a HTTP/1.1\r\nExpect: 200-ok\r\nContent-Length: 2\r\n\r\nhi", errResp(417)},
+
+		// 连接复用
+		{
+			"keep-alive then close",
+			"GET /a HTTP/1.1\r\n\r\nGET /b HTTP/1.1\r\nConnection: close\r\n\r\nGET /c HTTP/1.1\r\n\r\n",
+			okResp("HTTP/1.1", "", `GET /a HTTP/1.1 host= body="" err=<nil> trailer=map[]`) +
+				okResp("HTTP/1.1", "close", `GET /b HTTP/1.1 host= body="" err=<nil> trailer=map[]`),
+		},
+		{
+			"HTTP/1.0 keep-alive",
+			"GET /a HTTP/1.0\r\nConnection: keep-alive\r\n\r\nGET /b HTTP/1.0\r\n\r\n",
+			okResp("HTTP/1.0", "keep-alive", `GET /a HTTP/1.0 host= body="" err=<nil> trailer=map[]`) +
+				okResp("HTTP/1.0", "close", `GET /b HTTP/1.0 host= body="" err=<nil> trailer=map[]`),
+		},
+		{
+			"bad request after good request",
+			"GET /a HTTP/1.1\r\n\r\nBAD\r\n\r\n",
+			okResp("HTTP/1.1", "", `GET /a HTTP/1.1 host= body="" err=<nil> trailer=map[]`) + errResp(400),
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := serveRaw(t, testHandler(conformanceHandler), tt.raw); got != tt.want {
+				t.Errorf("\n got %q\nwant %q", got, tt.want)
+			}
+		})
+	}
+}
@@ -2,28 +2,56 @@ package httptoy
 
 import (
 	"bufio"
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
 	"io"
-	"io/ioutil"
 	"log"
 	"net"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
 )
 
 // handleError 处理 http 连接出现错误
+// 客户端关闭连接属于正常情况，不需要记录
 func handleError(err error, c *conn) {
-	log.Fatalf("http conn encounter err:%v", err)
+	if err == io.EOF || errors.Is(err, net.ErrClosed) {
+		return
+	}
+
+	// 长连接等待下一个请求超时
+	if ne, ok := err.(net.Error); ok && ne.Timeout() {
+		return
+	}
+
+	// 不符合协议的请求已经回复了客户端
+	if _, ok := err.(*badRequestError); ok {
+		return
+	}
+
+	log.Printf("http conn encounter err:%v", err)
 }
 
 // newConn 创建 http.conn
 func newConn(rwc net.Conn, svr *Server) *conn {
 	// 由于一个正常报文请求不会超过1mb，因此防止恶意连接，限制每次连接至多读取1mb请求报文
-	lr := &io.LimitedReader{R: rwc, N: 1 << 20} // 限制每次conn至多读取 1mb
+	var r io.Reader = rwc
+	var w io.Writer = rwc
+	if m := svr.Metrics; m != nil {
+		r, w = meteredReader{rwc, m}, meteredWriter{rwc, m}
+	}
+	lr := &io.LimitedReader{R: r, N: 1 << 20} // 限制每次conn至多读取 1mb
 
 	return &conn{
-		svr:  svr,
-		rwc:  rwc,
-		lr:   lr,
-		bufr: bufio.NewReaderSize(lr, 4<<10),  // 4kb 的读取缓冲
-		bufw: bufio.NewWriterSize(rwc, 4<<10), // 4kb 的写入缓冲
+		svr:     svr,
+		rwc:     rwc,
+		lr:      lr,
+		bufr:    bufio.NewReaderSize(lr, 4<<10), // 4kb 的读取缓冲
+		bufw:    bufio.NewWriterSize(w, 4<<10),  // 4kb 的写入缓冲
+		created: time.Now(),
 	}
 }
 
@@ -35,6 +63,125 @@ type conn struct {
 	lr   *io.LimitedReader // 限制读取
 	bufr *bufio.Reader     // 缓冲读取
 	bufw *bufio.Writer     //优化连接，能进行缓冲写入
+
+	pending  []*pipelined    // 并发执行中的流水线请求，按照请求顺序排列
+	requests int             // 连接已经读取的请求数
+	state    ConnState       // 连接的状态
+	hijacked bool            // handler 调用了 Hijack，连接交由 handler 管理
+	ctx      context.Context // 连接的 context，由 Server.BaseContext 以及 Server.ConnContext 创建
+	created  time.Time       // 建立连接的时间
+
+	// mu 保护 state 以及 requests 的写入，Server.Conns 在其他协程读取
+	// 只有服务连接的协程会修改两者，该协程自己读取时不需要加锁
+	mu sync.Mutex
+}
+
+// ConnState 是连接的状态，状态改变时调用 Server.ConnState
+type ConnState int
+
+const (
+	// StateNew 是刚建立、还没有读到请求的连接
+	StateNew ConnState = iota
+	// StateActive 是读到了请求，正在执行 handler 或者写回响应的连接
+	// HTTP/2 的连接一直处于 StateActive
+	StateActive
+	// StateIdle 是回复完毕，等待下一个请求的长连接
+	StateIdle
+	// StateHijacked 是调用了 Hijack 的连接，是最终状态，不会再变为 StateClosed
+	StateHijacked
+	// StateClosed 是已经关闭的连接，是最终状态
+	StateClosed
+)
+
+var stateName = map[ConnState]string{
+	StateNew:      "new",
+	StateActive:   "active",
+	StateIdle:     "idle",
+	StateHijacked: "hijacked",
+	StateClosed:   "closed",
+}
+
+func (s ConnState) String() string {
+	return stateName[s]
+}
+
+// meteredReader 将从连接读取的字节数记录到 ServerMetrics
+type meteredReader struct {
+	r io.Reader
+	m ServerMetrics
+}
+
+func (mr meteredReader) Read(p []byte) (int, error) {
+	n, err := mr.r.Read(p)
+	if n > 0 {
+		mr.m.BytesRead(int64(n))
+	}
+	return n, err
+}
+
+// meteredWriter 将写入连接的字节数记录到 ServerMetrics
+type meteredWriter struct {
+	w io.Writer
+	m ServerMetrics
+}
+
+func (mw meteredWriter) Write(p []byte) (int, error) {
+	n, err := mw.w.Write(p)
+	if n > 0 {
+		mw.m.BytesWritten(int64(n))
+	}
+	return n, err
+}
+
+// setState 记录连接的状态，调用 Server.ConnState 并通知 Server.Metrics
+// Server.Metrics 中 StateNew 跟 StateIdle 一样属于空闲的连接
+func (c *conn) setState(state ConnState) {
+	c.mu.Lock()
+	old := c.state
+	c.state = state
+	c.mu.Unlock()
+
+	if m := c.svr.Metrics; m != nil {
+		switch {
+		case state == StateNew:
+			m.ConnOpened()
+		case state == StateActive:
+			m.ConnActive()
+		case state == StateIdle && old == StateActive:
+			m.ConnIdle()
+		case state == StateHijacked || state == StateClosed:
+			m.ConnClosed(old != StateActive)
+		}
+	}
+
+	if hook := c.svr.ConnState; hook != nil {
+		hook(c.rwc, state)
+	}
+}
+
+// countRequest 记录连接读取了一个请求，HTTP/2 连接上每个流算作一个请求
+func (c *conn) countRequest() {
+	c.mu.Lock()
+	c.requests++
+	c.mu.Unlock()
+}
+
+// parseErrorKind 返回 ServerMetrics.ParseError 的 kind，客户端关闭连接以及等待超时不属于解析错误，返回空串
+func parseErrorKind(err error) string {
+	if e, ok := err.(*badRequestError); ok {
+		return strconv.Itoa(e.code)
+	}
+	if err == io.EOF || errors.Is(err, net.ErrClosed) {
+		return ""
+	}
+	if ne, ok := err.(net.Error); ok && ne.Timeout() {
+		return ""
+	}
+	if err == io.ErrUnexpectedEOF {
+		return "incomplete"
+	}
+
+	return "other"
 }
 
 // readRequest 读取请求
@@ -48,11 +195,31 @@ func (c *conn) setupResponse(req *Request) *Response {
 	return setupResponse(c, req)
 }
 
+// replyError 对不符合协议的请求回复对应的状态码，之后连接会被关闭
+// 其余错误（E.g. 客户端关闭连接、读取超时）不回复
+func (c *conn) replyError(err error) {
+	e, ok := err.(*badRequestError)
+	if !ok {
+		return
+	}
+
+	body := fmt.Sprintf("%d %s", e.code, http.StatusText(e.code))
+	fmt.Fprintf(c.bufw, "HTTP/1.1 %s\r\nConnection: close\r\nContent-Length: %d\r\nContent-Type: text/plain; charset=utf-8\r\n\r\n%s",
+		body, len(body), body)
+	c.bufw.Flush()
+}
+
 // close 关闭 tcp 连接
 func (c *conn) close() {
 	c.rwc.Close()
 }
 
+// maxDrainBody 是 finishRequest 帮 handler 丢弃未读完的报文主体的上限，超过则回复完毕后关闭连接
+const maxDrainBody = 256 << 10
+
+// defaultPipelineDepth 是 Server.MaxPipelineDepth 未设置时，单个连接上并发执行的流水线请求上限
+const defaultPipelineDepth = 16
+
 // finishRequest 处理 Request 缓冲Reader 跟 Writer的资源过剩, 写完与读完, 将在 serve 最后调用
 func (c *conn) finishRequest(req *Request, resp *Response) (err error) {
 	// 将可能保存的临时文件删除
@@ -61,59 +228,215 @@ func (c *conn) finishRequest(req *Request, resp *Response) (err error) {
 	}
 
 	resp.handlerDone = true // 记录 handler 结束flag
+
+	// 先消费完Body剩余的数据，再发送响应
+	// r.Body 未读完的数据会被当成下一个流水线请求解析，因此在回复之前将其丢弃
+	// 如果剩余数据过多，或者客户端还在等待 100 continue，则不再读取，回复完毕后关闭连接
+	if !req.discardBody() {
+		resp.closeAfterReply = true
+	}
+
 	// 将缓冲输出流中的剩余数据发送, resp的输出根据情况设置 chunk写入还是一次性
 	if err = resp.bufw.Flush(); err != nil {
 		return err
 	}
 
-	if resp.chunking {
-		_, err = c.bufw.WriteString("0\r\n\r\n")
+	// handler 没有写入任何数据时 bufw.Flush 不会调用 chunkWriter，需要手动发送首部
+	if !resp.cw.wrote {
+		if _, err = resp.cw.Write(nil); err != nil {
+			return err
+		}
+	}
+
+	// HEAD 请求以及不允许报文主体的响应不发送 chunk 的终止块
+	if resp.chunking && resp.sendBody() {
+		_, err = resp.out.WriteString("0\r\n\r\n")
 		if err != nil {
 			return err
 		}
 	}
 
-	if err = c.bufw.Flush(); err != nil {
-		return err
+	return resp.out.Flush()
+}
+
+// pipelined 记录一个并发执行中的流水线请求
+type pipelined struct {
+	resp *Response
+	buf  bytes.Buffer  // 响应报文的私有缓冲，轮到该请求时再写入连接
+	done chan struct{} // handler 以及 finishRequest 执行完毕时关闭
+	err  error
+}
+
+// pipelinable 判断请求能否并发执行
+// 需要开启 Server.ConcurrentPipeline，请求没有报文主体（不占用连接的读取流），
+// 并且读取缓冲中已经有下一个流水线请求的数据
+func (c *conn) pipelinable(req *Request, resp *Response) bool {
+	if !c.svr.ConcurrentPipeline || resp.closeAfterReply || c.bufr.Buffered() == 0 {
+		return false
 	}
 
-	// 消费完Body剩余的数据
-	// 同样的，r.Body 可能存在未读完的资源导致 conn 不能关闭
-	// 因此使用 io.Copy 将 r.Body 全部读取出来， ioutil.Discard 只会读取不做其他事
-	// 待将剩余的资源读完，则可以释放 r.Body
-	_, err = io.Copy(ioutil.Discard, req.Body)
+	_, ok := req.Body.(*eofReader)
+	return ok
+}
+
+// startPipelined 开启协程执行 handler，响应写入私有缓冲，由 flushPipeline 按顺序写回
+func (c *conn) startPipelined(req *Request, resp *Response) {
+	p := &pipelined{resp: resp, done: make(chan struct{})}
+	resp.out = bufio.NewWriter(&p.buf)
+	c.pending = append(c.pending, p)
+
+	go func() {
+		defer close(p.done)
+		defer func() {
+			if err := recover(); err != nil {
+				p.err = fmt.Errorf("panic serving %v: %v", c.rwc.RemoteAddr(), err)
+			}
+		}()
+
+		defer req.cancel()
 
-	return err
+		c.svr.Handler.ServeHTTP(resp, req)
+		p.err = c.finishRequest(req, resp)
+	}()
+}
+
+// flushPipeline 按照请求的顺序等待并写回所有并发执行的流水线响应，返回连接能否继续复用
+// 某个响应出错或者需要关闭连接时，其后的响应全部丢弃
+func (c *conn) flushPipeline() bool {
+	ok := true
+	for _, p := range c.pending {
+		<-p.done
+
+		if !ok {
+			continue
+		}
+
+		if p.err != nil {
+			log.Printf("http conn encounter err:%v", p.err)
+			ok = false
+			continue
+		}
+
+		if _, err := c.bufw.Write(p.buf.Bytes()); err != nil || p.resp.closeAfterReply {
+			ok = false
+		}
+	}
+	c.pending = c.pending[:0]
+
+	if err := c.bufw.Flush(); err != nil {
+		return false
+	}
+
+	return ok
 }
 
 // serve 模仿 http1.1 支持的 kepp-alive 长连接，该连接能读多个请求
+// 客户端可以在一个连接上流水线发送多个请求，响应一定按照请求的顺序写回
 func (c *conn) serve() {
 	// 防止 goroutine 宕机，使其恢复，并处理 http 连接错误
 	defer func() {
 		if err := recover(); err != nil {
-			log.Fatalf("panic recover, err :%v\n", err)
+			log.Printf("panic serving %v: %v\n", c.rwc.RemoteAddr(), err)
 		}
 
-		c.close()
+		// 被 Hijack 的连接由 handler 负责关闭
+		if !c.hijacked {
+			c.setState(StateClosed)
+			c.close()
+		}
+		c.svr.trackConn(c, false)
 	}()
 
+	c.setState(StateNew)
+	if c.ctx == nil {
+		c.ctx = context.Background()
+	}
+
+	depth := c.svr.MaxPipelineDepth
+	if depth <= 0 {
+		depth = defaultPipelineDepth
+	}
+
 	// for循环不退出，实现 keep-alive 长连接
 	for {
-		// 读取请求
+		// 读取缓冲中没有下一个请求时，读取会阻塞等待客户端，先将已经处理完的流水线响应写回
+		if c.bufr.Buffered() == 0 && !c.flushPipeline() {
+			break
+		}
+		// 流水线响应已经全部写回，开始等待下一个请求
+		if c.bufr.Buffered() == 0 && c.state == StateActive {
+			c.setState(StateIdle)
+		}
+
+		// 读取请求，等待请求的时间不能超过 IdleTimeout
+		if d := c.svr.IdleTimeout; d > 0 {
+			c.rwc.SetReadDeadline(time.Now().Add(d))
+		}
+
+		// 连接上的第一个请求如果是 HTTP/2 连接前言，则整个连接切换为 HTTP/2
+		if c.svr.EnableH2C && c.requests == 0 && c.isH2Preface() {
+			c.setState(StateActive)
+			c.rwc.SetReadDeadline(time.Time{})
+			c.serveH2(nil, nil)
+			return
+		}
+
 		req, err := c.readRequest()
 		if err != nil {
+			if kind := parseErrorKind(err); kind != "" && c.svr.Metrics != nil {
+				c.svr.Metrics.ParseError(kind)
+			}
+			if c.flushPipeline() {
+				c.replyError(err)
+			}
 			handleError(err, c)
 			break
 		}
+		if c.svr.IdleTimeout > 0 {
+			c.rwc.SetReadDeadline(time.Time{})
+		}
+		if c.state != StateActive {
+			c.setState(StateActive)
+		}
+
+		// h2c 升级请求需要等待之前的流水线请求全部写回，升级成功之后连接不再处理 HTTP/1 请求
+		if c.svr.EnableH2C && isH2CUpgrade(req) {
+			if !c.flushPipeline() {
+				break
+			}
+			if c.upgradeH2C(req) {
+				return
+			}
+		}
 
 		// 创建响应
 		resp := c.setupResponse(req)
 
+		// 无报文主体的流水线请求并发执行，达到上限时先等待之前的请求写回
+		if c.pipelinable(req, resp) {
+			if len(c.pending) >= depth && !c.flushPipeline() {
+				break
+			}
+
+			c.startPipelined(req, resp)
+			continue
+		}
+
+		// 其余请求需要串行处理，等待之前的流水线请求全部写回
+		if !c.flushPipeline() {
+			break
+		}
+
 		// 传入请求跟响应，执行后端服务
 		c.svr.Handler.ServeHTTP(resp, req)
+		if c.hijacked {
+			req.cancel()
+			return
+		}
 
 		// 将 tcp 连接 写完以及读完全部剩余数据, 防止资源释放失败
 		err = c.finishRequest(req, resp)
+		req.cancel()
 		// 如果出现错误，或者 响应回复完毕则退出
 		if err != nil || resp.closeAfterReply {
 			break
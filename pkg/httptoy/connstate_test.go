@@ -0,0 +1,211 @@
+package httptoy
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"io"
+	"net"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// stateRecorder 记录 Server.ConnState 的调用，连接到达最终状态时通知 done
+type stateRecorder struct {
+	mu     sync.Mutex
+	states []ConnState
+	done   chan struct{}
+}
+
+func newStateRecorder() *stateRecorder {
+	return &stateRecorder{done: make(chan struct{})}
+}
+
+func (sr *stateRecorder) hook(c net.Conn, state ConnState) {
+	sr.mu.Lock()
+	defer sr.mu.Unlock()
+
+	sr.states = append(sr.states, state)
+	if state == StateClosed || state == StateHijacked {
+		close(sr.done)
+	}
+}
+
+func (sr *stateRecorder) wait(t *testing.T) string {
+	t.Helper()
+
+	select {
+	case <-sr.done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("timed out waiting for final connection state")
+	}
+
+	sr.mu.Lock()
+	defer sr.mu.Unlock()
+	return fmt.Sprint(sr.states)
+}
+
+func TestConnState(t *testing.T) {
+	sr := newStateRecorder()
+	addr := startServer(t, &Server{Handler: testHandler(conformanceHandler), ConnState: sr.hook})
+
+	c, err := net.Dial("tcp", addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+	bufr := bufio.NewReader(c)
+
+	// 读完第一个响应之后才发送第二个请求，连接在两个请求之间处于空闲状态
+	io.WriteString(c, "GET /a HTTP/1.1\r\n\r\n")
+	want := okResp("HTTP/1.1", "", `GET /a HTTP/1.1 host= body="" err=<nil> trailer=map[]`)
+	buf := make([]byte, len(want))
+	if _, err = io.ReadFull(bufr, buf); err != nil || string(buf) != want {
+		t.Fatalf("got %q, %v", buf, err)
+	}
+
+	io.WriteString(c, "GET /b HTTP/1.1\r\nConnection: close\r\n\r\n")
+	io.ReadAll(bufr)
+
+	if got := sr.wait(t); got != "[new active idle active closed]" {
+		t.Errorf("states = %s", got)
+	}
+}
+
+func TestHijack(t *testing.T) {
+	sr := newStateRecorder()
+	h := testHandler(func(rw ResponseWriter, req *Request) {
+		rw.Header().Set("X-Ignored", "1")
+		io.WriteString(rw, "discarded")
+
+		conn, bufrw, err := NewWrapWriter(rw).Hijack()
+		if err != nil {
+			t.Error(err)
+			return
+		}
+		defer conn.Close()
+
+		if _, err = rw.Write([]byte("x")); err != ErrHijacked {
+			t.Errorf("Write after Hijack = %v", err)
+		}
+
+		// 客户端在请求之后紧接着发送的数据在读取缓冲中
+		line, _ := bufrw.ReadString('\n')
+		bufrw.WriteString("HTTP/1.1 101 Switching Protocols\r\nUpgrade: echo\r\n\r\n" + strings.ToUpper(line))
+		bufrw.Flush()
+
+		// Hijack 清除了之前设置的截止时间，之后才到达的数据依旧可以读取
+		line, err = bufrw.ReadString('\n')
+		if err != nil {
+			t.Errorf("read after deadline: %v", err)
+		}
+		bufrw.WriteString(strings.ToUpper(line))
+		bufrw.Flush()
+	})
+
+	// ConnContext 设置的截止时间在 Hijack 之前有效
+	svr := &Server{Handler: h, ConnState: sr.hook, ConnContext: func(ctx context.Context, c net.Conn) context.Context {
+		c.SetDeadline(time.Now().Add(100 * time.Millisecond))
+		return ctx
+	}}
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	go svr.Serve(l)
+	defer svr.Close()
+
+	c, err := net.Dial("tcp", l.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+
+	io.WriteString(c, "GET /ws HTTP/1.1\r\nUpgrade: echo\r\nConnection: Upgrade\r\n\r\nhello\n")
+	bufr := bufio.NewReader(c)
+	var got string
+	for !strings.HasSuffix(got, "HELLO\n") {
+		line, err := bufr.ReadString('\n')
+		if err != nil {
+			t.Fatalf("got %q: %v", got, err)
+		}
+		got += line
+	}
+
+	time.Sleep(200 * time.Millisecond)
+	io.WriteString(c, "late\n")
+	rest, _ := io.ReadAll(bufr)
+	got += string(rest)
+	if want := "HTTP/1.1 101 Switching Protocols\r\nUpgrade: echo\r\n\r\nHELLO\nLATE\n"; got != want {
+		t.Errorf("got %q, want %q", got, want)
+	}
+	if states := sr.wait(t); states != "[new active hijacked]" {
+		t.Errorf("states = %s", states)
+	}
+}
+
+func TestHijackAfterHeaderSent(t *testing.T) {
+	h := testHandler(func(rw ResponseWriter, req *Request) {
+		rw.Write(make([]byte, 8<<10)) // 超过缓冲大小，首部已经发送
+		if _, _, err := rw.(Hijacker).Hijack(); err == nil {
+			t.Error("Hijack should fail after the header was sent")
+		}
+	})
+
+	if got := serveRaw(t, h, "GET / HTTP/1.1\r\nConnection: close\r\n\r\n"); !strings.HasPrefix(got, "HTTP/1.1 200 OK\r\n") {
+		t.Errorf("got %q", got)
+	}
+}
+
+type ctxKey string
+
+func TestConnContext(t *testing.T) {
+	reqCtx := make(chan context.Context, 1)
+	svr := &Server{
+		BaseContext: func(l net.Listener) context.Context {
+			return context.WithValue(context.Background(), ctxKey("base"), l.Addr().String())
+		},
+		ConnContext: func(ctx context.Context, c net.Conn) context.Context {
+			return context.WithValue(ctx, ctxKey("conn"), c.RemoteAddr().String())
+		},
+	}
+	svr.Handler = testHandler(func(rw ResponseWriter, req *Request) {
+		ctx := req.Context()
+		reqCtx <- ctx
+		fmt.Fprintf(rw, "%v|%v|%v", ctx.Value(ctxKey("base")), ctx.Value(ctxKey("conn")), ctx.Value(ServerContextKey) == svr)
+	})
+
+	// BaseContext 以及 ConnContext 由 Serve 调用
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	go svr.Serve(l)
+	defer svr.Close()
+
+	c, err := net.Dial("tcp", l.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+
+	io.WriteString(c, "GET / HTTP/1.1\r\nConnection: close\r\n\r\n")
+	got, _ := io.ReadAll(c)
+	if want := fmt.Sprintf("%s|%s|true", l.Addr(), c.LocalAddr()); !strings.HasSuffix(string(got), "\r\n\r\n"+want) {
+		t.Errorf("got %q, want body %q", got, want)
+	}
+
+	// handler 返回之后请求的 context 被取消
+	select {
+	case <-(<-reqCtx).Done():
+	case <-time.After(5 * time.Second):
+		t.Error("request context not canceled")
+	}
+
+	req := (&Request{}).WithContext(context.WithValue(context.Background(), ctxKey("k"), "v"))
+	if req.Context().Value(ctxKey("k")) != "v" || (&Request{}).Context() == nil {
+		t.Error("WithContext")
+	}
+}
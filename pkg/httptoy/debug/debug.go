@@ -0,0 +1,212 @@
+// Package debug 提供挂载到 ServeMux 上的调试 handler，用于查看运行中的服务端内部的状态
+//
+// E.g.
+//
+//	mux := httptoy.NewServeMux()
+//	svr := &httptoy.Server{Addr: ":8080", Handler: mux}
+//	debug.Register(mux, "/debug", svr)
+//
+// 注册的路由：
+//
+//	/debug                  调试路由的列表
+//	/debug/routes           ServeMux 注册的路由
+//	/debug/conns            正在服务的连接，包括对端地址、状态、请求数以及存活的时间
+//	/debug/config           Server 的配置
+//	/debug/vars             expvar 发布的变量以及各个状态的连接数
+//	/debug/pprof/goroutine  goroutine profile
+//	/debug/pprof/heap       heap profile
+//
+// 调试信息包含服务端的内部细节，不应该暴露在公网上，可以挂载到只监听本地地址的 Server
+package debug
+
+import (
+	"encoding/json"
+	"expvar"
+	"fmt"
+	"runtime"
+	"runtime/pprof"
+	"strconv"
+	"time"
+
+	"build-HTTP-from-scracth/pkg/httptoy"
+)
+
+// Handlers 是一组调试 handler
+type Handlers struct {
+	Server *httptoy.Server   // 展示连接以及配置的 Server，为 nil 时对应的路由回复 404
+	Mux    *httptoy.ServeMux // 展示路由的 ServeMux，为 nil 时对应的路由回复 404
+}
+
+// profiles 是注册的 pprof profile
+var profiles = []string{"goroutine", "heap"}
+
+// Register 将调试 handler 挂载到 mux 的 prefix 之下，展示 svr 以及 mux 本身
+func Register(mux *httptoy.ServeMux, prefix string, svr *httptoy.Server) {
+	h := &Handlers{Server: svr, Mux: mux}
+	h.Register(mux, prefix)
+}
+
+// Register 将调试 handler 挂载到 mux 的 prefix 之下，E.g. prefix 为 /debug 时注册 /debug/conns
+func (h *Handlers) Register(mux *httptoy.ServeMux, prefix string) {
+	mux.HandleFunc(prefix, index(prefix))
+	mux.HandleFunc(prefix+"/routes", h.Routes)
+	mux.HandleFunc(prefix+"/conns", h.Conns)
+	mux.HandleFunc(prefix+"/config", h.Config)
+	mux.HandleFunc(prefix+"/vars", h.Vars)
+	for _, name := range profiles {
+		mux.Handle(prefix+"/pprof/"+name, Profile(name))
+	}
+}
+
+// index 以纯文本列出调试路由
+func index(prefix string) httptoy.HandlerFunc {
+	return func(rw httptoy.ResponseWriter, req *httptoy.Request) {
+		rw.Header().Set("Content-Type", "text/plain; charset=utf-8")
+
+		paths := []string{"/routes", "/conns", "/config", "/vars"}
+		for _, name := range profiles {
+			paths = append(paths, "/pprof/"+name)
+		}
+		for _, p := range paths {
+			fmt.Fprintln(rw, prefix+p)
+		}
+	}
+}
+
+// Routes 以 JSON 数组回复 Mux 注册的路由，按照字典序排列
+func (h *Handlers) Routes(rw httptoy.ResponseWriter, req *httptoy.Request) {
+	if h.Mux == nil {
+		rw.WriteHeader(404)
+		return
+	}
+
+	httptoy.WriteJSON(rw, 200, h.Mux.Patterns())
+}
+
+// connInfo 是 /conns 中的一个连接
+type connInfo struct {
+	RemoteAddr string    `json:"remote_addr"`
+	LocalAddr  string    `json:"local_addr"`
+	State      string    `json:"state"`
+	Requests   int       `json:"requests"`
+	Created    time.Time `json:"created"`
+	Age        string    `json:"age"` // E.g. 1m30.5s
+}
+
+// Conns 以 JSON 数组回复 Server 正在服务的连接，按照建立的时间排序
+func (h *Handlers) Conns(rw httptoy.ResponseWriter, req *httptoy.Request) {
+	if h.Server == nil {
+		rw.WriteHeader(404)
+		return
+	}
+
+	now := time.Now()
+	conns := h.Server.Conns()
+	infos := make([]connInfo, len(conns))
+	for i, c := range conns {
+		infos[i] = connInfo{
+			RemoteAddr: c.RemoteAddr.String(),
+			LocalAddr:  c.LocalAddr.String(),
+			State:      c.State.String(),
+			Requests:   c.Requests,
+			Created:    c.Created,
+			Age:        now.Sub(c.Created).Round(time.Millisecond).String(),
+		}
+	}
+
+	httptoy.WriteJSON(rw, 200, infos)
+}
+
+// config 是 /config 回复的 Server 配置，回调函数只展示是否设置
+type config struct {
+	Addr                 string   `json:"addr"`
+	Listeners            []string `json:"listeners"`
+	Handler              string   `json:"handler"` // handler 的类型 E.g. *httptoy.ServeMux
+	ConcurrentPipeline   bool     `json:"concurrent_pipeline"`
+	MaxPipelineDepth     int      `json:"max_pipeline_depth"`
+	IdleTimeout          string   `json:"idle_timeout"`
+	MaxRequestsPerConn   int      `json:"max_requests_per_conn"`
+	EnableH2C            bool     `json:"enable_h2c"`
+	MaxConcurrentStreams uint32   `json:"max_concurrent_streams"`
+	Metrics              bool     `json:"metrics"`
+	ConnState            bool     `json:"conn_state"`
+	BaseContext          bool     `json:"base_context"`
+	ConnContext          bool     `json:"conn_context"`
+}
+
+// Config 以 JSON 对象回复 Server 的配置以及正在监听的地址，未设置的字段为零值，不是生效的默认值
+func (h *Handlers) Config(rw httptoy.ResponseWriter, req *httptoy.Request) {
+	svr := h.Server
+	if svr == nil {
+		rw.WriteHeader(404)
+		return
+	}
+
+	listeners := []string{}
+	for _, addr := range svr.Addrs() {
+		listeners = append(listeners, addr.String())
+	}
+
+	httptoy.WriteJSON(rw, 200, config{
+		Addr:                 svr.Addr,
+		Listeners:            listeners,
+		Handler:              fmt.Sprintf("%T", svr.Handler),
+		ConcurrentPipeline:   svr.ConcurrentPipeline,
+		MaxPipelineDepth:     svr.MaxPipelineDepth,
+		IdleTimeout:          svr.IdleTimeout.String(),
+		MaxRequestsPerConn:   svr.MaxRequestsPerConn,
+		EnableH2C:            svr.EnableH2C,
+		MaxConcurrentStreams: svr.MaxConcurrentStreams,
+		Metrics:              svr.Metrics != nil,
+		ConnState:            svr.ConnState != nil,
+		BaseContext:          svr.BaseContext != nil,
+		ConnContext:          svr.ConnContext != nil,
+	})
+}
+
+// Vars 以 JSON 对象回复 expvar 发布的全部变量，跟标准库 /debug/vars 的格式相同
+// Server 不为 nil 时额外包含 httptoy.conns，即各个状态的连接数以及这些连接读取的请求总数
+func (h *Handlers) Vars(rw httptoy.ResponseWriter, req *httptoy.Request) {
+	vars := make(map[string]json.RawMessage)
+	expvar.Do(func(kv expvar.KeyValue) {
+		vars[kv.Key] = json.RawMessage(kv.Value.String())
+	})
+
+	if h.Server != nil {
+		counts := map[string]int{"new": 0, "active": 0, "idle": 0, "requests": 0}
+		for _, c := range h.Server.Conns() {
+			counts[c.State.String()]++
+			counts["requests"] += c.Requests
+		}
+		b, _ := json.Marshal(counts)
+		vars["httptoy.conns"] = b
+	}
+
+	httptoy.WriteJSON(rw, 200, vars)
+}
+
+// Profile 返回输出 runtime/pprof 中名为 name 的 profile 的 handler，profile 不存在时回复 404
+// 默认输出 go tool pprof 使用的二进制格式，?debug=1 或者 ?debug=2 时输出文本
+// heap profile 带有 ?gc=1 时先执行一次 GC，统计最新的存活对象
+func Profile(name string) httptoy.Handler {
+	return httptoy.HandlerFunc(func(rw httptoy.ResponseWriter, req *httptoy.Request) {
+		p := pprof.Lookup(name)
+		if p == nil {
+			rw.WriteHeader(404)
+			return
+		}
+
+		debug, _ := strconv.Atoi(req.Query("debug"))
+		if name == "heap" && req.Query("gc") != "" {
+			runtime.GC()
+		}
+
+		if debug > 0 {
+			rw.Header().Set("Content-Type", "text/plain; charset=utf-8")
+		} else {
+			rw.Header().Set("Content-Type", "application/octet-stream")
+			rw.Header().Set("Content-Disposition", `attachment; filename="`+name+`"`)
+		}
+		p.WriteTo(rw, debug)
+	})
+}
@@ -0,0 +1,137 @@
+package debug
+
+import (
+	"encoding/json"
+	"io"
+	"net"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"build-HTTP-from-scracth/pkg/httptoy"
+	"build-HTTP-from-scracth/pkg/httptoy/httptoytest"
+)
+
+func get(t *testing.T, url string) (*http.Response, string) {
+	t.Helper()
+
+	resp, err := http.Get(url)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return resp, string(body)
+}
+
+func TestHandlers(t *testing.T) {
+	mux := httptoy.NewServeMux()
+	mux.HandleFunc("/hello", func(rw httptoy.ResponseWriter, req *httptoy.Request) {
+		io.WriteString(rw, "hello")
+	})
+	ts := httptoytest.NewUnstartedServer(mux)
+	ts.Config.IdleTimeout = 0
+	ts.Config.MaxRequestsPerConn = 100
+	Register(mux, "/debug", ts.Config)
+	ts.Start()
+	defer ts.Close()
+
+	// 保持一个空闲的长连接
+	c, err := net.Dial("tcp", ts.Listener.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+	io.WriteString(c, "GET /hello HTTP/1.1\r\nHost: x\r\n\r\nGET /hello HTTP/1.1\r\nHost: x\r\n\r\n")
+	buf := make([]byte, 4096)
+	for n := 0; strings.Count(string(buf[:n]), "hello") < 2; {
+		m, err := c.Read(buf[n:])
+		if err != nil {
+			t.Fatal(err)
+		}
+		n += m
+	}
+
+	_, body := get(t, ts.URL+"/debug")
+	if !strings.Contains(body, "/debug/conns\n") || !strings.Contains(body, "/debug/pprof/heap\n") {
+		t.Errorf("index = %q", body)
+	}
+
+	var routes []string
+	_, body = get(t, ts.URL+"/debug/routes")
+	json.Unmarshal([]byte(body), &routes)
+	if len(routes) != 8 || routes[0] != "/debug" || routes[len(routes)-1] != "/hello" {
+		t.Errorf("routes = %v", routes)
+	}
+
+	// 空闲的长连接以及发起本次请求的连接，客户端读到响应时服务端可能还没有进入空闲状态
+	var idle *connInfo
+	for deadline := time.Now().Add(time.Second); time.Now().Before(deadline); time.Sleep(10 * time.Millisecond) {
+		var conns []connInfo
+		_, body = get(t, ts.URL+"/debug/conns")
+		if err := json.Unmarshal([]byte(body), &conns); err != nil {
+			t.Fatal(err, body)
+		}
+		for i := range conns {
+			if conns[i].RemoteAddr == c.LocalAddr().String() {
+				idle = &conns[i]
+			}
+		}
+		if idle != nil && idle.State == "idle" {
+			break
+		}
+	}
+	if idle == nil || idle.State != "idle" || idle.Requests != 2 || idle.Age == "" {
+		t.Errorf("conns = %s", body)
+	}
+
+	var cfg config
+	_, body = get(t, ts.URL+"/debug/config")
+	json.Unmarshal([]byte(body), &cfg)
+	if cfg.MaxRequestsPerConn != 100 || cfg.Handler != "*httptoy.ServeMux" ||
+		len(cfg.Listeners) != 1 || cfg.Listeners[0] != ts.Listener.Addr().String() {
+		t.Errorf("config = %s", body)
+	}
+
+	var vars map[string]json.RawMessage
+	_, body = get(t, ts.URL+"/debug/vars")
+	if err := json.Unmarshal([]byte(body), &vars); err != nil {
+		t.Fatal(err, body)
+	}
+	var counts map[string]int
+	json.Unmarshal(vars["httptoy.conns"], &counts)
+	if vars["memstats"] == nil || counts["idle"] < 1 || counts["active"] < 1 || counts["requests"] < 3 {
+		t.Errorf("vars httptoy.conns = %s", vars["httptoy.conns"])
+	}
+
+	resp, body := get(t, ts.URL+"/debug/pprof/goroutine?debug=1")
+	if !strings.HasPrefix(resp.Header.Get("Content-Type"), "text/plain") || !strings.Contains(body, "goroutine profile:") {
+		t.Errorf("goroutine profile: %s %q", resp.Header.Get("Content-Type"), body)
+	}
+	resp, body = get(t, ts.URL+"/debug/pprof/heap?gc=1")
+	if resp.Header.Get("Content-Type") != "application/octet-stream" || len(body) == 0 {
+		t.Errorf("heap profile: %s %d bytes", resp.Header.Get("Content-Type"), len(body))
+	}
+}
+
+func TestNilServer(t *testing.T) {
+	mux := httptoy.NewServeMux()
+	h := &Handlers{}
+	h.Register(mux, "/_debug")
+	ts := httptoytest.NewServer(mux)
+	defer ts.Close()
+
+	for _, p := range []string{"/_debug/routes", "/_debug/conns", "/_debug/config"} {
+		if resp, _ := get(t, ts.URL+p); resp.StatusCode != 404 {
+			t.Errorf("%s: status %d", p, resp.StatusCode)
+		}
+	}
+	if resp, _ := get(t, ts.URL+"/_debug/vars"); resp.StatusCode != 200 {
+		t.Errorf("vars: status %d", resp.StatusCode)
+	}
+}
@@ -0,0 +1,180 @@
+package httptoy
+
+import (
+	"fmt"
+	"io"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestParseQuery(t *testing.T) {
+	tests := []struct {
+		query   string
+		want    Values
+		wantErr bool
+	}{
+		{"tag=a&tag=b", Values{"tag": {"a", "b"}}, false},
+		{"name=a%20b&greet=hello+world", Values{"name": {"a b"}, "greet": {"hello world"}}, false},
+		{"k%3D1=v%26w", Values{"k=1": {"v&w"}}, false},
+		{"debug&empty=&&x=1", Values{"debug": {""}, "empty": {""}, "x": {"1"}}, false},
+		{"bad=%zz&ok=1", Values{"ok": {"1"}}, true},
+		{"", Values{}, false},
+	}
+
+	for _, tt := range tests {
+		got, err := ParseQuery(tt.query)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("ParseQuery(%q) err = %v, wantErr %v", tt.query, err, tt.wantErr)
+		}
+		if !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("ParseQuery(%q) = %v, want %v", tt.query, got, tt.want)
+		}
+	}
+}
+
+func TestValuesEncode(t *testing.T) {
+	v := make(Values)
+	v.Set("name", "a b")
+	v.Add("tag", "x&y")
+	v.Add("tag", "z")
+	v.Add("a", "1")
+
+	enc := v.Encode()
+	if want := "a=1&name=a+b&tag=x%26y&tag=z"; enc != want {
+		t.Fatalf("Encode = %q, want %q", enc, want)
+	}
+
+	got, err := ParseQuery(enc)
+	if err != nil || !reflect.DeepEqual(got, v) {
+		t.Fatalf("round trip = %v, %v, want %v", got, err, v)
+	}
+
+	v.Del("tag")
+	if v.Has("tag") || v.Get("tag") != "" || v.Get("name") != "a b" {
+		t.Fatalf("unexpected values after Del: %v", v)
+	}
+}
+
+func TestRequestForm(t *testing.T) {
+	var form, postForm, query Values
+	h := testHandler(func(rw ResponseWriter, req *Request) {
+		fmt.Fprintf(rw, "%s|%s|%s", req.FormValue("tag"), req.PostFormValue("tag"), req.Query("q"))
+		form, postForm, query = req.Form, req.PostForm, req.QueryValues()
+	})
+
+	body := "tag=post+1&tag=post%202&name=%E4%BD%A0%E5%A5%BD"
+	resp := serveRaw(t, h, "POST /?tag=query&q=a%2Bb HTTP/1.1\r\nConnection: close\r\n"+
+		"Content-Type: application/x-www-form-urlencoded\r\n"+
+		fmt.Sprintf("Content-Length: %d\r\n\r\n%s", len(body), body))
+
+	if !strings.HasSuffix(resp, "post 1|post 1|a+b") {
+		t.Fatalf("unexpected response: %q", resp)
+	}
+	if want := []string{"post 1", "post 2", "query"}; !reflect.DeepEqual(form["tag"], want) {
+		t.Errorf("Form[tag] = %q, want %q", form["tag"], want)
+	}
+	if postForm.Get("name") != "你好" || postForm.Has("q") {
+		t.Errorf("unexpected PostForm: %v", postForm)
+	}
+	if query.Get("tag") != "query" {
+		t.Errorf("unexpected query: %v", query)
+	}
+}
+
+func TestRequestFormGet(t *testing.T) {
+	var err error
+	h := testHandler(func(rw ResponseWriter, req *Request) {
+		err = req.ParseForm()
+		io.WriteString(rw, strings.Join(req.Form["id"], ","))
+	})
+
+	resp := serveRaw(t, h, "GET /?id=1&id=2 HTTP/1.1\r\nConnection: close\r\n\r\n")
+	if err != nil || !strings.HasSuffix(resp, "1,2") {
+		t.Fatalf("unexpected response: %q, err %v", resp, err)
+	}
+}
+
+func TestParseFormTwice(t *testing.T) {
+	var errs [2]error
+	var form, postForm Values
+	h := testHandler(func(rw ResponseWriter, req *Request) {
+		errs[0] = req.ParseForm()
+		errs[1] = req.ParseForm()
+		form, postForm = req.Form, req.PostForm
+	})
+
+	body := "tag=post"
+	serveRaw(t, h, "POST /?q=1 HTTP/1.1\r\nConnection: close\r\n"+
+		"Content-Type: application/x-www-form-urlencoded\r\n"+
+		fmt.Sprintf("Content-Length: %d\r\n\r\n%s", len(body), body))
+
+	if errs[0] != nil || errs[1] != nil {
+		t.Fatalf("ParseForm errors: %v", errs)
+	}
+	if postForm.Get("tag") != "post" || form.Get("tag") != "post" || form.Get("q") != "1" {
+		t.Errorf("second ParseForm replaced forms: Form %v, PostForm %v", form, postForm)
+	}
+
+	// 第一次解析的错误同样会被再次返回
+	h = testHandler(func(rw ResponseWriter, req *Request) {
+		errs[0] = req.ParseForm()
+		errs[1] = req.ParseForm()
+	})
+	serveRaw(t, h, "POST / HTTP/1.1\r\nConnection: close\r\nContent-Type: text/plain\r\nContent-Length: 1\r\n\r\nx")
+	if errs[0] != errUnsupportedForm || errs[1] != errUnsupportedForm {
+		t.Errorf("ParseForm errors: %v", errs)
+	}
+}
+
+func TestMultipartFormMultiValue(t *testing.T) {
+	body := strings.Join([]string{
+		"--xyz",
+		`Content-Disposition: form-data; name="tag"`,
+		"",
+		"a",
+		"--xyz",
+		`Content-Disposition: form-data; name="tag"`,
+		"",
+		"b",
+		"--xyz",
+		`Content-Disposition: form-data; name="files"; filename="1.txt"`,
+		"",
+		"one",
+		"--xyz",
+		`Content-Disposition: form-data; name="files"; filename="2.txt"`,
+		"",
+		"two",
+		"--xyz--",
+		"",
+	}, "\r\n")
+
+	var names, contents []string
+	var tags []string
+	h := testHandler(func(rw ResponseWriter, req *Request) {
+		if err := req.ParseForm(); err != nil {
+			t.Error(err)
+			return
+		}
+
+		tags = req.PostForm["tag"]
+		for _, fh := range req.MultipartForm.File["files"] {
+			rc, _ := fh.Open()
+			b, _ := io.ReadAll(rc)
+			rc.Close()
+			names = append(names, fh.Filename)
+			contents = append(contents, string(b))
+		}
+	})
+
+	serveRaw(t, h, "POST / HTTP/1.1\r\nConnection: close\r\n"+
+		"Content-Type: multipart/form-data; boundary=xyz\r\n"+
+		fmt.Sprintf("Content-Length: %d\r\n\r\n%s", len(body), body))
+
+	if !reflect.DeepEqual(tags, []string{"a", "b"}) {
+		t.Errorf("tags = %q", tags)
+	}
+	if !reflect.DeepEqual(names, []string{"1.txt", "2.txt"}) || !reflect.DeepEqual(contents, []string{"one", "two"}) {
+		t.Errorf("files = %q %q", names, contents)
+	}
+}
@@ -0,0 +1,260 @@
+package httptoy
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io"
+	"net"
+	"runtime"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+)
+
+// fuzz_test.go 对解析不可信输入的函数进行模糊测试，保证不会 panic、内存分配与输入大小成正比，并且编码之后能够还原
+// E.g. go test -run=^$ -fuzz=FuzzReadRequest ./pkg/httptoy
+
+// allocLimit 是解析 n 字节输入时允许分配的内存上限，包括读写缓冲等固定开销
+func allocLimit(n int) uint64 {
+	return uint64(64*n) + 256<<10
+}
+
+// checkAllocs 执行 fn，分配的内存超过 allocLimit(n) 时报错
+func checkAllocs(t *testing.T, n int, fn func()) {
+	t.Helper()
+
+	var before, after runtime.MemStats
+	runtime.ReadMemStats(&before)
+	fn()
+	runtime.ReadMemStats(&after)
+
+	if got := after.TotalAlloc - before.TotalAlloc; got > allocLimit(n) {
+		t.Fatalf("allocated %d bytes for %d bytes of input", got, n)
+	}
+}
+
+// fuzzConn 是从内存读取请求、丢弃响应的 net.Conn
+type fuzzConn struct {
+	r io.Reader
+}
+
+func (c fuzzConn) Read(p []byte) (int, error)         { return c.r.Read(p) }
+func (c fuzzConn) Write(p []byte) (int, error)        { return len(p), nil }
+func (c fuzzConn) Close() error                       { return nil }
+func (c fuzzConn) LocalAddr() net.Addr                { return &net.TCPAddr{} }
+func (c fuzzConn) RemoteAddr() net.Addr               { return &net.TCPAddr{} }
+func (c fuzzConn) SetDeadline(t time.Time) error      { return nil }
+func (c fuzzConn) SetReadDeadline(t time.Time) error  { return nil }
+func (c fuzzConn) SetWriteDeadline(t time.Time) error { return nil }
+
+// fuzzRequests 是真实客户端发送的请求，作为请求解析的种子语料
+var fuzzRequests = []string{
+	"GET / HTTP/1.1\r\nHost: 127.0.0.1:8080\r\nUser-Agent: curl/7.68.0\r\nAccept: */*\r\n\r\n",
+	"GET /index?name=gu&token=%E4%B8%AD HTTP/1.0\r\nCookie: uuid=12314753; tid=1BDB9E9; HOME=1\r\nConnection: keep-alive\r\n\r\n",
+	"POST /form HTTP/1.1\r\nHost: a\r\nContent-Type: application/x-www-form-urlencoded\r\nContent-Length: 19\r\n\r\nfoo1=bar1&foo2=bar2",
+	"POST / HTTP/1.1\r\nHost: a\r\nTransfer-Encoding: chunked\r\nContent-Length: 13\r\n\r\n17\r\nhello, this is chunked \r\nD\r\ndata sent by \r\n7\r\nclient!\r\n0\r\n\r\n",
+	"POST /upload HTTP/1.1\r\nHost: a\r\nExpect: 100-continue\r\nContent-Type: multipart/form-data; boundary=xyz\r\nContent-Length: 95\r\n\r\n" +
+		"--xyz\r\nContent-Disposition: form-data; name=\"file1\"; filename=\"1.txt\"\r\n\r\nthis is 1.txt!\r\n--xyz--\r\n",
+	"PUT /j HTTP/1.1\r\nContent-Type: application/json\r\nContent-Length: 7\r\n\r\n{\"a\":1}GET /next HTTP/1.1\r\n\r\n",
+}
+
+func FuzzReadRequest(f *testing.F) {
+	for _, s := range fuzzRequests {
+		f.Add([]byte(s))
+	}
+
+	f.Fuzz(func(t *testing.T, data []byte) {
+		checkAllocs(t, len(data), func() {
+			c := newConn(fuzzConn{bytes.NewReader(data)}, &Server{})
+
+			// 同一连接上可能有多个流水线请求
+			for i := 0; i < 8; i++ {
+				req, err := c.readRequest()
+				if err != nil {
+					return
+				}
+				if req.Method == "" || req.URL == nil || req.Header == nil || req.Body == nil {
+					t.Fatalf("incomplete request %+v", req)
+				}
+
+				req.Cookie("uuid")
+				req.Query("name")
+				req.ParseForm()
+				if !req.discardBody() {
+					return
+				}
+			}
+		})
+	})
+}
+
+func FuzzReadHeader(f *testing.F) {
+	for _, s := range fuzzRequests {
+		// 去掉请求行，只保留首部
+		_, rest, _ := strings.Cut(s, "\r\n")
+		f.Add([]byte(rest))
+	}
+	f.Add([]byte("Set-Cookie: a=1\r\nSet-Cookie: b=2\r\nEmpty:\r\nSpace: \r\n\r\n"))
+	f.Add([]byte(":no-name\r\n\r\n"))
+
+	f.Fuzz(func(t *testing.T, data []byte) {
+		var h Header
+		var err error
+		checkAllocs(t, len(data), func() {
+			h, err = readHeader(bufio.NewReader(bytes.NewReader(data)))
+		})
+		if err != nil {
+			return
+		}
+
+		// 按照解析的结果重新编码，再次解析的结果相同
+		keys := make([]string, 0, len(h))
+		for k := range h {
+			if k == "" {
+				t.Fatalf("empty header name in %q", data)
+			}
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+
+		var buf bytes.Buffer
+		for _, k := range keys {
+			for _, v := range h[k] {
+				fmt.Fprintf(&buf, "%s: %s\r\n", k, v)
+			}
+		}
+		buf.WriteString("\r\n")
+
+		h2, err := readHeader(bufio.NewReader(&buf))
+		if err != nil {
+			t.Fatalf("reparse %q: %v", buf.Bytes(), err)
+		}
+		if fmt.Sprint(h) != fmt.Sprint(h2) {
+			t.Fatalf("round trip mismatch:\n%v\n%v", h, h2)
+		}
+	})
+}
+
+// encodeChunked 以 size 字节为一块进行 chunk 编码
+func encodeChunked(data []byte, size int) []byte {
+	var buf bytes.Buffer
+	for len(data) > 0 {
+		n := size
+		if n > len(data) {
+			n = len(data)
+		}
+		fmt.Fprintf(&buf, "%x\r\n%s\r\n", n, data[:n])
+		data = data[n:]
+	}
+	buf.WriteString("0\r\n\r\n")
+
+	return buf.Bytes()
+}
+
+func FuzzChunkReader(f *testing.F) {
+	f.Add([]byte("hello, this is chunked data sent by client!"), uint16(7), uint8(3), []byte("17\r\nhello, this is chunked \r\n0\r\n\r\n"))
+	f.Add([]byte("x"), uint16(1), uint8(1), []byte("ffffffffffffffffff\r\n"))
+	f.Add([]byte{}, uint16(0), uint8(0), []byte("\r\n"))
+	// 读取的长度正好等于块的长度
+	f.Add([]byte("abcdefgh"), uint16(3), uint8(3), []byte("4\r\nabcd\r\n0\r\n\r\n"))
+
+	f.Fuzz(func(t *testing.T, data []byte, size uint16, readSize uint8, raw []byte) {
+		// 任意输入都不能 panic，解码的数据不会超过输入
+		checkAllocs(t, len(raw), func() {
+			cr := &chunkReader{bufr: bufio.NewReader(bytes.NewReader(raw))}
+			n, _ := io.Copy(io.Discard, cr)
+			if n > int64(len(raw)) {
+				t.Fatalf("decoded %d bytes from %d bytes", n, len(raw))
+			}
+		})
+
+		// 编码之后解码得到原始数据，并且正好消费完编码的数据
+		encoded := encodeChunked(data, int(size)+1)
+		bufr := bufio.NewReader(bytes.NewReader(append(encoded, "next"...)))
+		cr := &chunkReader{bufr: bufr}
+
+		var got bytes.Buffer
+		p := make([]byte, int(readSize)+1)
+		for {
+			n, err := cr.Read(p)
+			got.Write(p[:n])
+			if err == io.EOF {
+				break
+			}
+			if err != nil {
+				t.Fatalf("decode %q: %v", encoded, err)
+			}
+		}
+
+		if !bytes.Equal(got.Bytes(), data) {
+			t.Fatalf("round trip mismatch: got %q, want %q", got.Bytes(), data)
+		}
+		if rest, _ := io.ReadAll(bufr); string(rest) != "next" {
+			t.Fatalf("chunkReader consumed too much or too little, left %q", rest)
+		}
+	})
+}
+
+func FuzzMultipartReader(f *testing.F) {
+	f.Add([]byte(multipartBody(testPart{"name", "", "gu"}, testPart{"file", "1.txt", "this is 1.txt!"})), "xyz")
+	f.Add([]byte("preamble\r\n--xyz \r\nContent-Transfer-Encoding: base64\r\n\r\naGVsbG8=\r\n--xyz--\r\nepilogue"), "xyz")
+	f.Add([]byte("--a:b\r\nContent-Type: multipart/mixed; boundary=in\r\n\r\n--in\r\n\r\nnested\r\n--in--\r\n--a:b--\r\n"), "a:b")
+	f.Add([]byte("--xyz\r\nContent-Disposition: form-data; name=\"q\"\r\nContent-Transfer-Encoding: quoted-printable\r\n\r\na=3D=\r\nb\r\n--xyz"), "xyz")
+	f.Add([]byte("--a\r\nContent-Type: multipart/mixed; boundary="+strings.Repeat("b", 5000)+"\r\n\r\n--"+strings.Repeat("b", 5000)+"\r\n\r\nx\r\n--a--\r\n"), "a")
+
+	f.Fuzz(func(t *testing.T, body []byte, boundary string) {
+		mw := NewMultipartWriter(io.Discard)
+		if mw.SetBoundary(boundary) != nil {
+			return
+		}
+
+		// 任意输入都不能 panic，读到的数据不会超过输入
+		checkAllocs(t, len(body), func() {
+			var total int64
+			NewMultipartReader(bytes.NewReader(body), boundary).Walk(func(p *Part) error {
+				if nested, err := p.MultipartReader(); err == nil {
+					return nested.Walk(func(np *Part) error {
+						n, err := io.Copy(io.Discard, np)
+						total += n
+						return err
+					})
+				}
+
+				n, err := io.Copy(io.Discard, p)
+				total += n
+				return err
+			})
+			if total > int64(len(body)) {
+				t.Fatalf("read %d bytes from %d bytes", total, len(body))
+			}
+
+			mr := NewMultipartReader(bytes.NewReader(body), boundary)
+			if mf, err := mr.ReadForm(int64(len(body)) + 1); err == nil {
+				mf.RemoveAll()
+			}
+		})
+
+		// 将 body 作为文件内容写入，再读出相同的数据，内容中出现分隔符时无法还原
+		if bytes.Contains(append([]byte("\r\n"), body...), []byte("\r\n--"+boundary)) {
+			return
+		}
+		var buf bytes.Buffer
+		mw = NewMultipartWriter(&buf)
+		mw.SetBoundary(boundary)
+		w, _ := mw.CreateFormFile("file", "f.bin")
+		w.Write(body)
+		mw.Close()
+
+		mf, err := NewMultipartReader(&buf, boundary).ReadForm(int64(len(body)) + 1)
+		if err != nil {
+			t.Fatalf("read back: %v", err)
+		}
+		defer mf.RemoveAll()
+
+		if fhs := mf.File["file"]; len(fhs) != 1 || !bytes.Equal(fhs[0].content, body) {
+			t.Fatalf("round trip mismatch: %v", mf.File)
+		}
+	})
+}
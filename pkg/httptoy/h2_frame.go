@@ -0,0 +1,281 @@
+package httptoy
+
+import (
+	"bufio"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"sync"
+)
+
+// h2_frame.go 负责 HTTP/2 帧的读取与写入 (RFC 7540 第 4、6 章)
+// 每个帧由 9 字节的帧头以及负载组成：
+/* +-----------------------------------------------+
+ * |                 Length (24)                   |
+ * +---------------+---------------+---------------+
+ * |   Type (8)    |   Flags (8)   |
+ * +-+-------------+---------------+-------------------------------+
+ * |R|                 Stream Identifier (31)                      |
+ * +=+=============================================================+
+ * |                   Frame Payload (0...)                      ...
+ * +---------------------------------------------------------------+
+ */
+
+// h2Preface 是客户端在 HTTP/2 连接上发送的第一段数据
+const h2Preface = "PRI * HTTP/2.0\r\n\r\nSM\r\n\r\n"
+
+const (
+	h2FrameHeaderLen      = 9
+	h2DefaultMaxFrameSize = 16 << 10  // SETTINGS_MAX_FRAME_SIZE 的初始值
+	h2MaxFrameSizeLimit   = 1<<24 - 1 // SETTINGS_MAX_FRAME_SIZE 的上限
+	h2DefaultWindowSize   = 65535     // 流量控制窗口的初始值
+	h2MaxWindowSize       = 1<<31 - 1 // 流量控制窗口的上限
+	h2MaxHeaderBlockSize  = 1 << 20   // 首部块(HEADERS + CONTINUATION)的上限，与 HTTP/1 保持一致
+	h2DefaultMaxStreams   = 100       // Server.MaxConcurrentStreams 未设置时的并发流上限
+	h2DefaultTableSize    = 4096      // SETTINGS_HEADER_TABLE_SIZE 的初始值
+	h2StreamIDMask        = 1<<31 - 1 // 流 id 以及窗口增量只有低 31 位有效
+	h2SettingLen          = 6         // 每个 SETTINGS 参数占 6 字节
+	h2UpgradeToken        = "h2c"     // Upgrade 首部中明文 HTTP/2 的标识
+	h2MaxUpgradeBody      = 1 << 20   // h2c 升级请求携带的报文主体的上限，超过则不升级
+	h2SettingsHeader      = "HTTP2-Settings"
+	h2Proto               = "HTTP/2.0"
+	h2StatusSwitching     = "HTTP/1.1 101 Switching Protocols\r\nConnection: Upgrade\r\nUpgrade: h2c\r\n\r\n"
+)
+
+// 帧类型
+const (
+	h2FrameData         uint8 = 0x0
+	h2FrameHeaders      uint8 = 0x1
+	h2FramePriority     uint8 = 0x2
+	h2FrameRSTStream    uint8 = 0x3
+	h2FrameSettings     uint8 = 0x4
+	h2FramePushPromise  uint8 = 0x5
+	h2FramePing         uint8 = 0x6
+	h2FrameGoAway       uint8 = 0x7
+	h2FrameWindowUpdate uint8 = 0x8
+	h2FrameContinuation uint8 = 0x9
+)
+
+// 帧标志
+const (
+	h2FlagEndStream  uint8 = 0x1
+	h2FlagAck        uint8 = 0x1
+	h2FlagEndHeaders uint8 = 0x4
+	h2FlagPadded     uint8 = 0x8
+	h2FlagPriority   uint8 = 0x20
+)
+
+// SETTINGS 参数
+const (
+	h2SettingHeaderTableSize      uint16 = 0x1
+	h2SettingEnablePush           uint16 = 0x2
+	h2SettingMaxConcurrentStreams uint16 = 0x3
+	h2SettingInitialWindowSize    uint16 = 0x4
+	h2SettingMaxFrameSize         uint16 = 0x5
+	h2SettingMaxHeaderListSize    uint16 = 0x6
+)
+
+// h2ErrCode 是 RST_STREAM 以及 GOAWAY 携带的错误码
+type h2ErrCode uint32
+
+const (
+	h2ErrNo                 h2ErrCode = 0x0
+	h2ErrProtocol           h2ErrCode = 0x1
+	h2ErrInternal           h2ErrCode = 0x2
+	h2ErrFlowControl        h2ErrCode = 0x3
+	h2ErrSettingsTimeout    h2ErrCode = 0x4
+	h2ErrStreamClosed       h2ErrCode = 0x5
+	h2ErrFrameSize          h2ErrCode = 0x6
+	h2ErrRefusedStream      h2ErrCode = 0x7
+	h2ErrCancel             h2ErrCode = 0x8
+	h2ErrCompression        h2ErrCode = 0x9
+	h2ErrConnect            h2ErrCode = 0xa
+	h2ErrEnhanceYourCalm    h2ErrCode = 0xb
+	h2ErrInadequateSecurity h2ErrCode = 0xc
+	h2ErrHTTP11Required     h2ErrCode = 0xd
+)
+
+var h2ErrCodeName = map[h2ErrCode]string{
+	h2ErrNo:                 "NO_ERROR",
+	h2ErrProtocol:           "PROTOCOL_ERROR",
+	h2ErrInternal:           "INTERNAL_ERROR",
+	h2ErrFlowControl:        "FLOW_CONTROL_ERROR",
+	h2ErrSettingsTimeout:    "SETTINGS_TIMEOUT",
+	h2ErrStreamClosed:       "STREAM_CLOSED",
+	h2ErrFrameSize:          "FRAME_SIZE_ERROR",
+	h2ErrRefusedStream:      "REFUSED_STREAM",
+	h2ErrCancel:             "CANCEL",
+	h2ErrCompression:        "COMPRESSION_ERROR",
+	h2ErrConnect:            "CONNECT_ERROR",
+	h2ErrEnhanceYourCalm:    "ENHANCE_YOUR_CALM",
+	h2ErrInadequateSecurity: "INADEQUATE_SECURITY",
+	h2ErrHTTP11Required:     "HTTP_1_1_REQUIRED",
+}
+
+func (e h2ErrCode) String() string {
+	if name, ok := h2ErrCodeName[e]; ok {
+		return name
+	}
+
+	return fmt.Sprintf("unknown error code 0x%x", uint32(e))
+}
+
+// h2ConnError 连接错误，发送 GOAWAY 之后关闭连接
+type h2ConnError struct {
+	code   h2ErrCode
+	reason string
+}
+
+func (e h2ConnError) Error() string {
+	return fmt.Sprintf("http2: connection error: %v: %s", e.code, e.reason)
+}
+
+// h2StreamError 流错误，发送 RST_STREAM 关闭对应的流，连接继续使用
+type h2StreamError struct {
+	streamID uint32
+	code     h2ErrCode
+}
+
+func (e h2StreamError) Error() string {
+	return fmt.Sprintf("http2: stream %d error: %v", e.streamID, e.code)
+}
+
+// h2FrameHeader 帧头
+type h2FrameHeader struct {
+	length   uint32
+	typ      uint8
+	flags    uint8
+	streamID uint32
+}
+
+func (fh h2FrameHeader) has(flag uint8) bool {
+	return fh.flags&flag != 0
+}
+
+// readH2Frame 读取一个完整的帧，负载长度超过 maxSize 时返回 FRAME_SIZE_ERROR
+// 返回的负载复用 buf 的空间，下一次读取之前有效
+func readH2Frame(r io.Reader, buf []byte, maxSize uint32) (h2FrameHeader, []byte, error) {
+	var hdr [h2FrameHeaderLen]byte
+	if _, err := io.ReadFull(r, hdr[:]); err != nil {
+		return h2FrameHeader{}, nil, err
+	}
+
+	fh := h2FrameHeader{
+		length:   uint32(hdr[0])<<16 | uint32(hdr[1])<<8 | uint32(hdr[2]),
+		typ:      hdr[3],
+		flags:    hdr[4],
+		streamID: binary.BigEndian.Uint32(hdr[5:]) & h2StreamIDMask,
+	}
+	if fh.length > maxSize {
+		return fh, nil, h2ConnError{h2ErrFrameSize, "frame too large"}
+	}
+
+	if uint32(cap(buf)) < fh.length {
+		buf = make([]byte, fh.length)
+	}
+	payload := buf[:fh.length]
+	if _, err := io.ReadFull(r, payload); err != nil {
+		return fh, nil, err
+	}
+
+	return fh, payload, nil
+}
+
+// h2Writer 串行化帧的写入，多个流的 handler 以及读取循环共享同一个连接
+type h2Writer struct {
+	mu  sync.Mutex
+	w   *bufio.Writer
+	hdr [h2FrameHeaderLen]byte
+}
+
+// writeFrame 写入一个帧并立即发送
+func (fw *h2Writer) writeFrame(typ, flags uint8, streamID uint32, payload []byte) error {
+	fw.mu.Lock()
+	defer fw.mu.Unlock()
+
+	if err := fw.writeFrameLocked(typ, flags, streamID, payload); err != nil {
+		return err
+	}
+
+	return fw.w.Flush()
+}
+
+// writeHeaders 将首部块按照 maxFrameSize 拆分成 HEADERS 以及 CONTINUATION 帧，
+// 期间持有锁，保证其它帧不会插入首部块中间
+func (fw *h2Writer) writeHeaders(streamID uint32, endStream bool, block []byte, maxFrameSize uint32) error {
+	fw.mu.Lock()
+	defer fw.mu.Unlock()
+
+	typ := h2FrameHeaders
+	var flags uint8
+	if endStream {
+		flags |= h2FlagEndStream
+	}
+
+	for first := true; first || len(block) > 0; first = false {
+		frag := block
+		if uint32(len(frag)) > maxFrameSize {
+			frag = frag[:maxFrameSize]
+		}
+		block = block[len(frag):]
+
+		if len(block) == 0 {
+			flags |= h2FlagEndHeaders
+		}
+		if err := fw.writeFrameLocked(typ, flags, streamID, frag); err != nil {
+			return err
+		}
+
+		typ, flags = h2FrameContinuation, 0
+	}
+
+	return fw.w.Flush()
+}
+
+func (fw *h2Writer) writeFrameLocked(typ, flags uint8, streamID uint32, payload []byte) error {
+	n := len(payload)
+	fw.hdr[0], fw.hdr[1], fw.hdr[2] = byte(n>>16), byte(n>>8), byte(n)
+	fw.hdr[3], fw.hdr[4] = typ, flags
+	binary.BigEndian.PutUint32(fw.hdr[5:], streamID&h2StreamIDMask)
+
+	if _, err := fw.w.Write(fw.hdr[:]); err != nil {
+		return err
+	}
+	_, err := fw.w.Write(payload)
+
+	return err
+}
+
+// writeSettings 发送 SETTINGS 帧，参数按照 id, value 成对给出
+func (fw *h2Writer) writeSettings(settings ...uint32) error {
+	payload := make([]byte, 0, len(settings)/2*h2SettingLen)
+	for i := 0; i+1 < len(settings); i += 2 {
+		payload = binary.BigEndian.AppendUint16(payload, uint16(settings[i]))
+		payload = binary.BigEndian.AppendUint32(payload, settings[i+1])
+	}
+
+	return fw.writeFrame(h2FrameSettings, 0, 0, payload)
+}
+
+func (fw *h2Writer) writeWindowUpdate(streamID, incr uint32) error {
+	var payload [4]byte
+	binary.BigEndian.PutUint32(payload[:], incr&h2StreamIDMask)
+
+	return fw.writeFrame(h2FrameWindowUpdate, 0, streamID, payload[:])
+}
+
+func (fw *h2Writer) writeRSTStream(streamID uint32, code h2ErrCode) error {
+	var payload [4]byte
+	binary.BigEndian.PutUint32(payload[:], uint32(code))
+
+	return fw.writeFrame(h2FrameRSTStream, 0, streamID, payload[:])
+}
+
+func (fw *h2Writer) writeGoAway(lastStreamID uint32, code h2ErrCode, debug string) error {
+	payload := make([]byte, 8, 8+len(debug))
+	binary.BigEndian.PutUint32(payload, lastStreamID&h2StreamIDMask)
+	binary.BigEndian.PutUint32(payload[4:], uint32(code))
+	payload = append(payload, debug...)
+
+	return fw.writeFrame(h2FrameGoAway, 0, 0, payload)
+}
@@ -0,0 +1,1007 @@
+package httptoy
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/base64"
+	"encoding/binary"
+	"errors"
+	"io"
+	"log"
+	"net/http"
+	"net/textproto"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"build-HTTP-from-scracth/pkg/httptoy/hpack"
+)
+
+// h2_server.go 在 conn 之上实现明文 HTTP/2 (h2c)
+// 1.prior knowledge: 客户端直接发送连接前言 PRI * HTTP/2.0
+// 2.Upgrade: HTTP/1.1 请求携带 Upgrade: h2c 以及 HTTP2-Settings，回复 101 之后切换协议，该请求作为 stream 1
+// 每个流在各自的协程中执行 Handler，所有帧的写入经由 h2Writer 串行化
+// 连接结束之前等待所有的 Handler 返回，没有正在执行的 Handler 超过 Server.IdleTimeout 时发送 GOAWAY 并关闭连接
+
+var (
+	errH2StreamClosed = errors.New("http2: stream closed")
+	errH2ConnClosed   = errors.New("http2: client connection lost")
+)
+
+// h2Server 一个 HTTP/2 连接的状态
+type h2Server struct {
+	c    *conn
+	fw   *h2Writer
+	hdec *hpack.Decoder
+	buf  []byte // 读取帧负载的缓冲，只在读取循环中使用
+
+	maxStreams uint32 // 我方允许的并发流数
+
+	handlers  sync.WaitGroup // 正在执行的 handler，连接结束之前等待全部返回
+	idleTimer *time.Timer    // Server.IdleTimeout > 0 时，没有正在执行的 handler 之后开始计时
+
+	// 以下字段受 mu 保护，cond 在窗口变化、流数据到达或关闭时广播
+	mu                sync.Mutex
+	cond              *sync.Cond
+	streams           map[uint32]*h2Stream
+	lastStreamID      uint32 // 已处理过的最大客户端流 id
+	sendWindow        int64  // 连接级发送窗口
+	peerInitialWindow int64  // 对端 SETTINGS_INITIAL_WINDOW_SIZE
+	peerMaxFrameSize  uint32 // 对端 SETTINGS_MAX_FRAME_SIZE
+	shutdown          bool   // 连接已断开，唤醒所有等待中的 handler
+	goAway            bool   // 对端已发送 GOAWAY
+	active            int    // 正在执行的 handler 数
+	idleClosed        bool   // 空闲超时，读取循环因为截止时间而返回
+}
+
+// h2Stream 一个 HTTP/2 流，字段受 h2Server.mu 保护
+type h2Stream struct {
+	id uint32
+	sc *h2Server
+
+	sendWindow   int64 // 我方还能发送的字节数
+	recvWindow   int64 // 对端还能发送的字节数
+	remoteClosed bool  // 对端已发送 END_STREAM
+	resetErr     error // 流被重置或连接断开后，handler 的写入返回该错误
+
+	cancel context.CancelFunc // 取消请求的 context，流被重置或连接断开时调用，handler 不再阻塞连接的结束
+
+	body    bytes.Buffer // 已到达但 handler 尚未读取的请求数据
+	bodyErr error        // 请求数据读完之后返回的错误，正常结束为 io.EOF
+}
+
+// h2Body 是 HTTP/2 请求的 Body，读取后归还流量控制窗口
+type h2Body struct {
+	st *h2Stream
+}
+
+func (b *h2Body) Read(p []byte) (n int, err error) {
+	st := b.st
+	sc := st.sc
+
+	sc.mu.Lock()
+	for st.body.Len() == 0 && st.bodyErr == nil {
+		sc.cond.Wait()
+	}
+
+	if st.body.Len() == 0 {
+		err = st.bodyErr
+		sc.mu.Unlock()
+		return 0, err
+	}
+
+	n, _ = st.body.Read(p)
+	update := !st.remoteClosed
+	if update {
+		st.recvWindow += int64(n)
+	}
+	sc.mu.Unlock()
+
+	// handler 读取了多少数据，就允许对端在该流上再发送多少
+	if update {
+		sc.fw.writeWindowUpdate(st.id, uint32(n))
+	}
+
+	return n, nil
+}
+
+// isH2Preface 预览读取缓冲，判断客户端是否以 prior knowledge 的方式直接发送 HTTP/2 连接前言
+func (c *conn) isH2Preface() bool {
+	// 所有 HTTP/1 请求都不会短于 4 字节，先确认开头再预览完整的前言，防止短请求阻塞
+	if p, err := c.bufr.Peek(4); err != nil || string(p) != h2Preface[:4] {
+		return false
+	}
+
+	p, err := c.bufr.Peek(len(h2Preface))
+	return err == nil && string(p) == h2Preface
+}
+
+// isH2CUpgrade 判断请求是否为 h2c 升级请求
+// E.g. Connection: Upgrade, HTTP2-Settings
+//
+//	Upgrade: h2c
+//	HTTP2-Settings: <base64url 编码的 SETTINGS 负载>
+func isH2CUpgrade(req *Request) bool {
+	conn := req.Header.Get("Connection")
+	return req.ProtoMajor == 1 && req.ProtoMinor == 1 &&
+		hasToken(req.Header.Get("Upgrade"), h2UpgradeToken) &&
+		hasToken(conn, "Upgrade") && hasToken(conn, h2SettingsHeader) &&
+		len(req.Header.Values(h2SettingsHeader)) == 1
+}
+
+// upgradeH2C 处理 h2c 升级请求，返回 false 表示没有升级，请求依旧按 HTTP/1.1 处理
+// 升级之前需要将请求的报文主体完整读出，作为 stream 1 的请求数据
+func (c *conn) upgradeH2C(req *Request) bool {
+	settings, err := base64.RawURLEncoding.DecodeString(strings.TrimRight(req.Header.Get(h2SettingsHeader), "="))
+	if err != nil || len(settings)%h2SettingLen != 0 {
+		return false
+	}
+
+	body, err := io.ReadAll(io.LimitReader(req.Body, h2MaxUpgradeBody+1))
+	if err != nil || len(body) > h2MaxUpgradeBody {
+		// 报文主体过大，不升级，已经读取的数据还给 handler
+		req.Body = io.MultiReader(bytes.NewReader(body), req.Body)
+		return false
+	}
+
+	if _, err = c.bufw.WriteString(h2StatusSwitching); err != nil {
+		return false
+	}
+	if err = c.bufw.Flush(); err != nil {
+		return false
+	}
+
+	req.Proto, req.ProtoMajor, req.ProtoMinor = h2Proto, 2, 0
+	req.Body = bytes.NewReader(body)
+	for _, k := range []string{"Connection", "Upgrade", h2SettingsHeader} {
+		req.Header.Del(k)
+	}
+
+	c.serveH2(req, settings)
+	return true
+}
+
+// serveH2 在连接上运行 HTTP/2，upgradeReq 不为 nil 时为 h2c 升级，作为 stream 1 处理
+func (c *conn) serveH2(upgradeReq *Request, upgradeSettings []byte) {
+	c.lr.N = 1<<63 - 1 // HTTP/2 连接上不再限制读取
+
+	maxStreams := c.svr.MaxConcurrentStreams
+	if maxStreams == 0 {
+		maxStreams = h2DefaultMaxStreams
+	}
+
+	sc := &h2Server{
+		c:                 c,
+		fw:                &h2Writer{w: c.bufw},
+		hdec:              hpack.NewDecoder(h2DefaultTableSize),
+		maxStreams:        maxStreams,
+		streams:           make(map[uint32]*h2Stream),
+		sendWindow:        h2DefaultWindowSize,
+		peerInitialWindow: h2DefaultWindowSize,
+		peerMaxFrameSize:  h2DefaultMaxFrameSize,
+	}
+	sc.cond = sync.NewCond(&sc.mu)
+	sc.hdec.MaxStringLength = h2MaxHeaderBlockSize
+	if d := c.svr.IdleTimeout; d > 0 {
+		sc.idleTimer = time.AfterFunc(d, sc.onIdleTimeout)
+	}
+
+	err := sc.serve(upgradeReq, upgradeSettings)
+
+	// 连接错误需要告知对端原因，其余情况（对端关闭、GOAWAY）直接结束
+	var ce h2ConnError
+	if errors.As(err, &ce) {
+		sc.mu.Lock()
+		last := sc.lastStreamID
+		sc.mu.Unlock()
+		sc.fw.writeGoAway(last, ce.code, ce.reason)
+	} else if err != nil {
+		handleError(err, c)
+	}
+
+	sc.closeAll(errH2ConnClosed)
+
+	// 等待 handler 返回之后 conn.serve 才会关闭连接，handler 不会写入已经关闭的连接
+	sc.handlers.Wait()
+	if sc.idleTimer != nil {
+		sc.idleTimer.Stop()
+	}
+}
+
+// onIdleTimeout 没有正在执行的 handler 超过 IdleTimeout，让读取循环返回
+func (sc *h2Server) onIdleTimeout() {
+	sc.mu.Lock()
+	defer sc.mu.Unlock()
+
+	// 计时器触发之后、回调执行之前可能又有新的流
+	if sc.active > 0 || sc.shutdown {
+		return
+	}
+	sc.idleClosed = true
+	sc.c.rwc.SetReadDeadline(time.Now())
+}
+
+// startHandlerLocked 在独立的协程中执行 runHandler，期间暂停空闲计时，调用方需要持有 mu
+func (sc *h2Server) startHandlerLocked(st *h2Stream, req *Request) {
+	st.cancel = req.cancel
+	if sc.active++; sc.active == 1 && sc.idleTimer != nil {
+		sc.idleTimer.Stop()
+	}
+
+	sc.handlers.Add(1)
+	go func() {
+		defer func() {
+			sc.mu.Lock()
+			if sc.active--; sc.active == 0 && sc.idleTimer != nil && !sc.shutdown {
+				sc.idleTimer.Reset(sc.c.svr.IdleTimeout)
+			}
+			sc.mu.Unlock()
+			sc.handlers.Done()
+		}()
+
+		sc.runHandler(st, req)
+	}()
+}
+
+func (sc *h2Server) serve(upgradeReq *Request, upgradeSettings []byte) error {
+	// 服务端的连接前言是一个 SETTINGS 帧
+	if err := sc.fw.writeSettings(uint32(h2SettingMaxConcurrentStreams), sc.maxStreams); err != nil {
+		return err
+	}
+
+	// 客户端的连接前言：固定的 24 字节，之后必须是 SETTINGS 帧
+	var preface [len(h2Preface)]byte
+	if _, err := io.ReadFull(sc.c.bufr, preface[:]); err != nil {
+		return err
+	}
+	if string(preface[:]) != h2Preface {
+		return h2ConnError{h2ErrProtocol, "invalid connection preface"}
+	}
+
+	if upgradeReq != nil {
+		// HTTP2-Settings 首部中的参数视为客户端发送的第一个 SETTINGS
+		if err := sc.applySettings(upgradeSettings); err != nil {
+			return err
+		}
+
+		// 升级请求作为 stream 1，请求数据已经完整读出，处于半关闭(远端)状态
+		sc.mu.Lock()
+		st := sc.newStream(1)
+		st.remoteClosed = true
+		st.bodyErr = io.EOF
+		sc.lastStreamID = 1
+		sc.c.countRequest()
+		sc.startHandlerLocked(st, upgradeReq)
+		sc.mu.Unlock()
+	}
+
+	for first := true; ; first = false {
+		fh, payload, err := readH2Frame(sc.c.bufr, sc.buf, h2DefaultMaxFrameSize)
+		if err != nil {
+			sc.mu.Lock()
+			idle := sc.idleClosed
+			sc.mu.Unlock()
+			if idle {
+				return h2ConnError{h2ErrNo, "idle timeout"}
+			}
+			return err
+		}
+		if cap(payload) > cap(sc.buf) {
+			sc.buf = payload[:0]
+		}
+
+		if first && fh.typ != h2FrameSettings {
+			return h2ConnError{h2ErrProtocol, "expected SETTINGS frame"}
+		}
+
+		err = sc.processFrame(fh, payload)
+
+		var se h2StreamError
+		if errors.As(err, &se) {
+			sc.resetStream(se.streamID, se.code)
+			continue
+		}
+		if err != nil {
+			return err
+		}
+	}
+}
+
+func (sc *h2Server) processFrame(fh h2FrameHeader, payload []byte) error {
+	switch fh.typ {
+	case h2FrameData:
+		return sc.processData(fh, payload)
+	case h2FrameHeaders:
+		return sc.processHeaders(fh, payload)
+	case h2FramePriority:
+		if fh.streamID == 0 {
+			return h2ConnError{h2ErrProtocol, "PRIORITY on stream 0"}
+		}
+		if fh.length != 5 {
+			return h2StreamError{fh.streamID, h2ErrFrameSize}
+		}
+		return nil // 不支持优先级调度，直接忽略
+	case h2FrameRSTStream:
+		return sc.processRSTStream(fh, payload)
+	case h2FrameSettings:
+		return sc.processSettings(fh, payload)
+	case h2FramePushPromise:
+		return h2ConnError{h2ErrProtocol, "client sent PUSH_PROMISE"}
+	case h2FramePing:
+		if fh.streamID != 0 {
+			return h2ConnError{h2ErrProtocol, "PING on non-zero stream"}
+		}
+		if fh.length != 8 {
+			return h2ConnError{h2ErrFrameSize, "PING payload must be 8 bytes"}
+		}
+		if fh.has(h2FlagAck) {
+			return nil
+		}
+		return sc.fw.writeFrame(h2FramePing, h2FlagAck, 0, payload)
+	case h2FrameGoAway:
+		if fh.streamID != 0 {
+			return h2ConnError{h2ErrProtocol, "GOAWAY on non-zero stream"}
+		}
+
+		// 对端不会再创建新的流，已有的流继续处理，直到对端关闭连接
+		sc.mu.Lock()
+		sc.goAway = true
+		sc.mu.Unlock()
+		return nil
+	case h2FrameWindowUpdate:
+		return sc.processWindowUpdate(fh, payload)
+	case h2FrameContinuation:
+		return h2ConnError{h2ErrProtocol, "unexpected CONTINUATION"}
+	}
+
+	// 未知类型的帧直接忽略
+	return nil
+}
+
+func (sc *h2Server) processSettings(fh h2FrameHeader, payload []byte) error {
+	if fh.streamID != 0 {
+		return h2ConnError{h2ErrProtocol, "SETTINGS on non-zero stream"}
+	}
+	if fh.has(h2FlagAck) {
+		if fh.length != 0 {
+			return h2ConnError{h2ErrFrameSize, "SETTINGS ACK with payload"}
+		}
+		return nil
+	}
+	if fh.length%h2SettingLen != 0 {
+		return h2ConnError{h2ErrFrameSize, "SETTINGS payload not a multiple of 6"}
+	}
+
+	if err := sc.applySettings(payload); err != nil {
+		return err
+	}
+
+	return sc.fw.writeFrame(h2FrameSettings, h2FlagAck, 0, nil)
+}
+
+// applySettings 应用对端的 SETTINGS 参数
+func (sc *h2Server) applySettings(payload []byte) error {
+	sc.mu.Lock()
+	defer sc.mu.Unlock()
+
+	for ; len(payload) >= h2SettingLen; payload = payload[h2SettingLen:] {
+		id := binary.BigEndian.Uint16(payload)
+		val := binary.BigEndian.Uint32(payload[2:])
+
+		switch id {
+		case h2SettingEnablePush:
+			if val > 1 {
+				return h2ConnError{h2ErrProtocol, "invalid SETTINGS_ENABLE_PUSH"}
+			}
+		case h2SettingInitialWindowSize:
+			if val > h2MaxWindowSize {
+				return h2ConnError{h2ErrFlowControl, "invalid SETTINGS_INITIAL_WINDOW_SIZE"}
+			}
+
+			// 初始窗口的变化量作用于所有已存在的流
+			delta := int64(val) - sc.peerInitialWindow
+			for _, st := range sc.streams {
+				st.sendWindow += delta
+				if st.sendWindow > h2MaxWindowSize {
+					return h2ConnError{h2ErrFlowControl, "stream window overflow"}
+				}
+			}
+			sc.peerInitialWindow = int64(val)
+		case h2SettingMaxFrameSize:
+			if val < h2DefaultMaxFrameSize || val > h2MaxFrameSizeLimit {
+				return h2ConnError{h2ErrProtocol, "invalid SETTINGS_MAX_FRAME_SIZE"}
+			}
+			sc.peerMaxFrameSize = val
+		}
+		// HEADER_TABLE_SIZE: 编码器不使用动态表，无需处理
+		// MAX_CONCURRENT_STREAMS、MAX_HEADER_LIST_SIZE: 服务端不主动创建流，忽略
+	}
+
+	sc.cond.Broadcast()
+	return nil
+}
+
+func (sc *h2Server) processWindowUpdate(fh h2FrameHeader, payload []byte) error {
+	if fh.length != 4 {
+		return h2ConnError{h2ErrFrameSize, "WINDOW_UPDATE payload must be 4 bytes"}
+	}
+	incr := int64(binary.BigEndian.Uint32(payload) & h2StreamIDMask)
+
+	sc.mu.Lock()
+	defer sc.mu.Unlock()
+
+	if fh.streamID == 0 {
+		if incr == 0 {
+			return h2ConnError{h2ErrProtocol, "WINDOW_UPDATE with zero increment"}
+		}
+		sc.sendWindow += incr
+		if sc.sendWindow > h2MaxWindowSize {
+			return h2ConnError{h2ErrFlowControl, "connection window overflow"}
+		}
+		sc.cond.Broadcast()
+		return nil
+	}
+
+	st := sc.streams[fh.streamID]
+	if st == nil {
+		if fh.streamID > sc.lastStreamID {
+			return h2ConnError{h2ErrProtocol, "WINDOW_UPDATE on idle stream"}
+		}
+		return nil // 已关闭的流，忽略
+	}
+	if incr == 0 {
+		return h2StreamError{fh.streamID, h2ErrProtocol}
+	}
+
+	st.sendWindow += incr
+	if st.sendWindow > h2MaxWindowSize {
+		return h2StreamError{fh.streamID, h2ErrFlowControl}
+	}
+	sc.cond.Broadcast()
+
+	return nil
+}
+
+func (sc *h2Server) processRSTStream(fh h2FrameHeader, payload []byte) error {
+	if fh.length != 4 {
+		return h2ConnError{h2ErrFrameSize, "RST_STREAM payload must be 4 bytes"}
+	}
+	if fh.streamID == 0 {
+		return h2ConnError{h2ErrProtocol, "RST_STREAM on stream 0"}
+	}
+
+	sc.mu.Lock()
+	defer sc.mu.Unlock()
+
+	if fh.streamID > sc.lastStreamID {
+		return h2ConnError{h2ErrProtocol, "RST_STREAM on idle stream"}
+	}
+	if st := sc.streams[fh.streamID]; st != nil {
+		code := h2ErrCode(binary.BigEndian.Uint32(payload))
+		sc.closeStreamLocked(st, h2StreamError{st.id, code})
+	}
+
+	return nil
+}
+
+// trimPadding 去掉 DATA、HEADERS 帧的填充，返回去掉填充之后的负载
+func trimPadding(fh h2FrameHeader, payload []byte) ([]byte, error) {
+	if !fh.has(h2FlagPadded) {
+		return payload, nil
+	}
+	if len(payload) == 0 || int(payload[0]) >= len(payload) {
+		return nil, h2ConnError{h2ErrProtocol, "invalid padding"}
+	}
+
+	return payload[1 : len(payload)-int(payload[0])], nil
+}
+
+func (sc *h2Server) processData(fh h2FrameHeader, payload []byte) error {
+	if fh.streamID == 0 {
+		return h2ConnError{h2ErrProtocol, "DATA on stream 0"}
+	}
+
+	data, err := trimPadding(fh, payload)
+	if err != nil {
+		return err
+	}
+
+	// 整个帧(包括填充)都计入流量控制，连接级窗口收到即归还，流级窗口在 handler 读取后归还
+	n := int64(fh.length)
+	if n > 0 {
+		if err = sc.fw.writeWindowUpdate(0, uint32(n)); err != nil {
+			return err
+		}
+	}
+
+	sc.mu.Lock()
+	st := sc.streams[fh.streamID]
+	if st == nil || st.remoteClosed {
+		last := sc.lastStreamID
+		sc.mu.Unlock()
+
+		if fh.streamID > last {
+			return h2ConnError{h2ErrProtocol, "DATA on idle stream"}
+		}
+		return h2StreamError{fh.streamID, h2ErrStreamClosed}
+	}
+
+	if n > st.recvWindow {
+		sc.mu.Unlock()
+		return h2StreamError{fh.streamID, h2ErrFlowControl}
+	}
+	st.recvWindow -= n
+	st.body.Write(data)
+
+	if fh.has(h2FlagEndStream) {
+		st.remoteClosed = true
+		st.bodyErr = io.EOF
+	}
+	sc.cond.Broadcast()
+	sc.mu.Unlock()
+
+	// 填充部分不会被 handler 读取，直接归还流级窗口
+	if pad := n - int64(len(data)); pad > 0 && !fh.has(h2FlagEndStream) {
+		sc.mu.Lock()
+		st.recvWindow += pad
+		sc.mu.Unlock()
+
+		return sc.fw.writeWindowUpdate(st.id, uint32(pad))
+	}
+
+	return nil
+}
+
+func (sc *h2Server) processHeaders(fh h2FrameHeader, payload []byte) error {
+	id := fh.streamID
+	if id == 0 {
+		return h2ConnError{h2ErrProtocol, "HEADERS on stream 0"}
+	}
+
+	frag, err := trimPadding(fh, payload)
+	if err != nil {
+		return err
+	}
+	if fh.has(h2FlagPriority) {
+		if len(frag) < 5 {
+			return h2ConnError{h2ErrFrameSize, "HEADERS too short for priority"}
+		}
+		frag = frag[5:]
+	}
+
+	// END_STREAM 只出现在 HEADERS 帧上，
+	// 首部块可能被拆分到后续的 CONTINUATION 帧中，期间不能出现其它帧
+	endStream := fh.has(h2FlagEndStream)
+	block := append([]byte(nil), frag...)
+	for !fh.has(h2FlagEndHeaders) {
+		fh, frag, err = readH2Frame(sc.c.bufr, sc.buf, h2DefaultMaxFrameSize)
+		if err != nil {
+			return err
+		}
+		if fh.typ != h2FrameContinuation || fh.streamID != id {
+			return h2ConnError{h2ErrProtocol, "expected CONTINUATION"}
+		}
+
+		block = append(block, frag...)
+		if len(block) > h2MaxHeaderBlockSize {
+			return h2ConnError{h2ErrEnhanceYourCalm, "header block too large"}
+		}
+	}
+
+	// 即使之后拒绝该流，也必须解码，保持动态表的一致
+	fields, err := sc.hdec.Decode(block)
+	if err != nil {
+		return h2ConnError{h2ErrCompression, err.Error()}
+	}
+
+	sc.mu.Lock()
+	defer sc.mu.Unlock()
+
+	// 已存在的流上再次收到 HEADERS，只能是结束请求的 trailers
+	if st := sc.streams[id]; st != nil {
+		if st.remoteClosed {
+			return h2StreamError{id, h2ErrStreamClosed}
+		}
+		if !endStream {
+			return h2StreamError{id, h2ErrProtocol}
+		}
+
+		st.remoteClosed = true
+		st.bodyErr = io.EOF
+		sc.cond.Broadcast()
+		return nil
+	}
+
+	if id%2 == 0 || id <= sc.lastStreamID {
+		return h2ConnError{h2ErrProtocol, "invalid stream id"}
+	}
+	sc.lastStreamID = id
+
+	if sc.goAway || uint32(len(sc.streams)) >= sc.maxStreams {
+		return h2StreamError{id, h2ErrRefusedStream}
+	}
+
+	req, err := sc.newRequest(fields)
+	if err != nil {
+		return h2StreamError{id, h2ErrProtocol}
+	}
+
+	st := sc.newStream(id)
+	if endStream {
+		st.remoteClosed = true
+		st.bodyErr = io.EOF
+		req.Body = new(eofReader)
+	} else {
+		req.Body = &h2Body{st: st}
+	}
+
+	sc.c.countRequest()
+	sc.startHandlerLocked(st, req)
+
+	return nil
+}
+
+// newStream 创建流并加入连接，调用方需要持有 mu
+func (sc *h2Server) newStream(id uint32) *h2Stream {
+	st := &h2Stream{
+		id:         id,
+		sc:         sc,
+		sendWindow: sc.peerInitialWindow,
+		recvWindow: h2DefaultWindowSize,
+	}
+	sc.streams[id] = st
+
+	return st
+}
+
+// h2ConnHeaders 是 HTTP/2 中禁止出现的连接相关首部
+var h2ConnHeaders = map[string]bool{
+	"connection":        true,
+	"keep-alive":        true,
+	"proxy-connection":  true,
+	"transfer-encoding": true,
+	"upgrade":           true,
+}
+
+// newRequest 根据解码后的首部构建 Request
+// 伪首部必须出现在普通首部之前，名称必须为小写，不能携带连接相关首部
+func (sc *h2Server) newRequest(fields []hpack.HeaderField) (*Request, error) {
+	var (
+		method, scheme, authority, path string
+		sawRegular                      bool
+		cookies                         []string
+	)
+
+	header := make(Header)
+	for _, f := range fields {
+		if f.IsPseudo() {
+			if sawRegular {
+				return nil, errors.New("pseudo header after regular header")
+			}
+
+			var dst *string
+			switch f.Name {
+			case ":method":
+				dst = &method
+			case ":scheme":
+				dst = &scheme
+			case ":authority":
+				dst = &authority
+			case ":path":
+				dst = &path
+			default:
+				return nil, errors.New("unknown pseudo header " + f.Name)
+			}
+			if *dst != "" {
+				return nil, errors.New("duplicate pseudo header " + f.Name)
+			}
+			*dst = f.Value
+			continue
+		}
+
+		sawRegular = true
+		if f.Name != strings.ToLower(f.Name) || h2ConnHeaders[f.Name] {
+			return nil, errors.New("invalid header " + f.Name)
+		}
+		if f.Name == "te" && f.Value != "trailers" {
+			return nil, errors.New("invalid TE header")
+		}
+
+		// 多个 cookie 首部需要以 "; " 拼接
+		if f.Name == "cookie" {
+			cookies = append(cookies, f.Value)
+			continue
+		}
+
+		key := textproto.CanonicalMIMEHeaderKey(f.Name)
+		header[key] = append(header[key], f.Value)
+	}
+
+	if method == "" || scheme == "" || path == "" {
+		return nil, errors.New("missing pseudo header")
+	}
+	if len(cookies) > 0 {
+		header.Set("Cookie", strings.Join(cookies, "; "))
+	}
+	if authority != "" && header.Get("Host") == "" {
+		header.Set("Host", authority)
+	}
+
+	u, err := url.ParseRequestURI(path)
+	if err != nil {
+		return nil, err
+	}
+
+	r := &Request{
+		Method:      method,
+		RemoteURI:   path,
+		Proto:       h2Proto,
+		ProtoMajor:  2,
+		Header:      header,
+		URL:         u,
+		conn:        sc.c,
+		RemoteAddr:  sc.c.rwc.RemoteAddr().String(),
+		queryString: parseQuery(u.RawQuery),
+	}
+	r.parseContentType()
+	r.ctx, r.cancel = context.WithCancel(sc.c.ctx)
+
+	return r, nil
+}
+
+// runHandler 在独立的协程中执行 Handler，结束后发送剩余的响应并关闭流
+func (sc *h2Server) runHandler(st *h2Stream, req *Request) {
+	rw := &h2Response{
+		st:         st,
+		req:        req,
+		header:     make(Header),
+		statusCode: 200,
+	}
+	rw.bufw = bufio.NewWriterSize(&h2BodyWriter{rw}, 4<<10)
+	defer req.cancel()
+
+	defer func() {
+		if err := recover(); err != nil {
+			log.Printf("http2: panic serving %v: %v\n", req.RemoteAddr, err)
+			sc.resetStream(st.id, h2ErrInternal)
+		}
+	}()
+
+	sc.c.svr.Handler.ServeHTTP(rw, req)
+
+	// 将可能保存的临时文件删除
+	if req.MultipartForm != nil {
+		req.MultipartForm.RemoveAll()
+	}
+
+	if err := rw.finish(); err != nil {
+		sc.resetStream(st.id, h2ErrInternal)
+		return
+	}
+
+	// 响应已经发送完毕，但客户端还在发送请求数据，告知其停止发送
+	sc.mu.Lock()
+	remoteClosed := st.remoteClosed
+	sc.closeStreamLocked(st, errH2StreamClosed)
+	sc.mu.Unlock()
+
+	if !remoteClosed {
+		sc.fw.writeRSTStream(st.id, h2ErrNo)
+	}
+}
+
+// resetStream 发送 RST_STREAM 并关闭流
+func (sc *h2Server) resetStream(id uint32, code h2ErrCode) {
+	sc.mu.Lock()
+	if st := sc.streams[id]; st != nil {
+		sc.closeStreamLocked(st, h2StreamError{id, code})
+	}
+	sc.mu.Unlock()
+
+	sc.fw.writeRSTStream(id, code)
+}
+
+// closeStreamLocked 将流移出连接，唤醒等待读取或写入的 handler，调用方需要持有 mu
+func (sc *h2Server) closeStreamLocked(st *h2Stream, err error) {
+	delete(sc.streams, st.id)
+
+	if st.resetErr == nil {
+		st.resetErr = err
+	}
+	if st.bodyErr == nil {
+		st.bodyErr = err
+	}
+	if st.cancel != nil {
+		st.cancel()
+	}
+	sc.cond.Broadcast()
+}
+
+// closeAll 连接结束时关闭所有的流
+func (sc *h2Server) closeAll(err error) {
+	sc.mu.Lock()
+	defer sc.mu.Unlock()
+
+	sc.shutdown = true
+	for _, st := range sc.streams {
+		sc.closeStreamLocked(st, err)
+	}
+}
+
+// writeData 在流量控制的限制下发送 DATA 帧，窗口不足时等待对端的 WINDOW_UPDATE
+func (st *h2Stream) writeData(p []byte, endStream bool) error {
+	sc := st.sc
+
+	for {
+		sc.mu.Lock()
+		for len(p) > 0 && st.resetErr == nil && !sc.shutdown && (st.sendWindow <= 0 || sc.sendWindow <= 0) {
+			sc.cond.Wait()
+		}
+		if st.resetErr != nil || sc.shutdown {
+			sc.mu.Unlock()
+			return errH2StreamClosed
+		}
+
+		n := int64(len(p))
+		for _, limit := range []int64{st.sendWindow, sc.sendWindow, int64(sc.peerMaxFrameSize)} {
+			if n > limit {
+				n = limit
+			}
+		}
+		st.sendWindow -= n
+		sc.sendWindow -= n
+		sc.mu.Unlock()
+
+		chunk := p[:n]
+		p = p[n:]
+
+		var flags uint8
+		if endStream && len(p) == 0 {
+			flags = h2FlagEndStream
+		}
+		if err := sc.fw.writeFrame(h2FrameData, flags, st.id, chunk); err != nil {
+			return err
+		}
+
+		if len(p) == 0 {
+			return nil
+		}
+	}
+}
+
+// h2Response 是 HTTP/2 流上的 ResponseWriter
+// 与 Response 一样先写入 4kb 的缓冲，handler 结束之前缓冲没有写满则可以确定 content-length
+type h2Response struct {
+	st  *h2Stream
+	req *Request
+
+	header      Header
+	statusCode  int
+	wroteHeader bool // 已调用 WriteHeader
+	sentHeader  bool // 已发送 HEADERS 帧
+	handlerDone bool
+	streamEnded bool // 已发送 END_STREAM
+
+	bufw *bufio.Writer
+}
+
+func (w *h2Response) Header() Header {
+	return w.header
+}
+
+func (w *h2Response) WriteHeader(statusCode int) {
+	if w.wroteHeader {
+		return
+	}
+
+	w.statusCode = statusCode
+	w.wroteHeader = true
+}
+
+func (w *h2Response) Write(p []byte) (int, error) {
+	if !w.wroteHeader {
+		w.WriteHeader(200)
+	}
+
+	if !bodyAllowedForStatus(w.statusCode) {
+		return 0, ErrBodyNotAllowed
+	}
+
+	return w.bufw.Write(p)
+}
+
+// sendBody 判断本次响应是否需要发送报文主体
+func (w *h2Response) sendBody() bool {
+	return w.req.Method != "HEAD" && bodyAllowedForStatus(w.statusCode)
+}
+
+// finish 发送缓冲中剩余的数据，并以 END_STREAM 结束流
+func (w *h2Response) finish() error {
+	w.handlerDone = true
+
+	if err := w.bufw.Flush(); err != nil {
+		return err
+	}
+
+	if !w.sentHeader {
+		return w.writeHeaders(nil, true)
+	}
+	if !w.streamEnded {
+		w.streamEnded = true
+		return w.st.writeData(nil, true)
+	}
+
+	return nil
+}
+
+// writeHeaders 确定首部并发送 HEADERS 帧，p 为第一次写入的数据，用于检测报文类型以及长度
+func (w *h2Response) writeHeaders(p []byte, endStream bool) error {
+	header := w.header
+
+	if !bodyAllowedForStatus(w.statusCode) {
+		header.Del("Content-Length")
+	} else {
+		if header.Get("Content-Type") == "" && len(p) > 0 {
+			header.Set("Content-Type", http.DetectContentType(p))
+		}
+		// handler 已经结束，缓冲中的数据就是全部的报文主体
+		if w.handlerDone && header.Get("Content-Length") == "" {
+			header.Set("Content-Length", strconv.Itoa(len(p)))
+		}
+	}
+
+	block := hpack.AppendHeaderField(nil, hpack.HeaderField{Name: ":status", Value: strconv.Itoa(w.statusCode)})
+	for k, vs := range header {
+		name := strings.ToLower(k)
+		if h2ConnHeaders[name] {
+			continue
+		}
+		for _, v := range vs {
+			block = hpack.AppendHeaderField(block, hpack.HeaderField{Name: name, Value: v})
+		}
+	}
+
+	sc := w.st.sc
+	sc.mu.Lock()
+	maxFrameSize, err := sc.peerMaxFrameSize, w.st.resetErr
+	sc.mu.Unlock()
+	if err != nil {
+		return err
+	}
+
+	w.sentHeader = true
+	w.streamEnded = endStream
+
+	return sc.fw.writeHeaders(w.st.id, endStream, block, maxFrameSize)
+}
+
+// h2BodyWriter 是 h2Response.bufw 的下层，第一次写入时发送 HEADERS，之后发送 DATA
+type h2BodyWriter struct {
+	resp *h2Response
+}
+
+func (bw *h2BodyWriter) Write(p []byte) (int, error) {
+	w := bw.resp
+	sendData := w.sendBody() && len(p) > 0
+
+	if !w.sentHeader {
+		// handler 已经结束并且没有数据需要发送，HEADERS 即是整个响应
+		if err := w.writeHeaders(p, w.handlerDone && !sendData); err != nil {
+			return 0, err
+		}
+	}
+
+	if !sendData {
+		return len(p), nil
+	}
+
+	w.streamEnded = w.handlerDone
+	if err := w.st.writeData(p, w.handlerDone); err != nil {
+		return 0, err
+	}
+
+	return len(p), nil
+}
@@ -0,0 +1,482 @@
+package httptoy
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/base64"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"build-HTTP-from-scracth/pkg/httptoy/hpack"
+)
+
+// h2Client 直接读写帧的 HTTP/2 测试客户端
+type h2Client struct {
+	t    *testing.T
+	c    net.Conn
+	bufr *bufio.Reader
+	fw   *h2Writer
+	dec  *hpack.Decoder
+}
+
+// h2Resp 一个流上收到的完整响应
+type h2Resp struct {
+	header []hpack.HeaderField
+	body   []byte
+	rst    h2ErrCode // 收到 RST_STREAM 时的错误码
+	reset  bool
+}
+
+func (r *h2Resp) get(name string) string {
+	for _, f := range r.header {
+		if f.Name == name {
+			return f.Value
+		}
+	}
+
+	return ""
+}
+
+// dialH2 连接 addr 并以 prior knowledge 的方式发送连接前言以及 SETTINGS
+func dialH2(t *testing.T, addr string, settings ...uint32) *h2Client {
+	t.Helper()
+
+	c, err := net.Dial("tcp", addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { c.Close() })
+	c.SetDeadline(time.Now().Add(10 * time.Second))
+
+	hc := &h2Client{
+		t:    t,
+		c:    c,
+		bufr: bufio.NewReader(c),
+		fw:   &h2Writer{w: bufio.NewWriter(c)},
+		dec:  hpack.NewDecoder(h2DefaultTableSize),
+	}
+	io.WriteString(c, h2Preface)
+	hc.fw.writeSettings(settings...)
+
+	return hc
+}
+
+// request 发送请求首部，body 不为 nil 时随后发送 DATA 并结束流
+func (hc *h2Client) request(id uint32, method, path string, body []byte) {
+	block := hc.headerBlock(method, path)
+	hc.fw.writeHeaders(id, body == nil, block, h2DefaultMaxFrameSize)
+	if body != nil {
+		hc.fw.writeFrame(h2FrameData, h2FlagEndStream, id, body)
+	}
+}
+
+func (hc *h2Client) headerBlock(method, path string, extra ...string) []byte {
+	fields := []string{":method", method, ":scheme", "http", ":authority", "example.com", ":path", path}
+	fields = append(fields, extra...)
+
+	var block []byte
+	for i := 0; i < len(fields); i += 2 {
+		block = hpack.AppendHeaderField(block, hpack.HeaderField{Name: fields[i], Value: fields[i+1]})
+	}
+
+	return block
+}
+
+// readFrame 读取下一个帧，自动回复 SETTINGS
+func (hc *h2Client) readFrame() (h2FrameHeader, []byte) {
+	hc.t.Helper()
+
+	for {
+		fh, payload, err := readH2Frame(hc.bufr, nil, h2MaxFrameSizeLimit)
+		if err != nil {
+			hc.t.Fatalf("read frame: %v", err)
+		}
+
+		if fh.typ == h2FrameSettings && !fh.has(h2FlagAck) {
+			hc.fw.writeFrame(h2FrameSettings, h2FlagAck, 0, nil)
+			continue
+		}
+
+		return fh, payload
+	}
+}
+
+// readResponses 读取帧直到 ids 中的流全部结束，收到的 DATA 会归还流量控制窗口
+func (hc *h2Client) readResponses(ids ...uint32) map[uint32]*h2Resp {
+	hc.t.Helper()
+
+	resps := make(map[uint32]*h2Resp)
+	for _, id := range ids {
+		resps[id] = new(h2Resp)
+	}
+
+	for left := len(ids); left > 0; {
+		fh, payload := hc.readFrame()
+		r := resps[fh.streamID]
+		if r == nil {
+			continue
+		}
+
+		switch fh.typ {
+		case h2FrameHeaders:
+			fields, err := hc.dec.Decode(payload)
+			if err != nil {
+				hc.t.Fatalf("decode header: %v", err)
+			}
+			r.header = fields
+		case h2FrameData:
+			r.body = append(r.body, payload...)
+			if len(payload) > 0 {
+				hc.fw.writeWindowUpdate(0, uint32(len(payload)))
+				hc.fw.writeWindowUpdate(fh.streamID, uint32(len(payload)))
+			}
+		case h2FrameRSTStream:
+			r.reset = true
+			r.rst = h2ErrCode(binary.BigEndian.Uint32(payload))
+			left--
+			continue
+		default:
+			continue
+		}
+
+		if fh.has(h2FlagEndStream) {
+			left--
+		}
+	}
+
+	return resps
+}
+
+func h2EchoHandler() Handler {
+	return testHandler(func(rw ResponseWriter, req *Request) {
+		body, _ := io.ReadAll(req.Body)
+		rw.Header().Set("X-Proto", req.Proto)
+		fmt.Fprintf(rw, "%s %s %s %s", req.Method, req.URL.Path, req.Header.Get("Host"), body)
+	})
+}
+
+func TestH2PriorKnowledge(t *testing.T) {
+	addr := startServer(t, &Server{EnableH2C: true, Handler: h2EchoHandler()})
+	hc := dialH2(t, addr)
+
+	hc.request(1, "GET", "/hello", nil)
+	resp := hc.readResponses(1)[1]
+
+	if resp.get(":status") != "200" || resp.get("x-proto") != "HTTP/2.0" {
+		t.Fatalf("unexpected header: %v", resp.header)
+	}
+	if want := "GET /hello example.com "; string(resp.body) != want || resp.get("content-length") != fmt.Sprint(len(want)) {
+		t.Fatalf("got %q content-length %q", resp.body, resp.get("content-length"))
+	}
+}
+
+func TestH2Multiplexing(t *testing.T) {
+	release := make(chan struct{})
+	addr := startServer(t, &Server{EnableH2C: true, Handler: testHandler(func(rw ResponseWriter, req *Request) {
+		// 流 1 阻塞，直到流 3 的响应被客户端收到
+		if req.URL.Path == "/slow" {
+			<-release
+		}
+		io.WriteString(rw, req.URL.Path)
+	})})
+	hc := dialH2(t, addr)
+
+	hc.request(1, "GET", "/slow", nil)
+	hc.request(3, "GET", "/fast", nil)
+
+	if got := string(hc.readResponses(3)[3].body); got != "/fast" {
+		t.Fatalf("stream 3 got %q", got)
+	}
+	close(release)
+	if got := string(hc.readResponses(1)[1].body); got != "/slow" {
+		t.Fatalf("stream 1 got %q", got)
+	}
+}
+
+func TestH2PostBody(t *testing.T) {
+	addr := startServer(t, &Server{EnableH2C: true, Handler: h2EchoHandler()})
+	hc := dialH2(t, addr)
+
+	// 请求数据超过默认窗口，服务端需要在 handler 读取之后归还窗口
+	body := bytes.Repeat([]byte("b"), 100<<10)
+	hc.fw.writeHeaders(1, false, hc.headerBlock("POST", "/upload"), h2DefaultMaxFrameSize)
+
+	for p, window := body, h2DefaultWindowSize; len(p) > 0; {
+		// 窗口耗尽，等待服务端的 WINDOW_UPDATE
+		for window == 0 {
+			fh, payload := hc.readFrame()
+			if fh.typ == h2FrameWindowUpdate && fh.streamID == 1 {
+				window += int(binary.BigEndian.Uint32(payload))
+			}
+		}
+
+		n := len(p)
+		for _, limit := range []int{window, h2DefaultMaxFrameSize} {
+			if n > limit {
+				n = limit
+			}
+		}
+		var flags uint8
+		if n == len(p) {
+			flags = h2FlagEndStream
+		}
+		hc.fw.writeFrame(h2FrameData, flags, 1, p[:n])
+		p, window = p[n:], window-n
+	}
+
+	resp := hc.readResponses(1)[1]
+	if want := "POST /upload example.com " + string(body); string(resp.body) != want {
+		t.Fatalf("body mismatch, got %d bytes", len(resp.body))
+	}
+}
+
+func TestH2FlowControl(t *testing.T) {
+	size := 200 << 10
+	addr := startServer(t, &Server{EnableH2C: true, Handler: testHandler(func(rw ResponseWriter, req *Request) {
+		rw.Write(bytes.Repeat([]byte("a"), size))
+	})})
+
+	// 客户端的初始窗口只有 1000 字节，服务端每个 DATA 帧都不能超过剩余窗口
+	hc := dialH2(t, addr, uint32(h2SettingInitialWindowSize), 1000, uint32(h2SettingMaxFrameSize), 1<<20)
+	hc.request(1, "GET", "/", nil)
+
+	var got int
+	for window := 1000; ; {
+		fh, payload := hc.readFrame()
+		if fh.typ != h2FrameData {
+			continue
+		}
+
+		if len(payload) > window {
+			t.Fatalf("DATA of %d bytes exceeds window %d", len(payload), window)
+		}
+		got += len(payload)
+		if fh.has(h2FlagEndStream) {
+			break
+		}
+
+		window -= len(payload)
+		if window == 0 {
+			window = 1000
+			hc.fw.writeWindowUpdate(0, 1000)
+			hc.fw.writeWindowUpdate(1, 1000)
+		}
+	}
+
+	if got != size {
+		t.Fatalf("got %d bytes, want %d", got, size)
+	}
+}
+
+func TestH2Upgrade(t *testing.T) {
+	addr := startServer(t, &Server{EnableH2C: true, Handler: h2EchoHandler()})
+
+	c, err := net.Dial("tcp", addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+	c.SetDeadline(time.Now().Add(10 * time.Second))
+
+	var settings []byte
+	settings = binary.BigEndian.AppendUint16(settings, h2SettingInitialWindowSize)
+	settings = binary.BigEndian.AppendUint32(settings, 1<<20)
+	fmt.Fprintf(c, "POST /up HTTP/1.1\r\nHost: example.com\r\nContent-Length: 5\r\n"+
+		"Connection: Upgrade, HTTP2-Settings\r\nUpgrade: h2c\r\nHTTP2-Settings: %s\r\n\r\nhello",
+		base64.RawURLEncoding.EncodeToString(settings))
+
+	hc := &h2Client{t: t, c: c, bufr: bufio.NewReader(c), fw: &h2Writer{w: bufio.NewWriter(c)}, dec: hpack.NewDecoder(h2DefaultTableSize)}
+	resp, err := http.ReadResponse(hc.bufr, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp.StatusCode != 101 || resp.Header.Get("Upgrade") != "h2c" {
+		t.Fatalf("unexpected upgrade response: %v", resp)
+	}
+
+	io.WriteString(c, h2Preface)
+	hc.fw.writeSettings()
+
+	// 升级请求的响应在 stream 1 上返回
+	r := hc.readResponses(1)[1]
+	if got := string(r.body); got != "POST /up example.com hello" || r.get("x-proto") != "HTTP/2.0" {
+		t.Fatalf("got %q %v", got, r.header)
+	}
+
+	hc.request(3, "GET", "/next", nil)
+	if got := string(hc.readResponses(3)[3].body); got != "GET /next example.com " {
+		t.Fatalf("got %q", got)
+	}
+}
+
+func TestH2Disabled(t *testing.T) {
+	h := testHandler(func(rw ResponseWriter, req *Request) {
+		io.WriteString(rw, req.Proto)
+	})
+
+	// 未开启 EnableH2C 时忽略 Upgrade 首部，依旧按照 HTTP/1.1 回复
+	resp := serveRaw(t, h, "GET / HTTP/1.1\r\nConnection: Upgrade, HTTP2-Settings, close\r\nUpgrade: h2c\r\nHTTP2-Settings: \r\n\r\n")
+	if !strings.HasPrefix(resp, "HTTP/1.1 200 OK\r\n") || !strings.HasSuffix(resp, "HTTP/1.1") {
+		t.Fatalf("unexpected response: %q", resp)
+	}
+}
+
+func TestH2StreamReset(t *testing.T) {
+	canceled := make(chan error, 1)
+	addr := startServer(t, &Server{EnableH2C: true, Handler: testHandler(func(rw ResponseWriter, req *Request) {
+		_, err := io.ReadAll(req.Body)
+		canceled <- err
+	})})
+	hc := dialH2(t, addr)
+
+	hc.fw.writeHeaders(1, false, hc.headerBlock("POST", "/"), h2DefaultMaxFrameSize)
+	hc.fw.writeFrame(h2FrameData, 0, 1, []byte("partial"))
+	hc.fw.writeRSTStream(1, h2ErrCancel)
+
+	select {
+	case err := <-canceled:
+		if err == nil {
+			t.Fatal("reading a reset stream should fail")
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("handler not woken by RST_STREAM")
+	}
+
+	// 连接依旧可用
+	hc.request(3, "GET", "/", nil)
+	if r := hc.readResponses(3)[3]; r.get(":status") != "200" {
+		t.Fatalf("unexpected header: %v", r.header)
+	}
+}
+
+func TestH2ContinuationAndErrors(t *testing.T) {
+	addr := startServer(t, &Server{EnableH2C: true, Handler: h2EchoHandler()})
+	hc := dialH2(t, addr)
+
+	// 首部块拆分成 HEADERS + CONTINUATION
+	block := hc.headerBlock("GET", "/cont", "x-long", strings.Repeat("v", 100))
+	hc.fw.writeFrame(h2FrameHeaders, h2FlagEndStream, 1, block[:10])
+	hc.fw.writeFrame(h2FrameContinuation, h2FlagEndHeaders, 1, block[10:])
+	if got := string(hc.readResponses(1)[1].body); got != "GET /cont example.com " {
+		t.Fatalf("got %q", got)
+	}
+
+	// 缺少伪首部为流错误
+	var bad []byte
+	bad = hpack.AppendHeaderField(bad, hpack.HeaderField{Name: ":method", Value: "GET"})
+	hc.fw.writeHeaders(3, true, bad, h2DefaultMaxFrameSize)
+	if r := hc.readResponses(3)[3]; !r.reset || r.rst != h2ErrProtocol {
+		t.Fatalf("want RST_STREAM PROTOCOL_ERROR, got %+v", r)
+	}
+
+	// stream 0 上的 DATA 为连接错误
+	hc.fw.writeFrame(h2FrameData, 0, 0, []byte("x"))
+	for {
+		fh, payload := hc.readFrame()
+		if fh.typ != h2FrameGoAway {
+			continue
+		}
+		if code := h2ErrCode(binary.BigEndian.Uint32(payload[4:])); code != h2ErrProtocol {
+			t.Fatalf("GOAWAY code %v, want PROTOCOL_ERROR", code)
+		}
+		if last := binary.BigEndian.Uint32(payload); last != 3 {
+			t.Fatalf("GOAWAY last stream %d, want 3", last)
+		}
+		break
+	}
+
+	if _, err := hc.bufr.ReadByte(); err != io.EOF {
+		t.Fatalf("connection should be closed after GOAWAY, got %v", err)
+	}
+}
+
+// TestH2WaitHandlers 客户端断开之后，连接等待 handler 返回才关闭，handler 的 context 被取消
+func TestH2WaitHandlers(t *testing.T) {
+	started := make(chan struct{})
+	release := make(chan struct{})
+	closed := make(chan struct{})
+	var ctxErr error
+	addr := startServer(t, &Server{
+		EnableH2C: true,
+		Handler: testHandler(func(rw ResponseWriter, req *Request) {
+			close(started)
+			<-req.Context().Done()
+			ctxErr = req.Context().Err()
+			<-release
+		}),
+		ConnState: func(c net.Conn, state ConnState) {
+			if state == StateClosed {
+				close(closed)
+			}
+		},
+	})
+
+	hc := dialH2(t, addr)
+	hc.request(1, "GET", "/", nil)
+	<-started
+	hc.c.Close()
+
+	select {
+	case <-closed:
+		t.Fatal("connection closed while the handler is still running")
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	close(release)
+	select {
+	case <-closed:
+	case <-time.After(time.Second):
+		t.Fatal("connection not closed after the handler returned")
+	}
+	if ctxErr != context.Canceled {
+		t.Errorf("handler context err = %v", ctxErr)
+	}
+}
+
+// TestH2IdleTimeout 没有正在执行的 handler 超过 IdleTimeout 时发送 GOAWAY 并关闭连接，
+// 执行时间超过 IdleTimeout 的 handler 不受影响
+func TestH2IdleTimeout(t *testing.T) {
+	addr := startServer(t, &Server{
+		EnableH2C:   true,
+		IdleTimeout: 100 * time.Millisecond,
+		Handler: testHandler(func(rw ResponseWriter, req *Request) {
+			time.Sleep(200 * time.Millisecond)
+			io.WriteString(rw, "slow")
+		}),
+	})
+	hc := dialH2(t, addr)
+
+	start := time.Now()
+	hc.request(1, "GET", "/", nil)
+	if resp := hc.readResponses(1)[1]; string(resp.body) != "slow" {
+		t.Fatalf("got %+v", resp)
+	}
+
+	for {
+		fh, payload := hc.readFrame()
+		if fh.typ != h2FrameGoAway {
+			continue
+		}
+		if code := h2ErrCode(binary.BigEndian.Uint32(payload[4:])); code != h2ErrNo {
+			t.Fatalf("GOAWAY code %v, want NO_ERROR", code)
+		}
+		if last := binary.BigEndian.Uint32(payload); last != 1 {
+			t.Fatalf("GOAWAY last stream %d, want 1", last)
+		}
+		break
+	}
+	if d := time.Since(start); d < 300*time.Millisecond {
+		t.Errorf("idle timeout after %v, counted while the handler was running", d)
+	}
+
+	if _, err := hc.bufr.ReadByte(); err != io.EOF {
+		t.Fatalf("connection should be closed after GOAWAY, got %v", err)
+	}
+}
@@ -1,26 +1,36 @@
 package httptoy
 
+import "net/textproto"
+
 // header 针对请求报文的首部字段的解析
 
 // Header 用来储存一次请求报文的键值对
+// 键为规范格式的首部名，E.g. content-type -> Content-Type，各个方法会先将 key 转为规范格式
 type Header map[string][]string
 
+// Add 在 key 已有的值之后追加 val，同名首部可以出现多次，E.g. Set-Cookie、Vary
 func (h Header) Add(key, val string) {
-	h[key] = []string{val}
+	key = textproto.CanonicalMIMEHeaderKey(key)
+	h[key] = append(h[key], val)
 }
 
 func (h Header) Set(key, val string) {
-	h[key] = []string{val}
+	h[textproto.CanonicalMIMEHeaderKey(key)] = []string{val}
 }
 
 func (h Header) Get(key string) string {
-	if value, ok := h[key]; ok && len(value) > 0 {
+	if value, ok := h[textproto.CanonicalMIMEHeaderKey(key)]; ok && len(value) > 0 {
 		return value[0]
 	} else {
 		return ""
 	}
 }
 
+// Values 返回 key 的全部值
+func (h Header) Values(key string) []string {
+	return h[textproto.CanonicalMIMEHeaderKey(key)]
+}
+
 func (h Header) Del(key string) {
-	delete(h, key)
+	delete(h, textproto.CanonicalMIMEHeaderKey(key))
 }
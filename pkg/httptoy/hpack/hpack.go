@@ -0,0 +1,433 @@
+// Package hpack 实现 HTTP/2 的首部压缩 HPACK (RFC 7541)
+//
+// 解码器完整支持静态表、动态表、Huffman 编码以及动态表大小更新；
+// 编码器只使用静态表，不向动态表插入条目，因此不受对端 SETTINGS_HEADER_TABLE_SIZE 的影响
+package hpack
+
+import (
+	"errors"
+	"fmt"
+)
+
+// HeaderField 是一个首部键值对，名称均为小写
+type HeaderField struct {
+	Name, Value string
+
+	// Sensitive 为 true 时以 never indexed 的形式编码，提示中间节点不要缓存，E.g. authorization
+	Sensitive bool
+}
+
+// IsPseudo 判断是否为 :method、:path 等伪首部
+func (f HeaderField) IsPseudo() bool {
+	return len(f.Name) > 0 && f.Name[0] == ':'
+}
+
+// Size 返回首部在动态表中占用的大小，名称与值的长度加上 32 字节的额外开销
+func (f HeaderField) Size() uint32 {
+	return uint32(len(f.Name) + len(f.Value) + 32)
+}
+
+// DecodingError 表示首部块解码失败，HTTP/2 中对应 COMPRESSION_ERROR
+type DecodingError struct {
+	Err error
+}
+
+func (de DecodingError) Error() string {
+	return fmt.Sprintf("hpack: decoding error: %v", de.Err)
+}
+
+var (
+	errNeedMore        = errors.New("need more data")
+	errIntegerOverflow = errors.New("integer overflow")
+	errInvalidIndex    = errors.New("invalid table index")
+	errStringTooLong   = errors.New("string too long")
+	errTableSizeUpdate = errors.New("dynamic table size update exceeds limit")
+	errLateSizeUpdate  = errors.New("dynamic table size update after header field")
+)
+
+// staticTable RFC 7541 附录 A，下标 0 对应索引 1
+var staticTable = [...]HeaderField{
+	{Name: ":authority"},
+	{Name: ":method", Value: "GET"},
+	{Name: ":method", Value: "POST"},
+	{Name: ":path", Value: "/"},
+	{Name: ":path", Value: "/index.html"},
+	{Name: ":scheme", Value: "http"},
+	{Name: ":scheme", Value: "https"},
+	{Name: ":status", Value: "200"},
+	{Name: ":status", Value: "204"},
+	{Name: ":status", Value: "206"},
+	{Name: ":status", Value: "304"},
+	{Name: ":status", Value: "400"},
+	{Name: ":status", Value: "404"},
+	{Name: ":status", Value: "500"},
+	{Name: "accept-charset"},
+	{Name: "accept-encoding", Value: "gzip, deflate"},
+	{Name: "accept-language"},
+	{Name: "accept-ranges"},
+	{Name: "accept"},
+	{Name: "access-control-allow-origin"},
+	{Name: "age"},
+	{Name: "allow"},
+	{Name: "authorization"},
+	{Name: "cache-control"},
+	{Name: "content-disposition"},
+	{Name: "content-encoding"},
+	{Name: "content-language"},
+	{Name: "content-length"},
+	{Name: "content-location"},
+	{Name: "content-range"},
+	{Name: "content-type"},
+	{Name: "cookie"},
+	{Name: "date"},
+	{Name: "etag"},
+	{Name: "expect"},
+	{Name: "expires"},
+	{Name: "from"},
+	{Name: "host"},
+	{Name: "if-match"},
+	{Name: "if-modified-since"},
+	{Name: "if-none-match"},
+	{Name: "if-range"},
+	{Name: "if-unmodified-since"},
+	{Name: "last-modified"},
+	{Name: "link"},
+	{Name: "location"},
+	{Name: "max-forwards"},
+	{Name: "proxy-authenticate"},
+	{Name: "proxy-authorization"},
+	{Name: "range"},
+	{Name: "referer"},
+	{Name: "refresh"},
+	{Name: "retry-after"},
+	{Name: "server"},
+	{Name: "set-cookie"},
+	{Name: "strict-transport-security"},
+	{Name: "transfer-encoding"},
+	{Name: "user-agent"},
+	{Name: "vary"},
+	{Name: "via"},
+	{Name: "www-authenticate"},
+}
+
+// 静态表的反向索引，供编码器查找
+var (
+	staticByName  = make(map[string]uint64)
+	staticByField = make(map[HeaderField]uint64)
+)
+
+func init() {
+	for i, f := range staticTable {
+		idx := uint64(i + 1)
+		if _, ok := staticByName[f.Name]; !ok {
+			staticByName[f.Name] = idx
+		}
+		staticByField[f] = idx
+	}
+}
+
+// dynamicTable 动态表，新条目插入末尾，索引从最新的条目开始计数
+type dynamicTable struct {
+	ents    []HeaderField
+	size    uint32 // 当前占用大小
+	maxSize uint32 // 当前大小上限，由动态表大小更新指令设置
+}
+
+func (dt *dynamicTable) add(f HeaderField) {
+	dt.ents = append(dt.ents, f)
+	dt.size += f.Size()
+	dt.evict()
+}
+
+func (dt *dynamicTable) setMaxSize(n uint32) {
+	dt.maxSize = n
+	dt.evict()
+}
+
+// evict 淘汰最旧的条目，直到大小不超过上限
+func (dt *dynamicTable) evict() {
+	var n int
+	for dt.size > dt.maxSize && n < len(dt.ents) {
+		dt.size -= dt.ents[n].Size()
+		n++
+	}
+
+	if n > 0 {
+		copy(dt.ents, dt.ents[n:])
+		for k := len(dt.ents) - n; k < len(dt.ents); k++ {
+			dt.ents[k] = HeaderField{}
+		}
+		dt.ents = dt.ents[:len(dt.ents)-n]
+	}
+}
+
+// Decoder 解码首部块，同一个连接上的所有首部块必须按顺序交由同一个 Decoder 解码
+type Decoder struct {
+	dyn dynamicTable
+
+	// maxTableSize 是我方通过 SETTINGS_HEADER_TABLE_SIZE 允许对端使用的动态表上限
+	maxTableSize uint32
+
+	// MaxStringLength 限制单个字符串解码后的长度，<= 0 时不限制
+	MaxStringLength int
+}
+
+// NewDecoder 创建 Decoder，maxTableSize 为我方允许的动态表上限，HTTP/2 默认为 4096
+func NewDecoder(maxTableSize uint32) *Decoder {
+	return &Decoder{
+		dyn:          dynamicTable{maxSize: maxTableSize},
+		maxTableSize: maxTableSize,
+	}
+}
+
+// SetMaxTableSize 修改我方允许的动态表上限，对端需要通过大小更新指令确认
+func (d *Decoder) SetMaxTableSize(n uint32) {
+	d.maxTableSize = n
+	if d.dyn.maxSize > n {
+		d.dyn.setMaxSize(n)
+	}
+}
+
+// at 根据索引查找首部，1~61 为静态表，62 开始为动态表
+func (d *Decoder) at(idx uint64) (HeaderField, bool) {
+	if idx == 0 {
+		return HeaderField{}, false
+	}
+	if idx <= uint64(len(staticTable)) {
+		return staticTable[idx-1], true
+	}
+
+	k := idx - uint64(len(staticTable))
+	if k > uint64(len(d.dyn.ents)) {
+		return HeaderField{}, false
+	}
+
+	return d.dyn.ents[uint64(len(d.dyn.ents))-k], true
+}
+
+// Decode 解码一个完整的首部块
+func (d *Decoder) Decode(block []byte) ([]HeaderField, error) {
+	var (
+		fields   []HeaderField
+		f        HeaderField
+		err      error
+		sawField bool
+	)
+
+	for len(block) > 0 {
+		b := block[0]
+		switch {
+		case b&0x80 != 0: // 1xxxxxxx 索引首部
+			block, f, err = d.parseIndexed(block)
+		case b&0xc0 == 0x40: // 01xxxxxx 带索引的字面量
+			block, f, err = d.parseLiteral(block, 6, true)
+		case b&0xe0 == 0x20: // 001xxxxx 动态表大小更新，只能出现在首部块开头
+			if sawField {
+				return nil, DecodingError{errLateSizeUpdate}
+			}
+			block, err = d.parseSizeUpdate(block)
+			if err != nil {
+				return nil, DecodingError{err}
+			}
+			continue
+		case b&0xf0 == 0x10: // 0001xxxx 永不索引的字面量
+			block, f, err = d.parseLiteral(block, 4, false)
+			f.Sensitive = true
+		default: // 0000xxxx 不索引的字面量
+			block, f, err = d.parseLiteral(block, 4, false)
+		}
+
+		if err != nil {
+			return nil, DecodingError{err}
+		}
+
+		sawField = true
+		fields = append(fields, f)
+	}
+
+	return fields, nil
+}
+
+func (d *Decoder) parseIndexed(p []byte) ([]byte, HeaderField, error) {
+	idx, p, err := readVarInt(7, p)
+	if err != nil {
+		return nil, HeaderField{}, err
+	}
+
+	f, ok := d.at(idx)
+	if !ok {
+		return nil, HeaderField{}, errInvalidIndex
+	}
+
+	return p, HeaderField{Name: f.Name, Value: f.Value}, nil
+}
+
+// parseLiteral 解析字面量首部，名称可能来自索引，也可能是字面量
+func (d *Decoder) parseLiteral(p []byte, n byte, indexing bool) ([]byte, HeaderField, error) {
+	var f HeaderField
+
+	idx, p, err := readVarInt(n, p)
+	if err != nil {
+		return nil, f, err
+	}
+
+	if idx > 0 {
+		ent, ok := d.at(idx)
+		if !ok {
+			return nil, f, errInvalidIndex
+		}
+		f.Name = ent.Name
+	} else {
+		if f.Name, p, err = d.readString(p); err != nil {
+			return nil, f, err
+		}
+	}
+
+	if f.Value, p, err = d.readString(p); err != nil {
+		return nil, f, err
+	}
+
+	if indexing {
+		d.dyn.add(f)
+	}
+
+	return p, f, nil
+}
+
+func (d *Decoder) parseSizeUpdate(p []byte) ([]byte, error) {
+	size, p, err := readVarInt(5, p)
+	if err != nil {
+		return nil, err
+	}
+
+	if size > uint64(d.maxTableSize) {
+		return nil, errTableSizeUpdate
+	}
+	d.dyn.setMaxSize(uint32(size))
+
+	return p, nil
+}
+
+// readString 读取字符串：1 位 Huffman 标记，7 位前缀的长度，然后是数据
+func (d *Decoder) readString(p []byte) (string, []byte, error) {
+	if len(p) == 0 {
+		return "", nil, errNeedMore
+	}
+
+	huffman := p[0]&0x80 != 0
+	n, p, err := readVarInt(7, p)
+	if err != nil {
+		return "", nil, err
+	}
+	if uint64(len(p)) < n {
+		return "", nil, errNeedMore
+	}
+
+	// Huffman 编码每个字节最长 30 位，长度超过上限的 4 倍时解码后必定超过上限
+	if max := d.MaxStringLength; max > 0 && (!huffman && n > uint64(max) || huffman && n/4 > uint64(max)) {
+		return "", nil, errStringTooLong
+	}
+
+	data := p[:n]
+	p = p[n:]
+	if !huffman {
+		return string(data), p, nil
+	}
+
+	buf, err := HuffmanDecode(make([]byte, 0, len(data)*8/5), data)
+	if err != nil {
+		return "", nil, err
+	}
+	if max := d.MaxStringLength; max > 0 && len(buf) > max {
+		return "", nil, errStringTooLong
+	}
+
+	return string(buf), p, nil
+}
+
+// readVarInt 读取 n 位前缀的整数 (RFC 7541 5.1)
+func readVarInt(n byte, p []byte) (uint64, []byte, error) {
+	if len(p) == 0 {
+		return 0, nil, errNeedMore
+	}
+
+	mask := uint64(1)<<n - 1
+	i := uint64(p[0]) & mask
+	p = p[1:]
+	if i < mask {
+		return i, p, nil
+	}
+
+	var m uint
+	for len(p) > 0 {
+		b := p[0]
+		p = p[1:]
+
+		i += uint64(b&0x7f) << m
+		if b&0x80 == 0 {
+			return i, p, nil
+		}
+
+		m += 7
+		if m >= 63 {
+			return 0, nil, errIntegerOverflow
+		}
+	}
+
+	return 0, nil, errNeedMore
+}
+
+// appendVarInt 以 n 位前缀编码整数 i，first 为第一个字节中前缀之外的高位
+func appendVarInt(dst []byte, n byte, first byte, i uint64) []byte {
+	mask := uint64(1)<<n - 1
+	if i < mask {
+		return append(dst, first|byte(i))
+	}
+
+	dst = append(dst, first|byte(mask))
+	i -= mask
+	for i >= 0x80 {
+		dst = append(dst, byte(i&0x7f)|0x80)
+		i >>= 7
+	}
+
+	return append(dst, byte(i))
+}
+
+// appendString 编码字符串，Huffman 编码更短时使用 Huffman 编码
+func appendString(dst []byte, s string) []byte {
+	if n := HuffmanEncodeLength(s); n < len(s) {
+		dst = appendVarInt(dst, 7, 0x80, uint64(n))
+		return AppendHuffmanString(dst, s)
+	}
+
+	dst = appendVarInt(dst, 7, 0, uint64(len(s)))
+	return append(dst, s...)
+}
+
+// AppendHeaderField 编码首部并追加到 dst，只引用静态表，不修改动态表
+// 1.静态表中存在完全相同的条目，则编码为索引
+// 2.静态表中存在相同的名称，则编码为名称索引加字面量的值
+// 3.否则名称跟值都编码为字面量
+func AppendHeaderField(dst []byte, f HeaderField) []byte {
+	if !f.Sensitive {
+		if idx, ok := staticByField[HeaderField{Name: f.Name, Value: f.Value}]; ok {
+			return appendVarInt(dst, 7, 0x80, idx)
+		}
+	}
+
+	// 不索引 0000xxxx，永不索引 0001xxxx
+	var first byte
+	if f.Sensitive {
+		first = 0x10
+	}
+
+	if idx, ok := staticByName[f.Name]; ok {
+		dst = appendVarInt(dst, 4, first, idx)
+	} else {
+		dst = append(dst, first)
+		dst = appendString(dst, f.Name)
+	}
+
+	return appendString(dst, f.Value)
+}
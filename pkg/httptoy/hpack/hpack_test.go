@@ -0,0 +1,189 @@
+package hpack
+
+import (
+	"encoding/hex"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func mustHex(t *testing.T, s string) []byte {
+	t.Helper()
+
+	b, err := hex.DecodeString(strings.ReplaceAll(s, " ", ""))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return b
+}
+
+func fields(kv ...string) []HeaderField {
+	var fs []HeaderField
+	for i := 0; i < len(kv); i += 2 {
+		fs = append(fs, HeaderField{Name: kv[i], Value: kv[i+1]})
+	}
+
+	return fs
+}
+
+type decodeStep struct {
+	block string
+	want  []HeaderField
+	size  uint32
+}
+
+func runDecodeSteps(t *testing.T, d *Decoder, steps []decodeStep) {
+	t.Helper()
+
+	for i, step := range steps {
+		got, err := d.Decode(mustHex(t, step.block))
+		if err != nil {
+			t.Fatalf("step %d: %v", i, err)
+		}
+		if !reflect.DeepEqual(got, step.want) {
+			t.Fatalf("step %d:\n got %v\nwant %v", i, got, step.want)
+		}
+		if d.dyn.size != step.size {
+			t.Fatalf("step %d: table size %d, want %d", i, d.dyn.size, step.size)
+		}
+	}
+}
+
+// RFC 7541 C.3 不使用 Huffman 编码的请求
+func TestDecodeRequestsWithoutHuffman(t *testing.T) {
+	runDecodeSteps(t, NewDecoder(4096), []decodeStep{
+		{
+			"8286 8441 0f77 7777 2e65 7861 6d70 6c65 2e63 6f6d",
+			fields(":method", "GET", ":scheme", "http", ":path", "/", ":authority", "www.example.com"),
+			57,
+		},
+		{
+			"8286 84be 5808 6e6f 2d63 6163 6865",
+			fields(":method", "GET", ":scheme", "http", ":path", "/", ":authority", "www.example.com",
+				"cache-control", "no-cache"),
+			110,
+		},
+		{
+			"8287 85bf 400a 6375 7374 6f6d 2d6b 6579 0c63 7573 746f 6d2d 7661 6c75 65",
+			fields(":method", "GET", ":scheme", "https", ":path", "/index.html", ":authority", "www.example.com",
+				"custom-key", "custom-value"),
+			164,
+		},
+	})
+}
+
+// RFC 7541 C.4 使用 Huffman 编码的请求
+func TestDecodeRequestsWithHuffman(t *testing.T) {
+	runDecodeSteps(t, NewDecoder(4096), []decodeStep{
+		{
+			"8286 8441 8cf1 e3c2 e5f2 3a6b a0ab 90f4 ff",
+			fields(":method", "GET", ":scheme", "http", ":path", "/", ":authority", "www.example.com"),
+			57,
+		},
+		{
+			"8286 84be 5886 a8eb 1064 9cbf",
+			fields(":method", "GET", ":scheme", "http", ":path", "/", ":authority", "www.example.com",
+				"cache-control", "no-cache"),
+			110,
+		},
+		{
+			"8287 85bf 4088 25a8 49e9 5ba9 7d7f 8925 a849 e95b b8e8 b4bf",
+			fields(":method", "GET", ":scheme", "https", ":path", "/index.html", ":authority", "www.example.com",
+				"custom-key", "custom-value"),
+			164,
+		},
+	})
+}
+
+// RFC 7541 C.6 动态表上限为 256 时的响应，检验条目淘汰
+func TestDecodeResponsesWithEviction(t *testing.T) {
+	runDecodeSteps(t, NewDecoder(256), []decodeStep{
+		{
+			"4882 6402 5885 aec3 771a 4b61 96d0 7abe 9410 54d4 44a8 2005 9504 0b81 66e0 82a6 2d1b ff6e 919d 29ad 1718 63c7 8f0b 97c8 e9ae 82ae 43d3",
+			fields(":status", "302", "cache-control", "private", "date", "Mon, 21 Oct 2013 20:13:21 GMT",
+				"location", "https://www.example.com"),
+			222,
+		},
+		{
+			"4883 640e ffc1 c0bf",
+			fields(":status", "307", "cache-control", "private", "date", "Mon, 21 Oct 2013 20:13:21 GMT",
+				"location", "https://www.example.com"),
+			222,
+		},
+		{
+			"88c1 6196 d07a be94 1054 d444 a820 0595 040b 8166 e084 a62d 1bff c05a 839b d9ab 77ad 94e7 821d d7f2 e6c7 b335 dfdf cd5b 3960 d5af 2708 7f36 72c1 ab27 0fb5 291f 9587 3160 65c0 03ed 4ee5 b106 3d50 07",
+			fields(":status", "200", "cache-control", "private", "date", "Mon, 21 Oct 2013 20:13:22 GMT",
+				"location", "https://www.example.com", "content-encoding", "gzip",
+				"set-cookie", "foo=ASDJKHQKBZXOQWEOPIUAXQWEOIU; max-age=3600; version=1"),
+			215,
+		},
+	})
+}
+
+func TestDecodeErrors(t *testing.T) {
+	tests := []struct {
+		name  string
+		block string
+	}{
+		{"index zero", "80"},
+		{"index out of range", "ff00"},
+		{"truncated string", "400a6375"},
+		{"size update too large", "3fe21f"},
+		{"size update after field", "8220"},
+		{"integer overflow", "ffffffffffffffffffffff7f"},
+		{"huffman EOS padding too long", "4081ff00"},
+	}
+
+	for _, tt := range tests {
+		if _, err := NewDecoder(4096).Decode(mustHex(t, tt.block)); err == nil {
+			t.Errorf("%s: expected error", tt.name)
+		}
+	}
+}
+
+func TestHuffmanRoundTrip(t *testing.T) {
+	for _, s := range []string{"", "a", "www.example.com", "no-cache", "custom-key", "\x00\xff\x10 中文", strings.Repeat("z", 300)} {
+		enc := AppendHuffmanString(nil, s)
+		if len(enc) != HuffmanEncodeLength(s) {
+			t.Errorf("%q: encoded length %d, want %d", s, len(enc), HuffmanEncodeLength(s))
+		}
+
+		dec, err := HuffmanDecode(nil, enc)
+		if err != nil || string(dec) != s {
+			t.Errorf("%q: round trip got %q, %v", s, dec, err)
+		}
+	}
+
+	if got := hex.EncodeToString(AppendHuffmanString(nil, "www.example.com")); got != "f1e3c2e5f23a6ba0ab90f4ff" {
+		t.Errorf("huffman(www.example.com) = %s", got)
+	}
+}
+
+func TestEncodeRoundTrip(t *testing.T) {
+	want := []HeaderField{
+		{Name: ":status", Value: "200"},
+		{Name: ":status", Value: "418"},
+		{Name: "content-type", Value: "text/plain; charset=utf-8"},
+		{Name: "x-custom", Value: strings.Repeat("v", 200)},
+		{Name: "authorization", Value: "secret", Sensitive: true},
+		{Name: "accept-encoding", Value: "gzip, deflate"},
+	}
+
+	var block []byte
+	for _, f := range want {
+		block = AppendHeaderField(block, f)
+	}
+
+	d := NewDecoder(4096)
+	got, err := d.Decode(block)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Fatalf("got %v\nwant %v", got, want)
+	}
+	if len(d.dyn.ents) != 0 {
+		t.Fatalf("encoder should not insert into the dynamic table")
+	}
+}
@@ -0,0 +1,122 @@
+package hpack
+
+import "errors"
+
+// huffman.go 负责字符串的 Huffman 编码以及解码
+// 解码时根据编码表构建二叉树，按位从高到低遍历，到达叶子节点即得到一个字节
+
+// ErrInvalidHuffman 表示 Huffman 编码的字符串格式错误
+var ErrInvalidHuffman = errors.New("hpack: invalid Huffman-encoded data")
+
+const eosSymbol = 256 // EOS 的符号值
+
+type huffmanNode struct {
+	children [2]uint16 // 左右子节点在 huffmanTree 中的下标，0 表示不存在
+	sym      uint16    // 叶子节点对应的符号
+	leaf     bool
+}
+
+// huffmanTree 第 0 个节点为根节点
+var huffmanTree = buildHuffmanTree()
+
+func buildHuffmanTree() []huffmanNode {
+	tree := make([]huffmanNode, 1, 2*(eosSymbol+1))
+
+	insert := func(sym uint16, code uint32, n uint8) {
+		cur := 0
+		for i := int(n) - 1; i >= 0; i-- {
+			b := (code >> uint(i)) & 1
+			if tree[cur].children[b] == 0 {
+				tree = append(tree, huffmanNode{})
+				tree[cur].children[b] = uint16(len(tree) - 1)
+			}
+			cur = int(tree[cur].children[b])
+		}
+
+		tree[cur].sym = sym
+		tree[cur].leaf = true
+	}
+
+	for sym := 0; sym < eosSymbol; sym++ {
+		insert(uint16(sym), huffmanCodes[sym], huffmanCodeLen[sym])
+	}
+	insert(eosSymbol, 0x3fffffff, 30)
+
+	return tree
+}
+
+// HuffmanDecode 将 Huffman 编码的 src 解码后追加到 dst
+// 最后不足一个字节的部分必须是 EOS 编码的前缀（全 1），并且少于 8 位
+func HuffmanDecode(dst, src []byte) ([]byte, error) {
+	var (
+		cur     int
+		padBits int  // 上一个符号之后读取的位数
+		allOnes bool = true
+	)
+
+	for _, c := range src {
+		for i := 7; i >= 0; i-- {
+			b := (c >> uint(i)) & 1
+			next := huffmanTree[cur].children[b]
+			if next == 0 {
+				return dst, ErrInvalidHuffman
+			}
+			cur = int(next)
+
+			if !huffmanTree[cur].leaf {
+				padBits++
+				allOnes = allOnes && b == 1
+				continue
+			}
+
+			// 编码中不允许出现 EOS
+			if huffmanTree[cur].sym == eosSymbol {
+				return dst, ErrInvalidHuffman
+			}
+
+			dst = append(dst, byte(huffmanTree[cur].sym))
+			cur, padBits, allOnes = 0, 0, true
+		}
+	}
+
+	if padBits > 7 || !allOnes {
+		return dst, ErrInvalidHuffman
+	}
+
+	return dst, nil
+}
+
+// HuffmanEncodeLength 返回 s 经过 Huffman 编码后的字节数
+func HuffmanEncodeLength(s string) int {
+	var n uint64
+	for i := 0; i < len(s); i++ {
+		n += uint64(huffmanCodeLen[s[i]])
+	}
+
+	return int((n + 7) / 8)
+}
+
+// AppendHuffmanString 将 s 经过 Huffman 编码后追加到 dst，最后不足一个字节的部分以 EOS 的前缀填充
+func AppendHuffmanString(dst []byte, s string) []byte {
+	var (
+		acc  uint64 // 尚未写出的位
+		bits uint   // acc 中有效的位数
+	)
+
+	for i := 0; i < len(s); i++ {
+		acc = acc<<huffmanCodeLen[s[i]] | uint64(huffmanCodes[s[i]])
+		bits += uint(huffmanCodeLen[s[i]])
+
+		for bits >= 8 {
+			bits -= 8
+			dst = append(dst, byte(acc>>bits))
+		}
+	}
+
+	if bits > 0 {
+		acc = acc<<(8-bits) | (1<<(8-bits) - 1)
+		dst = append(dst, byte(acc))
+	}
+
+	return dst
+}
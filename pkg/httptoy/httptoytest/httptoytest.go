@@ -0,0 +1,26 @@
+// Package httptoytest 提供测试 httptoy Handler 的工具
+// ResponseRecorder 以及 NewRequest 可以不经过网络直接调用 Handler，
+// Server 在本地的临时端口上启动真正的 httptoy.Server，测试结束时关闭
+package httptoytest
+
+import (
+	"io"
+
+	"build-HTTP-from-scracth/pkg/httptoy"
+)
+
+// NewRequest 创建用于直接调用 Handler 的请求，参数错误时 panic，方便在测试中使用
+// target 为路径时 Host 默认为 example.com，RemoteAddr 为 192.0.2.1:1234 (RFC 5737 的文档地址)
+func NewRequest(method, target string, body io.Reader) *httptoy.Request {
+	req, err := httptoy.NewRequest(method, target, body)
+	if err != nil {
+		panic("httptoytest: invalid NewRequest arguments; " + err.Error())
+	}
+
+	if req.Header.Get("Host") == "" {
+		req.Header.Set("Host", "example.com")
+	}
+	req.RemoteAddr = "192.0.2.1:1234"
+
+	return req
+}
@@ -0,0 +1,95 @@
+package httptoytest
+
+import (
+	"io"
+	"strings"
+	"testing"
+
+	"build-HTTP-from-scracth/pkg/httptoy"
+)
+
+func echoHandler(rw httptoy.ResponseWriter, req *httptoy.Request) {
+	body, _ := io.ReadAll(req.Body)
+	rw.Header().Set("X-Method", req.Method)
+	rw.WriteHeader(201)
+	io.WriteString(rw, req.URL.Path+"?"+req.Query("q")+":"+string(body))
+}
+
+func TestRecorder(t *testing.T) {
+	rec := NewRecorder()
+	req := NewRequest("POST", "/echo?q=1", strings.NewReader("hello"))
+	httptoy.HandlerFunc(echoHandler).ServeHTTP(rec, req)
+
+	if rec.Code != 201 || rec.HeaderMap.Get("X-Method") != "POST" || rec.Body.String() != "/echo?1:hello" {
+		t.Fatalf("got %d %v %q", rec.Code, rec.HeaderMap, rec.Body.String())
+	}
+	if ct := rec.Header().Get("Content-Type"); ct != "text/plain; charset=utf-8" {
+		t.Errorf("Content-Type = %q", ct)
+	}
+}
+
+// TestRecorderNegotiate 内容协商在 Recorder 上同样维护 Vary
+func TestRecorderNegotiate(t *testing.T) {
+	rec := NewRecorder()
+	req := NewRequest("GET", "/", nil)
+	req.Header.Set("Accept", "text/html")
+	req.Header.Set("Accept-Language", "en")
+	httptoy.HandlerFunc(func(rw httptoy.ResponseWriter, req *httptoy.Request) {
+		typ, _ := httptoy.Negotiate(rw, req, "application/json", "text/html")
+		lang, _ := httptoy.NegotiateLanguage(rw, req, "zh", "en")
+		io.WriteString(rw, typ+" "+lang)
+	}).ServeHTTP(rec, req)
+
+	if rec.Body.String() != "text/html en" || rec.Header().Get("Vary") != "Accept, Accept-Language" {
+		t.Fatalf("body %q, Vary %q", rec.Body.String(), rec.Header().Get("Vary"))
+	}
+}
+
+func TestNewRequest(t *testing.T) {
+	req := NewRequest("POST", "https://golang.org:8443/form?a=1", strings.NewReader("b=2&b=3"))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+
+	if req.Header.Get("Host") != "golang.org:8443" || req.Header.Get("Content-Length") != "7" || req.RemoteURI != "/form?a=1" {
+		t.Fatalf("unexpected request %+v", req)
+	}
+	if err := req.ParseForm(); err != nil {
+		t.Fatal(err)
+	}
+	if req.FormValue("a") != "1" || strings.Join(req.PostForm["b"], ",") != "2,3" {
+		t.Fatalf("form = %v", req.Form)
+	}
+
+	req = NewRequest("GET", "/", nil)
+	if req.Header.Get("Host") != "example.com" || req.RemoteAddr == "" {
+		t.Fatalf("unexpected request %+v", req)
+	}
+	if b, err := io.ReadAll(req.Body); err != nil || len(b) != 0 {
+		t.Fatalf("body = %q, %v", b, err)
+	}
+}
+
+func TestServer(t *testing.T) {
+	for _, newServer := range []func(httptoy.Handler) *Server{NewServer, NewTLSServer} {
+		ts := newServer(httptoy.HandlerFunc(echoHandler))
+
+		resp, err := ts.Client().Post(ts.URL+"/echo?q=x", "text/plain", strings.NewReader("body"))
+		if err != nil {
+			ts.Close()
+			t.Fatal(err)
+		}
+		b, _ := io.ReadAll(resp.Body)
+		resp.Body.Close()
+
+		if resp.StatusCode != 201 || string(b) != "/echo?x:body" {
+			t.Errorf("%s: got %d %q", ts.URL, resp.StatusCode, b)
+		}
+		if (ts.Certificate() != nil) != strings.HasPrefix(ts.URL, "https://") {
+			t.Errorf("%s: certificate %v", ts.URL, ts.Certificate())
+		}
+
+		ts.Close()
+		if _, err = ts.Client().Get(ts.URL); err == nil {
+			t.Errorf("%s: request after Close should fail", ts.URL)
+		}
+	}
+}
@@ -0,0 +1,54 @@
+package httptoytest
+
+import (
+	"bytes"
+	"net/http"
+
+	"build-HTTP-from-scracth/pkg/httptoy"
+)
+
+// ResponseRecorder 实现 httptoy.ResponseWriter，记录 handler 写入的状态码、首部以及报文主体
+type ResponseRecorder struct {
+	Code      int            // 状态码，handler 没有调用 WriteHeader 时为 200
+	HeaderMap httptoy.Header // handler 设置的首部
+	Body      *bytes.Buffer  // handler 写入的报文主体
+
+	wroteHeader bool
+}
+
+// NewRecorder 创建 ResponseRecorder
+func NewRecorder() *ResponseRecorder {
+	return &ResponseRecorder{
+		Code:      200,
+		HeaderMap: make(httptoy.Header),
+		Body:      new(bytes.Buffer),
+	}
+}
+
+// Header 返回 handler 设置首部的 map
+func (rw *ResponseRecorder) Header() httptoy.Header {
+	return rw.HeaderMap
+}
+
+// WriteHeader 记录状态码，只有第一次调用有效
+func (rw *ResponseRecorder) WriteHeader(statusCode int) {
+	if rw.wroteHeader {
+		return
+	}
+
+	rw.Code = statusCode
+	rw.wroteHeader = true
+}
+
+// Write 记录报文主体，与 httptoy.Server 相同，没有设置 Content-Type 时根据第一次写入的数据检测类型
+func (rw *ResponseRecorder) Write(p []byte) (int, error) {
+	if !rw.wroteHeader {
+		rw.WriteHeader(200)
+	}
+	// 首部在第一次写入报文主体时才发送，WriteHeader 之后依旧可以检测类型
+	if rw.Body.Len() == 0 && len(p) > 0 && rw.HeaderMap.Get("Content-Type") == "" {
+		rw.HeaderMap.Set("Content-Type", http.DetectContentType(p))
+	}
+
+	return rw.Body.Write(p)
+}
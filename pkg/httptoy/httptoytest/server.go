@@ -0,0 +1,184 @@
+package httptoytest
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"math/big"
+	"net"
+	"net/http"
+	"time"
+
+	"build-HTTP-from-scracth/pkg/httptoy"
+)
+
+// Server 是监听本地临时端口的 httptoy.Server，用于端到端测试
+// E.g.
+//
+//	ts := httptoytest.NewServer(handler)
+//	defer ts.Close()
+//	resp, err := ts.Client().Get(ts.URL + "/path")
+type Server struct {
+	URL      string // http://127.0.0.1:port 或者 https://127.0.0.1:port，没有结尾的 /
+	Listener net.Listener
+
+	// Config 在 Start 之前可以修改，E.g. 开启 EnableH2C 或者设置 IdleTimeout
+	Config *httptoy.Server
+	// TLS 在 StartTLS 之前可以修改，证书为空时使用自动生成的自签名证书
+	TLS *tls.Config
+
+	certificate *x509.Certificate
+	client      *http.Client
+	done        chan struct{} // Serve 返回时关闭
+}
+
+// NewServer 创建并启动 Server
+func NewServer(handler httptoy.Handler) *Server {
+	ts := NewUnstartedServer(handler)
+	ts.Start()
+
+	return ts
+}
+
+// NewTLSServer 创建并启动使用 TLS 的 Server，Client 会信任其自签名证书
+func NewTLSServer(handler httptoy.Handler) *Server {
+	ts := NewUnstartedServer(handler)
+	ts.StartTLS()
+
+	return ts
+}
+
+// NewUnstartedServer 创建监听临时端口但还没有开始服务的 Server，修改配置之后调用 Start 或者 StartTLS
+func NewUnstartedServer(handler httptoy.Handler) *Server {
+	return &Server{
+		Listener: newLocalListener(),
+		Config:   &httptoy.Server{Handler: handler},
+		client:   &http.Client{Transport: &http.Transport{}},
+	}
+}
+
+// newLocalListener 监听本地回环地址的临时端口，不支持 IPv4 时使用 IPv6
+func newLocalListener() net.Listener {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		if l, err = net.Listen("tcp", "[::1]:0"); err != nil {
+			panic("httptoytest: failed to listen on a port: " + err.Error())
+		}
+	}
+
+	return l
+}
+
+// Start 开始服务
+func (ts *Server) Start() {
+	if ts.URL != "" {
+		panic("httptoytest: Server already started")
+	}
+
+	ts.URL = "http://" + ts.Listener.Addr().String()
+	ts.goServe()
+}
+
+// StartTLS 以 TLS 开始服务
+func (ts *Server) StartTLS() {
+	if ts.URL != "" {
+		panic("httptoytest: Server already started")
+	}
+
+	if ts.TLS == nil {
+		ts.TLS = new(tls.Config)
+	}
+	if len(ts.TLS.Certificates) == 0 {
+		cert, err := selfSignedCert()
+		if err != nil {
+			panic("httptoytest: failed to generate certificate: " + err.Error())
+		}
+		ts.TLS.Certificates = []tls.Certificate{cert}
+	}
+
+	ts.certificate = ts.TLS.Certificates[0].Leaf
+	if ts.certificate == nil {
+		leaf, err := x509.ParseCertificate(ts.TLS.Certificates[0].Certificate[0])
+		if err != nil {
+			panic("httptoytest: invalid certificate: " + err.Error())
+		}
+		ts.certificate = leaf
+	}
+
+	pool := x509.NewCertPool()
+	pool.AddCert(ts.certificate)
+	ts.client.Transport = &http.Transport{TLSClientConfig: &tls.Config{RootCAs: pool}}
+
+	ts.Listener = tls.NewListener(ts.Listener, ts.TLS)
+	ts.URL = "https://" + ts.Listener.Addr().String()
+	ts.goServe()
+}
+
+func (ts *Server) goServe() {
+	ts.done = make(chan struct{})
+
+	go func() {
+		defer close(ts.done)
+		ts.Config.Serve(ts.Listener)
+	}()
+}
+
+// Close 关闭 listener 以及所有连接，等待 Serve 返回
+func (ts *Server) Close() {
+	ts.Config.Close()
+	if ts.done != nil {
+		<-ts.done
+	} else {
+		// 没有启动的 Server 只需要关闭 listener
+		ts.Listener.Close()
+	}
+
+	if t, ok := ts.client.Transport.(*http.Transport); ok {
+		t.CloseIdleConnections()
+	}
+}
+
+// Client 返回请求该 Server 的客户端，TLS 的 Server 的客户端信任其证书
+func (ts *Server) Client() *http.Client {
+	return ts.client
+}
+
+// Certificate 返回 TLS 的 Server 使用的证书，非 TLS 时为 nil
+func (ts *Server) Certificate() *x509.Certificate {
+	return ts.certificate
+}
+
+// selfSignedCert 生成 127.0.0.1、::1 以及 localhost 的自签名证书
+func selfSignedCert() (tls.Certificate, error) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return tls.Certificate{}, err
+	}
+
+	tmpl := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{Organization: []string{"httptoytest"}},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(24 * time.Hour),
+		KeyUsage:              x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
+		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+		IPAddresses:           []net.IP{net.IPv4(127, 0, 0, 1), net.IPv6loopback},
+		DNSNames:              []string{"localhost", "example.com"},
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &key.PublicKey, key)
+	if err != nil {
+		return tls.Certificate{}, err
+	}
+	leaf, err := x509.ParseCertificate(der)
+	if err != nil {
+		return tls.Certificate{}, err
+	}
+
+	return tls.Certificate{Certificate: [][]byte{der}, PrivateKey: key, Leaf: leaf}, nil
+}
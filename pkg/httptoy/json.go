@@ -0,0 +1,231 @@
+package httptoy
+
+import (
+	"bufio"
+	"bytes"
+	"encoding"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"reflect"
+	"strings"
+)
+
+// json.go 提供 JSON 请求的绑定以及 JSON 响应的输出
+
+var (
+	// ErrNotJSON 请求的 Content-Type 不是 JSON，handler 可以据此回复 415
+	ErrNotJSON = errors.New("httptoy: request Content-Type is not application/json")
+	// ErrBodyTooLarge 请求的报文主体超过了上限，handler 可以据此回复 413
+	ErrBodyTooLarge = errors.New("httptoy: request body too large")
+	// ErrEmptyBody 请求没有报文主体
+	ErrEmptyBody = errors.New("httptoy: request body is empty")
+)
+
+// JSONError 描述 JSON 报文主体的语法错误或类型错误出现的位置
+type JSONError struct {
+	Offset int64 // 出错位置在报文主体中的字节偏移
+	Line   int   // 出错位置所在的行，从 1 开始
+	Column int   // 出错位置所在的列，从 1 开始
+	Err    error // *json.SyntaxError、*json.UnmarshalTypeError 或者未知字段等错误
+}
+
+func (je *JSONError) Error() string {
+	return fmt.Sprintf("invalid JSON at line %d, column %d (offset %d): %v", je.Line, je.Column, je.Offset, je.Err)
+}
+
+func (je *JSONError) Unwrap() error {
+	return je.Err
+}
+
+// JSONBinder 配置 JSON 报文主体的解码方式
+type JSONBinder struct {
+	// MaxBytes 限制报文主体的长度，<= 0 时不限制
+	MaxBytes int64
+	// DisallowUnknownFields 为 true 时，报文中出现结构体没有的字段会返回错误
+	DisallowUnknownFields bool
+}
+
+// DefaultJSONBinder 是 BindJSON 使用的配置，报文主体至多 1mb
+var DefaultJSONBinder = &JSONBinder{MaxBytes: 1 << 20}
+
+// BindJSON 包函数调用
+func BindJSON(req *Request, v interface{}) error {
+	return DefaultJSONBinder.Bind(req, v)
+}
+
+// isJSONContentType 判断 Content-Type 是否为 JSON
+// E.g. application/json, application/problem+json
+func isJSONContentType(ct string) bool {
+	ct = strings.ToLower(strings.TrimSpace(ct))
+	return ct == "application/json" || strings.HasPrefix(ct, "application/") && strings.HasSuffix(ct, "+json")
+}
+
+// Bind 检查 Content-Type，读取报文主体并解码到 v 中
+// 报文主体必须是单个 JSON 值，之后不能再有其它数据
+func (jb *JSONBinder) Bind(req *Request, v interface{}) error {
+	req.parseContentType()
+	if !isJSONContentType(req.contentType) {
+		return ErrNotJSON
+	}
+
+	// 先读出完整的报文主体，出错时能够计算行列位置
+	body := req.Body
+	if jb.MaxBytes > 0 {
+		body = io.LimitReader(body, jb.MaxBytes+1)
+	}
+	data, err := io.ReadAll(body)
+	if err != nil {
+		return err
+	}
+	if jb.MaxBytes > 0 && int64(len(data)) > jb.MaxBytes {
+		return ErrBodyTooLarge
+	}
+	if len(bytes.TrimSpace(data)) == 0 {
+		return ErrEmptyBody
+	}
+
+	dec := json.NewDecoder(bytes.NewReader(data))
+	if jb.DisallowUnknownFields {
+		dec.DisallowUnknownFields()
+	}
+
+	if err = dec.Decode(v); err != nil {
+		return newJSONError(data, dec.InputOffset(), err)
+	}
+
+	// 顶层的值之后只允许出现空白
+	end := dec.InputOffset()
+	if rest := bytes.TrimLeft(data[end:], " \t\r\n"); len(rest) > 0 {
+		return newJSONError(data, int64(len(data)-len(rest)), errors.New("unexpected data after top-level value"))
+	}
+
+	return nil
+}
+
+// newJSONError 根据错误类型确定出错的偏移，并换算成行列
+// 未知字段等没有位置信息的错误，使用解码器停止时的偏移
+func newJSONError(data []byte, offset int64, err error) error {
+	var (
+		se *json.SyntaxError
+		te *json.UnmarshalTypeError
+	)
+	switch {
+	case errors.As(err, &se):
+		// se.Offset 是读取出错字符之后的偏移，指向出错字符本身
+		offset = se.Offset - 1
+	case errors.As(err, &te):
+		offset = te.Offset
+	case errors.Is(err, io.ErrUnexpectedEOF):
+		offset = int64(len(data))
+	}
+
+	if offset < 0 {
+		offset = 0
+	}
+	if offset > int64(len(data)) {
+		offset = int64(len(data))
+	}
+
+	prefix := data[:offset]
+	line := bytes.Count(prefix, []byte("\n")) + 1
+	column := int(offset) - bytes.LastIndexByte(prefix, '\n')
+
+	return &JSONError{Offset: offset, Line: line, Column: column, Err: err}
+}
+
+// WriteJSON 设置 Content-Type 以及状态码，将 v 编码为 JSON 写入响应
+// 首部需要在第一次写入之前设置，chunkWriter 提交首部之后再设置就不会生效
+// 切片、数组以及 channel 逐个元素交给 json.Marshal 编码写入，不会在内存中缓存整个文档，
+// 超过响应缓冲的数据会以 chunk 的方式发送；其余的值整体交给 json.Marshal 编码
+// 输出跟 json.Marshal 相同，只有 json.Marshal 不支持的 channel 编码为数组
+func WriteJSON(rw ResponseWriter, status int, v interface{}) error {
+	if rw.Header().Get("Content-Type") == "" {
+		rw.Header().Set("Content-Type", "application/json; charset=utf-8")
+	}
+	rw.WriteHeader(status)
+
+	rv := reflect.ValueOf(v)
+	switch rv.Kind() {
+	case reflect.Slice:
+		// []byte 编码为 base64 字符串，nil 切片编码为 null，交给 json 处理
+		if rv.IsNil() || rv.Type().Elem().Kind() == reflect.Uint8 && !isJSONMarshaler(reflect.PtrTo(rv.Type().Elem())) {
+			break
+		}
+		fallthrough
+	case reflect.Array:
+		return writeJSONArray(rw, func(yield func(reflect.Value) error) error {
+			for i := 0; i < rv.Len(); i++ {
+				if err := yield(rv.Index(i)); err != nil {
+					return err
+				}
+			}
+			return nil
+		})
+	case reflect.Chan:
+		if rv.Type().ChanDir()&reflect.RecvDir == 0 {
+			break
+		}
+		// channel 关闭时数组结束
+		return writeJSONArray(rw, func(yield func(reflect.Value) error) error {
+			for {
+				elem, ok := rv.Recv()
+				if !ok {
+					return nil
+				}
+				if err := yield(elem); err != nil {
+					return err
+				}
+			}
+		})
+	}
+
+	return json.NewEncoder(rw).Encode(v)
+}
+
+var (
+	jsonMarshalerType = reflect.TypeOf((*json.Marshaler)(nil)).Elem()
+	textMarshalerType = reflect.TypeOf((*encoding.TextMarshaler)(nil)).Elem()
+)
+
+// isJSONMarshaler 判断 t 是否自定义了 JSON 编码
+func isJSONMarshaler(t reflect.Type) bool {
+	return t.Implements(jsonMarshalerType) || t.Implements(textMarshalerType)
+}
+
+// writeJSONArray 逐个编码 each 产生的元素，以 JSON 数组的形式写入 w
+func writeJSONArray(w io.Writer, each func(yield func(reflect.Value) error) error) error {
+	bufw := bufio.NewWriter(w)
+	if err := bufw.WriteByte('['); err != nil {
+		return err
+	}
+
+	first := true
+	err := each(func(elem reflect.Value) error {
+		// 切片的元素可以取地址，跟 json.Marshal 一样使用指针接收者的 MarshalJSON
+		if elem.CanAddr() {
+			elem = elem.Addr()
+		}
+		b, err := json.Marshal(elem.Interface())
+		if err != nil {
+			return err
+		}
+
+		if !first {
+			bufw.WriteByte(',')
+		}
+		first = false
+		_, err = bufw.Write(b)
+		return err
+	})
+	if err != nil {
+		return err
+	}
+
+	if _, err = bufw.WriteString("]\n"); err != nil {
+		return err
+	}
+
+	return bufw.Flush()
+}
@@ -0,0 +1,274 @@
+package httptoy
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+)
+
+type jsonUser struct {
+	Name string `json:"name"`
+	Age  int    `json:"age"`
+}
+
+// bindJSONRaw 以 Content-Type ct 发送 body，在 handler 中调用 jb.Bind
+func bindJSONRaw(t *testing.T, jb *JSONBinder, ct, body string, v interface{}) error {
+	t.Helper()
+
+	var err error
+	serveRaw(t, testHandler(func(rw ResponseWriter, req *Request) {
+		err = jb.Bind(req, v)
+	}), fmt.Sprintf("POST / HTTP/1.1\r\nConnection: close\r\nContent-Type: %s\r\nContent-Length: %d\r\n\r\n%s", ct, len(body), body))
+
+	return err
+}
+
+func TestBindJSON(t *testing.T) {
+	var u jsonUser
+	var err error
+	body := `{"name":"gu","age":22,"extra":true}`
+	serveRaw(t, testHandler(func(rw ResponseWriter, req *Request) {
+		err = BindJSON(req, &u)
+	}), fmt.Sprintf("POST / HTTP/1.1\r\nConnection: close\r\nContent-Type: application/json; charset=utf-8\r\nContent-Length: %d\r\n\r\n%s", len(body), body))
+
+	if err != nil || u != (jsonUser{"gu", 22}) {
+		t.Fatalf("got %+v, err %v", u, err)
+	}
+
+	if err := bindJSONRaw(t, DefaultJSONBinder, "application/vnd.api+json", `{"age":1}`, &u); err != nil || u.Age != 1 {
+		t.Fatalf("+json suffix: got %+v, err %v", u, err)
+	}
+}
+
+func TestBindJSONErrors(t *testing.T) {
+	strict := &JSONBinder{MaxBytes: 32, DisallowUnknownFields: true}
+
+	tests := []struct {
+		name   string
+		binder *JSONBinder
+		ct     string
+		body   string
+		want   error
+		line   int
+		column int
+	}{
+		{"content type", DefaultJSONBinder, "text/plain", `{}`, ErrNotJSON, 0, 0},
+		{"too large", strict, "application/json", `{"name":"` + strings.Repeat("a", 40) + `"}`, ErrBodyTooLarge, 0, 0},
+		{"empty", DefaultJSONBinder, "application/json", "  ", ErrEmptyBody, 0, 0},
+		{"syntax", DefaultJSONBinder, "application/json", "{\n  \"name\": \"gu\",\n  \"age\": 2x\n}", nil, 3, 11},
+		{"type", DefaultJSONBinder, "application/json", `{"age":"old"}`, nil, 1, 13},
+		{"unknown field", strict, "application/json", `{"nick":"x"}`, nil, 1, 13},
+		{"trailing data", DefaultJSONBinder, "application/json", `{"age":1} {}`, nil, 1, 11},
+		{"truncated", DefaultJSONBinder, "application/json", `{"age":1`, nil, 1, 9},
+	}
+
+	for _, tt := range tests {
+		var u jsonUser
+		err := bindJSONRaw(t, tt.binder, tt.ct, tt.body, &u)
+
+		if tt.want != nil {
+			if !errors.Is(err, tt.want) {
+				t.Errorf("%s: err = %v, want %v", tt.name, err, tt.want)
+			}
+			continue
+		}
+
+		var je *JSONError
+		if !errors.As(err, &je) {
+			t.Errorf("%s: want *JSONError, got %v", tt.name, err)
+			continue
+		}
+		if je.Line != tt.line || je.Column != tt.column {
+			t.Errorf("%s: position %d:%d, want %d:%d (%v)", tt.name, je.Line, je.Column, tt.line, tt.column, je)
+		}
+	}
+}
+
+func TestWriteJSON(t *testing.T) {
+	h := testHandler(func(rw ResponseWriter, req *Request) {
+		WriteJSON(rw, 201, map[string]int{"id": 1})
+	})
+
+	resp := serveRaw(t, h, "GET / HTTP/1.1\r\nConnection: close\r\n\r\n")
+	if !strings.HasPrefix(resp, "HTTP/1.1 201 Created\r\n") ||
+		!strings.Contains(resp, "Content-Type: application/json; charset=utf-8\r\n") ||
+		!strings.HasSuffix(resp, "\r\n\r\n{\"id\":1}\n") {
+		t.Fatalf("unexpected response: %q", resp)
+	}
+}
+
+func TestWriteJSONStream(t *testing.T) {
+	const n = 20000
+	users := make([]jsonUser, n)
+	for i := range users {
+		users[i] = jsonUser{Name: "user", Age: i}
+	}
+
+	ch := make(chan jsonUser)
+	go func() {
+		for _, u := range users {
+			ch <- u
+		}
+		close(ch)
+	}()
+
+	addr := startTestServer(t, testHandler(func(rw ResponseWriter, req *Request) {
+		if req.URL.Path == "/chan" {
+			WriteJSON(rw, 200, ch)
+			return
+		}
+		WriteJSON(rw, 200, users)
+	}))
+
+	c, err := net.Dial("tcp", addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+
+	bufr := bufio.NewReader(c)
+	for _, path := range []string{"/slice", "/chan"} {
+		fmt.Fprintf(c, "GET %s HTTP/1.1\r\nHost: %s\r\n\r\n", path, addr)
+		resp, err := http.ReadResponse(bufr, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		// 数据超过响应缓冲，以 chunk 的方式发送
+		if len(resp.TransferEncoding) == 0 {
+			t.Errorf("%s: want chunked response", path)
+		}
+
+		var got []jsonUser
+		err = json.NewDecoder(resp.Body).Decode(&got)
+		io.Copy(io.Discard, resp.Body)
+		resp.Body.Close()
+		if err != nil {
+			t.Fatalf("%s: %v", path, err)
+		}
+		if len(got) != n || got[n-1].Age != n-1 {
+			t.Fatalf("%s: got %d users", path, len(got))
+		}
+	}
+}
+
+type jsonEmbedded struct {
+	ID    int    `json:"id"`
+	Shade string // 被外层同名的字段覆盖
+	Dup   int
+}
+
+type JSONPromoted struct {
+	Extra string `json:"extra,omitempty"`
+	Dup   int
+}
+
+type jsonNilEmbedded struct {
+	Hidden string
+}
+
+type jsonTextKey string
+
+func (k jsonTextKey) MarshalText() ([]byte, error) { return []byte("key-" + string(k)), nil }
+
+type jsonByte uint8
+
+func (b jsonByte) MarshalText() ([]byte, error) { return []byte{'b', '0' + byte(b)}, nil }
+
+type jsonPtrMarshaler struct{ n int }
+
+func (m *jsonPtrMarshaler) MarshalJSON() ([]byte, error) {
+	return []byte(fmt.Sprintf(`"m%d"`, m.n)), nil
+}
+
+type jsonDoc struct {
+	jsonEmbedded
+	*JSONPromoted
+	*jsonNilEmbedded
+	Shade   string              `json:"shade"`
+	Skip    string              `json:"-"`
+	Dash    string              `json:"-,"`
+	Count   int64               `json:"count,string"`
+	Quoted  string              `json:"quoted,string"`
+	Empty   []int               `json:"empty,omitempty"`
+	Nil     []int               `json:"nil"`
+	Bytes   []byte              `json:"bytes"`
+	Array   [2]uint8            `json:"array"`
+	Map     map[int]string      `json:"map"`
+	TextMap map[jsonTextKey]int `json:"text_map"`
+	NilMap  map[string]int      `json:"nil_map"`
+	Any     interface{}         `json:"any"`
+	Ptr     *jsonUser           `json:"ptr"`
+	Raw     json.RawMessage     `json:"raw"`
+	Custom  jsonPtrMarshaler    `json:"custom"`
+	HTML    string              `json:"html"`
+	Time    time.Time           `json:"time,omitzero"`
+	Zero    float64             `json:"zero,omitempty"`
+	private int
+}
+
+// TestWriteJSONMatchesMarshal 逐个元素编码的结果跟 json.Marshal 整体编码相同
+func TestWriteJSONMatchesMarshal(t *testing.T) {
+	doc := jsonDoc{
+		jsonEmbedded: jsonEmbedded{ID: 7, Shade: "inner", Dup: 1},
+		JSONPromoted: &JSONPromoted{Dup: 2},
+		Shade:        "outer",
+		Skip:         "skip",
+		Dash:         "dash",
+		Count:        42,
+		Quoted:       `a"b`,
+		Empty:        []int{},
+		Bytes:        []byte("hi"),
+		Array:        [2]uint8{1, 2},
+		Map:          map[int]string{10: "ten", 2: "two"},
+		TextMap:      map[jsonTextKey]int{"b": 2, "a": 1},
+		Any:          map[string]interface{}{"z": []interface{}{1.5, nil, "x"}, "a": true},
+		Ptr:          &jsonUser{Name: "gu", Age: 3},
+		Raw:          json.RawMessage(`{"raw":1}`),
+		Custom:       jsonPtrMarshaler{5},
+		HTML:         "<a&b>\u2028",
+		private:      1,
+	}
+	set := doc
+	set.Time = time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC)
+	set.Zero = -0.0
+
+	for _, v := range []interface{}{
+		doc,
+		&doc,
+		[]jsonDoc{doc, set},
+		[2]*jsonDoc{&doc, nil},
+		[]interface{}{doc, 1, "s", nil},
+		map[string]jsonDoc{"d": doc},
+		map[jsonTextKey]int{"k": 1},
+		[]jsonPtrMarshaler{{1}, {2}},
+		[1]jsonPtrMarshaler{{3}},
+		[]jsonByte{1, 2},
+		[]byte("bytes"),
+		[]int(nil),
+		[]int{},
+		nil,
+	} {
+		want, err := json.Marshal(v)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		h := testHandler(func(rw ResponseWriter, req *Request) {
+			if err := WriteJSON(rw, 200, v); err != nil {
+				t.Errorf("%T: %v", v, err)
+			}
+		})
+		resp := serveRaw(t, h, "GET / HTTP/1.1\r\nConnection: close\r\n\r\n")
+		if _, body, _ := strings.Cut(resp, "\r\n\r\n"); body != string(want)+"\n" {
+			t.Errorf("%T:\n got %s\nwant %s", v, body, want)
+		}
+	}
+}
@@ -0,0 +1,122 @@
+package httptoy
+
+import (
+	"io"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestParseHTTPVersion(t *testing.T) {
+	tests := []struct {
+		proto        string
+		major, minor int
+		ok           bool
+	}{
+		{"HTTP/1.1", 1, 1, true},
+		{"HTTP/1.0", 1, 0, true},
+		{"HTTP/1.2", 1, 2, true},
+		{"HTTP/2.0", 0, 0, false},
+		{"HTTP/1", 0, 0, false},
+		{"HTTP/1.1x", 0, 0, false},
+		{"HTTPS/1.1", 0, 0, false},
+	}
+
+	for _, tt := range tests {
+		major, minor, err := parseHTTPVersion(tt.proto)
+		if (err == nil) != tt.ok || major != tt.major || minor != tt.minor {
+			t.Errorf("parseHTTPVersion(%q) = %d, %d, %v", tt.proto, major, minor, err)
+		}
+	}
+}
+
+func TestHTTP10Close(t *testing.T) {
+	resp := serveRaw(t, echoHandler, "GET /a HTTP/1.0\r\n\r\nGET /b HTTP/1.0\r\n\r\n")
+
+	if !strings.HasPrefix(resp, "HTTP/1.0 200 OK\r\n") {
+		t.Fatalf("unexpected status line: %q", resp)
+	}
+	if !strings.Contains(resp, "Connection: close\r\n") || strings.Count(resp, "HTTP/1.0") != 1 {
+		t.Fatalf("HTTP/1.0 without keep-alive should be answered once then closed: %q", resp)
+	}
+}
+
+func TestHTTP10KeepAlive(t *testing.T) {
+	svr := &Server{Handler: echoHandler, IdleTimeout: 5 * time.Second, MaxRequestsPerConn: 3}
+	resp := serveRawServer(t, svr,
+		"GET /a HTTP/1.0\r\nConnection: keep-alive\r\n\r\n"+
+			"GET /b HTTP/1.0\r\nConnection: Keep-Alive\r\n\r\n"+
+			"GET /c HTTP/1.0\r\nConnection: keep-alive\r\n\r\n")
+
+	if n := strings.Count(resp, "HTTP/1.0 200 OK\r\n"); n != 3 {
+		t.Fatalf("want 3 responses, got %d: %q", n, resp)
+	}
+	for _, want := range []string{
+		"Keep-Alive: timeout=5, max=2\r\n",
+		"Keep-Alive: timeout=5, max=1\r\n",
+		"Connection: keep-alive\r\n",
+		"Content-Length: 2\r\n",
+	} {
+		if !strings.Contains(resp, want) {
+			t.Errorf("response missing %q: %q", want, resp)
+		}
+	}
+
+	// 第三个请求达到 MaxRequestsPerConn，回复后关闭连接
+	last := resp[strings.LastIndex(resp, "HTTP/1.0"):]
+	if !strings.Contains(last, "Connection: close\r\n") || strings.Contains(last, "Keep-Alive") {
+		t.Errorf("last response should close the connection: %q", last)
+	}
+}
+
+// TestKeepAliveTimeout Keep-Alive 的 timeout 向下取整到秒，不足 1 秒时省略
+func TestKeepAliveTimeout(t *testing.T) {
+	tests := []struct {
+		idle time.Duration
+		max  int
+		want string
+	}{
+		{1500 * time.Millisecond, 0, "Keep-Alive: timeout=1\r\n"},
+		{500 * time.Millisecond, 3, "Keep-Alive: max=2\r\n"},
+		{500 * time.Millisecond, 0, ""},
+	}
+
+	for _, tt := range tests {
+		svr := &Server{Handler: echoHandler, IdleTimeout: tt.idle, MaxRequestsPerConn: tt.max}
+		resp := serveRawServer(t, svr, "GET /a HTTP/1.0\r\nConnection: keep-alive\r\n\r\n")
+		if !strings.Contains(resp, "Connection: keep-alive\r\n") {
+			t.Fatalf("%v: connection should be kept alive: %q", tt.idle, resp)
+		}
+		if tt.want == "" && strings.Contains(resp, "Keep-Alive:") || !strings.Contains(resp, tt.want) {
+			t.Errorf("%v, max %d: want %q in %q", tt.idle, tt.max, tt.want, resp)
+		}
+	}
+}
+
+func TestHTTP10CloseDelimited(t *testing.T) {
+	body := strings.Repeat("x", 8<<10)
+	h := testHandler(func(rw ResponseWriter, req *Request) {
+		io.WriteString(rw, body)
+	})
+
+	resp := serveRaw(t, h, "GET / HTTP/1.0\r\nConnection: keep-alive\r\n\r\nGET / HTTP/1.0\r\n\r\n")
+
+	if strings.Contains(resp, "Transfer-Encoding") || strings.Contains(resp, "Content-Length") {
+		t.Fatalf("HTTP/1.0 response of unknown length should not be framed: %q", resp[:200])
+	}
+	if !strings.Contains(resp, "Connection: close\r\n") || !strings.HasSuffix(resp, "\r\n\r\n"+body) {
+		t.Fatalf("HTTP/1.0 response of unknown length should be close-delimited")
+	}
+}
+
+func TestMaxRequestsPerConn(t *testing.T) {
+	svr := &Server{Handler: echoHandler, MaxRequestsPerConn: 2}
+	resp := serveRawServer(t, svr, get("/a")+get("/b")+get("/c"))
+
+	if n := strings.Count(resp, "HTTP/1.1 200 OK"); n != 2 {
+		t.Fatalf("want 2 responses, got %d: %q", n, resp)
+	}
+	if !strings.HasSuffix(resp, "/b") || !strings.Contains(resp, "Connection: close\r\n") {
+		t.Fatalf("second response should close the connection: %q", resp)
+	}
+}
@@ -0,0 +1,282 @@
+package httptoy
+
+import (
+	"errors"
+	"fmt"
+	"path"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+)
+
+// mediatype.go 负责 Content-Type 以及 Content-Disposition 这类带参数首部的解析 (RFC 2045、RFC 2231、RFC 7578)
+// E.g. multipart/form-data; boundary="----abc"
+//
+//	form-data; name="file"; filename="a;b=c.txt"; filename*=UTF-8''%E4%B8%AD.txt
+//	attachment; title*0*=us-ascii'en'This%20is; title*1=" long"
+
+var errMediaParam = errors.New("invalid media parameter")
+
+// isTSpecial 判断是否为 RFC 2045 中不能出现在 token 中的分隔符
+func isTSpecial(c byte) bool {
+	return strings.IndexByte(`()<>@,;:\"/[]?=`, c) >= 0
+}
+
+func isTokenChar(c byte) bool {
+	return c > 0x20 && c < 0x7f && !isTSpecial(c)
+}
+
+// consumeToken 读取开头的 token，返回 token 以及剩余部分
+func consumeToken(v string) (token, rest string) {
+	i := 0
+	for i < len(v) && isTokenChar(v[i]) {
+		i++
+	}
+
+	return v[:i], v[i:]
+}
+
+// consumeValue 读取参数值，值为 token 或者 quoted-string
+// quoted-string 中的反斜杠只转义分隔符，E.g. "a\"b" -> a"b，
+// 其余反斜杠原样保留，兼容 IE 发送的 filename="C:\dir\a.txt"
+func consumeValue(v string) (value, rest string, ok bool) {
+	if v == "" {
+		return "", v, false
+	}
+	if v[0] != '"' {
+		value, rest = consumeToken(v)
+		return value, rest, value != ""
+	}
+
+	var sb strings.Builder
+	for i := 1; i < len(v); i++ {
+		c := v[i]
+		switch {
+		case c == '"':
+			return sb.String(), v[i+1:], true
+		case c == '\\' && i+1 < len(v) && isTSpecial(v[i+1]):
+			i++
+			sb.WriteByte(v[i])
+		case c == '\r' || c == '\n':
+			return "", v, false
+		default:
+			sb.WriteByte(c)
+		}
+	}
+
+	// 缺少结尾的引号
+	return "", v, false
+}
+
+// parseMediaType 解析带参数的首部，返回小写的类型以及参数，参数名不区分大小写，统一转为小写
+// 扩展参数 name*=charset'lang'value 会被解码，并且优先于同名的普通参数
+// 参数格式错误时依旧返回已解析的类型，并返回错误
+func parseMediaType(v string) (mediatype string, params map[string]string, err error) {
+	base, _, _ := strings.Cut(v, ";")
+	mediatype = strings.ToLower(strings.TrimSpace(base))
+
+	// type/subtype 或者 Content-Disposition 的单个 token
+	typ, subtype, hasSlash := strings.Cut(mediatype, "/")
+	if t, r := consumeToken(typ); t == "" || r != "" {
+		return "", nil, fmt.Errorf("invalid media type %q", base)
+	}
+	if hasSlash {
+		if t, r := consumeToken(subtype); t == "" || r != "" {
+			return "", nil, fmt.Errorf("invalid media type %q", base)
+		}
+	}
+
+	params = make(map[string]string)
+	// continuation 保存 RFC 2231 拆分的参数，E.g. title*0, title*1*
+	continuation := make(map[string]map[string]string)
+
+	// 剩余部分为 ;key=value 形式的参数
+	for v = v[len(base):]; ; {
+		v = strings.TrimLeft(v, " \t")
+		if v == "" {
+			break
+		}
+
+		key, value, r, ok := consumeParam(v)
+		if !ok {
+			// 末尾多余的 ; 是常见的写法
+			if strings.TrimSpace(v) == ";" {
+				break
+			}
+			return mediatype, nil, errMediaParam
+		}
+		v = r
+
+		dst := params
+		if base, _, isCont := strings.Cut(key, "*"); isCont {
+			if continuation[base] == nil {
+				continuation[base] = make(map[string]string)
+			}
+			dst = continuation[base]
+		}
+		if _, dup := dst[key]; dup {
+			return mediatype, nil, errMediaParam
+		}
+		dst[key] = value
+	}
+
+	// 合并扩展参数以及拆分的参数
+	for base, pieces := range continuation {
+		if value, ok := decodeExtendedParam(base, pieces); ok {
+			params[base] = value
+		}
+	}
+
+	return mediatype, params, nil
+}
+
+// consumeParam 读取 ;key=value 形式的参数，key 转为小写
+func consumeParam(v string) (key, value, rest string, ok bool) {
+	if v[0] != ';' {
+		return "", "", v, false
+	}
+	v = strings.TrimLeft(v[1:], " \t")
+
+	key, v = consumeToken(v)
+	key = strings.ToLower(key)
+	if key == "" {
+		return "", "", v, false
+	}
+
+	v = strings.TrimLeft(v, " \t")
+	if v == "" || v[0] != '=' {
+		return "", "", v, false
+	}
+	v = strings.TrimLeft(v[1:], " \t")
+
+	value, v, ok = consumeValue(v)
+	if !ok {
+		return "", "", v, false
+	}
+
+	return key, value, v, true
+}
+
+// decodeExtendedParam 拼接 base 的扩展参数，pieces 的键为 base*、base*0、base*1* 等
+// 以 * 结尾的部分为 charset'lang'%XX 编码，只有第一部分携带 charset
+func decodeExtendedParam(base string, pieces map[string]string) (string, bool) {
+	// 未拆分的扩展参数 E.g. filename*=UTF-8''%E4%B8%AD.txt
+	if v, ok := pieces[base+"*"]; ok {
+		return decodeRFC2231(v)
+	}
+
+	var sb strings.Builder
+	charset := ""
+	for i := 0; ; i++ {
+		name := base + "*" + strconv.Itoa(i)
+		if v, ok := pieces[name]; ok {
+			sb.WriteString(v)
+			continue
+		}
+
+		v, ok := pieces[name+"*"]
+		if !ok {
+			break
+		}
+
+		if i == 0 {
+			var rest string
+			charset, rest, ok = splitRFC2231(v)
+			if !ok {
+				return "", false
+			}
+			v = rest
+		}
+		decoded, ok := percentDecode(v, charset)
+		if !ok {
+			return "", false
+		}
+		sb.WriteString(decoded)
+	}
+
+	if sb.Len() == 0 {
+		return "", false
+	}
+
+	return sb.String(), true
+}
+
+// splitRFC2231 拆分 charset'lang'value，返回 charset 以及 value
+func splitRFC2231(v string) (charset, value string, ok bool) {
+	parts := strings.SplitN(v, "'", 3)
+	if len(parts) != 3 {
+		return "", "", false
+	}
+
+	return strings.ToLower(parts[0]), parts[2], true
+}
+
+func decodeRFC2231(v string) (string, bool) {
+	charset, value, ok := splitRFC2231(v)
+	if !ok {
+		return "", false
+	}
+
+	return percentDecode(value, charset)
+}
+
+// percentDecode 解码 %XX，支持 utf-8、us-ascii 以及 iso-8859-1 字符集
+func percentDecode(v, charset string) (string, bool) {
+	b := make([]byte, 0, len(v))
+	for i := 0; i < len(v); i++ {
+		if v[i] != '%' {
+			b = append(b, v[i])
+			continue
+		}
+
+		if i+2 >= len(v) {
+			return "", false
+		}
+		n, err := strconv.ParseUint(v[i+1:i+3], 16, 8)
+		if err != nil {
+			return "", false
+		}
+		b = append(b, byte(n))
+		i += 2
+	}
+
+	switch charset {
+	case "utf-8", "us-ascii", "":
+		if !utf8.Valid(b) {
+			return "", false
+		}
+		return string(b), true
+	case "iso-8859-1":
+		// latin-1 的每个字节就是对应的 unicode 码点
+		rs := make([]rune, len(b))
+		for i, c := range b {
+			rs[i] = rune(c)
+		}
+		return string(rs), true
+	}
+
+	return "", false
+}
+
+// sanitizeFilename 去掉客户端文件名中的路径，防止保存文件时越过目标目录
+// E.g. ../../etc/passwd -> passwd, C:\dir\a.txt -> a.txt
+func sanitizeFilename(name string) string {
+	name = strings.Map(func(r rune) rune {
+		// 去掉控制字符
+		if r < 0x20 || r == 0x7f {
+			return -1
+		}
+		if r == '\\' {
+			return '/'
+		}
+		return r
+	}, name)
+
+	name = path.Base(name)
+	switch name {
+	case ".", "..", "/":
+		return ""
+	}
+
+	return name
+}
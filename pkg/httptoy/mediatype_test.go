@@ -0,0 +1,73 @@
+package httptoy
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestParseMediaType(t *testing.T) {
+	tests := []struct {
+		in     string
+		typ    string
+		params map[string]string
+		ok     bool
+	}{
+		{"text/html", "text/html", map[string]string{}, true},
+		{"Multipart/Form-Data; Boundary=abc", "multipart/form-data", map[string]string{"boundary": "abc"}, true},
+		{`multipart/form-data; boundary="--a b;c"`, "multipart/form-data", map[string]string{"boundary": "--a b;c"}, true},
+		{`form-data; name="file"; filename="a;b=c.txt"`, "form-data", map[string]string{"name": "file", "filename": "a;b=c.txt"}, true},
+		{`form-data; name="q\"uote"; filename="C:\dir\a.txt"`, "form-data", map[string]string{"name": `q"uote`, "filename": `C:\dir\a.txt`}, true},
+		{`form-data; name=x; filename="fallback.txt"; filename*=UTF-8''%E4%B8%AD.txt`, "form-data", map[string]string{"name": "x", "filename": "中.txt"}, true},
+		{`attachment; FILENAME*=iso-8859-1'en'%E9t%E9.txt`, "attachment", map[string]string{"filename": "été.txt"}, true},
+		{`attachment; title*0*=us-ascii'en'This%20is; title*1=" long"; title*2*=%21`, "attachment", map[string]string{"title": "This is long!"}, true},
+		{"text/plain; charset=utf-8;", "text/plain", map[string]string{"charset": "utf-8"}, true},
+		{"text/plain; charset", "text/plain", nil, false},
+		{`text/plain; charset="utf-8`, "text/plain", nil, false},
+		{"text/plain; a=1; A=2", "text/plain", nil, false},
+		{"text/ plain", "", nil, false},
+		{"", "", nil, false},
+	}
+
+	for _, tt := range tests {
+		typ, params, err := parseMediaType(tt.in)
+		if (err == nil) != tt.ok || typ != tt.typ || tt.ok && !reflect.DeepEqual(params, tt.params) {
+			t.Errorf("parseMediaType(%q) = %q, %v, %v; want %q, %v, ok %v", tt.in, typ, params, err, tt.typ, tt.params, tt.ok)
+		}
+	}
+}
+
+func TestSanitizeFilename(t *testing.T) {
+	tests := map[string]string{
+		"a.txt":             "a.txt",
+		"../../etc/passwd":  "passwd",
+		`C:\dir\a.txt`:      "a.txt",
+		"/abs/path/b.png":   "b.png",
+		"..":                "",
+		"dir/":              "dir",
+		"bad\x00name\n.txt": "badname.txt",
+		"":                  "",
+	}
+
+	for in, want := range tests {
+		if got := sanitizeFilename(in); got != want {
+			t.Errorf("sanitizeFilename(%q) = %q, want %q", in, got, want)
+		}
+	}
+}
+
+func TestPartFileName(t *testing.T) {
+	body := "--xyz\r\n" +
+		"Content-Disposition: form-data; name=\"doc\"; filename=\"../x;y=z.txt\"; filename*=UTF-8''%E6%96%87%E6%A1%A3.txt\r\n" +
+		"\r\ncontent\r\n--xyz--\r\n"
+
+	mr := NewMultipartReader(strings.NewReader(body), "xyz")
+	p, err := mr.NextPart()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if p.FormName() != "doc" || p.FileName() != "文档.txt" {
+		t.Fatalf("got name %q filename %q", p.FormName(), p.FileName())
+	}
+}
@@ -0,0 +1,436 @@
+// Package metrics 提供计数器、仪表以及直方图，并以 Prometheus 文本格式 (text exposition format 0.0.4) 输出
+// 不依赖 Prometheus 的客户端库
+//
+// E.g.
+//
+//	reg := metrics.NewRegistry()
+//	sm := metrics.NewServerMetrics(reg)
+//	mux.Handle("/metrics", reg.Handler())
+//	svr := &httptoy.Server{Handler: sm.Instrument(mux), Metrics: sm}
+package metrics
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+
+	"build-HTTP-from-scracth/pkg/httptoy"
+)
+
+// ContentType 是文本格式的报文类型
+const ContentType = "text/plain; version=0.0.4; charset=utf-8"
+
+// DefBuckets 是延迟直方图默认的桶上界，单位为秒
+var DefBuckets = []float64{.005, .01, .025, .05, .1, .25, .5, 1, 2.5, 5, 10}
+
+// atomicFloat 是可以并发累加的 float64
+type atomicFloat struct {
+	bits uint64
+}
+
+func (f *atomicFloat) Add(v float64) {
+	for {
+		old := atomic.LoadUint64(&f.bits)
+		if atomic.CompareAndSwapUint64(&f.bits, old, math.Float64bits(math.Float64frombits(old)+v)) {
+			return
+		}
+	}
+}
+
+func (f *atomicFloat) Set(v float64) {
+	atomic.StoreUint64(&f.bits, math.Float64bits(v))
+}
+
+func (f *atomicFloat) Load() float64 {
+	return math.Float64frombits(atomic.LoadUint64(&f.bits))
+}
+
+// Counter 是只增不减的计数器
+type Counter struct {
+	v atomicFloat
+}
+
+func (c *Counter) Inc() {
+	c.v.Add(1)
+}
+
+// Add 增加 v，v 不能为负数
+func (c *Counter) Add(v float64) {
+	if v < 0 {
+		panic("metrics: counter cannot decrease")
+	}
+	c.v.Add(v)
+}
+
+func (c *Counter) Value() float64 {
+	return c.v.Load()
+}
+
+// Gauge 是可增可减的仪表，E.g. 当前的连接数
+type Gauge struct {
+	v atomicFloat
+}
+
+func (g *Gauge) Set(v float64) { g.v.Set(v) }
+func (g *Gauge) Add(v float64) { g.v.Add(v) }
+func (g *Gauge) Inc()          { g.v.Add(1) }
+func (g *Gauge) Dec()          { g.v.Add(-1) }
+
+func (g *Gauge) Value() float64 {
+	return g.v.Load()
+}
+
+// Histogram 按照桶的上界统计观测值的分布，输出时每个桶为小于等于其上界的累计个数
+type Histogram struct {
+	upper  []float64 // 递增的桶上界，不包括 +Inf
+	counts []uint64  // 每个桶单独的个数，最后一个为 +Inf
+	count  uint64
+	sum    atomicFloat
+}
+
+func newHistogram(buckets []float64) *Histogram {
+	return &Histogram{upper: buckets, counts: make([]uint64, len(buckets)+1)}
+}
+
+// Observe 记录一个观测值
+func (h *Histogram) Observe(v float64) {
+	i := sort.SearchFloat64s(h.upper, v)
+	atomic.AddUint64(&h.counts[i], 1)
+	atomic.AddUint64(&h.count, 1)
+	h.sum.Add(v)
+}
+
+// Count 返回观测的次数
+func (h *Histogram) Count() uint64 {
+	return atomic.LoadUint64(&h.count)
+}
+
+// Sum 返回观测值的总和
+func (h *Histogram) Sum() float64 {
+	return h.sum.Load()
+}
+
+// vec 按照标签的值保存指标，指标为 *Counter、*Gauge 或者 *Histogram
+type vec struct {
+	labels   []string
+	newChild func() interface{}
+
+	mu       sync.RWMutex
+	children map[string]interface{}
+	values   map[string][]string // key 对应的标签值
+}
+
+func newVec(labels []string, newChild func() interface{}) *vec {
+	return &vec{labels: labels, newChild: newChild, children: make(map[string]interface{}), values: make(map[string][]string)}
+}
+
+// with 返回标签值对应的指标，不存在时创建
+func (v *vec) with(values []string) interface{} {
+	if len(values) != len(v.labels) {
+		panic(fmt.Sprintf("metrics: expected %d label values, got %d", len(v.labels), len(values)))
+	}
+
+	// 标签值不会包含 0xff (不是合法的 UTF-8)，用作分隔符
+	key := strings.Join(values, "\xff")
+	v.mu.RLock()
+	t, ok := v.children[key]
+	v.mu.RUnlock()
+	if ok {
+		return t
+	}
+
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	if t, ok = v.children[key]; !ok {
+		t = v.newChild()
+		v.children[key] = t
+		v.values[key] = append([]string(nil), values...)
+	}
+
+	return t
+}
+
+// each 按照标签值的顺序遍历指标
+func (v *vec) each(fn func(values []string, t interface{})) {
+	v.mu.RLock()
+	keys := make([]string, 0, len(v.children))
+	for k := range v.children {
+		keys = append(keys, k)
+	}
+	v.mu.RUnlock()
+	sort.Strings(keys)
+
+	for _, k := range keys {
+		v.mu.RLock()
+		t, values := v.children[k], v.values[k]
+		v.mu.RUnlock()
+		fn(values, t)
+	}
+}
+
+// CounterVec 是按照标签区分的一组计数器
+type CounterVec struct{ *vec }
+
+// With 返回标签值对应的计数器，标签值的顺序与注册时的标签名相同
+func (v CounterVec) With(values ...string) *Counter {
+	return v.with(values).(*Counter)
+}
+
+// GaugeVec 是按照标签区分的一组仪表
+type GaugeVec struct{ *vec }
+
+func (v GaugeVec) With(values ...string) *Gauge {
+	return v.with(values).(*Gauge)
+}
+
+// HistogramVec 是按照标签区分的一组直方图
+type HistogramVec struct{ *vec }
+
+func (v HistogramVec) With(values ...string) *Histogram {
+	return v.with(values).(*Histogram)
+}
+
+// metric 是注册到 Registry 的一个指标
+type metric struct {
+	name, help, typ string
+	write           func(w *bufio.Writer, name string)
+}
+
+// Registry 保存注册的指标，Handler 按照名称的顺序输出
+type Registry struct {
+	mu      sync.Mutex
+	metrics map[string]*metric
+}
+
+func NewRegistry() *Registry {
+	return &Registry{metrics: make(map[string]*metric)}
+}
+
+// register 注册指标，名称不合法或者重复时 panic
+func (r *Registry) register(m *metric, labels []string) {
+	if !validName(m.name) {
+		panic(fmt.Sprintf("metrics: invalid metric name %q", m.name))
+	}
+	for _, l := range labels {
+		if !validName(l) || strings.Contains(l, ":") || l == "le" {
+			panic(fmt.Sprintf("metrics: invalid label name %q", l))
+		}
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if _, ok := r.metrics[m.name]; ok {
+		panic(fmt.Sprintf("metrics: duplicate metric %q", m.name))
+	}
+	r.metrics[m.name] = m
+}
+
+// validName 判断指标名或者标签名是否合法 [a-zA-Z_:][a-zA-Z0-9_:]*
+func validName(s string) bool {
+	if s == "" {
+		return false
+	}
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		if !(c == '_' || c == ':' || 'a' <= c && c <= 'z' || 'A' <= c && c <= 'Z' || i > 0 && '0' <= c && c <= '9') {
+			return false
+		}
+	}
+	return true
+}
+
+// Counter 注册没有标签的计数器，计数器的名称一般以 _total 结尾
+func (r *Registry) Counter(name, help string) *Counter {
+	c := new(Counter)
+	r.register(&metric{name, help, "counter", func(w *bufio.Writer, name string) {
+		writeSample(w, name, nil, nil, c.Value())
+	}}, nil)
+
+	return c
+}
+
+// CounterVec 注册带有标签的计数器
+func (r *Registry) CounterVec(name, help string, labels ...string) CounterVec {
+	v := newVec(labels, func() interface{} { return new(Counter) })
+	r.register(&metric{name, help, "counter", func(w *bufio.Writer, name string) {
+		v.each(func(values []string, c interface{}) {
+			writeSample(w, name, labels, values, c.(*Counter).Value())
+		})
+	}}, labels)
+
+	return CounterVec{v}
+}
+
+// Gauge 注册没有标签的仪表
+func (r *Registry) Gauge(name, help string) *Gauge {
+	g := new(Gauge)
+	r.register(&metric{name, help, "gauge", func(w *bufio.Writer, name string) {
+		writeSample(w, name, nil, nil, g.Value())
+	}}, nil)
+
+	return g
+}
+
+// GaugeVec 注册带有标签的仪表
+func (r *Registry) GaugeVec(name, help string, labels ...string) GaugeVec {
+	v := newVec(labels, func() interface{} { return new(Gauge) })
+	r.register(&metric{name, help, "gauge", func(w *bufio.Writer, name string) {
+		v.each(func(values []string, g interface{}) {
+			writeSample(w, name, labels, values, g.(*Gauge).Value())
+		})
+	}}, labels)
+
+	return GaugeVec{v}
+}
+
+// GaugeFunc 注册输出时才调用 fn 取值的仪表，E.g. runtime.NumGoroutine
+func (r *Registry) GaugeFunc(name, help string, fn func() float64) {
+	r.register(&metric{name, help, "gauge", func(w *bufio.Writer, name string) {
+		writeSample(w, name, nil, nil, fn())
+	}}, nil)
+}
+
+// HistogramVec 注册带有标签的直方图，buckets 为空时使用 DefBuckets
+func (r *Registry) HistogramVec(name, help string, buckets []float64, labels ...string) HistogramVec {
+	if len(buckets) == 0 {
+		buckets = DefBuckets
+	}
+	if !sort.Float64sAreSorted(buckets) {
+		panic("metrics: histogram buckets must be sorted")
+	}
+	buckets = append([]float64(nil), buckets...)
+
+	v := newVec(labels, func() interface{} { return newHistogram(buckets) })
+	r.register(&metric{name, help, "histogram", func(w *bufio.Writer, name string) {
+		v.each(func(values []string, h interface{}) {
+			writeHistogram(w, name, labels, values, h.(*Histogram))
+		})
+	}}, labels)
+
+	return HistogramVec{v}
+}
+
+// WriteTo 以文本格式输出全部指标
+func (r *Registry) WriteTo(w io.Writer) (int64, error) {
+	r.mu.Lock()
+	names := make([]string, 0, len(r.metrics))
+	for name := range r.metrics {
+		names = append(names, name)
+	}
+	r.mu.Unlock()
+	sort.Strings(names)
+
+	cw := &countWriter{w: w}
+	bufw := bufio.NewWriter(cw)
+	for _, name := range names {
+		r.mu.Lock()
+		m := r.metrics[name]
+		r.mu.Unlock()
+
+		fmt.Fprintf(bufw, "# HELP %s %s\n", name, escapeHelp(m.help))
+		fmt.Fprintf(bufw, "# TYPE %s %s\n", name, m.typ)
+		m.write(bufw, name)
+	}
+	err := bufw.Flush()
+
+	return cw.n, err
+}
+
+// Handler 返回以文本格式输出全部指标的 handler
+func (r *Registry) Handler() httptoy.Handler {
+	return httptoy.HandlerFunc(func(rw httptoy.ResponseWriter, req *httptoy.Request) {
+		rw.Header().Set("Content-Type", ContentType)
+		r.WriteTo(rw)
+	})
+}
+
+type countWriter struct {
+	w io.Writer
+	n int64
+}
+
+func (cw *countWriter) Write(p []byte) (int, error) {
+	n, err := cw.w.Write(p)
+	cw.n += int64(n)
+	return n, err
+}
+
+// writeSample 输出一行样本 E.g. name{a="1",b="2"} 3
+func writeSample(w *bufio.Writer, name string, labels, values []string, v float64) {
+	w.WriteString(name)
+	writeLabels(w, labels, values, "", "")
+	w.WriteByte(' ')
+	w.WriteString(formatFloat(v))
+	w.WriteByte('\n')
+}
+
+// writeHistogram 输出直方图的 _bucket、_sum 以及 _count
+func writeHistogram(w *bufio.Writer, name string, labels, values []string, h *Histogram) {
+	var cumulative uint64
+	for i := range h.counts {
+		cumulative += atomic.LoadUint64(&h.counts[i])
+
+		le := math.Inf(1)
+		if i < len(h.upper) {
+			le = h.upper[i]
+		}
+		w.WriteString(name + "_bucket")
+		writeLabels(w, labels, values, "le", formatFloat(le))
+		w.WriteString(" " + strconv.FormatUint(cumulative, 10) + "\n")
+	}
+
+	writeSample(w, name+"_sum", labels, values, h.Sum())
+	w.WriteString(name + "_count")
+	writeLabels(w, labels, values, "", "")
+	w.WriteString(" " + strconv.FormatUint(cumulative, 10) + "\n")
+}
+
+// writeLabels 输出 {a="1",b="2"}，extra 非空时追加在最后，没有标签时不输出
+func writeLabels(w *bufio.Writer, labels, values []string, extra, extraValue string) {
+	if len(labels) == 0 && extra == "" {
+		return
+	}
+
+	w.WriteByte('{')
+	for i, l := range labels {
+		if i > 0 {
+			w.WriteByte(',')
+		}
+		w.WriteString(l + `="` + escapeLabel(values[i]) + `"`)
+	}
+	if extra != "" {
+		if len(labels) > 0 {
+			w.WriteByte(',')
+		}
+		w.WriteString(extra + `="` + extraValue + `"`)
+	}
+	w.WriteByte('}')
+}
+
+var (
+	labelReplacer = strings.NewReplacer(`\`, `\\`, "\n", `\n`, `"`, `\"`)
+	helpReplacer  = strings.NewReplacer(`\`, `\\`, "\n", `\n`)
+)
+
+func escapeLabel(s string) string { return labelReplacer.Replace(s) }
+func escapeHelp(s string) string  { return helpReplacer.Replace(s) }
+
+// formatFloat 按照文本格式输出浮点数，E.g. +Inf、-Inf、NaN
+func formatFloat(v float64) string {
+	switch {
+	case math.IsInf(v, 1):
+		return "+Inf"
+	case math.IsInf(v, -1):
+		return "-Inf"
+	case math.IsNaN(v):
+		return "NaN"
+	}
+
+	return strconv.FormatFloat(v, 'g', -1, 64)
+}
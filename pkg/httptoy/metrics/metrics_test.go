@@ -0,0 +1,168 @@
+package metrics
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"math"
+	"net"
+	"strings"
+	"testing"
+
+	"build-HTTP-from-scracth/pkg/httptoy"
+	"build-HTTP-from-scracth/pkg/httptoy/httptoytest"
+)
+
+func TestExposition(t *testing.T) {
+	reg := NewRegistry()
+	reg.Counter("b_total", "Counter with \\ and\nnewline.").Add(2.5)
+	g := reg.GaugeVec("a_gauge", "Gauge.", "x", "y")
+	g.With("2", `q"\`+"\n").Set(math.Inf(1))
+	g.With("1", "").Dec()
+	h := reg.HistogramVec("c_seconds", "Histogram.", []float64{0.1, 1}, "route")
+	h.With("/").Observe(0.1)
+	h.With("/").Observe(0.5)
+	h.With("/").Observe(3)
+	reg.GaugeFunc("d", "Func.", func() float64 { return 7 })
+
+	var buf bytes.Buffer
+	n, err := reg.WriteTo(&buf)
+	if err != nil || n != int64(buf.Len()) {
+		t.Fatalf("WriteTo = %d, %v", n, err)
+	}
+
+	want := `# HELP a_gauge Gauge.
+# TYPE a_gauge gauge
+a_gauge{x="1",y=""} -1
+a_gauge{x="2",y="q\"\\\n"} +Inf
+# HELP b_total Counter with \\ and\nnewline.
+# TYPE b_total counter
+b_total 2.5
+# HELP c_seconds Histogram.
+# TYPE c_seconds histogram
+c_seconds_bucket{route="/",le="0.1"} 1
+c_seconds_bucket{route="/",le="1"} 2
+c_seconds_bucket{route="/",le="+Inf"} 3
+c_seconds_sum{route="/"} 3.6
+c_seconds_count{route="/"} 3
+# HELP d Func.
+# TYPE d gauge
+d 7
+`
+	if buf.String() != want {
+		t.Errorf("got\n%s\nwant\n%s", buf.String(), want)
+	}
+}
+
+func TestRegisterPanics(t *testing.T) {
+	for _, fn := range []func(r *Registry){
+		func(r *Registry) { r.Counter("1abc", "") },
+		func(r *Registry) { r.CounterVec("a", "", "le") },
+		func(r *Registry) { r.Counter("a", ""); r.Gauge("a", "") },
+		func(r *Registry) { r.HistogramVec("a", "", []float64{2, 1}) },
+		func(r *Registry) { r.CounterVec("a", "", "x").With("1", "2") },
+		func(r *Registry) { r.Counter("a", "").Add(-1) },
+	} {
+		func() {
+			defer func() {
+				if recover() == nil {
+					t.Errorf("expected panic")
+				}
+			}()
+			fn(NewRegistry())
+		}()
+	}
+}
+
+func TestServerMetrics(t *testing.T) {
+	reg := NewRegistry()
+	sm := NewServerMetrics(reg)
+
+	mux := httptoy.NewServeMux()
+	mux.HandleFunc("/hello", func(rw httptoy.ResponseWriter, req *httptoy.Request) {
+		io.WriteString(rw, "hello")
+	})
+	mux.Handle("/metrics", reg.Handler())
+
+	ts := httptoytest.NewUnstartedServer(sm.Instrument(mux))
+	ts.Config.Metrics = sm
+	ts.Start()
+	defer ts.Close()
+
+	for _, path := range []string{"/hello", "/hello", "/missing"} {
+		resp, err := ts.Client().Get(ts.URL + path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		io.ReadAll(resp.Body)
+		resp.Body.Close()
+	}
+
+	// 无法解析的请求
+	c, err := net.Dial("tcp", ts.Listener.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	io.WriteString(c, "BAD\r\n\r\n")
+	io.ReadAll(c)
+	c.Close()
+
+	resp, err := ts.Client().Get(ts.URL + "/metrics")
+	if err != nil {
+		t.Fatal(err)
+	}
+	body, _ := io.ReadAll(resp.Body)
+	resp.Body.Close()
+
+	if ct := resp.Header.Get("Content-Type"); ct != ContentType {
+		t.Errorf("Content-Type = %q", ct)
+	}
+	for _, line := range []string{
+		`httptoy_requests_total{pattern="/hello",method="GET",code="2xx"} 2`,
+		`httptoy_requests_total{pattern="none",method="GET",code="4xx"} 1`,
+		`httptoy_request_duration_seconds_count{pattern="/hello",method="GET"} 2`,
+		`httptoy_requests_in_flight 1`,
+		// 客户端的长连接正在请求 /metrics，无法解析请求的连接已经关闭
+		`httptoy_connections{state="active"} 1`,
+		`httptoy_connections{state="idle"} 0`,
+		`httptoy_connections_total 2`,
+		`httptoy_parse_errors_total{type="400"} 1`,
+	} {
+		if !strings.Contains(string(body), line+"\n") {
+			t.Errorf("missing %q in\n%s", line, body)
+		}
+	}
+	if strings.Contains(string(body), "httptoy_received_bytes_total 0\n") || strings.Contains(string(body), "httptoy_sent_bytes_total 0\n") {
+		t.Errorf("bytes not counted:\n%s", body)
+	}
+}
+
+func TestInstrumentPatternThroughMiddleware(t *testing.T) {
+	reg := NewRegistry()
+	sm := NewServerMetrics(reg)
+
+	mux := httptoy.NewServeMux()
+	mux.HandleFunc("/hello", func(rw httptoy.ResponseWriter, req *httptoy.Request) {})
+
+	// 中间件向下游传递 WithContext 的副本，ServeMux 的 req.Pattern 写在副本上
+	type key struct{}
+	middleware := httptoy.HandlerFunc(func(rw httptoy.ResponseWriter, req *httptoy.Request) {
+		mux.ServeHTTP(rw, req.WithContext(context.WithValue(req.Context(), key{}, 1)))
+	})
+	h := sm.Instrument(middleware)
+
+	for _, path := range []string{"/hello", "/missing"} {
+		h.ServeHTTP(httptoytest.NewRecorder(), httptoytest.NewRequest("GET", path, nil))
+	}
+
+	var buf bytes.Buffer
+	reg.WriteTo(&buf)
+	for _, line := range []string{
+		`httptoy_requests_total{pattern="/hello",method="GET",code="2xx"} 1`,
+		`httptoy_requests_total{pattern="none",method="GET",code="4xx"} 1`,
+	} {
+		if !strings.Contains(buf.String(), line+"\n") {
+			t.Errorf("missing %q in\n%s", line, buf.String())
+		}
+	}
+}
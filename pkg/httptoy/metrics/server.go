@@ -0,0 +1,122 @@
+package metrics
+
+import (
+	"strconv"
+	"time"
+
+	"build-HTTP-from-scracth/pkg/httptoy"
+)
+
+// ServerMetrics 统计 httptoy 服务端的请求以及连接
+// 设置为 Server.Metrics 统计连接数、收发的字节数以及解析错误，Instrument 包装的 handler 统计请求数以及延迟
+type ServerMetrics struct {
+	requests  CounterVec   // httptoy_requests_total{pattern,method,code}
+	latency   HistogramVec // httptoy_request_duration_seconds{pattern,method}
+	inFlight  *Gauge
+	conns     GaugeVec // httptoy_connections{state}
+	accepted  *Counter
+	bytesIn   *Counter
+	bytesOut  *Counter
+	parseErrs CounterVec // httptoy_parse_errors_total{type}
+}
+
+// NewServerMetrics 在 reg 中注册服务端的指标
+func NewServerMetrics(reg *Registry) *ServerMetrics {
+	return &ServerMetrics{
+		requests: reg.CounterVec("httptoy_requests_total",
+			"Number of HTTP requests handled, by route pattern, method and status class.", "pattern", "method", "code"),
+		latency: reg.HistogramVec("httptoy_request_duration_seconds",
+			"Time spent in the handler, by route pattern and method.", nil, "pattern", "method"),
+		inFlight: reg.Gauge("httptoy_requests_in_flight", "Number of requests currently being handled."),
+		conns: reg.GaugeVec("httptoy_connections",
+			"Number of open connections, by state: active connections are serving a request, idle ones wait for the next.", "state"),
+		accepted:  reg.Counter("httptoy_connections_total", "Number of connections accepted."),
+		bytesIn:   reg.Counter("httptoy_received_bytes_total", "Bytes read from client connections."),
+		bytesOut:  reg.Counter("httptoy_sent_bytes_total", "Bytes written to client connections."),
+		parseErrs: reg.CounterVec("httptoy_parse_errors_total", "Requests that could not be parsed, by type.", "type"),
+	}
+}
+
+// Instrument 包装 next，统计每个请求
+// 路由标签为内层 ServeMux 匹配到的路由，中间可以有替换请求的中间件，没有匹配的路由时为 "none"
+func (m *ServerMetrics) Instrument(next httptoy.Handler) httptoy.Handler {
+	return httptoy.HandlerFunc(func(rw httptoy.ResponseWriter, req *httptoy.Request) {
+		start := time.Now()
+		ww := httptoy.NewWrapWriter(rw)
+		req = req.WithContext(httptoy.CapturePattern(req.Context()))
+		m.inFlight.Inc()
+
+		// handler panic 时以 500 统计
+		status := 500
+		defer func() {
+			m.inFlight.Dec()
+
+			pattern := httptoy.PatternFromContext(req.Context())
+			if pattern == "" {
+				pattern = "none"
+			}
+			method := methodLabel(req.Method)
+			m.requests.With(pattern, method, statusClass(status)).Inc()
+			m.latency.With(pattern, method).Observe(time.Since(start).Seconds())
+		}()
+
+		next.ServeHTTP(ww, req)
+		status = ww.Status()
+	})
+}
+
+// methodLabel 将不常见的请求方法归为 OTHER，防止客户端任意的方法导致标签无限增长
+func methodLabel(method string) string {
+	switch method {
+	case "GET", "HEAD", "POST", "PUT", "PATCH", "DELETE", "OPTIONS", "CONNECT", "TRACE":
+		return method
+	}
+	return "OTHER"
+}
+
+// statusClass 返回状态码的类别，E.g. 404 -> 4xx
+func statusClass(code int) string {
+	if code < 100 || code > 599 {
+		return "unknown"
+	}
+	return strconv.Itoa(code/100) + "xx"
+}
+
+// 以下方法实现 httptoy.ServerMetrics
+
+func (m *ServerMetrics) ConnOpened() {
+	m.accepted.Inc()
+	m.conns.With("idle").Inc()
+}
+
+func (m *ServerMetrics) ConnClosed(idle bool) {
+	if idle {
+		m.conns.With("idle").Dec()
+	} else {
+		m.conns.With("active").Dec()
+	}
+}
+
+func (m *ServerMetrics) ConnActive() {
+	m.conns.With("idle").Dec()
+	m.conns.With("active").Inc()
+}
+
+func (m *ServerMetrics) ConnIdle() {
+	m.conns.With("active").Dec()
+	m.conns.With("idle").Inc()
+}
+
+func (m *ServerMetrics) BytesRead(n int64) {
+	m.bytesIn.Add(float64(n))
+}
+
+func (m *ServerMetrics) BytesWritten(n int64) {
+	m.bytesOut.Add(float64(n))
+}
+
+func (m *ServerMetrics) ParseError(kind string) {
+	m.parseErrs.With(kind).Inc()
+}
+
+var _ httptoy.ServerMetrics = (*ServerMetrics)(nil)
@@ -0,0 +1,450 @@
+package httptoy
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"io"
+	"mime/quotedprintable"
+	"os"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+// testPart 描述测试表单中的一个 part，filename 为空时为非文件数据
+type testPart struct {
+	name, filename, content string
+}
+
+// multipartBody 以 boundary xyz 构建 multipart 表单报文
+func multipartBody(parts ...testPart) string {
+	var sb strings.Builder
+	for _, p := range parts {
+		cd := fmt.Sprintf(`form-data; name="%s"`, p.name)
+		if p.filename != "" {
+			cd += fmt.Sprintf(`; filename="%s"`, p.filename)
+		}
+		fmt.Fprintf(&sb, "--xyz\r\nContent-Disposition: %s\r\n\r\n%s\r\n", cd, p.content)
+	}
+	sb.WriteString("--xyz--\r\n")
+
+	return sb.String()
+}
+
+// tempDirEntries 返回临时目录中的文件数
+func tempDirEntries(t *testing.T, dir string) int {
+	t.Helper()
+
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return len(entries)
+}
+
+func TestReadFormMaxMemory(t *testing.T) {
+	dir := t.TempDir()
+	big := strings.Repeat("b", 1000)
+
+	mr := NewMultipartReader(strings.NewReader(multipartBody(
+		testPart{"name", "", "gu"},
+		testPart{"small", "s.txt", "tiny"},
+		testPart{"big", "b.txt", big},
+	)), "xyz")
+	mr.TempDir = dir
+
+	mf, err := mr.ReadForm(100)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if mf.Value.Get("name") != "gu" || mf.File["small"][0].tmpFile != "" {
+		t.Fatalf("small values should stay in memory: %+v", mf)
+	}
+
+	fh := mf.File["big"][0]
+	if fh.tmpFile == "" || fh.Size != len(big) || tempDirEntries(t, dir) != 1 {
+		t.Fatalf("big file should be saved into %s: %+v", dir, fh)
+	}
+	rc, _ := fh.Open()
+	got, _ := io.ReadAll(rc)
+	rc.Close()
+	if string(got) != big {
+		t.Fatalf("temp file content mismatch")
+	}
+
+	mf.RemoveAll()
+	if n := tempDirEntries(t, dir); n != 0 {
+		t.Fatalf("RemoveAll left %d files", n)
+	}
+}
+
+func TestReadFormValueMemory(t *testing.T) {
+	dir := t.TempDir()
+	big := strings.Repeat("b", 100)
+
+	// 文件用完 maxMemory 之后，之后的文件保存到临时文件，非文件数据依旧可以保存在内存中
+	mr := NewMultipartReader(strings.NewReader(multipartBody(
+		testPart{"f1", "1.txt", big},
+		testPart{"f2", "2.txt", "tiny"},
+		testPart{"v", "", "value"},
+	)), "xyz")
+	mr.TempDir = dir
+
+	mf, err := mr.ReadForm(100)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer mf.RemoveAll()
+
+	if mf.Value.Get("v") != "value" || mf.File["f1"][0].tmpFile != "" || mf.File["f2"][0].tmpFile == "" {
+		t.Errorf("unexpected form: %+v, f1 %+v, f2 %+v", mf.Value, mf.File["f1"][0], mf.File["f2"][0])
+	}
+
+	// 非文件数据超过 maxMemory 以及额外的 maxValueBytes
+	mr = NewMultipartReader(strings.NewReader(multipartBody(
+		testPart{"v1", "", big},
+		testPart{"v2", "", strings.Repeat("v", maxValueBytes+1)},
+	)), "xyz")
+	if _, err := mr.ReadForm(100); err != ErrMessageTooLarge {
+		t.Errorf("err = %v, want ErrMessageTooLarge", err)
+	}
+}
+
+func TestReadFormLimits(t *testing.T) {
+	tests := []struct {
+		name      string
+		opts      MultipartOptions
+		maxMemory int64
+		want      error
+	}{
+		{"file size", MultipartOptions{MaxFileSize: 500}, 100, ErrFileTooLarge},
+		{"total size", MultipartOptions{MaxTotalSize: 1500}, 100, ErrMessageTooLarge},
+		{"within limits", MultipartOptions{MaxFileSize: 1000, MaxTotalSize: 2100}, 100, nil},
+	}
+
+	for _, tt := range tests {
+		dir := t.TempDir()
+		// 前两个文件先被保存到临时文件，之后才超过限制
+		body := multipartBody(
+			testPart{"f1", "1.txt", strings.Repeat("1", 400)},
+			testPart{"f2", "2.txt", strings.Repeat("2", 400)},
+			testPart{"f3", "3.txt", strings.Repeat("3", 1000)},
+			testPart{"v", "", "value"},
+		)
+
+		mr := NewMultipartReader(strings.NewReader(body), "xyz")
+		mr.MultipartOptions = tt.opts
+		mr.TempDir = dir
+
+		mf, err := mr.ReadForm(tt.maxMemory)
+		if !errors.Is(err, tt.want) {
+			t.Errorf("%s: err = %v, want %v", tt.name, err, tt.want)
+			continue
+		}
+		if err != nil {
+			// 出错时不能留下临时文件
+			if mf != nil || tempDirEntries(t, dir) != 0 {
+				t.Errorf("%s: partial temp files not cleaned up", tt.name)
+			}
+			continue
+		}
+
+		if len(mf.File) != 3 || mf.Value.Get("v") != "value" {
+			t.Errorf("%s: unexpected form %+v", tt.name, mf)
+		}
+		mf.RemoveAll()
+	}
+}
+
+func TestMultipartWalk(t *testing.T) {
+	// 文件远大于读取缓冲，Walk 中直接计算摘要，不缓存整个文件
+	data := bytes.Repeat([]byte("0123456789"), 100<<10)
+	want := sha256.Sum256(data)
+	body := multipartBody(testPart{"note", "", "hi"}, testPart{"file", "big.bin", string(data)}, testPart{"skip", "", "ignored"})
+
+	var (
+		got   [sha256.Size]byte
+		note  string
+		names []string
+	)
+	err := NewMultipartReader(strings.NewReader(body), "xyz").Walk(func(p *Part) error {
+		names = append(names, p.FormName())
+
+		switch p.FormName() {
+		case "note":
+			b, err := io.ReadAll(p)
+			note = string(b)
+			return err
+		case "file":
+			h := sha256.New()
+			if _, err := io.Copy(h, p); err != nil {
+				return err
+			}
+			copy(got[:], h.Sum(nil))
+		}
+		// 不读取的 part 由 Walk 丢弃
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if note != "hi" || got != want || strings.Join(names, ",") != "note,file,skip" {
+		t.Fatalf("note %q names %v digest match %v", note, names, got == want)
+	}
+
+	// fn 返回的错误以及大小限制都会中断 Walk
+	stop := errors.New("stop")
+	if err = NewMultipartReader(strings.NewReader(body), "xyz").Walk(func(p *Part) error { return stop }); err != stop {
+		t.Fatalf("want stop, got %v", err)
+	}
+
+	mr := NewMultipartReader(strings.NewReader(body), "xyz")
+	mr.MaxFileSize = 1 << 10
+	if err = mr.Walk(func(p *Part) error { return nil }); err != ErrFileTooLarge {
+		t.Fatalf("want ErrFileTooLarge, got %v", err)
+	}
+}
+
+func TestRequestMultipartOptions(t *testing.T) {
+	body := multipartBody(testPart{"file", "1.txt", strings.Repeat("a", 2000)})
+
+	var err error
+	serveRaw(t, testHandler(func(rw ResponseWriter, req *Request) {
+		req.MultipartOptions = &MultipartOptions{MaxFileSize: 1000}
+		_, err = req.FormFile("file")
+	}), "POST / HTTP/1.1\r\nConnection: close\r\nContent-Type: multipart/form-data; boundary=xyz\r\n"+
+		"Content-Length: "+strconv.Itoa(len(body))+"\r\n\r\n"+body)
+
+	if err != ErrFileTooLarge {
+		t.Fatalf("want ErrFileTooLarge, got %v", err)
+	}
+}
+
+func TestMultipartPreambleEpilogue(t *testing.T) {
+	// 边界之后的空白为 transport padding，终止边界之后的数据为 epilogue
+	body := "This is the preamble.\r\nIt is to be ignored.\r\n" +
+		"--xyz \t\r\n\r\nfirst\r\n" +
+		"--xyz\r\nContent-Type: text/html\r\n\r\n<b>second</b>\r\n" +
+		"--xyz--  \r\nThis is the epilogue.\r\n--xyz\r\n\r\nnot a part\r\n"
+
+	mr := NewMultipartReader(strings.NewReader(body), "xyz")
+
+	var got []string
+	err := mr.Walk(func(p *Part) error {
+		b, err := io.ReadAll(p)
+		got = append(got, p.ContentType()+":"+string(b))
+		return err
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := "text/plain:first,text/html:<b>second</b>"
+	if strings.Join(got, ",") != want {
+		t.Fatalf("got %q, want %q", got, want)
+	}
+	if _, err = mr.NextPart(); err != io.EOF {
+		t.Fatalf("NextPart after final boundary = %v, want EOF", err)
+	}
+}
+
+func TestMultipartNested(t *testing.T) {
+	// multipart/mixed 中嵌套另一个 multipart/mixed
+	var inner bytes.Buffer
+	imw := NewMultipartWriter(&inner)
+	for _, s := range []string{"att1", "att2"} {
+		h := make(Header)
+		h.Set("Content-Type", "application/octet-stream")
+		w, _ := imw.CreatePart(h)
+		io.WriteString(w, s)
+	}
+	imw.Close()
+
+	var outer bytes.Buffer
+	omw := NewMultipartWriter(&outer)
+	w, _ := omw.CreatePart(Header{"Content-Type": {"text/plain; charset=utf-8"}})
+	io.WriteString(w, "body text")
+	w, _ = omw.CreatePart(Header{"Content-Type": {imw.ContentType("mixed")}})
+	w.Write(inner.Bytes())
+	omw.Close()
+
+	var got []string
+	serveRaw(t, testHandler(func(rw ResponseWriter, req *Request) {
+		mr, err := req.MultipartReader()
+		if err != nil {
+			t.Error(err)
+			return
+		}
+
+		err = mr.Walk(func(p *Part) error {
+			if p.ContentType() != "multipart/mixed" {
+				b, err := io.ReadAll(p)
+				got = append(got, p.ContentType()+":"+string(b))
+				return err
+			}
+
+			nested, err := p.MultipartReader()
+			if err != nil {
+				return err
+			}
+			return nested.Walk(func(np *Part) error {
+				b, err := io.ReadAll(np)
+				got = append(got, "nested "+np.ContentType()+":"+string(b))
+				return err
+			})
+		})
+		if err != nil {
+			t.Error(err)
+		}
+	}), "POST / HTTP/1.1\r\nConnection: close\r\nContent-Type: "+omw.ContentType("mixed")+"\r\n"+
+		"Content-Length: "+strconv.Itoa(outer.Len())+"\r\n\r\n"+outer.String())
+
+	want := "text/plain:body text,nested application/octet-stream:att1,nested application/octet-stream:att2"
+	if strings.Join(got, ",") != want {
+		t.Fatalf("got %q, want %q", got, want)
+	}
+}
+
+func TestMultipartReaderNotMultipart(t *testing.T) {
+	var reqErr, partErr error
+	serveRaw(t, testHandler(func(rw ResponseWriter, req *Request) {
+		_, reqErr = req.MultipartReader()
+	}), "POST / HTTP/1.1\r\nConnection: close\r\nContent-Type: text/plain; boundary=xyz\r\nContent-Length: 0\r\n\r\n")
+
+	mr := NewMultipartReader(strings.NewReader(multipartBody(testPart{"a", "", "1"})), "xyz")
+	if p, err := mr.NextPart(); err == nil {
+		_, partErr = p.MultipartReader()
+	}
+
+	if reqErr == nil || partErr == nil {
+		t.Fatalf("non-multipart content should fail: %v, %v", reqErr, partErr)
+	}
+}
+
+func TestMultipartTransferEncoding(t *testing.T) {
+	data := "héllo, world! = 0123456789 " + strings.Repeat("x", 100)
+	b64 := base64.StdEncoding.EncodeToString([]byte(data))
+	// base64 每 76 个字符换行
+	var lines []string
+	for len(b64) > 76 {
+		lines = append(lines, b64[:76])
+		b64 = b64[76:]
+	}
+	lines = append(lines, b64)
+
+	var qp bytes.Buffer
+	qw := quotedprintable.NewWriter(&qp)
+	io.WriteString(qw, data)
+	qw.Close()
+
+	body := "--xyz\r\nContent-Disposition: form-data; name=\"b\"; filename=\"b.txt\"\r\nContent-Transfer-Encoding: BASE64\r\n\r\n" +
+		strings.Join(lines, "\r\n") + "\r\n" +
+		"--xyz\r\nContent-Disposition: form-data; name=\"q\"\r\nContent-Transfer-Encoding: quoted-printable\r\n\r\n" +
+		qp.String() + "\r\n" +
+		"--xyz\r\nContent-Disposition: form-data; name=\"plain\"\r\nContent-Transfer-Encoding: 8bit\r\n\r\n" +
+		data + "\r\n--xyz--\r\n"
+
+	// Progress 的 read 以及 total 都是报文中未解码的长度
+	progress := make(map[string]int64)
+	var total int64
+	mr := NewMultipartReader(strings.NewReader(body), "xyz")
+	mr.Progress = func(p *Part, read, n int64) {
+		progress[p.FormName()], total = read, n
+	}
+	mf, err := mr.ReadForm(1 << 20)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer mf.RemoveAll()
+
+	rawB, rawQ := int64(len(strings.Join(lines, "\r\n"))), int64(qp.Len())
+	if progress["b"] != rawB || progress["q"] != rawQ || progress["plain"] != int64(len(data)) ||
+		total != rawB+rawQ+int64(len(data)) {
+		t.Errorf("progress = %v, total = %d", progress, total)
+	}
+
+	fh := mf.File["b"][0]
+	rc, _ := fh.Open()
+	got, _ := io.ReadAll(rc)
+	rc.Close()
+	if string(got) != data || fh.Size != len(data) || fh.Header.Get("Content-Transfer-Encoding") != "" {
+		t.Errorf("base64 file = %q, size %d, header %v", got, fh.Size, fh.Header)
+	}
+	if mf.Value.Get("q") != data || mf.Value.Get("plain") != data {
+		t.Errorf("q = %q, plain = %q", mf.Value.Get("q"), mf.Value.Get("plain"))
+	}
+
+	// NextRawPart 返回未解码的数据，并保留首部
+	p, err := NewMultipartReader(strings.NewReader(body), "xyz").NextRawPart()
+	if err != nil {
+		t.Fatal(err)
+	}
+	raw, _ := io.ReadAll(p)
+	if string(raw) != strings.Join(lines, "\r\n") || p.Header.Get("Content-Transfer-Encoding") != "BASE64" {
+		t.Errorf("raw part = %q, header %v", raw, p.Header)
+	}
+}
+
+func TestMultipartHooks(t *testing.T) {
+	png := "\x89PNG\r\n\x1a\n" + strings.Repeat("p", 1000)
+	errNotImage := errors.New("only images are allowed")
+	opts := MultipartOptions{
+		Accept: func(info *PartInfo) error {
+			if info.FileName != "" && !strings.HasPrefix(info.ContentType, "image/") {
+				return errNotImage
+			}
+			return nil
+		},
+	}
+
+	// 只有图片能够上传，Progress 记录每个 part 读取的字节数
+	var progress []string
+	var total int64
+	mr := NewMultipartReader(strings.NewReader(multipartBody(
+		testPart{"name", "", "gu"},
+		testPart{"avatar", "a.png", png},
+	)), "xyz")
+	mr.MultipartOptions = opts
+	mr.Progress = func(p *Part, read, n int64) {
+		progress = append(progress, p.FormName()+":"+strconv.FormatInt(read, 10))
+		total = n
+	}
+
+	mf, err := mr.ReadForm(100)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer mf.RemoveAll()
+
+	if fh := mf.File["avatar"][0]; fh.Size != len(png) {
+		t.Fatalf("avatar size = %d, want %d", fh.Size, len(png))
+	}
+	if len(progress) < 2 || progress[0] != "name:2" || progress[len(progress)-1] != "avatar:"+strconv.Itoa(len(png)) {
+		t.Errorf("progress = %v", progress)
+	}
+	if total != int64(2+len(png)) {
+		t.Errorf("total = %d", total)
+	}
+
+	// 拒绝文本文件，不会留下临时文件
+	dir := t.TempDir()
+	var err2 error
+	body := multipartBody(testPart{"avatar", "a.txt", strings.Repeat("text ", 1000)})
+	serveRaw(t, testHandler(func(rw ResponseWriter, req *Request) {
+		o := opts
+		o.TempDir = dir
+		req.MultipartOptions = &o
+		_, err2 = req.FormFile("avatar")
+	}), "POST / HTTP/1.1\r\nConnection: close\r\nContent-Type: multipart/form-data; boundary=xyz\r\n"+
+		"Content-Length: "+strconv.Itoa(len(body))+"\r\n\r\n"+body)
+
+	if err2 != errNotImage || tempDirEntries(t, dir) != 0 {
+		t.Fatalf("want %v without temp files, got %v", errNotImage, err2)
+	}
+}
@@ -0,0 +1,216 @@
+package httptoy
+
+import (
+	"crypto/rand"
+	"errors"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+	"unicode/utf8"
+)
+
+// multipart_writer.go 负责生成 multipart 报文，格式与 multipart.go 解析的相同
+// 可用于 multipart/form-data 上传以及 multipart/byteranges、multipart/mixed 响应
+
+// MultipartWriter 依次写入各个 part，Close 时写入终止边界
+type MultipartWriter struct {
+	w        io.Writer
+	boundary string
+	lastPart *partWriter // 当前正在写入的 part，写入下一个 part 之后失效
+	closed   bool
+}
+
+// NewMultipartWriter 创建写入 w 的 MultipartWriter，使用随机生成的边界
+func NewMultipartWriter(w io.Writer) *MultipartWriter {
+	return &MultipartWriter{
+		w:        w,
+		boundary: randomBoundary(),
+	}
+}
+
+// randomBoundary 生成 60 个十六进制字符的边界，与报文内容冲突的概率可以忽略
+func randomBoundary() string {
+	var buf [30]byte
+	if _, err := io.ReadFull(rand.Reader, buf[:]); err != nil {
+		panic(err)
+	}
+
+	return fmt.Sprintf("%x", buf[:])
+}
+
+// Boundary 返回当前的边界
+func (mw *MultipartWriter) Boundary() string {
+	return mw.boundary
+}
+
+// SetBoundary 设置自定义的边界，必须在写入第一个 part 之前调用
+// 边界为 1 到 70 个 RFC 2046 允许的字符，并且不能以空格结尾
+func (mw *MultipartWriter) SetBoundary(boundary string) error {
+	if mw.lastPart != nil {
+		return errors.New("multipart: SetBoundary called after write")
+	}
+	if len(boundary) < 1 || len(boundary) > 70 || strings.HasSuffix(boundary, " ") {
+		return errors.New("multipart: invalid boundary length")
+	}
+
+	for i := 0; i < len(boundary); i++ {
+		c := boundary[i]
+		if 'a' <= c && c <= 'z' || 'A' <= c && c <= 'Z' || '0' <= c && c <= '9' {
+			continue
+		}
+		if strings.IndexByte("'()+_,-./:=? ", c) < 0 {
+			return errors.New("multipart: invalid boundary character")
+		}
+	}
+
+	mw.boundary = boundary
+	return nil
+}
+
+// FormDataContentType 返回 multipart/form-data 请求的 Content-Type，边界包含分隔符时加上引号
+func (mw *MultipartWriter) FormDataContentType() string {
+	return mw.ContentType("form-data")
+}
+
+// ContentType 返回指定子类型的 Content-Type
+// E.g. ContentType("byteranges") -> multipart/byteranges; boundary=...
+func (mw *MultipartWriter) ContentType(subtype string) string {
+	b := mw.boundary
+	if strings.ContainsAny(b, `()<>@,;:\"/[]?= `) {
+		b = `"` + b + `"`
+	}
+
+	return "multipart/" + subtype + "; boundary=" + b
+}
+
+// CreatePart 写入边界以及 part 的首部，返回写入 part 内容的 Writer
+// 上一个 part 在此之后不能再写入，首部按照键的顺序写入
+func (mw *MultipartWriter) CreatePart(header Header) (io.Writer, error) {
+	if mw.closed {
+		return nil, errors.New("multipart: CreatePart called after Close")
+	}
+
+	var sb strings.Builder
+	// 第一个边界之前没有 \r\n
+	if mw.lastPart != nil {
+		mw.lastPart.closed = true
+		sb.WriteString("\r\n")
+	}
+	sb.WriteString("--" + mw.boundary + "\r\n")
+
+	keys := make([]string, 0, len(header))
+	for k := range header {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		for _, v := range header[k] {
+			fmt.Fprintf(&sb, "%s: %s\r\n", k, v)
+		}
+	}
+	sb.WriteString("\r\n")
+
+	if _, err := io.WriteString(mw.w, sb.String()); err != nil {
+		return nil, err
+	}
+
+	mw.lastPart = &partWriter{mw: mw}
+	return mw.lastPart, nil
+}
+
+// quoteEscaper 转义 quoted-string 中的引号以及反斜杠，换行按照 RFC 7578 进行百分号编码
+var quoteEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\r", "%0D", "\n", "%0A")
+
+// formDataDisposition 生成 form-data 的 Content-Disposition
+// 文件名包含非 ASCII 字符时，额外附加 RFC 5987 编码的 filename*
+func formDataDisposition(fieldname, filename string, isFile bool) string {
+	cd := fmt.Sprintf(`form-data; name="%s"`, quoteEscaper.Replace(fieldname))
+	if !isFile {
+		return cd
+	}
+
+	cd += fmt.Sprintf(`; filename="%s"`, quoteEscaper.Replace(filename))
+	for i := 0; i < len(filename); i++ {
+		if filename[i] >= utf8.RuneSelf {
+			cd += "; filename*=UTF-8''" + encodeRFC5987(filename)
+			break
+		}
+	}
+
+	return cd
+}
+
+// encodeRFC5987 将 attr-char 之外的字节编码为 %XX
+func encodeRFC5987(v string) string {
+	var sb strings.Builder
+	for i := 0; i < len(v); i++ {
+		c := v[i]
+		if 'a' <= c && c <= 'z' || 'A' <= c && c <= 'Z' || '0' <= c && c <= '9' || strings.IndexByte("!#$&+-.^_`|~", c) >= 0 {
+			sb.WriteByte(c)
+			continue
+		}
+		fmt.Fprintf(&sb, "%%%02X", c)
+	}
+
+	return sb.String()
+}
+
+// CreateFormField 创建非文件字段的 part
+func (mw *MultipartWriter) CreateFormField(fieldname string) (io.Writer, error) {
+	h := make(Header)
+	h.Set("Content-Disposition", formDataDisposition(fieldname, "", false))
+
+	return mw.CreatePart(h)
+}
+
+// CreateFormFile 创建文件字段的 part，Content-Type 为 application/octet-stream
+func (mw *MultipartWriter) CreateFormFile(fieldname, filename string) (io.Writer, error) {
+	h := make(Header)
+	h.Set("Content-Disposition", formDataDisposition(fieldname, filename, true))
+	h.Set("Content-Type", "application/octet-stream")
+
+	return mw.CreatePart(h)
+}
+
+// WriteField 创建非文件字段并写入 value
+func (mw *MultipartWriter) WriteField(fieldname, value string) error {
+	w, err := mw.CreateFormField(fieldname)
+	if err != nil {
+		return err
+	}
+
+	_, err = io.WriteString(w, value)
+	return err
+}
+
+// Close 写入终止边界，之后不能再创建 part
+func (mw *MultipartWriter) Close() error {
+	if mw.closed {
+		return nil
+	}
+	mw.closed = true
+
+	prefix := ""
+	if mw.lastPart != nil {
+		mw.lastPart.closed = true
+		prefix = "\r\n"
+	}
+
+	_, err := io.WriteString(mw.w, prefix+"--"+mw.boundary+"--\r\n")
+	return err
+}
+
+// partWriter 写入一个 part 的内容
+type partWriter struct {
+	mw     *MultipartWriter
+	closed bool
+}
+
+func (pw *partWriter) Write(p []byte) (int, error) {
+	if pw.closed {
+		return 0, errors.New("multipart: can't write to finished part")
+	}
+
+	return pw.mw.w.Write(p)
+}
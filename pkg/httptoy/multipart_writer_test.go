@@ -0,0 +1,130 @@
+package httptoy
+
+import (
+	"bytes"
+	"io"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+func TestMultipartWriterRoundTrip(t *testing.T) {
+	// 文件内容中包含类似边界的数据以及 \r\n，字段名以及文件名包含需要转义的字符
+	// 文件名中的反斜杠会被当作路径分隔符去掉，因此只出现在字段名中
+	binary := bytes.Repeat([]byte("\r\n--\x00\xff"), 3000)
+	files := []struct{ field, name string }{
+		{`fi\le`, `a "quoted" name;x=1.txt`},
+		{"file", "中文.txt"},
+		{"line\r\nbreak", "plain.bin"},
+	}
+
+	var buf bytes.Buffer
+	mw := NewMultipartWriter(&buf)
+	if err := mw.WriteField("title", "hello\r\nworld"); err != nil {
+		t.Fatal(err)
+	}
+	for _, f := range files {
+		w, err := mw.CreateFormFile(f.field, f.name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		w.Write(binary)
+	}
+	if err := mw.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	typ, params, err := parseMediaType(mw.FormDataContentType())
+	if err != nil || typ != "multipart/form-data" || params["boundary"] != mw.Boundary() || len(mw.Boundary()) != 60 {
+		t.Fatalf("bad content type %q", mw.FormDataContentType())
+	}
+
+	mf, err := NewMultipartReader(&buf, params["boundary"]).ReadForm(1 << 20)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if got := mf.Value.Get("title"); got != "hello\r\nworld" {
+		t.Errorf("title = %q", got)
+	}
+	// 字段名中的换行按照 RFC 7578 编码为 %0D%0A
+	got := append(mf.File[`fi\le`], mf.File["file"]...)
+	got = append(got, mf.File["line%0D%0Abreak"]...)
+	if len(got) != len(files) {
+		t.Fatalf("got %d files: %v", len(got), mf.File)
+	}
+	for i, fh := range got {
+		if fh.Filename != files[i].name {
+			t.Errorf("filename = %q, want %q", fh.Filename, files[i].name)
+		}
+		rc, _ := fh.Open()
+		b, _ := io.ReadAll(rc)
+		rc.Close()
+		if !bytes.Equal(b, binary) {
+			t.Errorf("%s: content mismatch, got %d bytes", fh.Filename, len(b))
+		}
+	}
+}
+
+func TestMultipartWriterBoundary(t *testing.T) {
+	var buf bytes.Buffer
+	mw := NewMultipartWriter(&buf)
+
+	for _, b := range []string{"", strings.Repeat("a", 71), "trailing ", "bad\"quote"} {
+		if mw.SetBoundary(b) == nil {
+			t.Errorf("SetBoundary(%q) should fail", b)
+		}
+	}
+
+	if err := mw.SetBoundary("my:boundary"); err != nil {
+		t.Fatal(err)
+	}
+	if ct := mw.ContentType("mixed"); ct != `multipart/mixed; boundary="my:boundary"` {
+		t.Errorf("ContentType = %q", ct)
+	}
+
+	h := make(Header)
+	h.Set("Content-Type", "text/plain")
+	h.Set("Content-Range", "bytes 0-4/10")
+	w, _ := mw.CreatePart(h)
+	io.WriteString(w, "hello")
+	mw.Close()
+
+	want := "--my:boundary\r\nContent-Range: bytes 0-4/10\r\nContent-Type: text/plain\r\n\r\nhello\r\n--my:boundary--\r\n"
+	if buf.String() != want {
+		t.Fatalf("got %q, want %q", buf.String(), want)
+	}
+
+	// 写入下一个 part 或者 Close 之后不能再写入
+	if _, err := w.Write([]byte("x")); err == nil {
+		t.Error("write to finished part should fail")
+	}
+	if _, err := mw.CreatePart(h); err == nil {
+		t.Error("CreatePart after Close should fail")
+	}
+	if mw.SetBoundary("other") == nil {
+		t.Error("SetBoundary after write should fail")
+	}
+}
+
+func TestMultipartWriterRequest(t *testing.T) {
+	var buf bytes.Buffer
+	mw := NewMultipartWriter(&buf)
+	mw.WriteField("name", "gu")
+	w, _ := mw.CreateFormFile("avatar", "../a.png")
+	io.WriteString(w, "PNG")
+	mw.Close()
+
+	var name, filename string
+	serveRaw(t, testHandler(func(rw ResponseWriter, req *Request) {
+		name = req.PostFormValue("name")
+		if fh, err := req.FormFile("avatar"); err == nil {
+			filename = fh.Filename
+		}
+	}), "POST / HTTP/1.1\r\nConnection: close\r\nContent-Type: "+mw.FormDataContentType()+"\r\n"+
+		"Content-Length: "+strconv.Itoa(buf.Len())+"\r\n\r\n"+buf.String())
+
+	if name != "gu" || filename != "a.png" {
+		t.Fatalf("got name %q filename %q", name, filename)
+	}
+}
@@ -3,10 +3,13 @@ package httptoy
 import (
 	"bufio"
 	"bytes"
+	"encoding/base64"
 	"errors"
 	"fmt"
 	"io"
 	"io/ioutil"
+	"mime/quotedprintable"
+	"net/http"
 	"os"
 	"strings"
 )
@@ -39,6 +42,9 @@ import (
 type Part struct {
 	Header Header // 存储 当前 part 的首部
 	mr     *MultipartReader
+	r      io.Reader // 读取 part 数据的流，设置了 Content-Transfer-Encoding 时为解码流
+	n      int64     // 已经读取的(解码后)数据长度
+	raw    int64     // 已经读取的原始数据长度，跟 MultipartReader.total 的单位相同
 
 	formName         string
 	fileName         string
@@ -58,12 +64,82 @@ func (p *Part) Close() error {
 	return err
 }
 
-// Read 需要处理 Body 存在数据读取时如何寻找boundary以及 Body 出现 eof 情况
+// Read 读取 part 的数据，并检查 MultipartOptions 中的大小限制
+// 超过限制时返回 ErrFileTooLarge 或者 ErrMessageTooLarge，之后的 NextPart 同样返回该错误
+// MaxFileSize 限制解码后的文件大小，MaxTotalSize 限制报文中原始数据的总长度
+func (p *Part) Read(buf []byte) (n int, err error) {
+	n, err = p.r.Read(buf)
+	p.n += int64(n)
+
+	if max := p.mr.MaxFileSize; max > 0 && p.n > max && p.FileName() != "" {
+		return n, ErrFileTooLarge
+	}
+	if fn := p.mr.Progress; fn != nil && n > 0 {
+		fn(p, p.raw, p.mr.total)
+	}
+
+	return n, err
+}
+
+// accept 预读 part 开头至多 sniffLen 字节检测类型，交给 MultipartOptions.Accept 判断
+// 预读的数据会放回 p.r，之后依旧能通过 Read 读取
+func (p *Part) accept() error {
+	buf := make([]byte, sniffLen)
+	n, err := io.ReadFull(p.r, buf)
+	if err != nil && err != io.EOF && err != io.ErrUnexpectedEOF {
+		return err
+	}
+	buf = buf[:n]
+	p.r = io.MultiReader(bytes.NewReader(buf), p.r)
+
+	return p.mr.Accept(&PartInfo{
+		FormName:    p.FormName(),
+		FileName:    p.FileName(),
+		Header:      p.Header,
+		ContentType: http.DetectContentType(buf),
+	})
+}
+
+// rawPartReader 读取 part 未解码的原始数据，并统计报文的总长度
+type rawPartReader struct {
+	p *Part
+}
+
+func (rr rawPartReader) Read(buf []byte) (n int, err error) {
+	n, err = rr.p.read(buf)
+	rr.p.raw += int64(n)
+	rr.p.mr.total += int64(n)
+
+	if max := rr.p.mr.MaxTotalSize; max > 0 && rr.p.mr.total > max {
+		return n, ErrMessageTooLarge
+	}
+
+	return n, err
+}
+
+// setupDecoder 根据 Content-Transfer-Encoding 设置解码流，解码之后删除该首部
+// E.g. Content-Transfer-Encoding: base64
+func (p *Part) setupDecoder() {
+	switch strings.ToLower(strings.TrimSpace(p.Header.Get("Content-Transfer-Encoding"))) {
+	case "base64":
+		// base64 解码会忽略换行
+		p.r = base64.NewDecoder(base64.StdEncoding, p.r)
+	case "quoted-printable":
+		p.r = quotedprintable.NewReader(p.r)
+	default:
+		// 7bit、8bit、binary 不需要解码
+		return
+	}
+
+	p.Header.Del("Content-Transfer-Encoding")
+}
+
+// read 需要处理 Body 存在数据读取时如何寻找boundary以及 Body 出现 eof 情况
 // 1.Body 出现 eof 时，如果以及找到 终止边界，则需要关闭 Part
 // 另一种情况是 客户端异常提前关闭了表单连接，导致服务端没读取完，则同样进行关闭
 // 2.正常的解析Boundary，通过 bufr的 peek方法寻找 boundary 出现的位置，读取之前的数据进行下一步的字符串解析
 // 可能出现 bufSize长度的缓存数据中没找到，需要保留 最后 boundary 长度的数据，需要跟下一次缓存数据拼接成 boundary标志
-func (p *Part) Read(buf []byte) (n int, err error) {
+func (p *Part) read(buf []byte) (n int, err error) {
 	// case 1: p 已经关闭
 	if p.closed {
 		return 0, io.EOF
@@ -109,35 +185,50 @@ func (p *Part) Read(buf []byte) (n int, err error) {
 }
 
 // parseFormData 必须在 readHeader 之后调用，否则可能为空串，或是旧字符串
-// parseFormData 用于解析 multipart中每个part第一行的信息
+// parseFormData 用于解析 multipart中每个part第一行的信息，文件名会去掉路径部分
 // E.g. Content-Disposition: form-data; name="file1"; filename="1.txt"\r\n
+//
+//	Content-Disposition: form-data; name="file1"; filename*=UTF-8''%E4%B8%AD.txt\r\n
 func (p *Part) parseFormData() {
 	p.parsed = true // 先设置，防止卡壳
 
-	// cd 因为被 readHeader 做处理，所以如下形式
-	// form-data; name="password"
-	cd := p.Header.Get("Content-Disposition")
-	splitCd := strings.Split(cd, ";")
+	disposition, params, err := parseMediaType(p.Header.Get("Content-Disposition"))
 	// 解析错误处理
-	if len(splitCd) < 2 || strings.ToLower(splitCd[0]) != "form-data" {
+	if err != nil || disposition != "form-data" {
 		return
 	}
 
-	// 提取信息
-	for _, str := range splitCd[1:] {
-		kvStr := strings.Split(str, "=")
-		if len(kvStr) != 2 { // 格式错误
-			return
-		}
+	p.formName = params["name"]
+	p.fileName = sanitizeFilename(params["filename"])
+}
 
-		k, v := strings.TrimSpace(kvStr[0]), strings.Trim(kvStr[1], `"`)
-		switch k { // 用 switch 进行正确的解析
-		case "name":
-			p.formName = v
-		case "filename":
-			p.fileName = v
-		}
+// ContentType 返回 part 的媒体类型，没有设置 Content-Type 时按照 RFC 2046 默认为 text/plain
+// E.g. Content-Type: Multipart/Mixed; boundary=abc -> multipart/mixed
+func (p *Part) ContentType() string {
+	ct := p.Header.Get("Content-Type")
+	if ct == "" {
+		return "text/plain"
 	}
+
+	mediatype, _, _ := parseMediaType(ct)
+	return mediatype
+}
+
+// MultipartReader 将 part 本身作为嵌套的 multipart 报文读取，
+// 常见于 multipart/mixed 中的多个附件，嵌套的 part 继承外层的 MultipartOptions
+func (p *Part) MultipartReader() (*MultipartReader, error) {
+	mediatype, params, err := parseMediaType(p.Header.Get("Content-Type"))
+	if err != nil || !strings.HasPrefix(mediatype, "multipart/") {
+		return nil, fmt.Errorf("multipart: part is not multipart: %q", p.Header.Get("Content-Type"))
+	}
+	if params["boundary"] == "" {
+		return nil, errors.New("no boundary detected")
+	}
+
+	mr := NewMultipartReader(p, params["boundary"])
+	mr.MultipartOptions = p.mr.MultipartOptions
+
+	return mr, nil
 }
 
 // FormName ...
@@ -161,7 +252,39 @@ func (p *Part) FileName() string {
 
 const bufSize = 4 << 10 // 滑动窗口的大小
 
+var (
+	// ErrMessageTooLarge 表单超过了 ReadForm 的内存上限或者 MultipartOptions.MaxTotalSize
+	ErrMessageTooLarge = errors.New("multipart: message too large")
+	// ErrFileTooLarge 文件超过了 MultipartOptions.MaxFileSize
+	ErrFileTooLarge = errors.New("multipart: file too large")
+)
+
+// MultipartOptions 配置 multipart 表单的大小限制、临时文件的位置以及读取 part 时的回调
+type MultipartOptions struct {
+	MaxFileSize  int64  // 单个文件的上限，<= 0 时不限制
+	MaxTotalSize int64  // 所有 part 数据的总长度上限，<= 0 时不限制
+	TempDir      string // 保存大文件的临时目录，空串时为 os.TempDir()
+
+	// Accept 在返回 part 之前调用，返回错误时拒绝该 part，NextPart、Walk 以及 ReadForm 返回该错误
+	// 可以根据字段名、文件名以及检测到的类型拒绝不允许上传的文件，此时文件还没有写入内存或者临时文件
+	Accept func(info *PartInfo) error
+	// Progress 在每次读取 part 的数据之后调用，
+	// read 为当前 part 已经读取的原始字节数，total 为报文中所有 part 已经读取的原始字节数，
+	// 两者都是报文中未解码的长度，设置了 Content-Transfer-Encoding 时跟解码后的大小不同
+	Progress func(p *Part, read, total int64)
+}
+
+// PartInfo 描述交给 MultipartOptions.Accept 判断的 part
+type PartInfo struct {
+	FormName    string
+	FileName    string // 经过 sanitizeFilename 处理的文件名，非文件数据时为空
+	Header      Header
+	ContentType string // 根据 part 数据的前 sniffLen 字节检测的类型，E.g. image/png
+}
+
 type MultipartReader struct {
+	MultipartOptions
+
 	// bufr 是对 Body 的封装，方便使用peek预查Body上的数据，从而确定part之间边界
 	// 每个part共享这个bufr，但只有Body的读取指针指向对应part的报文
 	// 对应的part能从指针中读取数据，此时其他part是无效的
@@ -176,6 +299,8 @@ type MultipartReader struct {
 	dashBoundaryDash     []byte  // --boundary--
 	curPart              *Part   // 当前解析到了哪个part
 	crlf                 [2]byte // 用于消费 \r\n
+	total                int64   // 所有 part 已经读取的数据长度
+	done                 bool    // 已经读到终止边界，之后的数据为 epilogue
 }
 
 func NewMultipartReader(r io.Reader, boundary string) *MultipartReader {
@@ -202,7 +327,35 @@ func (mr *MultipartReader) discardCRLF() error {
 	return fmt.Errorf("Expect crlf, but got %s", mr.crlf)
 }
 
-func (mr *MultipartReader) NextPart() (p *Part, err error) {
+// boundaryLine 判断 line 是否为边界或者终止边界
+// RFC 2046 允许边界之后出现空白(transport padding)，E.g. "--boundary  \r\n"
+func (mr *MultipartReader) boundaryLine(line []byte) (isBoundary, isFinal bool) {
+	line = bytes.TrimRight(line, " \t")
+
+	return bytes.Equal(line, mr.dashBoundary), bytes.Equal(line, mr.dashBoundaryDash)
+}
+
+// NextPart 返回下一个 part，读到终止边界时返回 io.EOF
+// 第一个边界之前的 preamble 以及终止边界之后的 epilogue 都会被忽略
+// part 设置了 base64 或者 quoted-printable 的 Content-Transfer-Encoding 时，读取的是解码后的数据
+func (mr *MultipartReader) NextPart() (*Part, error) {
+	return mr.nextPart(false)
+}
+
+// NextRawPart 与 NextPart 相同，但不处理 Content-Transfer-Encoding，读取的是原始数据
+func (mr *MultipartReader) NextRawPart() (*Part, error) {
+	return mr.nextPart(true)
+}
+
+func (mr *MultipartReader) nextPart(raw bool) (p *Part, err error) {
+	if mr.done {
+		return nil, io.EOF
+	}
+	// RFC 2046 限制边界至多 70 个字符，过长的边界无法在 bufSize 的滑动窗口中找到
+	if len(mr.dashBoundary) > 2+70 {
+		return nil, errors.New("multipart: boundary too long")
+	}
+
 	// 如果curPart存在，将其关闭，消费掉当前part数据，让下一次part做准备
 	if mr.curPart != nil {
 		if err = mr.curPart.Close(); err != nil {
@@ -215,30 +368,55 @@ func (mr *MultipartReader) NextPart() (p *Part, err error) {
 	}
 
 	// 前提: 当前是创建新part的时候，因此下一行必定是boundary, 对其判断是否为终止边界，否则继续创建下一个part
-	line, err := readLine(mr.bufr)
-	if err != nil {
-		return
-	}
+	// 第一个 part 之前可能有 preamble，跳过直到遇到边界
+	for {
+		var line []byte
+		line, err = readLine(mr.bufr)
+		if err != nil {
+			if err == io.EOF {
+				err = io.ErrUnexpectedEOF
+			}
+			return
+		}
 
-	// 到达 multipart 表单末尾，终止读取
-	if bytes.Equal(line, mr.dashBoundaryDash) {
-		return nil, io.EOF
-	}
-	// 如果当前行不是boudary，则出现错误
-	if !bytes.Equal(line, mr.dashBoundary) {
-		err = fmt.Errorf("Want delimiter %s, but got %s", mr.dashBoundary, line)
-		return
+		isBoundary, isFinal := mr.boundaryLine(line)
+		// 到达 multipart 表单末尾，终止读取
+		if isFinal {
+			mr.done = true
+			return nil, io.EOF
+		}
+		if isBoundary {
+			break
+		}
+
+		// 如果已经读过 part，当前行不是boudary，则出现错误
+		if mr.curPart != nil {
+			err = fmt.Errorf("Want delimiter %s, but got %s", mr.dashBoundary, line)
+			return
+		}
 	}
 
 	p = new(Part)
 	p.mr = mr
+	p.r = rawPartReader{p}
 	// 为 part的类似首部字段解析处理
 	p.Header, err = readHeader(mr.bufr)
 	if err != nil {
 		return
 	}
 
+	if !raw {
+		p.setupDecoder()
+	}
+
+	// 先设置 curPart，拒绝之后依旧能够通过 Close 消费剩余数据
 	mr.curPart = p
+	if mr.Accept != nil {
+		if err = p.accept(); err != nil {
+			return nil, err
+		}
+	}
+
 	return
 }
 
@@ -284,41 +462,76 @@ func (fh *FileHeader) Save(dest string) error {
 	return nil
 }
 
+// MultipartForm 保存解析后的 multipart 表单，同名的字段以及文件按照出现的顺序保存
 type MultipartForm struct {
-	Value map[string]string
-	File  map[string]*FileHeader
+	Value Values
+	File  map[string][]*FileHeader
 }
 
 // RemoveAll 用于 handler 结束后删除临时文件
 func (mf *MultipartForm) RemoveAll() {
-	for _, fh := range mf.File {
-		if fh == nil || fh.tmpFile == "" {
-			continue
+	for _, fhs := range mf.File {
+		for _, fh := range fhs {
+			if fh == nil || fh.tmpFile == "" {
+				continue
+			}
+
+			os.Remove(fh.tmpFile)
 		}
+	}
+}
 
-		os.Remove(fh.tmpFile)
+// Walk 依次将每个 part 交给 fn 处理，fn 可以直接将 part 的数据写入存储，不经过内存缓冲
+// fn 没有读完的数据会被丢弃，fn 返回错误时停止并返回该错误
+func (mr *MultipartReader) Walk(fn func(p *Part) error) error {
+	for {
+		p, err := mr.NextPart()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+
+		if err = fn(p); err != nil {
+			return err
+		}
 	}
 }
 
-// ReadForm ...
-func (mr *MultipartReader) ReadForm() (*MultipartForm, error) {
-	var (
-		part             *Part
-		err              error
-		n                int64
-		nonFileMaxMemory int64 = 10 << 20 // 保存非文件数据最大内存为 10 mb
-		fileMaxMemory    int64 = 30 << 20 // 保存文件数据最大内存为 30 mb
-	)
+// maxValueBytes 是 ReadForm 在 maxMemory 之外额外给非文件数据的内存，跟 net/http 相同
+// 文件至多使用 maxMemory 字节的内存，前面的文件不会用完后面非文件数据的内存
+const maxValueBytes = 10 << 20
+
+// ReadForm 读取整个表单，文件至多使用 maxMemory 字节的内存，超过剩余内存的文件保存到
+// MultipartOptions.TempDir 的临时文件中
+// 非文件数据只保存在内存中，在 maxMemory 之外还有额外的 maxValueBytes，超过时返回 ErrMessageTooLarge
+// 出现错误时，已经创建的临时文件全部删除
+func (mr *MultipartReader) ReadForm(maxMemory int64) (_ *MultipartForm, err error) {
+	mf := &MultipartForm{
+		Value: make(Values),
+		File:  make(map[string][]*FileHeader),
+	}
+	defer func() {
+		if err != nil {
+			mf.RemoveAll()
+		}
+	}()
 
-	mf := MultipartForm{
-		Value: make(map[string]string),
-		File:  make(map[string]*FileHeader),
+	// fileMemory 是文件剩余可用的内存，valueMemory 是非文件数据以及内存中的文件总共剩余可用的内存
+	fileMemory := maxMemory
+	valueMemory := maxMemory + maxValueBytes
+	if valueMemory < 0 { // 溢出
+		valueMemory = 1<<63 - 1
 	}
 
 	for {
-		part, err = mr.NextPart()
-		if err == io.EOF || err != nil {
-			break
+		part, err := mr.NextPart()
+		if err == io.EOF { // 读到终止边界属于正常结束
+			return mf, nil
+		}
+		if err != nil {
+			return nil, err
 		}
 
 		name := part.FormName()
@@ -329,65 +542,63 @@ func (mr *MultipartReader) ReadForm() (*MultipartForm, error) {
 		var buff bytes.Buffer
 		filename := part.FileName()
 
+		// 多读一个字节，用于判断是否超过内存
+		limit := fileMemory
+		if filename == "" || limit > valueMemory {
+			limit = valueMemory
+		}
+		n, err := io.CopyN(&buff, part, limit+1)
+		if err != nil && err != io.EOF {
+			return nil, err
+		}
+
 		// case 1: non-file part
 		if filename == "" {
-			n, err = io.CopyN(&buff, part, nonFileMaxMemory+1)
-			if err != nil && err != io.EOF {
-				break
-			}
-
 			// 统计内存
-			nonFileMaxMemory -= n
-			if nonFileMaxMemory < 0 { // sub-case 1: 超过保存非文件最大内存则报错
-				return nil, errors.New("Multipart: message too large.")
+			valueMemory -= n
+			if valueMemory < 0 { // sub-case 1: 超过保存非文件最大内存则报错
+				return nil, ErrMessageTooLarge
 			}
 
 			// sub-case 2: 保存成字符串
-			mf.Value[name] = buff.String()
+			mf.Value.Add(name, buff.String())
 			continue
 		}
 
 		// case 2: file part
-		n, err = io.CopyN(&buff, part, fileMaxMemory+1)
-		if err != nil && err != io.EOF {
-			break
-		}
-
-		fh := FileHeader{
+		fh := &FileHeader{
 			Filename: filename,
 			Header:   part.Header,
 		}
+		// 先加入表单，保证出错时临时文件能被删除
+		mf.File[name] = append(mf.File[name], fh)
 
-		// sub-case 1:超过文件最大内存限制，保存到临时文件
-		if n > fileMaxMemory {
-			var tmpFile *os.File
-			tmpFile, err = os.CreateTemp("", "multipart-")
-			if err != nil { // 创建临时文件失败
-				break
-			}
-
-			// 将拷贝到 buff的数据以及 part剩余的部分写入硬盘中
-			n, err = io.Copy(tmpFile, io.MultiReader(&buff, part))
-			if cerr := tmpFile.Close(); err == nil {
-				err = cerr
-			}
-			if err != nil { // 如果写入 或者 关闭文件出错，则都删除 tmpFile
-				os.Remove(tmpFile.Name())
-				break
-			}
-
-			// 设置超过内存的文件大小，以及临时文件名
-			fh.Size = int(n)
-			fh.tmpFile = tmpFile.Name()
-		} else {
-			// sub-case 2:未超过文件最大内存限制
+		// sub-case 1:未超过剩余内存，保存在内存中
+		if n <= limit {
 			fh.content = buff.Bytes()
 			fh.Size = int(n)
-			fileMaxMemory -= n
+			fileMemory -= n
+			valueMemory -= n
+			continue
 		}
 
-		mf.File[name] = &fh
-	}
+		// sub-case 2:超过剩余内存，保存到临时文件
+		tmpFile, err := os.CreateTemp(mr.TempDir, "multipart-")
+		if err != nil { // 创建临时文件失败
+			return nil, err
+		}
+		fh.tmpFile = tmpFile.Name()
+
+		// 将拷贝到 buff的数据以及 part剩余的部分写入硬盘中
+		n, err = io.Copy(tmpFile, io.MultiReader(&buff, part))
+		if cerr := tmpFile.Close(); err == nil {
+			err = cerr
+		}
+		if err != nil { // 如果写入 或者 关闭文件出错，交给 defer 删除
+			return nil, err
+		}
 
-	return &mf, err
+		// 设置超过内存的文件大小
+		fh.Size = int(n)
+	}
 }
@@ -0,0 +1,241 @@
+package httptoy
+
+import (
+	"errors"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// negotiate.go 负责内容协商，根据 Accept 系列首部从服务端提供的表示中选出最合适的一个 (RFC 7231 5.3)
+// E.g. Accept: text/html, application/json;q=0.9, */*;q=0.1
+//
+//	Accept-Language: zh-CN, zh;q=0.8, en;q=0.5
+//	Accept-Encoding: gzip, identity;q=0
+
+// ErrNotAcceptable 客户端不接受服务端提供的任何一种表示，handler 应该回复 406
+var ErrNotAcceptable = errors.New("httptoy: not acceptable")
+
+// AcceptItem 是 Accept 系列首部中的一项
+type AcceptItem struct {
+	Value  string            // 小写的媒体类型、语言、字符集或者编码，可能为通配符
+	Q      float64           // 权重，范围为 0 到 1，0 表示不接受
+	Params map[string]string // 除 q 之外的参数，只有 Accept 会携带，E.g. text/html;level=1
+}
+
+// specificity 返回 Accept 项的具体程度，越具体的项优先级越高
+// E.g. text/html;level=1 > text/html > text/* > */*
+func (item AcceptItem) specificity() int {
+	switch {
+	case item.Value == "*" || item.Value == "*/*":
+		return 0
+	case strings.HasSuffix(item.Value, "/*"):
+		return 1
+	}
+
+	return 2 + len(item.Params)
+}
+
+// ParseAccept 解析 Accept 系列首部，返回按照权重从高到低排列的列表，
+// 权重相同时更具体的项在前，其余保持首部中的顺序，格式错误的项会被忽略
+func ParseAccept(v string) []AcceptItem {
+	var items []AcceptItem
+	for _, s := range strings.Split(v, ",") {
+		s = strings.TrimSpace(s)
+		if s == "" {
+			continue
+		}
+
+		value, rest, _ := strings.Cut(s, ";")
+		item := AcceptItem{Value: strings.ToLower(strings.TrimSpace(value)), Q: 1}
+		if item.Value == "" {
+			continue
+		}
+
+		ok := true
+		for _, param := range strings.Split(rest, ";") {
+			if strings.TrimSpace(param) == "" {
+				continue
+			}
+			k, pv, _ := strings.Cut(param, "=")
+			k = strings.ToLower(strings.TrimSpace(k))
+			pv = strings.Trim(strings.TrimSpace(pv), `"`)
+
+			// q 之后的参数为 accept-ext，不参与匹配
+			if k == "q" {
+				q, err := strconv.ParseFloat(pv, 64)
+				if err != nil || q < 0 || q > 1 {
+					ok = false
+				}
+				item.Q = q
+				break
+			}
+			if item.Params == nil {
+				item.Params = make(map[string]string)
+			}
+			item.Params[k] = pv
+		}
+
+		if ok {
+			items = append(items, item)
+		}
+	}
+
+	sort.SliceStable(items, func(i, j int) bool {
+		if items[i].Q != items[j].Q {
+			return items[i].Q > items[j].Q
+		}
+		return items[i].specificity() > items[j].specificity()
+	})
+
+	return items
+}
+
+// matchFunc 判断 Accept 项是否匹配服务端提供的 offer，返回匹配的具体程度，不匹配时返回 -1
+type matchFunc func(item AcceptItem, offer string) int
+
+// matchMediaType 匹配媒体类型，Accept 项中的参数必须与 offer 的参数相同
+func matchMediaType(item AcceptItem, offer string) int {
+	typ, params, err := parseMediaType(offer)
+	if err != nil {
+		return -1
+	}
+
+	switch {
+	case item.Value == "*/*":
+	case strings.HasSuffix(item.Value, "/*"):
+		if !strings.HasPrefix(typ, item.Value[:len(item.Value)-1]) {
+			return -1
+		}
+	case item.Value != typ:
+		return -1
+	}
+
+	for k, v := range item.Params {
+		if !strings.EqualFold(params[k], v) {
+			return -1
+		}
+	}
+
+	return item.specificity()
+}
+
+// matchLanguage 匹配语言，前缀匹配以 - 为界，E.g. en 匹配 en-US，但不匹配 eng
+// 越长的语言范围越具体，E.g. en-GB 比 en 具体
+func matchLanguage(item AcceptItem, offer string) int {
+	offer = strings.ToLower(offer)
+
+	switch {
+	case item.Value == "*":
+		return 0
+	case item.Value == offer || strings.HasPrefix(offer, item.Value+"-"):
+		return len(item.Value)
+	}
+
+	return -1
+}
+
+// matchToken 匹配字符集以及编码，不区分大小写
+func matchToken(item AcceptItem, offer string) int {
+	switch {
+	case item.Value == "*":
+		return 0
+	case strings.EqualFold(item.Value, offer):
+		return 1
+	}
+
+	return -1
+}
+
+// negotiate 为每个 offer 找到匹配的最具体的 Accept 项，返回权重最高的 offer，权重相同时按照 offers 的顺序
+// rw 的 Vary 首部会加上 key，因为响应的内容取决于该请求首部
+func negotiate(rw ResponseWriter, req *Request, key string, match matchFunc, offers []string, implicit func(offer string) float64) (string, error) {
+	rw.Header().AddVary(key)
+	if len(offers) == 0 {
+		return "", ErrNotAcceptable
+	}
+
+	values := req.Header.Values(key)
+	// 没有该首部时，客户端接受任何表示
+	if len(values) == 0 {
+		return offers[0], nil
+	}
+	items := ParseAccept(strings.Join(values, ","))
+
+	best, bestQ := "", 0.0
+	for _, offer := range offers {
+		q, spec := 0.0, -1
+		for _, item := range items {
+			if n := match(item, offer); n > spec {
+				q, spec = item.Q, n
+			}
+		}
+		if spec < 0 && implicit != nil {
+			q = implicit(offer)
+		}
+
+		if q > bestQ {
+			best, bestQ = offer, q
+		}
+	}
+
+	if bestQ == 0 {
+		return "", ErrNotAcceptable
+	}
+
+	return best, nil
+}
+
+// Negotiate 根据 Accept 首部从 offers 中选出客户端最希望的媒体类型，
+// 权重相同时优先选择排在前面的 offer，都不接受时返回 ErrNotAcceptable
+// 响应的 Vary 首部会加上 Accept，需要在写入响应之前调用
+// E.g. Negotiate(rw, req, "application/json", "text/html")
+func Negotiate(rw ResponseWriter, req *Request, offers ...string) (string, error) {
+	return negotiate(rw, req, "Accept", matchMediaType, offers, nil)
+}
+
+// NegotiateLanguage 根据 Accept-Language 首部选出语言
+func NegotiateLanguage(rw ResponseWriter, req *Request, offers ...string) (string, error) {
+	return negotiate(rw, req, "Accept-Language", matchLanguage, offers, nil)
+}
+
+// NegotiateCharset 根据 Accept-Charset 首部选出字符集
+func NegotiateCharset(rw ResponseWriter, req *Request, offers ...string) (string, error) {
+	return negotiate(rw, req, "Accept-Charset", matchToken, offers, nil)
+}
+
+// NegotiateEncoding 根据 Accept-Encoding 首部选出内容编码，
+// 除非被 identity;q=0 或者 *;q=0 排除，identity 总是可以接受的
+func NegotiateEncoding(rw ResponseWriter, req *Request, offers ...string) (string, error) {
+	return negotiate(rw, req, "Accept-Encoding", matchToken, offers, func(offer string) float64 {
+		if strings.EqualFold(offer, "identity") {
+			return 0.001
+		}
+		return 0
+	})
+}
+
+// AddVary 将 fields 加入 Vary 首部，已经存在的字段不会重复加入，Vary: * 时不做任何事
+func (h Header) AddVary(fields ...string) {
+	var existing []string
+	for _, v := range h["Vary"] {
+		for _, f := range strings.Split(v, ",") {
+			if f = strings.TrimSpace(f); f != "" {
+				existing = append(existing, f)
+			}
+		}
+	}
+
+	changed := false
+	for _, field := range fields {
+		if hasToken(strings.Join(existing, ","), field) || hasToken(strings.Join(existing, ","), "*") {
+			continue
+		}
+		existing = append(existing, field)
+		changed = true
+	}
+
+	if changed {
+		h.Set("Vary", strings.Join(existing, ", "))
+	}
+}
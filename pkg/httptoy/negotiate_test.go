@@ -0,0 +1,105 @@
+package httptoy
+
+import (
+	"strconv"
+	"strings"
+	"testing"
+)
+
+func TestParseAccept(t *testing.T) {
+	items := ParseAccept(`text/*;q=0.5, text/html;level=1, */*;q=0.1, application/json, text/plain;q=bad, text/html;q=0.5;ext="x"`)
+
+	var got []string
+	for _, item := range items {
+		got = append(got, item.Value+";"+strconv.FormatFloat(item.Q, 'g', -1, 64))
+	}
+	want := "text/html;1,application/json;1,text/html;0.5,text/*;0.5,*/*;0.1"
+	if strings.Join(got, ",") != want {
+		t.Fatalf("got %v, want %v", got, want)
+	}
+	if items[0].Params["level"] != "1" || items[2].Params != nil {
+		t.Errorf("params = %v, %v", items[0].Params, items[2].Params)
+	}
+}
+
+func TestNegotiate(t *testing.T) {
+	tests := []struct {
+		header, value string
+		fn            func(ResponseWriter, *Request, ...string) (string, error)
+		offers        []string
+		want          string
+	}{
+		{"Accept", "", Negotiate, []string{"application/json", "text/html"}, "application/json"},
+		{"Accept", "text/html, application/json;q=0.9", Negotiate, []string{"application/json", "text/html"}, "text/html"},
+		{"Accept", "text/*, application/json", Negotiate, []string{"application/json", "text/plain"}, "application/json"},
+		{"Accept", "*/*;q=0.1, text/plain", Negotiate, []string{"application/json", "text/plain; charset=utf-8"}, "text/plain; charset=utf-8"},
+		{"Accept", "text/html;level=1", Negotiate, []string{"text/html", "text/html;level=1"}, "text/html;level=1"},
+		{"Accept", "application/json;q=0, */*", Negotiate, []string{"application/json"}, ""},
+		{"Accept", "image/png", Negotiate, []string{"application/json", "text/html"}, ""},
+		{"Accept-Language", "zh-CN, en;q=0.8", NegotiateLanguage, []string{"en-US", "zh-cn"}, "zh-cn"},
+		{"Accept-Language", "en;q=0.8, en-GB;q=0.1", NegotiateLanguage, []string{"en-GB", "en-US"}, "en-US"},
+		{"Accept-Language", "eng", NegotiateLanguage, []string{"en"}, ""},
+		{"Accept-Charset", "iso-8859-1, *;q=0.5", NegotiateCharset, []string{"utf-8", "ISO-8859-1"}, "ISO-8859-1"},
+		{"Accept-Encoding", "gzip;q=0.5, br", NegotiateEncoding, []string{"gzip", "br", "identity"}, "br"},
+		{"Accept-Encoding", "deflate", NegotiateEncoding, []string{"gzip", "identity"}, "identity"},
+		{"Accept-Encoding", "gzip, *;q=0", NegotiateEncoding, []string{"identity"}, ""},
+	}
+
+	for _, tt := range tests {
+		req := &Request{Header: make(Header)}
+		if tt.value != "" {
+			req.Header.Set(tt.header, tt.value)
+		}
+		rw := &Response{header: make(Header)}
+
+		got, err := tt.fn(rw, req, tt.offers...)
+		if got != tt.want || (err == ErrNotAcceptable) != (tt.want == "") {
+			t.Errorf("%s: %q %v = %q, %v; want %q", tt.header, tt.value, tt.offers, got, err, tt.want)
+		}
+		if rw.Header().Get("Vary") != tt.header {
+			t.Errorf("%s: Vary = %q", tt.header, rw.Header().Get("Vary"))
+		}
+	}
+}
+
+func TestAddVary(t *testing.T) {
+	h := make(Header)
+	h.Set("Vary", "accept-encoding")
+	h.AddVary("Accept", "Accept-Encoding")
+	h.AddVary("Accept")
+	if got := h.Get("Vary"); got != "accept-encoding, Accept" {
+		t.Fatalf("Vary = %q", got)
+	}
+
+	h.Set("Vary", "*")
+	h.AddVary("Accept")
+	if got := h.Get("Vary"); got != "*" {
+		t.Fatalf("Vary = %q", got)
+	}
+}
+
+func TestNegotiateResponse(t *testing.T) {
+	h := testHandler(func(rw ResponseWriter, req *Request) {
+		typ, err := Negotiate(rw, req, "application/json", "text/plain")
+		if err != nil {
+			rw.WriteHeader(406)
+			return
+		}
+		rw.Header().Set("Content-Type", typ)
+		rw.Header().Add("Set-Cookie", "a=1")
+		rw.Header().Add("Set-Cookie", "b=2")
+		rw.Write([]byte("ok"))
+	})
+
+	resp := serveRaw(t, h, "GET / HTTP/1.1\r\nAccept: text/plain\r\nAccept: application/json;q=0.5\r\nConnection: close\r\n\r\n")
+	for _, want := range []string{"Content-Type: text/plain\r\n", "Vary: Accept\r\n", "Set-Cookie: a=1\r\n", "Set-Cookie: b=2\r\n"} {
+		if !strings.Contains(resp, want) {
+			t.Errorf("response missing %q:\n%s", want, resp)
+		}
+	}
+
+	resp = serveRaw(t, h, "GET / HTTP/1.1\r\nAccept: image/*\r\nConnection: close\r\n\r\n")
+	if !strings.HasPrefix(resp, "HTTP/1.1 406 ") || !strings.Contains(resp, "Vary: Accept\r\n") {
+		t.Errorf("want 406 with Vary, got:\n%s", resp)
+	}
+}
@@ -0,0 +1,190 @@
+package httptoy
+
+import (
+	"bufio"
+	"io"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// echoHandler 回复请求路径，POST 请求则在路径之后附上报文主体
+var echoHandler = testHandler(func(rw ResponseWriter, req *Request) {
+	io.WriteString(rw, req.URL.Path)
+	if req.Method == "POST" {
+		io.Copy(rw, req.Body)
+	}
+})
+
+// pipelineRequests 在一个连接上一次性写入全部请求，然后按顺序读取 n 个响应的报文主体
+func pipelineRequests(t *testing.T, addr, raw string, n int) []string {
+	t.Helper()
+
+	c, err := net.Dial("tcp", addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+	c.SetDeadline(time.Now().Add(5 * time.Second))
+
+	if _, err = io.WriteString(c, raw); err != nil {
+		t.Fatal(err)
+	}
+
+	bufr := bufio.NewReader(c)
+	bodies := make([]string, 0, n)
+	for i := 0; i < n; i++ {
+		resp, err := http.ReadResponse(bufr, nil)
+		if err != nil {
+			t.Fatalf("response %d: %v", i, err)
+		}
+
+		b, err := io.ReadAll(resp.Body)
+		resp.Body.Close()
+		if err != nil {
+			t.Fatalf("response %d body: %v", i, err)
+		}
+		bodies = append(bodies, string(b))
+	}
+
+	return bodies
+}
+
+func get(path string) string {
+	return "GET " + path + " HTTP/1.1\r\nHost: test\r\n\r\n"
+}
+
+func post(path, body string) string {
+	return "POST " + path + " HTTP/1.1\r\nHost: test\r\nContent-Length: " +
+		strconv.Itoa(len(body)) + "\r\n\r\n" + body
+}
+
+func checkBodies(t *testing.T, got, want []string) {
+	t.Helper()
+
+	if len(got) != len(want) {
+		t.Fatalf("got %d responses, want %d", len(got), len(want))
+	}
+	for i := range want {
+		if got[i] != want[i] {
+			t.Errorf("response %d = %q, want %q", i, got[i], want[i])
+		}
+	}
+}
+
+func TestPipelineSerial(t *testing.T) {
+	addr := startTestServer(t, echoHandler)
+
+	raw := get("/a") + post("/b", "hello") + get("/c") + post("/d", "world") + get("/e")
+	got := pipelineRequests(t, addr, raw, 5)
+	checkBodies(t, got, []string{"/a", "/bhello", "/c", "/dworld", "/e"})
+}
+
+func TestPipelineUnreadBody(t *testing.T) {
+	// handler 不读取报文主体，finishRequest 需要在解析下一个请求之前将其丢弃
+	addr := startTestServer(t, testHandler(func(rw ResponseWriter, req *Request) {
+		io.WriteString(rw, req.URL.Path)
+	}))
+
+	raw := post("/a", "unread body") + get("/b") + post("/c", "x") + get("/d")
+	got := pipelineRequests(t, addr, raw, 4)
+	checkBodies(t, got, []string{"/a", "/b", "/c", "/d"})
+}
+
+func TestPipelineConcurrentOrder(t *testing.T) {
+	var running, maxRunning int32
+	var mu sync.Mutex
+	h := testHandler(func(rw ResponseWriter, req *Request) {
+		n := atomic.AddInt32(&running, 1)
+		mu.Lock()
+		if n > maxRunning {
+			maxRunning = n
+		}
+		mu.Unlock()
+
+		// 越早到达的请求执行得越久，检验写回顺序不受完成顺序影响
+		d := time.Duration(10-len(req.URL.Path)) * 5 * time.Millisecond
+		time.Sleep(d)
+		atomic.AddInt32(&running, -1)
+
+		echoHandler(rw, req)
+	})
+
+	addr := startServer(t, &Server{Handler: h, ConcurrentPipeline: true})
+
+	raw := get("/a") + get("/bb") + get("/ccc") + post("/d", "body") + get("/eeee") + get("/fffff") + get("/g")
+	got := pipelineRequests(t, addr, raw, 7)
+	checkBodies(t, got, []string{"/a", "/bb", "/ccc", "/dbody", "/eeee", "/fffff", "/g"})
+
+	if maxRunning < 2 {
+		t.Errorf("handlers never ran concurrently, max running = %d", maxRunning)
+	}
+}
+
+func TestPipelineDepth(t *testing.T) {
+	var running, maxRunning int32
+	var mu sync.Mutex
+	h := testHandler(func(rw ResponseWriter, req *Request) {
+		n := atomic.AddInt32(&running, 1)
+		mu.Lock()
+		if n > maxRunning {
+			maxRunning = n
+		}
+		mu.Unlock()
+
+		time.Sleep(10 * time.Millisecond)
+		atomic.AddInt32(&running, -1)
+		echoHandler(rw, req)
+	})
+
+	addr := startServer(t, &Server{Handler: h, ConcurrentPipeline: true, MaxPipelineDepth: 2})
+
+	var raw strings.Builder
+	var want []string
+	for _, p := range []string{"/1", "/2", "/3", "/4", "/5", "/6", "/7", "/8"} {
+		raw.WriteString(get(p))
+		want = append(want, p)
+	}
+
+	got := pipelineRequests(t, addr, raw.String(), len(want))
+	checkBodies(t, got, want)
+
+	if maxRunning > 2 {
+		t.Errorf("pipeline depth exceeded, max running = %d", maxRunning)
+	}
+}
+
+func TestPipelineConnectionClose(t *testing.T) {
+	addr := startServer(t, &Server{Handler: echoHandler, ConcurrentPipeline: true})
+
+	c, err := net.Dial("tcp", addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+	c.SetDeadline(time.Now().Add(5 * time.Second))
+
+	io.WriteString(c, get("/a")+"GET /b HTTP/1.1\r\nHost: test\r\nConnection: close\r\n\r\n"+get("/c"))
+
+	bufr := bufio.NewReader(c)
+	for _, want := range []string{"/a", "/b"} {
+		resp, err := http.ReadResponse(bufr, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		b, _ := io.ReadAll(resp.Body)
+		if string(b) != want {
+			t.Fatalf("got %q, want %q", b, want)
+		}
+	}
+
+	// Connection: close 之后的请求不再处理，连接被关闭
+	if _, err = http.ReadResponse(bufr, nil); err != io.EOF && err != io.ErrUnexpectedEOF {
+		t.Fatalf("expected connection to be closed, got %v", err)
+	}
+}
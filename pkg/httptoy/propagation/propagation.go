@@ -0,0 +1,59 @@
+// Package propagation 将请求 context 中的值传播给下游服务
+//
+// 需要跨服务传播的中间件 E.g. tracing、requestid 提供 Injector，将 context 中的值写入首部；
+// httptoy 没有 HTTP 客户端，handler 请求下游服务时通过 Transport 包装 net/http 的 RoundTripper
+//
+// E.g.
+//
+//	client := &http.Client{Transport: propagation.Transport(nil,
+//		propagation.InjectorFunc(tracing.Inject),
+//		propagation.InjectorFunc(requestid.Inject),
+//	)}
+//	outReq, _ := http.NewRequestWithContext(req.Context(), "GET", "http://backend/api", nil)
+//	client.Do(outReq)
+package propagation
+
+import (
+	"context"
+	"net/http"
+
+	"build-HTTP-from-scracth/pkg/httptoy"
+)
+
+// Injector 将 ctx 中需要传播的值写入发送给下游的请求首部，ctx 中没有时不做任何事
+type Injector interface {
+	Inject(ctx context.Context, h httptoy.Header)
+}
+
+// InjectorFunc 使普通函数能够作为 Injector
+type InjectorFunc func(ctx context.Context, h httptoy.Header)
+
+func (f InjectorFunc) Inject(ctx context.Context, h httptoy.Header) {
+	f(ctx, h)
+}
+
+// Transport 返回 net/http 的 RoundTripper，发送请求之前依次调用 injectors 写入首部，
+// base 为 nil 时使用 http.DefaultTransport
+// 请求的 context 需要派生自 handler 的 req.Context()
+func Transport(base http.RoundTripper, injectors ...Injector) http.RoundTripper {
+	if base == nil {
+		base = http.DefaultTransport
+	}
+
+	return &transport{base: base, injectors: injectors}
+}
+
+type transport struct {
+	base      http.RoundTripper
+	injectors []Injector
+}
+
+func (tr *transport) RoundTrip(req *http.Request) (*http.Response, error) {
+	// RoundTripper 不能修改传入的请求
+	req = req.Clone(req.Context())
+	for _, inj := range tr.injectors {
+		inj.Inject(req.Context(), httptoy.Header(req.Header))
+	}
+
+	return tr.base.RoundTrip(req)
+}
@@ -0,0 +1,46 @@
+package propagation
+
+import (
+	"context"
+	"net/http"
+	"testing"
+
+	"build-HTTP-from-scracth/pkg/httptoy"
+	"build-HTTP-from-scracth/pkg/httptoy/httptoytest"
+)
+
+type ctxKey struct{}
+
+func TestTransport(t *testing.T) {
+	var got http.Header
+	ts := httptoytest.NewServer(httptoy.HandlerFunc(func(rw httptoy.ResponseWriter, req *httptoy.Request) {
+		got = http.Header(req.Header)
+	}))
+	defer ts.Close()
+
+	fromCtx := InjectorFunc(func(ctx context.Context, h httptoy.Header) {
+		if v, _ := ctx.Value(ctxKey{}).(string); v != "" {
+			h.Set("X-From-Context", v)
+		}
+	})
+	static := InjectorFunc(func(ctx context.Context, h httptoy.Header) {
+		h.Set("X-Static", "1")
+	})
+	client := &http.Client{Transport: Transport(ts.Client().Transport, fromCtx, static)}
+
+	ctx := context.WithValue(context.Background(), ctxKey{}, "abc")
+	req, _ := http.NewRequestWithContext(ctx, "GET", ts.URL, nil)
+	resp, err := client.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+
+	if got.Get("X-From-Context") != "abc" || got.Get("X-Static") != "1" {
+		t.Errorf("downstream header = %v", got)
+	}
+	// 传入的请求不会被修改
+	if len(req.Header) != 0 {
+		t.Errorf("request header modified: %v", req.Header)
+	}
+}
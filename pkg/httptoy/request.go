@@ -3,10 +3,13 @@ package httptoy
 import (
 	"bufio"
 	"bytes"
+	"context"
 	"errors"
 	"fmt"
 	"io"
 	"io/ioutil"
+	"net/http"
+	"net/textproto"
 	"net/url"
 	"strconv"
 	"strings"
@@ -34,9 +37,11 @@ import (
  */
 type Request struct {
 	// 请求行
-	Method    string // 请求方法，如 GET, POST, PUT等
-	RemoteURI string // 客户端字符串形式 url
-	Proto     string // 协议以及版本
+	Method     string // 请求方法，如 GET, POST, PUT等
+	RemoteURI  string // 客户端字符串形式 url
+	Proto      string // 协议以及版本
+	ProtoMajor int    // 协议主版本号，E.g. HTTP/1.0 中的 1
+	ProtoMinor int    // 协议次版本号，E.g. HTTP/1.0 中的 0
 
 	// 首部字段
 	Header      Header // 首部字段
@@ -48,15 +53,23 @@ type Request struct {
 	conn        *conn             // 请求连接对象
 	RemoteAddr  string            // 客户端地址
 	cookies     map[string]string // 客户端cookies
-	queryString map[string]string // 请求的url 询问键值对
+	queryString Values            // 请求的url 询问键值对
 	Body        io.Reader         // 用于读取报文的io
+	Trailer     Header            // chunk 编码的报文主体读取完毕之后的 trailer 首部
+	Pattern     string            // ServeMux 匹配到的路由，没有匹配时为空
+
+	ctx    context.Context    // 请求的 context，handler 返回之后被取消
+	cancel context.CancelFunc // 取消 ctx，由 conn.serve 调用
 
 	// 特殊表单处理
-	// 需要 ParseForm 调用之后才能直接调用 PostForm 以及 MultipartForm
-	PostForm      map[string]string
+	// 需要 ParseForm 调用之后才能直接调用 Form, PostForm 以及 MultipartForm
+	Form          Values // 报文主体的表单以及 queryString 合并后的键值对，表单的值在前
+	PostForm      Values // 报文主体的表单
 	MultipartForm *MultipartForm
-	hadParsedForm bool
-	parseFromErr  error
+	// MultipartOptions 由 handler 在解析表单之前设置，限制 multipart 表单的大小以及临时文件的位置
+	MultipartOptions *MultipartOptions
+	hadParsedForm    bool
+	parseFromErr     error
 }
 
 // readLine 包装 bufr.ReadLine(), 保证请求行完整，直到 \r\n
@@ -81,31 +94,58 @@ func readLine(bufr *bufio.Reader) ([]byte, error) {
 }
 
 // 解析 请求报文的 function:
-// parseQuery 包装
-func parseQuery(RawQuery string) map[string]string {
-	// name=jack&age=12
-	parts := strings.Split(RawQuery, "&")
-	queries := make(map[string]string, len(parts))
+// parseQuery 包装 ParseQuery，忽略解码失败的键值对
+func parseQuery(rawQuery string) Values {
+	v, _ := ParseQuery(rawQuery)
+	return v
+}
 
-	for _, part := range parts {
-		p := strings.IndexByte(part, '=')
+// parseHTTPVersion 解析请求行中的协议版本，只支持 HTTP/1.x
+// E.g. HTTP/1.1 -> 1, 1
+func parseHTTPVersion(proto string) (major, minor int, err error) {
+	switch proto {
+	case "HTTP/1.1":
+		return 1, 1, nil
+	case "HTTP/1.0":
+		return 1, 0, nil
+	}
 
-		// 防止 query 格式错误， 以及 空值传入
-		if p < 0 || p == len(part)-1 {
-			continue
-		}
+	if !strings.HasPrefix(proto, "HTTP/") {
+		return 0, 0, fmt.Errorf("malformed HTTP version %q", proto)
+	}
 
-		// 去除 k-v 对的空格
-		queries[strings.TrimSpace(part[:p])] = strings.TrimSpace(part[p+1:])
+	var extra string
+	n, _ := fmt.Sscanf(proto, "HTTP/%d.%d%s", &major, &minor, &extra)
+	if n != 2 || major < 0 || minor < 0 {
+		return 0, 0, fmt.Errorf("malformed HTTP version %q", proto)
+	}
+	if major != 1 {
+		return 0, 0, &badRequestError{http.StatusHTTPVersionNotSupported, fmt.Sprintf("unsupported HTTP version %q", proto)}
 	}
 
-	return queries
+	return major, minor, nil
 }
 
-// readHeader 用来解析 header 首部字段
+// badRequestError 表示请求报文不符合协议，conn.serve 以 code 回复之后关闭连接
+type badRequestError struct {
+	code int // E.g. 400 Bad Request、501 Not Implemented
+	msg  string
+}
+
+func (e *badRequestError) Error() string {
+	return e.msg
+}
+
+func badRequest(msg string) error {
+	return &badRequestError{http.StatusBadRequest, msg}
+}
+
+// readHeader 用来解析 header 首部字段，首部名转为规范格式
 // E.g. Content-Length: 13
+// 以空格或者制表符开头的行为上一个首部值的延续(obs-fold)，按照 RFC 7230 3.2.4 替换为一个空格
 func readHeader(bufr *bufio.Reader) (Header, error) {
 	header := make(Header)
+	lastKey := "" // 上一个首部名，用于拼接 obs-fold
 
 	// E.g. Content-Type: text/plain\r\n
 	for {
@@ -120,18 +160,34 @@ func readHeader(bufr *bufio.Reader) (Header, error) {
 			break
 		}
 
+		// obs-fold 拼接到上一个首部的最后一个值
+		if line[0] == ' ' || line[0] == '\t' {
+			if lastKey == "" {
+				return nil, badRequest("unexpected continuation line")
+			}
+			vs := header[lastKey]
+			if v := strings.TrimSpace(string(line)); v != "" {
+				vs[len(vs)-1] = strings.TrimSpace(vs[len(vs)-1] + " " + v)
+			}
+			continue
+		}
+
 		p := bytes.IndexByte(line, ':')
-		// 如果没找打':', 首部字段读取失败
-		if p < 0 {
-			return nil, errors.New("Unsupport protocol")
+		// 如果没找打':'，或者首部名为空，首部字段读取失败
+		if p <= 0 {
+			return nil, badRequest("malformed header line")
 		}
-		// 如果 ':' 为最后一位, 则为空值, 跳过
-		if p == len(line)-1 {
-			continue
+		// 首部名必须是 token，首部名与 ':' 之间不能有空格
+		key := string(line[:p])
+		for i := 0; i < len(key); i++ {
+			if !isTokenChar(key[i]) {
+				return nil, badRequest(fmt.Sprintf("invalid header name %q", key))
+			}
 		}
 
-		// 添加首部键值对
-		header.Add(string(line[:p]), strings.TrimSpace(string(line[p+1:])))
+		// 添加首部键值对，值可以为空
+		lastKey = textproto.CanonicalMIMEHeaderKey(key)
+		header[lastKey] = append(header[lastKey], strings.TrimSpace(string(line[p+1:])))
 	}
 
 	return header, nil
@@ -147,8 +203,8 @@ func (r *Request) parseCookies() {
 
 	r.cookies = make(map[string]string)
 
-	rawCookies, ok := r.Header["Cookie"]
-	if !ok {
+	rawCookies := r.Header.Values("Cookie")
+	if len(rawCookies) == 0 {
 		return
 	}
 
@@ -178,42 +234,45 @@ func (r *Request) parseCookies() {
 // 特殊表单的解析处理 parsePostForm, parseMultipartForm
 // parseContentType 主要解析 content-type, 根据情况 解析 boudanry
 // Content-Type: multipart/form-data; boundary=------974767299852498929531610575
-// Content-Type: multipart/form-data; boundary=""------974767299852498929531610575"
+// Content-Type: multipart/form-data; boundary="------974767299852498929531610575"
 // Content-Type: application/x-www-form-urlencoded
+// 解析表单之前会再次调用，handler 或者测试可以在此之前修改 Content-Type
 func (r *Request) parseContentType() {
-	// 1.解析 content-type
+	r.contentType, r.boundary = "", ""
+
 	ct := r.Header.Get("Content-Type")
-	pivot := strings.IndexByte(ct, ';')
-	// 如果没找到 ; 说明content-type 不是 from-data, 直接保存
-	if pivot < 0 {
-		r.contentType = ct
-		return
-	}
-	// pivot 在最后一位，可能解析失败，直接退出
-	if pivot == len(ct)-1 {
+	if ct == "" {
 		return
 	}
-	r.contentType = ct[:pivot]
 
-	// 2.解析 boudnary
-	sStr := strings.Split(ct[pivot+1:], "=")
-	// exit cond: sStr 长度小于2 或者 第一个子串 不是 “boundary”
-	if len(sStr) < 2 || strings.TrimSpace(sStr[0]) != "boundary" {
-		return
-	}
-	r.boundary = strings.Trim(sStr[1], `"`)
+	// 参数格式错误时依旧保留类型，此时没有 boundary
+	mediatype, params, _ := parseMediaType(ct)
+	r.contentType = mediatype
+	r.boundary = params["boundary"]
 }
 
-// MultipartReader 用于读取 multipart表单
+// MultipartReader 用于读取 multipart表单，也可以读取 multipart/mixed、multipart/related 等报文
 func (r *Request) MultipartReader() (*MultipartReader, error) {
+	r.parseContentType()
+	if !strings.HasPrefix(r.contentType, "multipart/") {
+		return nil, errors.New("request Content-Type isn't multipart")
+	}
 	if len(r.boundary) < 1 {
 		return nil, errors.New("no boundary detected")
 	}
 
-	return NewMultipartReader(r.Body, r.boundary), nil
+	mr := NewMultipartReader(r.Body, r.boundary)
+	if r.MultipartOptions != nil {
+		mr.MultipartOptions = *r.MultipartOptions
+	}
+
+	return mr, nil
 }
 
-// parse-form 1:parsePostForm Post表单的数据类似 queryString ，直接用 parseQuery 解析
+// defaultMaxMemory 是 ParseForm 解析 multipart 表单时使用的内存上限，超过的文件保存到临时文件
+const defaultMaxMemory = 32 << 20
+
+// parse-form 1:parsePostForm Post表单的数据类似 queryString ，直接用 ParseQuery 解析
 // E.g. name=jack&age=22
 func (r *Request) parsePostForm() error {
 	bb, err := ioutil.ReadAll(r.Body)
@@ -221,8 +280,8 @@ func (r *Request) parsePostForm() error {
 		return err
 	}
 
-	r.PostForm = parseQuery(string(bb))
-	return nil
+	r.PostForm, err = ParseQuery(string(bb))
+	return err
 }
 
 // parse-form 2:parseMultipartForm multipart表单 创建文件流对象保存数据，让handler调用
@@ -232,28 +291,56 @@ func (r *Request) parseMultipartForm() error {
 		return err
 	}
 
-	r.MultipartForm, err = mr.ReadForm()
+	r.MultipartForm, err = mr.ReadForm(defaultMaxMemory)
+	if err != nil {
+		return err
+	}
+
 	r.PostForm = r.MultipartForm.Value // postForm也可以通过multipart表单文本数据解析
 	return nil
 }
 
-// ParseForm ...
+// errUnsupportedForm 报文主体不是表单
+var errUnsupportedForm = errors.New("unsupport form type")
+
+// ParseForm 解析 queryString 以及报文主体的表单，合并到 r.Form 中
+// 只有 POST 以及 PUT 请求会解析报文主体，其余请求的 r.Form 只包含 queryString
+// 重复调用直接返回第一次解析的结果，报文主体已经读完，再次解析只会得到空的表单
 func (r *Request) ParseForm() error {
-	if r.Method != "POST" && r.Method != "PUT" { // 排除掉没有body的表单解析
-		return errors.New("Missing form body.")
+	if r.hadParsedForm {
+		return r.parseFromErr
 	}
-
 	r.hadParsedForm = true
 
-	// 根据 contenType 进行解析表单
-	switch r.contentType {
-	case "application/x-www-form-urlencoded":
-		return r.parsePostForm()
-	case "multipart/form-data":
-		return r.parseMultipartForm()
+	var err error
+	if r.Method == "POST" || r.Method == "PUT" { // 排除掉没有body的表单解析
+		// 根据 contenType 进行解析表单
+		r.parseContentType()
+		switch r.contentType {
+		case "application/x-www-form-urlencoded":
+			err = r.parsePostForm()
+		case "multipart/form-data":
+			err = r.parseMultipartForm()
+		default:
+			err = errUnsupportedForm
+		}
+	}
+
+	if r.PostForm == nil {
+		r.PostForm = make(Values)
 	}
 
-	return errors.New("unsupport form type")
+	// 表单的值在前，queryString 的值在后
+	r.Form = make(Values, len(r.PostForm)+len(r.queryString))
+	for k, vs := range r.PostForm {
+		r.Form[k] = append(r.Form[k], vs...)
+	}
+	for k, vs := range r.queryString {
+		r.Form[k] = append(r.Form[k], vs...)
+	}
+
+	r.parseFromErr = err
+	return err
 }
 
 // eofReader 用来读取报文主体的
@@ -284,9 +371,22 @@ func (ecr *expectContinueReader) Read(p []byte) (int, error) {
 	return ecr.r.Read(p)
 }
 
+// discardBody 丢弃 Body 中 handler 没有读完的数据，返回连接能否继续读取下一个请求
+func (r *Request) discardBody() bool {
+	// handler 没有读取过 Body，客户端还在等待 100 continue，此时不应该再发送 100 continue
+	if ecr, ok := r.Body.(*expectContinueReader); ok && !ecr.wroteContinue {
+		return false
+	}
+
+	// 剩余数据超过 maxDrainBody 时，与其读完不如直接关闭连接
+	_, err := io.CopyN(ioutil.Discard, r.Body, maxDrainBody+1)
+	return err == io.EOF
+}
+
 // fixExpectContinueReader 包装 r.Body，包装成发送 100 continue的特殊流
+// HTTP/1.0 的客户端不认识 100 Continue，不需要发送
 func (r *Request) fixExpectContinueReader() {
-	if r.Header.Get("Expect") != "100-continue" {
+	if !strings.EqualFold(r.Header.Get("Expect"), "100-continue") || !r.ProtoAtLeast(1, 1) {
 		return
 	}
 
@@ -297,60 +397,96 @@ func (r *Request) fixExpectContinueReader() {
 	}
 }
 
-// setupBody 为连接提供读取流对象，只有put跟post请求能够创建相应的读取流
+// setupBody 根据 Transfer-Encoding 以及 Content-Length 为连接提供读取流对象 (RFC 7230 3.3.3)
+// 任何方法的请求都可以携带报文主体，忽略报文主体会导致其被当成下一个请求解析
 // chunkReader 以及 LimitReader 根据客户端情况进行创建，以及请求报文的预处理 100 continue
-func (r *Request) setupBody() {
-	// 按照http协议，除了POST和PUT以外的方法不允许设置报文主体
-	switch r.Method {
-	case "POST":
-		fallthrough
-	case "PUT":
-		if cl := r.Header.Get("Content-Length"); cl != "" {
-			contentLength, err := strconv.ParseInt(cl, 10, 64)
-			if err != nil {
-				break
-			}
+func (r *Request) setupBody() error {
+	// chunk 编码读取，Transfer-Encoding 优先于 Content-Length
+	if te := r.Header.Values("Transfer-Encoding"); len(te) > 0 {
+		codings := strings.Split(strings.Join(te, ","), ",")
+		if !strings.EqualFold(strings.TrimSpace(codings[len(codings)-1]), "chunked") {
+			return badRequest("final transfer coding is not chunked")
+		}
+		if len(codings) > 1 {
+			return &badRequestError{http.StatusNotImplemented, "unsupported transfer coding"}
+		}
 
-			// 根据客户端查询方式，进行包装读取流，提前进行发送 100 continue
-			defer r.fixExpectContinueReader()
+		r.Header.Del("Content-Length")
+		r.Body = &chunkReader{bufr: r.conn.bufr, trailer: &r.Trailer}
+		r.fixExpectContinueReader()
+		return nil
+	}
 
-			// chunk 编码读取
-			if r.Header.Get("Transfer-Encoding") == "chunked" {
-				r.Body = &chunkReader{bufr: r.conn.bufr}
-				return
+	if cls := r.Header.Values("Content-Length"); len(cls) > 0 {
+		// 多个 Content-Length 必须相同，并且只能是十进制数字
+		for _, cl := range cls[1:] {
+			if cl != cls[0] {
+				return badRequest("conflicting Content-Length")
 			}
+		}
+		contentLength, err := strconv.ParseUint(cls[0], 10, 63)
+		if err != nil || cls[0][0] == '+' {
+			return badRequest(fmt.Sprintf("invalid Content-Length %q", cls[0]))
+		}
 
-			// 普通限制
-			// 限制Body 读取至多长度contentLength的数据
-			r.Body = io.LimitReader(r.conn.bufr, contentLength)
-			return
+		// 普通限制
+		// 限制Body 读取至多长度contentLength的数据
+		if contentLength > 0 {
+			r.Body = io.LimitReader(r.conn.bufr, int64(contentLength))
+			// 根据客户端查询方式，进行包装读取流，提前进行发送 100 continue
+			r.fixExpectContinueReader()
+			return nil
 		}
 	}
 
 	// 其余情况，直接创建eof终止对象
 	r.Body = new(eofReader)
+	return nil
 }
 
 // readRequest 创建并返回request，解析基本的 request 的信息
-func readRequest(c *conn) (*Request, error) {
+func readRequest(c *conn) (_ *Request, err error) {
 	r := Request{conn: c, RemoteAddr: c.rwc.RemoteAddr().String()}
 
 	// 1.读取请求行
-	line, err := readLine(c.bufr)
-	if err != nil {
-		return nil, err
+	// 请求行之前的空行需要忽略 (RFC 7230 3.5)
+	var line []byte
+	for len(line) == 0 {
+		if line, err = readLine(c.bufr); err != nil {
+			return nil, err
+		}
+	}
+
+	// 解析请求行，三部分由单个空格分隔 E.g. GET /index HTTP/1.1
+	parts := strings.Split(string(line), " ")
+	if len(parts) != 3 || parts[0] == "" || parts[1] == "" {
+		return nil, badRequest(fmt.Sprintf("malformed request line %q", line))
+	}
+	r.Method, r.RemoteURI, r.Proto = parts[0], parts[1], parts[2]
+	for i := 0; i < len(r.Method); i++ {
+		if !isTokenChar(r.Method[i]) {
+			return nil, badRequest(fmt.Sprintf("invalid method %q", r.Method))
+		}
 	}
 
-	// 解析请求行
-	_, err = fmt.Sscanf(string(line), "%s%s%s", &r.Method, &r.RemoteURI, &r.Proto)
+	r.ProtoMajor, r.ProtoMinor, err = parseHTTPVersion(r.Proto)
 	if err != nil {
+		if _, ok := err.(*badRequestError); !ok {
+			err = badRequest(err.Error())
+		}
 		return nil, err
 	}
 
 	// 2.URL转变形式
-	r.URL, err = url.ParseRequestURI(r.RemoteURI)
-	if err != nil {
-		return nil, err
+	// asterisk-form 只用于 OPTIONS，E.g. OPTIONS * HTTP/1.1
+	// absolute-form 常见于代理请求，E.g. GET http://example.com/index HTTP/1.1
+	if r.RemoteURI == "*" {
+		if r.Method != "OPTIONS" {
+			return nil, badRequest("asterisk-form is only allowed for OPTIONS")
+		}
+		r.URL = &url.URL{Path: "*"}
+	} else if r.URL, err = url.ParseRequestURI(r.RemoteURI); err != nil {
+		return nil, badRequest(err.Error())
 	}
 
 	// 3.解析queryString
@@ -359,23 +495,146 @@ func readRequest(c *conn) (*Request, error) {
 	// 4.解析首部字段
 	r.Header, err = readHeader(c.bufr)
 	if err != nil {
+		if err == io.EOF {
+			err = io.ErrUnexpectedEOF
+		}
 		return nil, err
 	}
+	if len(r.Header.Values("Host")) > 1 {
+		return nil, badRequest("too many Host headers")
+	}
+	// absolute-form 中的 host 优先于 Host 首部 (RFC 7230 5.4)
+	if r.URL.Host != "" {
+		r.Header.Set("Host", r.URL.Host)
+	}
+	if expect := r.Header.Get("Expect"); expect != "" && !strings.EqualFold(expect, "100-continue") {
+		return nil, &badRequestError{http.StatusExpectationFailed, fmt.Sprintf("unsupported Expect %q", expect)}
+	}
 
 	// 5.根据 Content-Type字段进行报文解析
 	r.parseContentType()
 
 	// 6.设置 body 读取流
 	r.conn.lr.N = (1<<63 - 1) // 设置body读取无需限制
-	r.setupBody()
+	if err = r.setupBody(); err != nil {
+		return nil, err
+	}
+
+	// 7.请求的 context 派生自连接的 context
+	parent := c.ctx
+	if parent == nil {
+		parent = context.Background()
+	}
+	r.ctx, r.cancel = context.WithCancel(parent)
 
 	return &r, nil
 }
 
+// Context 返回请求的 context，服务端读到的请求在 handler 返回之后被取消
+// 其中可以获取 Server.ConnContext 添加的值，以及 ServerContextKey 对应的 *Server
+func (r *Request) Context() context.Context {
+	if r.ctx != nil {
+		return r.ctx
+	}
+	return context.Background()
+}
+
+// WithContext 返回 context 为 ctx 的 r 的浅拷贝，ctx 不能为 nil
+// E.g. 中间件向请求添加值之后传给下一个 handler
+func (r *Request) WithContext(ctx context.Context) *Request {
+	if ctx == nil {
+		panic("httptoy: nil context")
+	}
+
+	r2 := new(Request)
+	*r2 = *r
+	r2.ctx = ctx
+	return r2
+}
+
+// NewRequest 创建不依赖连接的请求，用于直接调用 Handler 进行测试
+// target 可以是路径 E.g. /index?name=gu，也可以是绝对 URL，此时 Host 首部取自 URL
+// body 为 *bytes.Buffer、*bytes.Reader 或者 *strings.Reader 时会设置 Content-Length
+func NewRequest(method, target string, body io.Reader) (*Request, error) {
+	if method == "" {
+		method = "GET"
+	}
+
+	u, err := url.Parse(target)
+	if err != nil {
+		return nil, err
+	}
+	if u.Path == "" && u.Opaque == "" {
+		u.Path = "/"
+	}
+
+	r := &Request{
+		Method:      method,
+		RemoteURI:   u.RequestURI(),
+		Proto:       "HTTP/1.1",
+		ProtoMajor:  1,
+		ProtoMinor:  1,
+		Header:      make(Header),
+		URL:         u,
+		queryString: parseQuery(u.RawQuery),
+		Body:        body,
+	}
+	if u.Host != "" {
+		r.Header.Set("Host", u.Host)
+	}
+
+	switch b := body.(type) {
+	case nil:
+		r.Body = new(eofReader)
+	case *bytes.Buffer:
+		r.Header.Set("Content-Length", strconv.Itoa(b.Len()))
+	case *bytes.Reader:
+		r.Header.Set("Content-Length", strconv.Itoa(b.Len()))
+	case *strings.Reader:
+		r.Header.Set("Content-Length", strconv.Itoa(b.Len()))
+	}
+
+	return r, nil
+}
+
+// ProtoAtLeast 判断请求的协议版本是否不低于 major.minor
+func (r *Request) ProtoAtLeast(major, minor int) bool {
+	return r.ProtoMajor > major || r.ProtoMajor == major && r.ProtoMinor >= minor
+}
+
+// hasToken 判断以逗号分隔的首部值中是否包含 token，忽略大小写
+// E.g. Connection: keep-alive, Upgrade
+func hasToken(v, token string) bool {
+	for _, t := range strings.Split(v, ",") {
+		if strings.EqualFold(strings.TrimSpace(t), token) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// wantsKeepAlive 判断客户端是否希望复用连接
+// HTTP/1.1 默认复用，除非设置了 Connection: close
+// HTTP/1.0 默认不复用，除非设置了 Connection: keep-alive
+func (r *Request) wantsKeepAlive() bool {
+	conn := r.Header.Get("Connection")
+	if r.ProtoAtLeast(1, 1) {
+		return !hasToken(conn, "close")
+	}
+
+	return r.ProtoAtLeast(1, 0) && hasToken(conn, "keep-alive")
+}
+
 // 查询 Request function:
-// Query 用来查询 请求的 queryString
+// Query 用来查询 请求的 queryString，同名参数返回第一个值
 func (r *Request) Query(key string) string {
-	return r.queryString[key]
+	return r.queryString.Get(key)
+}
+
+// QueryValues 返回解码后的全部 queryString
+func (r *Request) QueryValues() Values {
+	return r.queryString
 }
 
 // Cookie 用于查询 请求的 Cookies
@@ -388,6 +647,15 @@ func (r *Request) Cookie(key string) string {
 	return r.cookies[key]
 }
 
+// FormValue 查询表单以及 queryString，同名参数返回第一个值，表单的值优先
+func (r *Request) FormValue(key string) string {
+	if !r.hadParsedForm { // lazy-parse
+		r.parseFromErr = r.ParseForm()
+	}
+
+	return r.Form.Get(key)
+}
+
 // PostFormValue 用来做单次查询
 func (r *Request) PostFormValue(key string) string {
 	if !r.hadParsedForm { // lazy-parse
@@ -399,10 +667,10 @@ func (r *Request) PostFormValue(key string) string {
 		return ""
 	}
 
-	return r.PostForm[key]
+	return r.PostForm.Get(key)
 }
 
-// FormFile 用来查询某个名字的文件
+// FormFile 用来查询某个名字的文件，同名的多个文件返回第一个
 func (r *Request) FormFile(filename string) (*FileHeader, error) {
 	if !r.hadParsedForm { // lazy-parse
 		r.parseFromErr = r.ParseForm()
@@ -412,10 +680,10 @@ func (r *Request) FormFile(filename string) (*FileHeader, error) {
 		return nil, r.parseFromErr
 	}
 
-	fh, ok := r.MultipartForm.File[filename]
-	if !ok { // 如果文件保存失败
+	fhs := r.MultipartForm.File[filename]
+	if len(fhs) == 0 { // 如果文件保存失败
 		return nil, errors.New("http: missing multipart file")
 	}
 
-	return fh, nil
+	return fhs[0], nil
 }
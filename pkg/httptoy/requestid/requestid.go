@@ -0,0 +1,152 @@
+// Package requestid 为每个请求分配请求 ID，用于关联多个服务的日志
+//
+// 请求带有合法的 X-Request-Id 时沿用上游的 ID，否则生成新的 ID；
+// ID 以及传递它的首部保存在请求的 context 中，并且在 handler 执行之前写入响应首部
+//
+// E.g.
+//
+//	handler := accesslog.New(requestid.New(mux, requestid.Options{}), accesslog.Options{Format: accesslog.Combined})
+//
+//	// handler 中请求下游服务时以同一个首部传播请求 ID
+//	client := &http.Client{Transport: propagation.Transport(nil, propagation.InjectorFunc(requestid.Inject))}
+//	outReq, _ := http.NewRequestWithContext(req.Context(), "GET", "http://backend/api", nil)
+//	client.Do(outReq)
+package requestid
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+
+	"build-HTTP-from-scracth/pkg/httptoy"
+)
+
+// Header 是传递请求 ID 的首部
+const Header = "X-Request-Id"
+
+// MaxLength 是接受的上游请求 ID 的最大长度
+const MaxLength = 128
+
+// Options 是请求 ID 中间件的配置
+type Options struct {
+	// Header 是读取以及回写请求 ID 的首部，为空时为 X-Request-Id
+	Header string
+
+	// Generate 生成新的请求 ID，为空时为 NewID
+	Generate func() string
+
+	// IgnoreIncoming 为 true 时不信任上游的请求 ID，总是生成新的 ID，E.g. 直接面向公网的服务
+	IgnoreIncoming bool
+}
+
+// New 返回分配请求 ID 的 handler
+// ID 在 next 执行之前写入响应首部，chunkWriter 提交首部时一定会带上
+func New(next httptoy.Handler, opts Options) httptoy.Handler {
+	if opts.Header == "" {
+		opts.Header = Header
+	}
+	if opts.Generate == nil {
+		opts.Generate = NewID
+	}
+
+	return httptoy.HandlerFunc(func(rw httptoy.ResponseWriter, req *httptoy.Request) {
+		id := req.Header.Get(opts.Header)
+		if opts.IgnoreIncoming || !Valid(id) {
+			id = opts.Generate()
+		}
+
+		rw.Header().Set(opts.Header, id)
+		next.ServeHTTP(rw, req.WithContext(newContext(req.Context(), id, opts.Header)))
+	})
+}
+
+// Valid 判断上游的请求 ID 是否可以沿用
+// 长度为 1 到 MaxLength，只能包含字母、数字以及 - _ . : + / = @，防止 ID 被用来伪造日志
+func Valid(id string) bool {
+	if id == "" || len(id) > MaxLength {
+		return false
+	}
+
+	for i := 0; i < len(id); i++ {
+		c := id[i]
+		switch {
+		case 'a' <= c && c <= 'z', 'A' <= c && c <= 'Z', '0' <= c && c <= '9':
+		case c == '-', c == '_', c == '.', c == ':', c == '+', c == '/', c == '=', c == '@':
+		default:
+			return false
+		}
+	}
+	return true
+}
+
+// NewID 生成随机的请求 ID，格式为 UUID 版本 4
+// E.g. 3f2a5c1e-8b7d-4e6f-9a0b-1c2d3e4f5a6b
+func NewID() string {
+	var b [16]byte
+	rand.Read(b[:])
+	b[6] = b[6]&0x0f | 0x40 // 版本 4
+	b[8] = b[8]&0x3f | 0x80 // RFC 4122 变体
+
+	var buf [36]byte
+	hex.Encode(buf[0:8], b[0:4])
+	buf[8] = '-'
+	hex.Encode(buf[9:13], b[4:6])
+	buf[13] = '-'
+	hex.Encode(buf[14:18], b[6:8])
+	buf[18] = '-'
+	hex.Encode(buf[19:23], b[8:10])
+	buf[23] = '-'
+	hex.Encode(buf[24:], b[10:])
+
+	return string(buf[:])
+}
+
+type ctxKey struct{}
+
+// idValue 是保存在 context 中的请求 ID 以及传递它的首部
+// Capture 预先放入空的 idValue，New 分配 ID 时直接填充，外层的中间件在 next 返回之后也能读取
+type idValue struct {
+	id     string
+	header string
+}
+
+// NewContext 返回带有请求 ID 的 ctx，传递 ID 的首部为 X-Request-Id
+func NewContext(ctx context.Context, id string) context.Context {
+	return newContext(ctx, id, Header)
+}
+
+func newContext(ctx context.Context, id, header string) context.Context {
+	if v, ok := ctx.Value(ctxKey{}).(*idValue); ok && v.id == "" {
+		v.id, v.header = id, header
+		return ctx
+	}
+
+	return context.WithValue(ctx, ctxKey{}, &idValue{id: id, header: header})
+}
+
+// Capture 返回带有空的请求 ID 的 ctx，内层的 New 分配的 ID 之后可以通过 FromContext(ctx) 读取，
+// 供包装在 New 之外、需要在 next 返回之后读取 ID 的中间件使用 E.g. 访问日志
+// ctx 中已经有请求 ID 时原样返回
+func Capture(ctx context.Context) context.Context {
+	if _, ok := ctx.Value(ctxKey{}).(*idValue); ok {
+		return ctx
+	}
+
+	return context.WithValue(ctx, ctxKey{}, new(idValue))
+}
+
+// FromContext 返回 ctx 中的请求 ID，没有时返回空串
+func FromContext(ctx context.Context) string {
+	if v, ok := ctx.Value(ctxKey{}).(*idValue); ok {
+		return v.id
+	}
+	return ""
+}
+
+// Inject 以 New 读取 ID 的首部将 ctx 中的请求 ID 写入发送给下游的请求首部，没有请求 ID 时不做任何事
+// 可以作为 propagation.InjectorFunc 跟其他 Injector 组合
+func Inject(ctx context.Context, h httptoy.Header) {
+	if v, ok := ctx.Value(ctxKey{}).(*idValue); ok && v.id != "" {
+		h.Set(v.header, v.id)
+	}
+}
@@ -0,0 +1,136 @@
+package requestid
+
+import (
+	"context"
+	"net/http"
+	"regexp"
+	"strings"
+	"testing"
+
+	"build-HTTP-from-scracth/pkg/httptoy"
+	"build-HTTP-from-scracth/pkg/httptoy/httptoytest"
+	"build-HTTP-from-scracth/pkg/httptoy/propagation"
+)
+
+func TestValid(t *testing.T) {
+	tests := []struct {
+		id    string
+		valid bool
+	}{
+		{"3f2a5c1e-8b7d-4e6f-9a0b-1c2d3e4f5a6b", true},
+		{"svc.a:1234/req_9+x=@y", true},
+		{strings.Repeat("a", MaxLength), true},
+		{"", false},
+		{strings.Repeat("a", MaxLength+1), false},
+		{"has space", false},
+		{"new\nline", false},
+		{`quote"`, false},
+		{"中文", false},
+	}
+
+	for _, tt := range tests {
+		if got := Valid(tt.id); got != tt.valid {
+			t.Errorf("Valid(%q) = %v", tt.id, got)
+		}
+	}
+}
+
+func TestNewID(t *testing.T) {
+	uuid := regexp.MustCompile(`^[0-9a-f]{8}-[0-9a-f]{4}-4[0-9a-f]{3}-[89ab][0-9a-f]{3}-[0-9a-f]{12}$`)
+
+	seen := make(map[string]bool)
+	for i := 0; i < 100; i++ {
+		id := NewID()
+		if !uuid.MatchString(id) || seen[id] {
+			t.Fatalf("NewID() = %q", id)
+		}
+		seen[id] = true
+	}
+}
+
+func TestMiddleware(t *testing.T) {
+	var ctxID string
+	next := httptoy.HandlerFunc(func(rw httptoy.ResponseWriter, req *httptoy.Request) {
+		ctxID = FromContext(req.Context())
+		rw.WriteHeader(204)
+	})
+
+	tests := []struct {
+		name     string
+		opts     Options
+		incoming string
+		want     string
+	}{
+		{"reuse", Options{}, "upstream-1", "upstream-1"},
+		{"generate", Options{Generate: func() string { return "new" }}, "", "new"},
+		{"invalid", Options{Generate: func() string { return "new" }}, "a b", "new"},
+		{"ignore", Options{Generate: func() string { return "new" }, IgnoreIncoming: true}, "upstream-1", "new"},
+	}
+
+	for _, tt := range tests {
+		req := httptoytest.NewRequest("GET", "/", nil)
+		if tt.incoming != "" {
+			req.Header.Set(Header, tt.incoming)
+		}
+		rec := httptoytest.NewRecorder()
+		New(next, tt.opts).ServeHTTP(rec, req)
+
+		if ctxID != tt.want || rec.Header().Get(Header) != tt.want {
+			t.Errorf("%s: context %q, header %q, want %q", tt.name, ctxID, rec.Header().Get(Header), tt.want)
+		}
+	}
+}
+
+func TestPropagation(t *testing.T) {
+	for _, header := range []string{Header, "X-Correlation-Id"} {
+		var backendHeader httptoy.Header
+		backend := httptoytest.NewServer(httptoy.HandlerFunc(func(rw httptoy.ResponseWriter, req *httptoy.Request) {
+			backendHeader = req.Header
+		}))
+		defer backend.Close()
+
+		client := &http.Client{Transport: propagation.Transport(backend.Client().Transport, propagation.InjectorFunc(Inject))}
+		front := httptoytest.NewServer(New(httptoy.HandlerFunc(func(rw httptoy.ResponseWriter, req *httptoy.Request) {
+			outReq, _ := http.NewRequestWithContext(req.Context(), "GET", backend.URL, nil)
+			resp, err := client.Do(outReq)
+			if err != nil {
+				t.Error(err)
+				return
+			}
+			resp.Body.Close()
+		}), Options{Header: header}))
+		defer front.Close()
+
+		resp, err := front.Client().Get(front.URL)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+
+		// 响应首部在 handler 写回之前就已经提交，下游收到同一个首部
+		id := resp.Header.Get(header)
+		if !Valid(id) || backendHeader.Get(header) != id || len(backendHeader.Values(Header)) != 0 && header != Header {
+			t.Errorf("%s: response id %q, backend header %v", header, id, backendHeader)
+		}
+	}
+}
+
+func TestCapture(t *testing.T) {
+	var ctx context.Context
+	h := New(httptoy.HandlerFunc(func(rw httptoy.ResponseWriter, req *httptoy.Request) {}),
+		Options{Generate: func() string { return "inner" }})
+
+	// 外层放入的空 ID 由内层的 New 填充
+	req := httptoytest.NewRequest("GET", "/", nil)
+	ctx = Capture(req.Context())
+	h.ServeHTTP(httptoytest.NewRecorder(), req.WithContext(ctx))
+	if FromContext(ctx) != "inner" {
+		t.Errorf("captured id = %q", FromContext(ctx))
+	}
+
+	// 已经有 ID 时 Capture 不会覆盖
+	ctx = NewContext(req.Context(), "outer")
+	if FromContext(Capture(ctx)) != "outer" {
+		t.Errorf("Capture overwrote id: %q", FromContext(Capture(ctx)))
+	}
+}
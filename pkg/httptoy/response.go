@@ -2,7 +2,15 @@ package httptoy
 
 import (
 	"bufio"
-	"fmt"
+	"bytes"
+	"errors"
+	"io"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
 )
 
 /* 一般的响应报文
@@ -13,6 +21,21 @@ import (
  * <h1>hello world</h1>
  */
 
+// ErrBodyNotAllowed 当请求方法或响应状态码不允许携带报文主体时，由 Write 返回
+var ErrBodyNotAllowed = errors.New("httptoy: request method or response status code does not allow body")
+
+// ErrHijacked 调用 Hijack 之后再写入响应时，由 Write 返回
+var ErrHijacked = errors.New("httptoy: connection has been hijacked")
+
+// Hijacker 由能够交出底层连接的 ResponseWriter 实现，E.g. 升级为 WebSocket
+// HTTP/2 的响应不支持 Hijack
+type Hijacker interface {
+	// Hijack 返回底层连接以及连接的读写缓冲，读取缓冲中可能有客户端已经发送的数据
+	// 之后 httptoy 不再处理该连接，handler 负责关闭连接
+	// 之前设置的读写截止时间会被清除，E.g. ConnContext 中设置的截止时间，handler 需要时自行设置
+	Hijack() (net.Conn, *bufio.ReadWriter, error)
+}
+
 type ResponseWriter interface {
 	Write(p []byte) (int, error)
 
@@ -23,24 +46,28 @@ type ResponseWriter interface {
 }
 
 func setupResponse(c *conn, req *Request) *Response {
-	var (
-		protoMinor, protoMajor int
-		closeAfterReply        bool
-	)
+	c.countRequest()
 
-	fmt.Sscanf(req.Proto, "HTTP/%d.%d", &protoMinor, &protoMajor)
-	if protoMajor < 1 || protoMinor == 1 && protoMajor == 0 || req.Header.Get("Connection") == "close" {
-		closeAfterReply = true
+	// 客户端不希望复用连接，或者连接处理的请求数达到上限，则回复完毕后关闭连接
+	requestsLeft := -1
+	closeAfterReply := !req.wantsKeepAlive()
+	if max := c.svr.MaxRequestsPerConn; max > 0 {
+		requestsLeft = max - c.requests
+		if requestsLeft <= 0 {
+			closeAfterReply = true
+		}
 	}
 
 	cw := chunkWriter{}
 
 	resp := Response{
 		closeAfterReply: closeAfterReply,
+		requestsLeft:    requestsLeft,
 		statusCode:      200,
 		header:          make(Header),
 		cw:              &cw,
 		bufw:            bufio.NewWriterSize(&cw, 4<<10), // 4kb 缓存size
+		out:             c.bufw,
 		c:               c,
 		req:             req,
 	}
@@ -53,26 +80,45 @@ func setupResponse(c *conn, req *Request) *Response {
 // Response 是针对 http 连接处理的响应报文载体
 type Response struct {
 	wroteHeader bool // 第一次写入resp header flag
+	hijacked    bool // 调用过 Hijack
 	chunking    bool // chunk编码 flag
 	handlerDone bool // handler结束 flag
 
 	//是否在本次http请求结束后关闭tcp连接，以下情况需要关闭连接：
-	//1、HTTP/1.1之前的版本协议
+	//1、HTTP/1.0 没有设置 Connection: keep-alive，或者更早的版本协议
 	//2、请求报文头部设置了Connection: close
 	//3、在net.Conn进行Write的过程中发生错误
+	//4、连接处理的请求数达到 Server.MaxRequestsPerConn
+	//5、HTTP/1.0 的报文主体长度未知，只能通过关闭连接表示结束
 	closeAfterReply bool
+	requestsLeft    int // 连接还能处理的请求数，-1 表示不限制
 
 	statusCode int    // 状态码
 	header     Header // 响应报文的首部信息
 
 	cw   *chunkWriter  // 块编码 writer
 	bufw *bufio.Writer // 缓存 writer
+	out  *bufio.Writer // 响应报文的输出流，通常为 conn.bufw，并发执行的流水线请求则为私有缓冲
 
 	req *Request
 	c   *conn
 }
 
 func (w *Response) Write(p []byte) (int, error) {
+	if w.hijacked {
+		return 0, ErrHijacked
+	}
+
+	// 没有调用 WriteHeader 就直接写入，默认为 200
+	if !w.wroteHeader {
+		w.WriteHeader(200)
+	}
+
+	// 1xx、204、304 不允许携带报文主体
+	if !bodyAllowedForStatus(w.statusCode) {
+		return 0, ErrBodyNotAllowed
+	}
+
 	n, err := w.bufw.Write(p)
 	if err != nil {
 		w.closeAfterReply = true
@@ -88,7 +134,7 @@ func (w *Response) Header() Header {
 
 // WriterHeader ...
 func (w *Response) WriteHeader(statusCode int) {
-	if w.wroteHeader {
+	if w.wroteHeader || w.hijacked {
 		return
 	}
 
@@ -96,3 +142,211 @@ func (w *Response) WriteHeader(statusCode int) {
 	w.wroteHeader = true
 
 }
+
+// Hijack 实现 Hijacker 接口
+// 响应的首部已经发送，或者请求是并发执行的流水线请求时无法 Hijack
+func (w *Response) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	if w.hijacked {
+		return nil, nil, ErrHijacked
+	}
+	if w.cw.wrote {
+		return nil, nil, errors.New("httptoy: Hijack after response header was sent")
+	}
+	if w.out != w.c.bufw {
+		return nil, nil, errors.New("httptoy: Hijack is not supported for concurrent pipelined requests")
+	}
+
+	// 丢弃尚未发送的报文主体，handler 自行写回所有数据
+	w.bufw.Reset(w.cw)
+	w.hijacked = true
+
+	c := w.c
+	c.hijacked = true
+	c.lr.N = 1<<63 - 1             // 不再限制读取
+	c.rwc.SetDeadline(time.Time{}) // 截止时间由 handler 自行管理
+	c.setState(StateHijacked)
+	c.svr.trackConn(c, false) // Server.Close 不再关闭该连接
+
+	return c.rwc, bufio.NewReadWriter(c.bufr, c.bufw), nil
+}
+
+// keepAliveParams 返回 HTTP/1.0 复用连接时 Keep-Alive 首部的参数，都没有限制时返回空串
+// E.g. Keep-Alive: timeout=5, max=99
+func (w *Response) keepAliveParams() string {
+	var params []string
+	// timeout 以秒为单位并且向下取整，不足 1 秒时 timeout=0 会让客户端不再复用连接，因此省略
+	if secs := int(w.c.svr.IdleTimeout / time.Second); secs > 0 {
+		params = append(params, "timeout="+strconv.Itoa(secs))
+	}
+	if w.requestsLeft >= 0 {
+		params = append(params, "max="+strconv.Itoa(w.requestsLeft))
+	}
+
+	return strings.Join(params, ", ")
+}
+
+// sniffLen 是 http.DetectContentType 检测报文类型时最多使用的字节数
+const sniffLen = 512
+
+// ReadFrom 实现 io.ReaderFrom 接口，io.Copy(rw, src) 会调用该方法
+// 1.如果能得知 src 的大小，并且首部尚未发送，则直接设置 Content-Length，避免 chunk 编码
+// 2.如果 src 是文件并且连接是 *net.TCPConn，发送完首部后交由内核的 sendfile/splice 发送，
+// 跳过 Response.bufw、chunkWriter 以及 conn.bufw 三层缓冲
+// 3.其余情况退回到普通的缓冲写入
+func (w *Response) ReadFrom(src io.Reader) (n int64, err error) {
+	if w.hijacked {
+		return 0, ErrHijacked
+	}
+	if !w.wroteHeader {
+		w.WriteHeader(200)
+	}
+
+	if !bodyAllowedForStatus(w.statusCode) {
+		return 0, ErrBodyNotAllowed
+	}
+
+	defer func() {
+		if err != nil {
+			w.closeAfterReply = true
+		}
+	}()
+
+	size := readerSize(src)
+	if size < 0 || w.cw.wrote || w.header.Get("Content-Length") != "" || w.header.Get("Transfer-Encoding") != "" {
+		// 大小未知，或者首部已经确定，只能按照普通的方式写入
+		return w.bufw.ReadFrom(src)
+	}
+
+	// 缓存中还未发送的数据跟 src 一起作为报文主体
+	w.header.Set("Content-Length", strconv.FormatInt(int64(w.bufw.Buffered())+size, 10))
+
+	// HEAD 请求只需要首部，不需要读取 src
+	if !w.sendBody() {
+		return 0, nil
+	}
+
+	// 未设置报文类型时，先通过缓冲写入至多 sniffLen 字节，让 chunkWriter 检测类型
+	if w.header.Get("Content-Type") == "" {
+		n, err = io.Copy(w.bufw, io.LimitReader(src, sniffLen))
+		size -= n
+		if err != nil || size <= 0 {
+			return n, err
+		}
+	}
+
+	// 并发执行的流水线请求写入的是私有缓冲，不能直接写入连接
+	tcpConn, ok := w.c.rwc.(*net.TCPConn)
+	if !ok || w.out != w.c.bufw || !isFileReader(src) {
+		m, err := w.bufw.ReadFrom(src)
+		return n + m, err
+	}
+
+	// 发送首部以及缓存的数据，保证 sendfile 写入之前连接上的数据顺序正确
+	if err = w.bufw.Flush(); err != nil {
+		return n, err
+	}
+	w.cw.commitHeader(nil)
+	if err = w.out.Flush(); err != nil {
+		return n, err
+	}
+
+	m, err := sendFile(tcpConn, src, size)
+	if metrics := w.c.svr.Metrics; metrics != nil && m > 0 {
+		metrics.BytesWritten(m)
+	}
+	return n + m, err
+}
+
+// fileReader 描述能够使用 sendfile 发送的文件
+// *os.File 实现了 io.WriterTo，io.Copy(rw, file) 时会先调用 file.WriteTo，
+// 其内部把 *os.File 包装成只隐藏了 WriteTo 的类型再调用 rw.ReadFrom，因此不能直接断言 *os.File
+type fileReader interface {
+	io.Reader
+	io.Seeker
+	syscall.Conn
+	Stat() (os.FileInfo, error)
+}
+
+// readerSize 返回 r 剩余可读的字节数，无法得知时返回 -1
+func readerSize(r io.Reader) int64 {
+	switch v := r.(type) {
+	case fileReader:
+		fi, err := v.Stat()
+		if err != nil || !fi.Mode().IsRegular() {
+			return -1
+		}
+
+		off, err := v.Seek(0, io.SeekCurrent)
+		if err != nil || off > fi.Size() {
+			return -1
+		}
+
+		return fi.Size() - off
+	case *io.LimitedReader:
+		// 只有内部的 Reader 大小已知时，才能确定 LimitedReader 实际能读取多少
+		size := readerSize(v.R)
+		if size < 0 {
+			return -1
+		}
+
+		if v.N < size {
+			return v.N
+		}
+
+		return size
+	case *bytes.Reader:
+		return int64(v.Len())
+	case *bytes.Buffer:
+		return int64(v.Len())
+	case *strings.Reader:
+		return int64(v.Len())
+	}
+
+	return -1
+}
+
+// isFileReader 判断 r 是否能够使用 sendfile 发送
+func isFileReader(r io.Reader) bool {
+	switch v := r.(type) {
+	case fileReader:
+		return true
+	case *io.LimitedReader:
+		_, ok := v.R.(fileReader)
+		return ok
+	}
+
+	return false
+}
+
+// sendFile 将 src 中 size 字节的数据交由 net.TCPConn.ReadFrom 发送，
+// 其内部会根据 *io.LimitedReader 包装的文件使用 sendfile/splice
+func sendFile(tcpConn *net.TCPConn, src io.Reader, size int64) (int64, error) {
+	lr, ok := src.(*io.LimitedReader)
+	if !ok {
+		return tcpConn.ReadFrom(&io.LimitedReader{R: src, N: size})
+	}
+
+	// 外层的 LimitedReader 需要同步扣除已读取的长度
+	n, err := tcpConn.ReadFrom(&io.LimitedReader{R: lr.R, N: size})
+	lr.N -= n
+
+	return n, err
+}
+
+// sendBody 判断本次响应是否需要发送报文主体
+// HEAD 请求按照 GET 的方式计算首部，但不发送报文主体
+func (w *Response) sendBody() bool {
+	return w.req.Method != "HEAD" && bodyAllowedForStatus(w.statusCode)
+}
+
+// bodyAllowedForStatus 判断状态码是否允许携带报文主体，1xx、204、304 不允许
+func bodyAllowedForStatus(status int) bool {
+	switch {
+	case status >= 100 && status <= 199:
+		return false
+	case status == 204, status == 304:
+		return false
+	}
+
+	return true
+}
@@ -0,0 +1,255 @@
+package httptoy
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// testHandler 将函数包装成 Handler
+type testHandler func(rw ResponseWriter, req *Request)
+
+func (th testHandler) ServeHTTP(rw ResponseWriter, req *Request) {
+	th(rw, req)
+}
+
+// serveRaw 通过 net.Pipe 将原始请求报文交给 conn.serve 处理，返回服务端写回的全部数据
+// 最后一个请求需要让服务端回复完毕后关闭连接，E.g. 携带 Connection: close
+func serveRaw(t *testing.T, h Handler, raw string) string {
+	t.Helper()
+
+	return serveRawServer(t, &Server{Handler: h}, raw)
+}
+
+// serveRawServer 同 serveRaw，使用指定配置的 svr
+func serveRawServer(t *testing.T, svr *Server, raw string) string {
+	t.Helper()
+
+	cli, srv := net.Pipe()
+	c := newConn(srv, svr)
+	go c.serve()
+
+	go func() {
+		io.WriteString(cli, raw)
+	}()
+
+	out, err := io.ReadAll(cli)
+	if err != nil {
+		t.Fatalf("read response: %v", err)
+	}
+	cli.Close()
+
+	return string(out)
+}
+
+func TestHeadResponse(t *testing.T) {
+	body := strings.Repeat("a", 100)
+	h := testHandler(func(rw ResponseWriter, req *Request) {
+		io.WriteString(rw, body)
+	})
+
+	get := serveRaw(t, h, "GET / HTTP/1.1\r\nConnection: close\r\n\r\n")
+	head := serveRaw(t, h, "HEAD / HTTP/1.1\r\nConnection: close\r\n\r\n")
+
+	if !strings.HasSuffix(get, "\r\n\r\n"+body) {
+		t.Fatalf("GET response missing body: %q", get)
+	}
+	if !strings.Contains(head, "Content-Length: 100\r\n") {
+		t.Fatalf("HEAD response should compute Content-Length as GET: %q", head)
+	}
+	if !strings.HasSuffix(head, "\r\n\r\n") {
+		t.Fatalf("HEAD response should not carry body: %q", head)
+	}
+}
+
+func TestHeadChunkedResponse(t *testing.T) {
+	h := testHandler(func(rw ResponseWriter, req *Request) {
+		io.WriteString(rw, strings.Repeat("a", 8<<10))
+	})
+
+	head := serveRaw(t, h, "HEAD / HTTP/1.1\r\nConnection: close\r\n\r\n")
+	if !strings.Contains(head, "Transfer-Encoding: chunked\r\n") {
+		t.Fatalf("HEAD response should compute Transfer-Encoding as GET: %q", head)
+	}
+	if !strings.HasSuffix(head, "\r\n\r\n") || strings.Contains(head, "0\r\n\r\n") {
+		t.Fatalf("HEAD response should not carry chunk framing: %q", head)
+	}
+}
+
+func TestBodilessStatus(t *testing.T) {
+	for _, code := range []int{204, 304} {
+		var werr error
+		h := testHandler(func(rw ResponseWriter, req *Request) {
+			rw.Header().Set("Content-Length", "5")
+			rw.WriteHeader(code)
+			_, werr = io.WriteString(rw, "hello")
+		})
+
+		resp := serveRaw(t, h, "GET / HTTP/1.1\r\nConnection: close\r\n\r\n")
+		if werr != ErrBodyNotAllowed {
+			t.Errorf("%d: Write err = %v, want ErrBodyNotAllowed", code, werr)
+		}
+		if strings.Contains(resp, "Content-Length") || strings.Contains(resp, "Transfer-Encoding") {
+			t.Errorf("%d: response should not carry framing headers: %q", code, resp)
+		}
+		if !strings.HasSuffix(resp, "\r\n\r\n") {
+			t.Errorf("%d: response should not carry body: %q", code, resp)
+		}
+	}
+}
+
+func TestEmptyResponseSendsHeader(t *testing.T) {
+	h := testHandler(func(rw ResponseWriter, req *Request) {
+		rw.WriteHeader(404)
+	})
+
+	resp := serveRaw(t, h, "GET / HTTP/1.1\r\nConnection: close\r\n\r\n")
+	if !strings.HasPrefix(resp, "HTTP/1.1 404 Not Found\r\n") {
+		t.Fatalf("unexpected response: %q", resp)
+	}
+}
+
+// startTestServer 在随机端口上启动服务，返回监听地址
+func startTestServer(tb testing.TB, h Handler) string {
+	tb.Helper()
+
+	return startServer(tb, &Server{Handler: h})
+}
+
+// startServer 在随机端口上启动 svr，返回监听地址
+func startServer(tb testing.TB, svr *Server) string {
+	tb.Helper()
+
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		tb.Fatal(err)
+	}
+	tb.Cleanup(func() { l.Close() })
+
+	go func() {
+		for {
+			rwc, err := l.Accept()
+			if err != nil {
+				return
+			}
+			go newConn(rwc, svr).serve()
+		}
+	}()
+
+	return l.Addr().String()
+}
+
+// writeTempFile 创建 size 字节的临时文件，返回文件路径
+func writeTempFile(tb testing.TB, size int) string {
+	tb.Helper()
+
+	name := filepath.Join(tb.TempDir(), "data.bin")
+	if err := os.WriteFile(name, bytes.Repeat([]byte("0123456789abcdef"), size/16), 0644); err != nil {
+		tb.Fatal(err)
+	}
+
+	return name
+}
+
+// writerOnly 隐藏 Response 的 ReadFrom，强制 io.Copy 走缓冲写入
+type writerOnly struct {
+	io.Writer
+}
+
+func TestResponseReadFrom(t *testing.T) {
+	name := writeTempFile(t, 64<<10)
+	want, _ := os.ReadFile(name)
+
+	addr := startTestServer(t, testHandler(func(rw ResponseWriter, req *Request) {
+		f, err := os.Open(name)
+		if err != nil {
+			t.Error(err)
+			return
+		}
+		defer f.Close()
+
+		io.WriteString(rw, "prefix:")
+		io.Copy(rw, f)
+	}))
+
+	c, err := net.Dial("tcp", addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+
+	bufr := bufio.NewReader(c)
+	for i := 0; i < 2; i++ {
+		fmt.Fprintf(c, "GET / HTTP/1.1\r\nHost: %s\r\n\r\n", addr)
+		resp, err := http.ReadResponse(bufr, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		got, err := io.ReadAll(resp.Body)
+		resp.Body.Close()
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if resp.ContentLength != int64(len(want)+7) || len(resp.TransferEncoding) != 0 {
+			t.Fatalf("want Content-Length %d, got %d %v", len(want)+7, resp.ContentLength, resp.TransferEncoding)
+		}
+		if !bytes.Equal(got, append([]byte("prefix:"), want...)) {
+			t.Fatalf("body mismatch, got %d bytes", len(got))
+		}
+	}
+}
+
+func benchmarkServeFile(b *testing.B, size int, buffered bool) {
+	name := writeTempFile(b, size)
+
+	addr := startTestServer(b, testHandler(func(rw ResponseWriter, req *Request) {
+		f, err := os.Open(name)
+		if err != nil {
+			b.Error(err)
+			return
+		}
+		defer f.Close()
+
+		if buffered {
+			rw.Header().Set("Content-Length", fmt.Sprint(size))
+			io.Copy(writerOnly{rw}, f)
+			return
+		}
+		io.Copy(rw, f)
+	}))
+
+	c, err := net.Dial("tcp", addr)
+	if err != nil {
+		b.Fatal(err)
+	}
+	defer c.Close()
+
+	bufr := bufio.NewReader(c)
+	b.SetBytes(int64(size))
+	b.ReportAllocs()
+	b.ResetTimer()
+
+	for i := 0; i < b.N; i++ {
+		fmt.Fprintf(c, "GET / HTTP/1.1\r\nHost: %s\r\n\r\n", addr)
+		resp, err := http.ReadResponse(bufr, nil)
+		if err != nil {
+			b.Fatal(err)
+		}
+		io.Copy(io.Discard, resp.Body)
+		resp.Body.Close()
+	}
+}
+
+func BenchmarkServeFileSendfile1MB(b *testing.B)  { benchmarkServeFile(b, 1<<20, false) }
+func BenchmarkServeFileBuffered1MB(b *testing.B)  { benchmarkServeFile(b, 1<<20, true) }
+func BenchmarkServeFileSendfile64KB(b *testing.B) { benchmarkServeFile(b, 64<<10, false) }
+func BenchmarkServeFileBuffered64KB(b *testing.B) { benchmarkServeFile(b, 64<<10, true) }
@@ -1,9 +1,14 @@
 package httptoy
 
 import (
+	"context"
+	"errors"
 	"net"
 	"net/http"
+	"sort"
 	"strings"
+	"sync"
+	"time"
 )
 
 // Handler ...
@@ -17,9 +22,98 @@ type HandlerFunc func(rw ResponseWriter, req *Request)
 type Server struct {
 	Addr    string  // 监听地址
 	Handler Handler // 处理http请求的回调函数
+
+	// ConcurrentPipeline 为 true 时，同一连接上流水线发送的无报文主体请求会并发执行 handler，
+	// 响应依旧按照请求的顺序写回
+	ConcurrentPipeline bool
+	// MaxPipelineDepth 限制单个连接上并发执行但尚未写回的流水线请求数，<= 0 时为 16
+	MaxPipelineDepth int
+
+	// IdleTimeout 是长连接等待下一个请求的最长时间，HTTP/2 连接上为没有正在处理的流的最长时间，<= 0 时不限制
+	IdleTimeout time.Duration
+	// MaxRequestsPerConn 限制单个长连接能处理的请求数，达到上限后关闭连接，<= 0 时不限制
+	MaxRequestsPerConn int
+
+	// EnableH2C 为 true 时支持明文 HTTP/2，客户端可以直接发送连接前言(prior knowledge)，
+	// 也可以通过 Upgrade: h2c 从 HTTP/1.1 升级
+	EnableH2C bool
+	// MaxConcurrentStreams 限制单个 HTTP/2 连接上同时处理的流数，0 时为 100
+	MaxConcurrentStreams uint32
+
+	// Metrics 非空时接收连接层面的事件，E.g. metrics.NewServerMetrics
+	Metrics ServerMetrics
+
+	// ConnState 非空时在连接的状态改变时调用，各个连接会并发调用
+	ConnState func(net.Conn, ConnState)
+	// BaseContext 非空时为 l 上所有连接的根 context，不能返回 nil，默认为 context.Background
+	BaseContext func(l net.Listener) context.Context
+	// ConnContext 非空时修改单个连接的 context，E.g. 添加连接相关的值，不能返回 nil
+	// 该连接上所有请求的 Request.Context 都派生自返回的 context
+	ConnContext func(ctx context.Context, c net.Conn) context.Context
+
+	mu        sync.Mutex
+	listeners map[net.Listener]struct{} // Serve 正在监听的 listener
+	conns     map[*conn]struct{}        // 正在服务的连接
+	closed    bool                      // 调用过 Close
+}
+
+// ServerMetrics 接收 conn.serve 中连接层面的事件，各个方法会被多个连接并发调用
+// 新建的连接处于空闲状态，读到请求之后变为活跃，回复完毕并且等待下一个请求时重新变为空闲
+type ServerMetrics interface {
+	ConnOpened()
+	ConnClosed(idle bool) // idle 为关闭时连接是否处于空闲状态
+	ConnActive()          // 空闲的连接读到请求
+	ConnIdle()            // 活跃的连接开始等待下一个请求
+	BytesRead(n int64)
+	BytesWritten(n int64)
+	// ParseError 记录无法解析的请求，kind 为回复的状态码 E.g. "400"，没有回复时为 "incomplete" 或者 "other"
+	ParseError(kind string)
+}
+
+// contextKey 是 httptoy 放在 context 中的值的键
+type contextKey struct {
+	name string
 }
 
-// ListenAndServe ...
+func (k *contextKey) String() string {
+	return "httptoy context value " + k.name
+}
+
+// ServerContextKey 对应的值为处理该请求的 *Server
+var ServerContextKey = &contextKey{"http-server"}
+
+// patternContextKey 对应的值为 *string，ServeMux 匹配到路由后写入
+var patternContextKey = &contextKey{"pattern"}
+
+// CapturePattern 返回带有路由槽位的 ctx，内层的 ServeMux 匹配到路由之后写入，
+// 中间件把请求替换为 req.WithContext 的副本时，外层仍然可以通过 PatternFromContext 读取
+// E.g. metrics 以及 tracing 在 next 返回之后按照路由统计
+// ctx 中已经有路由槽位时原样返回
+func CapturePattern(ctx context.Context) context.Context {
+	if _, ok := ctx.Value(patternContextKey).(*string); ok {
+		return ctx
+	}
+
+	return context.WithValue(ctx, patternContextKey, new(string))
+}
+
+// PatternFromContext 返回 ServeMux 写入 ctx 中路由槽位的路由，没有匹配或者没有槽位时返回空串
+func PatternFromContext(ctx context.Context) string {
+	if p, ok := ctx.Value(patternContextKey).(*string); ok {
+		return *p
+	}
+	return ""
+}
+
+// ErrServerClosed 调用 Server.Close 之后，由 Serve 以及 ListenAndServe 返回
+var ErrServerClosed = errors.New("httptoy: Server closed")
+
+// ServeHTTP 调用 f(rw, req)，使普通函数能够作为 Handler
+func (f HandlerFunc) ServeHTTP(rw ResponseWriter, req *Request) {
+	f(rw, req)
+}
+
+// ListenAndServe 监听 s.Addr 并调用 Serve
 func (s *Server) ListenAndServe() error {
 	// 开启tcp，监听 s.Addr 地址
 	l, err := net.Listen("tcp", s.Addr)
@@ -27,21 +121,185 @@ func (s *Server) ListenAndServe() error {
 		return err
 	}
 
+	return s.Serve(l)
+}
+
+// Serve 接受 l 上的连接，为每个连接开启协程处理请求，返回时关闭 l
+// 调用 Close 之后返回 ErrServerClosed
+func (s *Server) Serve(l net.Listener) error {
+	defer l.Close()
+
+	if !s.trackListener(l, true) {
+		return ErrServerClosed
+	}
+	defer s.trackListener(l, false)
+
+	baseCtx := context.Background()
+	if s.BaseContext != nil {
+		if baseCtx = s.BaseContext(l); baseCtx == nil {
+			panic("httptoy: BaseContext returned a nil context")
+		}
+	}
+	baseCtx = context.WithValue(baseCtx, ServerContextKey, s)
+
+	var delay time.Duration // 临时错误之后的等待时间
 	for {
 		// 获取tcp连接的上下文
 		rwc, err := l.Accept()
 		if err != nil {
-			continue
+			if s.isClosed() {
+				return ErrServerClosed
+			}
+
+			// 超时之类的临时错误，等待一段时间之后继续接受连接
+			if ne, ok := err.(net.Error); ok && ne.Timeout() {
+				if delay = delay * 2; delay == 0 {
+					delay = 5 * time.Millisecond
+				} else if delay > time.Second {
+					delay = time.Second
+				}
+				time.Sleep(delay)
+				continue
+			}
+			return err
 		}
+		delay = 0
 
 		// 创建连接
 		conn := newConn(rwc, s)
+		conn.ctx = baseCtx
+		if s.ConnContext != nil {
+			if conn.ctx = s.ConnContext(baseCtx, rwc); conn.ctx == nil {
+				panic("httptoy: ConnContext returned a nil context")
+			}
+		}
+		if !s.trackConn(conn, true) {
+			rwc.Close()
+			return ErrServerClosed
+		}
 
 		// 开启协程，运行conn的服务
 		go conn.serve()
 	}
 }
 
+// Close 关闭所有的 listener 以及连接，正在执行的 handler 不会被打断，但是无法再写回响应
+func (s *Server) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.closed = true
+
+	var err error
+	for l := range s.listeners {
+		if cerr := l.Close(); cerr != nil && err == nil {
+			err = cerr
+		}
+	}
+	for c := range s.conns {
+		c.rwc.Close()
+	}
+
+	return err
+}
+
+func (s *Server) isClosed() bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.closed
+}
+
+// trackListener 记录或者移除 listener，Server 已经关闭时无法记录，返回 false
+func (s *Server) trackListener(l net.Listener, add bool) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if !add {
+		delete(s.listeners, l)
+		return true
+	}
+	if s.closed {
+		return false
+	}
+	if s.listeners == nil {
+		s.listeners = make(map[net.Listener]struct{})
+	}
+	s.listeners[l] = struct{}{}
+
+	return true
+}
+
+// trackConn 记录或者移除连接，Server 已经关闭时无法记录，返回 false
+func (s *Server) trackConn(c *conn, add bool) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if !add {
+		delete(s.conns, c)
+		return true
+	}
+	if s.closed {
+		return false
+	}
+	if s.conns == nil {
+		s.conns = make(map[*conn]struct{})
+	}
+	s.conns[c] = struct{}{}
+
+	return true
+}
+
+// ConnInfo 是 Server.Conns 返回的连接快照
+type ConnInfo struct {
+	RemoteAddr net.Addr
+	LocalAddr  net.Addr
+	State      ConnState
+	Requests   int       // 连接已经读取的请求数，HTTP/2 连接上为流的数量
+	Created    time.Time // 建立连接的时间
+}
+
+// Conns 返回正在服务的连接，按照建立的时间排序
+// 被 Hijack 的连接已经交由 handler 管理，不再包含在内
+func (s *Server) Conns() []ConnInfo {
+	s.mu.Lock()
+	conns := make([]ConnInfo, 0, len(s.conns))
+	for c := range s.conns {
+		c.mu.Lock()
+		conns = append(conns, ConnInfo{
+			RemoteAddr: c.rwc.RemoteAddr(),
+			LocalAddr:  c.rwc.LocalAddr(),
+			State:      c.state,
+			Requests:   c.requests,
+			Created:    c.created,
+		})
+		c.mu.Unlock()
+	}
+	s.mu.Unlock()
+
+	sort.Slice(conns, func(i, j int) bool {
+		return conns[i].Created.Before(conns[j].Created)
+	})
+
+	return conns
+}
+
+// Addrs 返回 Serve 正在监听的地址
+func (s *Server) Addrs() []net.Addr {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	addrs := make([]net.Addr, 0, len(s.listeners))
+	for l := range s.listeners {
+		addrs = append(addrs, l.Addr())
+	}
+	sort.Slice(addrs, func(i, j int) bool {
+		return addrs[i].String() < addrs[j].String()
+	})
+
+	return addrs
+}
+
 // NewServeMux ...
 func NewServeMux() *ServeMux {
 	return &ServeMux{m: make(map[string]HandlerFunc)}
@@ -70,11 +328,25 @@ func (sm *ServeMux) Handle(pattern string, hanlder Handler) {
 	sm.m[pattern] = hanlder.ServeHTTP
 }
 
+// Patterns 返回注册的路由，按照字典序排列
+func (sm *ServeMux) Patterns() []string {
+	patterns := make([]string, 0, len(sm.m))
+	for p := range sm.m {
+		patterns = append(patterns, p)
+	}
+	sort.Strings(patterns)
+
+	return patterns
+}
+
 func (sm *ServeMux) ServeHTTP(rw ResponseWriter, req *Request) {
-	hf, ok := sm.m[req.URL.Path]
+	pattern := req.URL.Path
+	hf, ok := sm.m[pattern]
 	if !ok && len(req.URL.Path) > 1 {
-		p := strings.LastIndex(req.URL.Path, `\`)
-		hf, ok = sm.m[req.URL.Path[p:]]
+		if p := strings.LastIndex(req.URL.Path, `\`); p >= 0 {
+			pattern = req.URL.Path[p:]
+			hf, ok = sm.m[pattern]
+		}
 	}
 
 	if !ok {
@@ -82,6 +354,11 @@ func (sm *ServeMux) ServeHTTP(rw ResponseWriter, req *Request) {
 		return
 	}
 
+	// 记录匹配到的路由，req 可能是中间件的副本，因此同时写入外层 CapturePattern 放入的槽位
+	req.Pattern = pattern
+	if p, ok := req.Context().Value(patternContextKey).(*string); ok {
+		*p = pattern
+	}
 	hf(rw, req)
 }
 
@@ -0,0 +1,83 @@
+package tracing
+
+import (
+	"net/http"
+	"strconv"
+
+	"build-HTTP-from-scracth/pkg/httptoy"
+	"build-HTTP-from-scracth/pkg/httptoy/propagation"
+)
+
+// Middleware 包装 next，为每个请求创建 server span
+// 请求带有合法的 traceparent 时 span 属于上游的链路，handler 通过 req.Context() 获取当前 span
+// span 的名称为 "方法 路由"，E.g. GET /hello，没有匹配的路由时只有方法
+func (t *Tracer) Middleware(next httptoy.Handler) httptoy.Handler {
+	return httptoy.HandlerFunc(func(rw httptoy.ResponseWriter, req *httptoy.Request) {
+		ctx := req.Context()
+		if sc := Extract(req.Header); sc.IsValid() {
+			ctx = ContextWithRemoteSpanContext(ctx, sc)
+		}
+		ctx, span := t.Start(ctx, req.Method, SpanKindServer)
+
+		span.SetAttribute("http.request.method", req.Method)
+		span.SetAttribute("url.path", req.URL.Path)
+		span.SetAttribute("network.protocol.version", strconv.Itoa(req.ProtoMajor)+"."+strconv.Itoa(req.ProtoMinor))
+		span.SetAttribute("client.address", req.RemoteAddr)
+		if ua := req.Header.Get("User-Agent"); ua != "" {
+			span.SetAttribute("user_agent.original", ua)
+		}
+
+		ww := httptoy.NewWrapWriter(rw)
+		req = req.WithContext(httptoy.CapturePattern(ctx))
+
+		// handler panic 时以 500 结束 span，之后继续 panic
+		status := 500
+		defer func() {
+			if pattern := httptoy.PatternFromContext(req.Context()); pattern != "" {
+				span.Name = req.Method + " " + pattern
+				span.SetAttribute("http.route", pattern)
+			}
+			span.SetAttribute("http.response.status_code", status)
+			if status >= 500 {
+				span.SetError(http.StatusText(status))
+			}
+			span.Finish()
+		}()
+
+		next.ServeHTTP(ww, req)
+		status = ww.Status()
+	})
+}
+
+// Transport 返回 net/http 的 RoundTripper，为每个发送给下游的请求创建 client span，
+// 通过 propagation.Transport 写入 client span 的追踪上下文，base 为 nil 时使用 http.DefaultTransport
+func (t *Tracer) Transport(base http.RoundTripper) http.RoundTripper {
+	return &transport{tracer: t, base: propagation.Transport(base, propagation.InjectorFunc(Inject))}
+}
+
+type transport struct {
+	tracer *Tracer
+	base   http.RoundTripper
+}
+
+func (tr *transport) RoundTrip(req *http.Request) (*http.Response, error) {
+	ctx, span := tr.tracer.Start(req.Context(), req.Method, SpanKindClient)
+	defer span.Finish()
+
+	span.SetAttribute("http.request.method", req.Method)
+	span.SetAttribute("url.full", req.URL.String())
+	span.SetAttribute("server.address", req.URL.Host)
+
+	resp, err := tr.base.RoundTrip(req.WithContext(ctx))
+	if err != nil {
+		span.SetError(err.Error())
+		return nil, err
+	}
+
+	span.SetAttribute("http.response.status_code", resp.StatusCode)
+	if resp.StatusCode >= 400 {
+		span.SetError(http.StatusText(resp.StatusCode))
+	}
+
+	return resp, nil
+}
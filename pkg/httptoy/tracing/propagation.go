@@ -0,0 +1,119 @@
+package tracing
+
+import (
+	"context"
+	"strings"
+
+	"build-HTTP-from-scracth/pkg/httptoy"
+)
+
+// 追踪上下文的首部
+const (
+	TraceparentHeader = "Traceparent"
+	TracestateHeader  = "Tracestate"
+)
+
+// maxTracestateMembers 是 tracestate 最多的成员数
+const maxTracestateMembers = 32
+
+// ParseTracestate 校验并规范 tracestate，多个首部值以 , 拼接之后传入
+// 不合法时返回 false，按照规范此时整个 tracestate 都被丢弃
+// E.g. rojo=00f067aa0ba902b7,congo=t61rcWkgMzE
+func ParseTracestate(s string) (string, bool) {
+	var members []string
+	seen := make(map[string]bool)
+
+	for _, m := range strings.Split(s, ",") {
+		// 成员之间允许空白以及空的成员
+		m = strings.Trim(m, " \t")
+		if m == "" {
+			continue
+		}
+
+		key, value, ok := strings.Cut(m, "=")
+		if !ok || !validTracestateKey(key) || !validTracestateValue(value) || seen[key] {
+			return "", false
+		}
+		seen[key] = true
+		members = append(members, m)
+	}
+	if len(members) > maxTracestateMembers {
+		return "", false
+	}
+
+	return strings.Join(members, ","), true
+}
+
+// validTracestateKey 校验 key
+// simple-key = lcalpha 0*255( lcalpha / DIGIT / "_" / "-"/ "*" / "/" )
+// multi-tenant-key = tenant-id "@" system-id，tenant-id 可以以数字开头，长度分别不超过 241 以及 14
+func validTracestateKey(key string) bool {
+	tenant, system, multi := strings.Cut(key, "@")
+	if !multi {
+		return len(key) <= 256 && key != "" && isLower(key[0]) && validKeyChars(key)
+	}
+
+	return tenant != "" && len(tenant) <= 241 && (isLower(tenant[0]) || isDigit(tenant[0])) && validKeyChars(tenant) &&
+		system != "" && len(system) <= 14 && isLower(system[0]) && validKeyChars(system)
+}
+
+func validKeyChars(s string) bool {
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		if !(isLower(c) || isDigit(c) || c == '_' || c == '-' || c == '*' || c == '/') {
+			return false
+		}
+	}
+	return true
+}
+
+// validTracestateValue 校验 value，0x20 到 0x7e 之间除了 , 以及 = 的字符，不能以空格结尾，长度不超过 256
+func validTracestateValue(v string) bool {
+	if v == "" || len(v) > 256 || v[len(v)-1] == ' ' {
+		return false
+	}
+	for i := 0; i < len(v); i++ {
+		if c := v[i]; c < 0x20 || c > 0x7e || c == ',' || c == '=' {
+			return false
+		}
+	}
+	return true
+}
+
+func isLower(c byte) bool { return 'a' <= c && c <= 'z' }
+func isDigit(c byte) bool { return '0' <= c && c <= '9' }
+
+// Extract 从首部解析上游的追踪上下文，traceparent 缺失或者不合法时返回无效的 SpanContext
+// traceparent 出现多次时同样不合法
+func Extract(h httptoy.Header) SpanContext {
+	tp := h.Values(TraceparentHeader)
+	if len(tp) != 1 {
+		return SpanContext{}
+	}
+	sc, err := ParseTraceparent(strings.Trim(tp[0], " \t"))
+	if err != nil {
+		return SpanContext{}
+	}
+
+	if ts := h.Values(TracestateHeader); len(ts) > 0 {
+		sc.TraceState, _ = ParseTracestate(strings.Join(ts, ","))
+	}
+
+	return sc
+}
+
+// Inject 将 ctx 中当前的追踪上下文写入发送给下游的请求首部，没有追踪上下文时不做任何事
+// 可以作为 propagation.InjectorFunc 跟其他 Injector 组合
+func Inject(ctx context.Context, h httptoy.Header) {
+	sc := SpanContextFromContext(ctx)
+	if !sc.IsValid() {
+		return
+	}
+
+	h.Set(TraceparentHeader, FormatTraceparent(sc))
+	if sc.TraceState != "" {
+		h.Set(TracestateHeader, sc.TraceState)
+	} else {
+		h.Del(TracestateHeader)
+	}
+}
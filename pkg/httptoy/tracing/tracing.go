@@ -0,0 +1,288 @@
+// Package tracing 为 httptoy 提供分布式追踪，按照 W3C Trace Context 传播 traceparent 以及 tracestate 首部
+//
+// E.g.
+//
+//	exp := tracing.NewInMemoryExporter()
+//	tracer := tracing.NewTracer(exp)
+//	svr := &httptoy.Server{Handler: tracer.Middleware(mux)}
+//
+//	// handler 中请求下游服务时创建 client span 并传播追踪上下文
+//	client := &http.Client{Transport: tracer.Transport(nil)}
+//	outReq, _ := http.NewRequestWithContext(req.Context(), "GET", "http://backend/api", nil)
+//	client.Do(outReq)
+package tracing
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"sync"
+	"time"
+)
+
+// TraceID 是一条链路的 ID，全为 0 时无效
+type TraceID [16]byte
+
+func (t TraceID) IsValid() bool  { return t != TraceID{} }
+func (t TraceID) String() string { return hex.EncodeToString(t[:]) }
+
+// SpanID 是一个 span 的 ID，全为 0 时无效
+type SpanID [8]byte
+
+func (s SpanID) IsValid() bool  { return s != SpanID{} }
+func (s SpanID) String() string { return hex.EncodeToString(s[:]) }
+
+// FlagSampled 是 trace-flags 中表示链路被采样的位
+const FlagSampled byte = 0x01
+
+// SpanContext 是在服务之间传播的追踪上下文
+type SpanContext struct {
+	TraceID    TraceID
+	SpanID     SpanID
+	Flags      byte   // trace-flags
+	TraceState string // 校验过的 tracestate，各个厂商的额外数据
+	Remote     bool   // 从请求首部解析得到
+}
+
+func (sc SpanContext) IsValid() bool {
+	return sc.TraceID.IsValid() && sc.SpanID.IsValid()
+}
+
+func (sc SpanContext) IsSampled() bool {
+	return sc.Flags&FlagSampled != 0
+}
+
+// SpanKind 是 span 的类型
+type SpanKind int
+
+const (
+	SpanKindServer SpanKind = iota // 处理收到的请求
+	SpanKindClient                 // 发送请求给下游服务
+)
+
+func (k SpanKind) String() string {
+	if k == SpanKindClient {
+		return "client"
+	}
+	return "server"
+}
+
+// Attribute 是 span 的一个属性，E.g. http.response.status_code: 200
+type Attribute struct {
+	Key   string
+	Value interface{}
+}
+
+// Span 是链路中的一次操作，End 之后交给 Exporter
+type Span struct {
+	Name         string
+	Kind         SpanKind
+	SpanContext  SpanContext
+	Parent       SpanContext // 没有父 span 时无效
+	Start, End   time.Time
+	Attributes   []Attribute
+	Error        bool   // 操作失败 E.g. 5xx 响应
+	ErrorMessage string // 失败的原因
+
+	mu     sync.Mutex
+	ended  bool
+	tracer *Tracer
+}
+
+// SetAttribute 设置属性，key 已经存在时覆盖
+func (s *Span) SetAttribute(key string, value interface{}) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for i := range s.Attributes {
+		if s.Attributes[i].Key == key {
+			s.Attributes[i].Value = value
+			return
+		}
+	}
+	s.Attributes = append(s.Attributes, Attribute{key, value})
+}
+
+// Attribute 返回 key 对应的属性值，不存在时返回 nil
+func (s *Span) Attribute(key string) interface{} {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for _, a := range s.Attributes {
+		if a.Key == key {
+			return a.Value
+		}
+	}
+	return nil
+}
+
+// SetError 将 span 标记为失败
+func (s *Span) SetError(msg string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.Error = true
+	s.ErrorMessage = msg
+}
+
+// Finish 记录结束时间，被采样的 span 交给 Exporter，多次调用只有第一次生效
+func (s *Span) Finish() {
+	s.mu.Lock()
+	if s.ended {
+		s.mu.Unlock()
+		return
+	}
+	s.ended = true
+	s.End = time.Now()
+	s.mu.Unlock()
+
+	if s.SpanContext.IsSampled() && s.tracer.exporter != nil {
+		s.tracer.exporter.ExportSpan(s)
+	}
+}
+
+// Duration 返回 span 的耗时，没有结束时为 0
+func (s *Span) Duration() time.Duration {
+	if s.End.IsZero() {
+		return 0
+	}
+	return s.End.Sub(s.Start)
+}
+
+// Exporter 接收结束的 span，会被多个协程并发调用
+type Exporter interface {
+	ExportSpan(s *Span)
+}
+
+// Tracer 创建 span
+type Tracer struct {
+	exporter Exporter
+}
+
+// NewTracer 创建把 span 交给 exp 的 Tracer，exp 为 nil 时只传播追踪上下文
+func NewTracer(exp Exporter) *Tracer {
+	return &Tracer{exporter: exp}
+}
+
+type ctxKey int
+
+const (
+	spanKey ctxKey = iota
+	remoteKey
+)
+
+// ContextWithSpan 返回带有 span 的 ctx
+func ContextWithSpan(ctx context.Context, s *Span) context.Context {
+	return context.WithValue(ctx, spanKey, s)
+}
+
+// SpanFromContext 返回 ctx 中当前的 span，没有时返回 nil
+func SpanFromContext(ctx context.Context) *Span {
+	s, _ := ctx.Value(spanKey).(*Span)
+	return s
+}
+
+// ContextWithRemoteSpanContext 返回带有从上游解析得到的 sc 的 ctx，之后创建的 span 以其为父
+func ContextWithRemoteSpanContext(ctx context.Context, sc SpanContext) context.Context {
+	return context.WithValue(ctx, remoteKey, sc)
+}
+
+// SpanContextFromContext 返回 ctx 中当前 span 的 SpanContext，没有当前 span 时返回上游的 SpanContext
+func SpanContextFromContext(ctx context.Context) SpanContext {
+	if s := SpanFromContext(ctx); s != nil {
+		return s.SpanContext
+	}
+	sc, _ := ctx.Value(remoteKey).(SpanContext)
+	return sc
+}
+
+// Start 创建 span，ctx 中有当前 span 或者上游的 SpanContext 时为其子 span，否则开始新的链路
+// 子 span 继承 trace-flags 以及 tracestate，新的链路总是被采样
+func (t *Tracer) Start(ctx context.Context, name string, kind SpanKind) (context.Context, *Span) {
+	s := &Span{Name: name, Kind: kind, Start: time.Now(), tracer: t}
+
+	parent := SpanContextFromContext(ctx)
+	if parent.IsValid() {
+		s.Parent = parent
+		s.SpanContext = SpanContext{TraceID: parent.TraceID, Flags: parent.Flags, TraceState: parent.TraceState}
+	} else {
+		s.SpanContext = SpanContext{TraceID: newTraceID(), Flags: FlagSampled}
+	}
+	s.SpanContext.SpanID = newSpanID()
+
+	return ContextWithSpan(ctx, s), s
+}
+
+func newTraceID() (id TraceID) {
+	for !id.IsValid() {
+		rand.Read(id[:])
+	}
+	return id
+}
+
+func newSpanID() (id SpanID) {
+	for !id.IsValid() {
+		rand.Read(id[:])
+	}
+	return id
+}
+
+// ErrInvalidTraceparent 由 ParseTraceparent 返回
+var ErrInvalidTraceparent = errors.New("tracing: invalid traceparent")
+
+// ParseTraceparent 解析 traceparent 首部
+// E.g. 00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01
+// 版本号为 00 时长度必须正好为 55；更高的版本只解析前 4 个字段，之后的内容需要以 - 分隔
+func ParseTraceparent(s string) (SpanContext, error) {
+	var sc SpanContext
+	if len(s) < 55 || s[2] != '-' || s[35] != '-' || s[52] != '-' {
+		return sc, ErrInvalidTraceparent
+	}
+
+	version, ok := decodeHex(s[:2])
+	if !ok || version[0] == 0xff {
+		return sc, ErrInvalidTraceparent
+	}
+	if version[0] == 0 && len(s) != 55 || len(s) > 55 && s[55] != '-' {
+		return sc, ErrInvalidTraceparent
+	}
+
+	traceID, ok1 := decodeHex(s[3:35])
+	spanID, ok2 := decodeHex(s[36:52])
+	flags, ok3 := decodeHex(s[53:55])
+	if !ok1 || !ok2 || !ok3 {
+		return sc, ErrInvalidTraceparent
+	}
+	copy(sc.TraceID[:], traceID)
+	copy(sc.SpanID[:], spanID)
+	if !sc.IsValid() {
+		return sc, ErrInvalidTraceparent
+	}
+
+	// 未知版本的其他标志位没有意义，只保留 sampled
+	sc.Flags = flags[0]
+	if version[0] != 0 {
+		sc.Flags &= FlagSampled
+	}
+	sc.Remote = true
+
+	return sc, nil
+}
+
+// decodeHex 解码小写的十六进制，大写字母不合法
+func decodeHex(s string) ([]byte, bool) {
+	for i := 0; i < len(s); i++ {
+		if c := s[i]; !('0' <= c && c <= '9' || 'a' <= c && c <= 'f') {
+			return nil, false
+		}
+	}
+
+	b, err := hex.DecodeString(s)
+	return b, err == nil
+}
+
+// FormatTraceparent 返回 sc 的 traceparent 首部值
+func FormatTraceparent(sc SpanContext) string {
+	return "00-" + sc.TraceID.String() + "-" + sc.SpanID.String() + "-" + hex.EncodeToString([]byte{sc.Flags})
+}
@@ -0,0 +1,193 @@
+package tracing
+
+import (
+	"io"
+	"net/http"
+	"strings"
+	"testing"
+
+	"build-HTTP-from-scracth/pkg/httptoy"
+	"build-HTTP-from-scracth/pkg/httptoy/httptoytest"
+	"build-HTTP-from-scracth/pkg/httptoy/requestid"
+)
+
+func TestParseTraceparent(t *testing.T) {
+	tests := []struct {
+		in    string
+		valid bool
+		flags byte
+	}{
+		{"00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01", true, 1},
+		{"00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-00", true, 0},
+		// 未来的版本可以追加字段，并且只保留 sampled 标志
+		{"cc-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-ff-what-the-future-will-be", true, 1},
+		{"cc-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-09", true, 1},
+		{"cc-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-09x", false, 0},
+		{"00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01-extra", false, 0},
+		{"ff-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01", false, 0},
+		{"00-4BF92F3577B34DA6A3CE929D0E0E4736-00f067aa0ba902b7-01", false, 0},
+		{"00-00000000000000000000000000000000-00f067aa0ba902b7-01", false, 0},
+		{"00-4bf92f3577b34da6a3ce929d0e0e4736-0000000000000000-01", false, 0},
+		{"00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7", false, 0},
+		{"00_4bf92f3577b34da6a3ce929d0e0e4736_00f067aa0ba902b7_01", false, 0},
+		{"00-4bf92f3577b34da6a3ce929d0e0e473g-00f067aa0ba902b7-01", false, 0},
+	}
+
+	for _, tt := range tests {
+		sc, err := ParseTraceparent(tt.in)
+		if (err == nil) != tt.valid {
+			t.Errorf("ParseTraceparent(%q) error = %v", tt.in, err)
+			continue
+		}
+		if !tt.valid {
+			continue
+		}
+		if sc.TraceID.String() != "4bf92f3577b34da6a3ce929d0e0e4736" || sc.SpanID.String() != "00f067aa0ba902b7" || sc.Flags != tt.flags || !sc.Remote {
+			t.Errorf("ParseTraceparent(%q) = %+v", tt.in, sc)
+		}
+		if tt.in[:2] == "00" && FormatTraceparent(sc) != tt.in {
+			t.Errorf("FormatTraceparent = %q, want %q", FormatTraceparent(sc), tt.in)
+		}
+	}
+}
+
+func TestParseTracestate(t *testing.T) {
+	tests := []struct {
+		in, want string
+		valid    bool
+	}{
+		{"rojo=00f067aa0ba902b7,congo=t61rcWkgMzE", "rojo=00f067aa0ba902b7,congo=t61rcWkgMzE", true},
+		{" rojo=1 ,\t, congo=2", "rojo=1,congo=2", true},
+		{"fw529a3039@dt=abc,0tenant@sys-1=x", "fw529a3039@dt=abc,0tenant@sys-1=x", true},
+		{"", "", true},
+		{manyMembers(32), manyMembers(32), true},
+		{"rojo=1,rojo=2", "", false},
+		{"Rojo=1", "", false},
+		{"1abc=1", "", false},
+		{"rojo", "", false},
+		{"rojo=a=b", "", false},
+		{"rojo=\x01", "", false},
+		{"t@toolongsystemid=1", "", false},
+		{manyMembers(33), "", false},
+	}
+
+	for _, tt := range tests {
+		got, ok := ParseTracestate(tt.in)
+		if ok != tt.valid || got != tt.want {
+			t.Errorf("ParseTracestate(%q) = %q, %v", tt.in, got, ok)
+		}
+	}
+}
+
+func manyMembers(n int) string {
+	var members []string
+	for i := 0; i < n; i++ {
+		members = append(members, "k"+strings.Repeat("a", i)+"=v")
+	}
+	return strings.Join(members, ",")
+}
+
+func TestPropagation(t *testing.T) {
+	exp := NewInMemoryExporter()
+	tracer := NewTracer(exp)
+
+	// backend 记录收到的追踪首部
+	var backendHeader httptoy.Header
+	backendMux := httptoy.NewServeMux()
+	backendMux.HandleFunc("/api", func(rw httptoy.ResponseWriter, req *httptoy.Request) {
+		backendHeader = req.Header
+		io.WriteString(rw, "ok")
+	})
+	backend := httptoytest.NewServer(tracer.Middleware(backendMux))
+	defer backend.Close()
+
+	// frontend 通过 Transport 请求 backend
+	client := &http.Client{Transport: tracer.Transport(backend.Client().Transport)}
+	frontMux := httptoy.NewServeMux()
+	frontMux.HandleFunc("/front", func(rw httptoy.ResponseWriter, req *httptoy.Request) {
+		outReq, _ := http.NewRequestWithContext(req.Context(), "GET", backend.URL+"/api", nil)
+		resp, err := client.Do(outReq)
+		if err != nil {
+			t.Error(err)
+			rw.WriteHeader(502)
+			return
+		}
+		resp.Body.Close()
+		rw.WriteHeader(503)
+	})
+	// requestid 向 frontMux 传递 WithContext 的副本，路由仍然记录在 server span 中
+	front := httptoytest.NewServer(tracer.Middleware(requestid.New(frontMux, requestid.Options{})))
+	defer front.Close()
+
+	req, _ := http.NewRequest("GET", front.URL+"/front", nil)
+	req.Header.Set("traceparent", "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01")
+	req.Header.Add("tracestate", "rojo=1")
+	req.Header.Add("tracestate", "congo=2")
+	resp, err := front.Client().Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+
+	// 按照结束的顺序：backend 的 server span、client span、frontend 的 server span
+	spans := exp.Spans()
+	if len(spans) != 3 {
+		t.Fatalf("got %d spans", len(spans))
+	}
+	server, client2, frontSpan := spans[0], spans[1], spans[2]
+
+	for _, s := range spans {
+		if s.SpanContext.TraceID.String() != "4bf92f3577b34da6a3ce929d0e0e4736" || s.SpanContext.TraceState != "rojo=1,congo=2" {
+			t.Errorf("span %q: %+v", s.Name, s.SpanContext)
+		}
+		if s.Duration() <= 0 {
+			t.Errorf("span %q: duration %v", s.Name, s.Duration())
+		}
+	}
+	if frontSpan.Parent.SpanID.String() != "00f067aa0ba902b7" || !frontSpan.Parent.Remote {
+		t.Errorf("front parent = %+v", frontSpan.Parent)
+	}
+	if client2.Kind != SpanKindClient || client2.Parent.SpanID != frontSpan.SpanContext.SpanID {
+		t.Errorf("client span %+v", client2)
+	}
+	if server.Parent.SpanID != client2.SpanContext.SpanID {
+		t.Errorf("backend parent = %v, want %v", server.Parent.SpanID, client2.SpanContext.SpanID)
+	}
+	if got := backendHeader.Get("Traceparent"); got != FormatTraceparent(client2.SpanContext) {
+		t.Errorf("backend traceparent = %q", got)
+	}
+
+	if frontSpan.Name != "GET /front" || frontSpan.Attribute("http.route") != "/front" ||
+		frontSpan.Attribute("http.response.status_code") != 503 || !frontSpan.Error {
+		t.Errorf("front span %q %+v error=%v", frontSpan.Name, frontSpan.Attributes, frontSpan.Error)
+	}
+	if server.Name != "GET /api" || server.Attribute("http.response.status_code") != 200 || server.Error {
+		t.Errorf("backend span %q %+v", server.Name, server.Attributes)
+	}
+}
+
+func TestNotSampled(t *testing.T) {
+	exp := NewInMemoryExporter()
+	tracer := NewTracer(exp)
+
+	var sc SpanContext
+	h := tracer.Middleware(httptoy.HandlerFunc(func(rw httptoy.ResponseWriter, req *httptoy.Request) {
+		sc = SpanContextFromContext(req.Context())
+	}))
+
+	// 没有被采样的链路依旧传播，但是不导出
+	req := httptoytest.NewRequest("GET", "/", nil)
+	req.Header.Set("traceparent", "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-00")
+	h.ServeHTTP(httptoytest.NewRecorder(), req)
+	if sc.TraceID.String() != "4bf92f3577b34da6a3ce929d0e0e4736" || sc.IsSampled() || len(exp.Spans()) != 0 {
+		t.Errorf("span context %+v, exported %d", sc, len(exp.Spans()))
+	}
+
+	// 不合法的 traceparent 开始新的链路
+	req = httptoytest.NewRequest("GET", "/", nil)
+	req.Header.Set("traceparent", "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-0")
+	h.ServeHTTP(httptoytest.NewRecorder(), req)
+	if sc.TraceID.String() == "4bf92f3577b34da6a3ce929d0e0e4736" || !sc.IsValid() || len(exp.Spans()) != 1 || exp.Spans()[0].Parent.IsValid() {
+		t.Errorf("span context %+v", sc)
+	}
+}
@@ -0,0 +1,99 @@
+package httptoy
+
+import (
+	"net/url"
+	"sort"
+	"strings"
+)
+
+// values 针对 queryString 以及 x-www-form-urlencoded 表单的解析
+
+// Values 用来储存查询参数以及表单的键值对，同一个键可以有多个值
+// E.g. ?tag=a&tag=b -> Values{"tag": {"a", "b"}}
+type Values map[string][]string
+
+// Get 返回 key 的第一个值，不存在则返回空字符串
+func (v Values) Get(key string) string {
+	if vs := v[key]; len(vs) > 0 {
+		return vs[0]
+	}
+
+	return ""
+}
+
+// Set 将 key 的值替换为 val
+func (v Values) Set(key, val string) {
+	v[key] = []string{val}
+}
+
+// Add 在 key 已有的值之后追加 val
+func (v Values) Add(key, val string) {
+	v[key] = append(v[key], val)
+}
+
+func (v Values) Del(key string) {
+	delete(v, key)
+}
+
+func (v Values) Has(key string) bool {
+	_, ok := v[key]
+	return ok
+}
+
+// Encode 按照键的顺序编码成 x-www-form-urlencoded 格式
+// E.g. Values{"name": {"a b"}, "tag": {"x", "y"}} -> name=a+b&tag=x&tag=y
+func (v Values) Encode() string {
+	keys := make([]string, 0, len(v))
+	for k := range v {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	var sb strings.Builder
+	for _, k := range keys {
+		key := url.QueryEscape(k)
+		for _, val := range v[k] {
+			if sb.Len() > 0 {
+				sb.WriteByte('&')
+			}
+			sb.WriteString(key)
+			sb.WriteByte('=')
+			sb.WriteString(url.QueryEscape(val))
+		}
+	}
+
+	return sb.String()
+}
+
+// ParseQuery 解析 x-www-form-urlencoded 格式的字符串，键与值都会进行百分号解码，'+' 解码为空格
+// 解码失败的键值对会被跳过，返回遇到的第一个错误
+// E.g. name=a%20b&tag=x&tag=y -> Values{"name": {"a b"}, "tag": {"x", "y"}}
+func ParseQuery(query string) (Values, error) {
+	v := make(Values)
+	var firstErr error
+
+	for query != "" {
+		var part string
+		part, query, _ = strings.Cut(query, "&")
+		if part == "" {
+			continue
+		}
+
+		// 没有 '=' 时视为空值，E.g. ?debug -> debug: ""
+		key, val, _ := strings.Cut(part, "=")
+		key, err := url.QueryUnescape(key)
+		if err == nil {
+			val, err = url.QueryUnescape(val)
+		}
+		if err != nil {
+			if firstErr == nil {
+				firstErr = err
+			}
+			continue
+		}
+
+		v[key] = append(v[key], val)
+	}
+
+	return v, firstErr
+}
@@ -0,0 +1,120 @@
+package httptoy
+
+import (
+	"bufio"
+	"errors"
+	"io"
+	"net"
+)
+
+// WrapWriter 包装 ResponseWriter，记录 handler 最终写入的状态码以及报文主体的字节数，供中间件使用
+// E.g.
+//
+//	ww := httptoy.NewWrapWriter(rw)
+//	next.ServeHTTP(ww, req)
+//	log.Println(ww.Status(), ww.BytesWritten())
+type WrapWriter struct {
+	ResponseWriter
+
+	status      int
+	bytes       int64
+	wroteHeader bool
+}
+
+// NewWrapWriter 包装 rw，rw 本身已经是 *WrapWriter 时直接返回
+func NewWrapWriter(rw ResponseWriter) *WrapWriter {
+	if ww, ok := rw.(*WrapWriter); ok {
+		return ww
+	}
+
+	return &WrapWriter{ResponseWriter: rw}
+}
+
+// WriteHeader 只有第一次调用生效，与 Response.WriteHeader 相同
+func (w *WrapWriter) WriteHeader(statusCode int) {
+	if !w.wroteHeader {
+		w.status = statusCode
+		w.wroteHeader = true
+	}
+
+	w.ResponseWriter.WriteHeader(statusCode)
+}
+
+func (w *WrapWriter) Write(p []byte) (int, error) {
+	if !w.wroteHeader {
+		w.WriteHeader(200)
+	}
+
+	n, err := w.ResponseWriter.Write(p)
+	if sendsBody(w.ResponseWriter) {
+		w.bytes += int64(n)
+	}
+	return n, err
+}
+
+// ReadFrom 保留被包装的 Response 的 sendfile 优化
+func (w *WrapWriter) ReadFrom(src io.Reader) (n int64, err error) {
+	if !w.wroteHeader {
+		w.WriteHeader(200)
+	}
+
+	if rf, ok := w.ResponseWriter.(io.ReaderFrom); ok {
+		n, err = rf.ReadFrom(src)
+	} else {
+		// 隐藏 WrapWriter 的 ReadFrom，防止 io.Copy 递归调用
+		n, err = io.Copy(struct{ io.Writer }{w.ResponseWriter}, src)
+	}
+	if sendsBody(w.ResponseWriter) {
+		w.bytes += n
+	}
+
+	return n, err
+}
+
+// bodySender 由知道报文主体是否真正发送的 ResponseWriter 实现，E.g. Response 丢弃 HEAD 请求的报文主体
+type bodySender interface {
+	sendBody() bool
+}
+
+// sendsBody 沿着 Unwrap 找到 bodySender，判断写入的报文主体是否会被发送，找不到时认为会发送
+func sendsBody(rw ResponseWriter) bool {
+	for {
+		if bs, ok := rw.(bodySender); ok {
+			return bs.sendBody()
+		}
+		u, ok := rw.(interface{ Unwrap() ResponseWriter })
+		if !ok {
+			return true
+		}
+		rw = u.Unwrap()
+	}
+}
+
+// Hijack 调用被包装的 ResponseWriter 的 Hijack，其没有实现 Hijacker 时返回错误
+func (w *WrapWriter) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	if h, ok := w.ResponseWriter.(Hijacker); ok {
+		return h.Hijack()
+	}
+
+	return nil, nil, errors.New("httptoy: ResponseWriter does not implement Hijacker")
+}
+
+// Status 返回响应的状态码，handler 没有调用 WriteHeader 以及 Write 时为默认的 200
+func (w *WrapWriter) Status() int {
+	if !w.wroteHeader {
+		return 200
+	}
+
+	return w.status
+}
+
+// BytesWritten 返回发送的报文主体字节数，不包括首部以及 chunk 编码
+// HEAD 请求的响应写入的数据会被丢弃，不计算在内
+func (w *WrapWriter) BytesWritten() int64 {
+	return w.bytes
+}
+
+// Unwrap 返回被包装的 ResponseWriter
+func (w *WrapWriter) Unwrap() ResponseWriter {
+	return w.ResponseWriter
+}
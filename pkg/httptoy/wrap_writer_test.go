@@ -0,0 +1,44 @@
+package httptoy
+
+import (
+	"io"
+	"strings"
+	"testing"
+)
+
+func TestWrapWriter(t *testing.T) {
+	var ww *WrapWriter
+	h := testHandler(func(rw ResponseWriter, req *Request) {
+		ww = NewWrapWriter(rw)
+		if NewWrapWriter(ww) != ww {
+			t.Error("NewWrapWriter should not wrap twice")
+		}
+
+		ww.Header().Set("Content-Type", "text/plain")
+		ww.WriteHeader(201)
+		ww.WriteHeader(500)
+		io.WriteString(ww, "hello, ")
+		// io.Copy 调用 ReadFrom，仍然由 Response 处理
+		io.Copy(ww, strings.NewReader("world"))
+	})
+
+	got := serveRaw(t, h, "GET / HTTP/1.1\r\nConnection: close\r\n\r\n")
+	want := "HTTP/1.1 201 Created\r\nConnection: close\r\nContent-Length: 12\r\nContent-Type: text/plain\r\n\r\nhello, world"
+	if got != want {
+		t.Fatalf("got %q, want %q", got, want)
+	}
+	if ww.Status() != 201 || ww.BytesWritten() != 12 {
+		t.Errorf("status %d, bytes %d", ww.Status(), ww.BytesWritten())
+	}
+
+	// HEAD 请求的报文主体被丢弃，不计算在内
+	got = serveRaw(t, h, "HEAD / HTTP/1.1\r\nConnection: close\r\n\r\n")
+	if !strings.HasSuffix(got, "\r\n\r\n") || ww.Status() != 201 || ww.BytesWritten() != 0 {
+		t.Errorf("HEAD: got %q, status %d, bytes %d", got, ww.Status(), ww.BytesWritten())
+	}
+
+	// handler 没有写入任何数据时为默认的 200
+	if ww = NewWrapWriter(&Response{}); ww.Status() != 200 || ww.BytesWritten() != 0 {
+		t.Errorf("status %d, bytes %d", ww.Status(), ww.BytesWritten())
+	}
+}
@@ -1,13 +1,18 @@
 package httptoy_test
 
 import (
+	"bufio"
 	"build-HTTP-from-scracth/pkg/httptoy"
+	"build-HTTP-from-scracth/pkg/httptoy/httptoytest"
 	"bytes"
 	"fmt"
 	"io"
 	"io/ioutil"
-	"log"
+	"mime/multipart"
+	"net"
+	"net/http"
 	"os"
+	"path/filepath"
 	"strconv"
 	"strings"
 	"testing"
@@ -44,155 +49,168 @@ func TestHex(t *testing.T) {
 
 }
 
-// test http server
-type testHandler struct {
-	F func(rw httptoy.ResponseWriter, req *httptoy.Request)
-}
+// get 请求 url 并返回响应以及报文主体
+func get(t *testing.T, ts *httptoytest.Server, req *http.Request) (*http.Response, string) {
+	t.Helper()
+
+	resp, err := ts.Client().Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
 
-func (th *testHandler) ServeHTTP(rw httptoy.ResponseWriter, req *httptoy.Request) {
-	th.F(rw, req)
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return resp, string(body)
 }
 
 // TestParseHeaderInfo 测试 request 解析 请求行，Header 信息
 func TestParseHeaderInfo(t *testing.T) {
-	fmt.Println("localhost:8080")
-	th := new(testHandler)
-	th.F = func(rw httptoy.ResponseWriter, req *httptoy.Request) {
-		// 用户的头部信息保存到buff中
-		buff := &bytes.Buffer{}
+	ts := httptoytest.NewServer(httptoy.HandlerFunc(func(rw httptoy.ResponseWriter, req *httptoy.Request) {
 		// 测试Request的解析
-		fmt.Fprintf(buff, "[query]name=%s\n", req.Query("name"))
-		fmt.Fprintf(buff, "[query]token=%s\n", req.Query("token"))
-		fmt.Fprintf(buff, "[cookie]foo1=%s\n", req.Cookie("foo1"))
-		fmt.Fprintf(buff, "[cookie]foo2=%s\n", req.Cookie("foo2"))
-		fmt.Fprintf(buff, "[Header]User-Agent=%s\n", req.Header.Get("User-Agent"))
-		fmt.Fprintf(buff, "[Header]Proto=%s\n", req.Proto)
-		fmt.Fprintf(buff, "[Header]Method=%s\n", req.Method)
-		fmt.Fprintf(buff, "[Addr]Addr=%s\n", req.RemoteAddr)
-
-		fmt.Fprintf(buff, "[Request] Header:%v\n", req.Header)
-
-		//手动发送响应报文
-		io.WriteString(rw, "HTTP/1.1 200 OK\r\n")
-		io.WriteString(rw, fmt.Sprintf("Content-Length: %d\r\n", buff.Len()))
-		io.WriteString(rw, "\r\n")
-		io.Copy(rw, buff) //将buff缓存数据发送给客户端
-
+		fmt.Fprintf(rw, "[query]name=%s\n", req.Query("name"))
+		fmt.Fprintf(rw, "[query]token=%s\n", req.Query("token"))
+		fmt.Fprintf(rw, "[cookie]foo1=%s\n", req.Cookie("foo1"))
+		fmt.Fprintf(rw, "[cookie]foo2=%s\n", req.Cookie("foo2"))
+		fmt.Fprintf(rw, "[Header]User-Agent=%s\n", req.Header.Get("User-Agent"))
+		fmt.Fprintf(rw, "[Header]Proto=%s\n", req.Proto)
+		fmt.Fprintf(rw, "[Header]Method=%s\n", req.Method)
+		fmt.Fprintf(rw, "[Addr]Addr=%t\n", req.RemoteAddr != "")
+	}))
+	defer ts.Close()
+
+	req, _ := http.NewRequest("GET", ts.URL+"/?name=gu&token=abc", nil)
+	req.Header.Set("User-Agent", "httptoy-test")
+	req.Header.Set("Cookie", "foo1=bar1; foo2=bar2")
+	_, body := get(t, ts, req)
+
+	want := "[query]name=gu\n[query]token=abc\n[cookie]foo1=bar1\n[cookie]foo2=bar2\n" +
+		"[Header]User-Agent=httptoy-test\n[Header]Proto=HTTP/1.1\n[Header]Method=GET\n[Addr]Addr=true\n"
+	if body != want {
+		t.Fatalf("got:\n%s\nwant:\n%s", body, want)
 	}
-
-	svr := &httptoy.Server{
-		Addr:    "127.0.0.1:8080",
-		Handler: th,
-	}
-
-	panic(svr.ListenAndServe())
 }
 
 // TestRequestBody 测试 request body信息 读写流
 // 测试1： limitReader
-// curl -H "Content-Length: 43" -d "hello, this is chunked message from client!" http://127.0.0.1:8080 -i
 // 测试2： chunkReader
-// curl -H "Transfer-Encoding: chunked" -H "Content-Length: 13" -d "hello, this is chunked message from client!" http://127.0.0.1:8080 -i
 func TestRequestBody(t *testing.T) {
-	th := new(testHandler)
-	th.F = func(rw httptoy.ResponseWriter, req *httptoy.Request) {
-
+	ts := httptoytest.NewServer(httptoy.HandlerFunc(func(rw httptoy.ResponseWriter, req *httptoy.Request) {
 		buf, err := ioutil.ReadAll(req.Body)
 		if err != nil {
+			rw.WriteHeader(http.StatusBadRequest)
 			return
 		}
-
-		io.WriteString(rw, "HTTP/1.1 200 OK\r\n")
-		io.WriteString(rw, fmt.Sprintf("Content-Length: %d\r\n", len(buf)))
-		io.WriteString(rw, "\r\n")
 		rw.Write(buf)
+	}))
+	defer ts.Close()
 
-		// 查看 header
-		io.WriteString(rw, "\r\n")
-		buff := &bytes.Buffer{}
-		_, err = fmt.Fprint(buff, "\nHeader:", req.Header)
-		if err != nil {
-			fmt.Println(err)
-		}
-		fmt.Println(req.Header)
+	msg := "hello, this is chunked message from client!"
+
+	// limitReader
+	req, _ := http.NewRequest("POST", ts.URL, strings.NewReader(msg))
+	if _, body := get(t, ts, req); body != msg {
+		t.Fatalf("limitReader: got %q", body)
+	}
+
+	// chunkReader
+	conn, err := net.Dial("tcp", ts.Listener.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	fmt.Fprintf(conn, "POST / HTTP/1.1\r\nHost: test\r\nConnection: close\r\nTransfer-Encoding: chunked\r\nContent-Length: 13\r\n\r\n"+
+		"%x\r\n%s\r\n0\r\n\r\n", len(msg), msg)
+	resp, err := http.ReadResponse(bufio.NewReader(conn), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	body, _ := ioutil.ReadAll(resp.Body)
+	if string(body) != msg {
+		t.Fatalf("chunkReader: got %q", body)
+	}
+}
+
+// multipartRequest 构建 username、password 以及 file1、file2 两个文件的表单请求
+func multipartRequest(t *testing.T, url string) *http.Request {
+	t.Helper()
 
-		_, err = io.Copy(rw, buff)
+	var buf bytes.Buffer
+	mw := multipart.NewWriter(&buf)
+	mw.WriteField("username", "gu")
+	mw.WriteField("password", "123")
+	for _, name := range []string{"1", "2"} {
+		w, err := mw.CreateFormFile("file"+name, name+".txt")
 		if err != nil {
-			fmt.Println(err)
+			t.Fatal(err)
 		}
+		io.WriteString(w, "this is "+name+".txt!")
 	}
+	mw.Close()
 
-	svr := &httptoy.Server{
-		Addr:    "127.0.0.1:8080",
-		Handler: th,
-	}
-	panic(svr.ListenAndServe())
+	req, _ := http.NewRequest("POST", url, &buf)
+	req.Header.Set("Content-Type", mw.FormDataContentType())
+
+	return req
 }
 
-// TestMultipartReader 用于测试 MultipartReader
-// 任意目录下传输 1.txt, 2.txt.
-// cmd：curl -F "username=gu" -F "password=123" -F "file1=@1.txt" -F "file2=@2.txt" http://127.0.0.1:8080
+// TestMultipartReader 用于测试 MultipartReader，文本 part 写回响应，文件 part 保存到临时目录
 func TestMultipartReader(t *testing.T) {
+	dir := t.TempDir()
 
-	th := new(testHandler)
-	th.F = func(rw httptoy.ResponseWriter, req *httptoy.Request) {
+	ts := httptoytest.NewServer(httptoy.HandlerFunc(func(rw httptoy.ResponseWriter, req *httptoy.Request) {
 		mr, err := req.MultipartReader()
 		if err != nil {
-			log.Println(err)
+			rw.WriteHeader(http.StatusBadRequest)
 			return
 		}
 
-		var part *httptoy.Part
-	label:
-		for {
-			part, err = mr.NextPart()
-			if err != nil {
-				break
-			}
+		err = mr.Walk(func(part *httptoy.Part) error {
 			// 判断是文本part还是文件part
-			switch part.FileName() {
-			case "": //文本
-				fmt.Printf("FormName=%s, FormData:\n", part.FormName())
-				// 输出到终端
-				if _, err = io.Copy(os.Stdout, part); err != nil {
-					break label
-				}
-				fmt.Println()
-			default: //文件
-				// 打印文件信息
-				fmt.Printf("FormName=%s, FileName=%s\n", part.FormName(), part.FileName())
-
-				// 创建文件
-				var file *os.File
-				if file, err = os.Create(part.FileName()); err != nil {
-					break label
-				}
-				if _, err = io.Copy(file, part); err != nil {
-					file.Close()
-					break label
-				}
-				file.Close()
+			if part.FileName() == "" {
+				fmt.Fprintf(rw, "FormName=%s, FormData=", part.FormName())
+				_, err := io.Copy(rw, part)
+				io.WriteString(rw, "\n")
+				return err
 			}
-		}
-		if err != io.EOF {
-			fmt.Println(err)
-		}
 
-		// 发送响应报文
-		io.WriteString(rw, "HTTP/1.1 200 OK\r\n")
-		io.WriteString(rw, fmt.Sprintf("Content-Length: %d\r\n", 0))
-		io.WriteString(rw, "\r\n")
+			// 文件保存到临时目录
+			fmt.Fprintf(rw, "FormName=%s, FileName=%s\n", part.FormName(), part.FileName())
+			file, err := os.Create(filepath.Join(dir, part.FileName()))
+			if err != nil {
+				return err
+			}
+			defer file.Close()
+
+			_, err = io.Copy(file, part)
+			return err
+		})
+		if err != nil {
+			fmt.Fprintln(rw, err)
+		}
+	}))
+	defer ts.Close()
+
+	_, body := get(t, ts, multipartRequest(t, ts.URL))
+	want := "FormName=username, FormData=gu\nFormName=password, FormData=123\n" +
+		"FormName=file1, FileName=1.txt\nFormName=file2, FileName=2.txt\n"
+	if body != want {
+		t.Fatalf("got:\n%s\nwant:\n%s", body, want)
 	}
 
-	svr := &httptoy.Server{
-		Addr:    "127.0.0.1:8080",
-		Handler: th,
+	for _, name := range []string{"1", "2"} {
+		b, err := ioutil.ReadFile(filepath.Join(dir, name+".txt"))
+		if err != nil || string(b) != "this is "+name+".txt!" {
+			t.Errorf("%s.txt = %q, %v", name, b, err)
+		}
 	}
-	panic(svr.ListenAndServe())
 }
 
-// 测试FormFile。 将文件文本输出到终端
-// cmd : curl -F "file1=@1.txt" http://127.0.0.1:8080/test1
+// 测试FormFile。 将文件文本写回响应
 func handleTest1(rw httptoy.ResponseWriter, req *httptoy.Request) (err error) {
 	fh, err := req.FormFile("file1")
 	if err != nil {
@@ -207,105 +225,109 @@ func handleTest1(rw httptoy.ResponseWriter, req *httptoy.Request) (err error) {
 	if err != nil {
 		return
 	}
-	fmt.Printf("%s\n", buf)
+	fmt.Fprintf(rw, "%s", buf)
 	return
 }
 
-// 测试Save。 将文件保存到硬盘
-// cmd :  curl -F "file1=@1.txt" -F "file2=@2.txt" http://127.0.0.1/test2
-func handleTest2(rw httptoy.ResponseWriter, req *httptoy.Request) (err error) {
-	if err = req.ParseForm(); err != nil {
-		return
-	}
+// 测试Save。 将文件保存到 dir 目录
+func handleTest2(dir string) func(rw httptoy.ResponseWriter, req *httptoy.Request) error {
+	return func(rw httptoy.ResponseWriter, req *httptoy.Request) (err error) {
+		if err = req.ParseForm(); err != nil {
+			return
+		}
 
-	mr := req.MultipartForm
-	for _, fh := range mr.File {
-		err = fh.Save(fh.Filename)
-		if err == nil {
-			fmt.Printf("file %v saved.\n", fh.Filename)
+		mr := req.MultipartForm
+		for _, fhs := range mr.File {
+			for _, fh := range fhs {
+				err = fh.Save(filepath.Join(dir, fh.Filename))
+				if err == nil {
+					fmt.Fprintf(rw, "file %v saved.\n", fh.Filename)
+				}
+			}
 		}
-	}
 
-	return err
+		return err
+	}
 }
 
 // 测试PostForm
-// cmd : curl -d "foo1=bar1&foo2=bar2" http://127.0.0.1/test3
 func handleTest3(rw httptoy.ResponseWriter, req *httptoy.Request) (err error) {
-
 	value1 := req.PostFormValue("foo1")
 	value2 := req.PostFormValue("foo2")
-	fmt.Printf("post form :%v\n", req.PostForm)
-	fmt.Printf("foo1=%s,foo2=%s\n", value1, value2)
+	fmt.Fprintf(rw, "foo1=%s,foo2=%s", value1, value2)
 
 	return nil
 }
 
 func TestParseForm(t *testing.T) {
-	th := new(testHandler)
-	th.F = func(rw httptoy.ResponseWriter, req *httptoy.Request) {
+	dir := t.TempDir()
+	test2 := handleTest2(dir)
+
+	ts := httptoytest.NewServer(httptoy.HandlerFunc(func(rw httptoy.ResponseWriter, req *httptoy.Request) {
 		var err error
 
 		switch req.URL.Path {
 		case "/test1":
 			err = handleTest1(rw, req)
 		case "/test2":
-			err = handleTest2(rw, req)
+			err = test2(rw, req)
 		case "/test3":
 			err = handleTest3(rw, req)
 		}
 		if err != nil {
-			fmt.Println(err)
+			fmt.Fprint(rw, err)
 		}
+	}))
+	defer ts.Close()
+
+	if _, body := get(t, ts, multipartRequest(t, ts.URL+"/test1")); body != "this is 1.txt!" {
+		t.Errorf("test1: got %q", body)
+	}
 
-		// 手动构建响应报文
-		io.WriteString(rw, "HTTP/1.1 200 OK\r\n")
-		io.WriteString(rw, fmt.Sprintf("Content-Length: %d\r\n", 0))
-		io.WriteString(rw, "\r\n")
+	_, body := get(t, ts, multipartRequest(t, ts.URL+"/test2"))
+	if !strings.Contains(body, "file 1.txt saved.\n") || !strings.Contains(body, "file 2.txt saved.\n") {
+		t.Errorf("test2: got %q", body)
+	}
+	if b, err := ioutil.ReadFile(filepath.Join(dir, "2.txt")); err != nil || string(b) != "this is 2.txt!" {
+		t.Errorf("test2: 2.txt = %q, %v", b, err)
 	}
 
-	svr := &httptoy.Server{
-		Addr:    "127.0.0.1:80",
-		Handler: th,
+	req, _ := http.NewRequest("POST", ts.URL+"/test3", strings.NewReader("foo1=bar1&foo2=bar2"))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	if _, body = get(t, ts, req); body != "foo1=bar1,foo2=bar2" {
+		t.Errorf("test3: got %q", body)
 	}
-	panic(svr.ListenAndServe())
 }
 
 // TestResponse 测试 response 块写入
-// test.html [小于4kb] 以及 test.webp [大于4kb] 存在于 assets 目录中，测试需要放置在该测试文件同目录下
-// cmd : curl http://127.0.0.1
+// 小于4kb 的响应一次性发送并设置 Content-Length，大于4kb 的响应使用 chunk 编码
 func TestResponse(t *testing.T) {
-	th := new(testHandler)
-	th.F = func(rw httptoy.ResponseWriter, req *httptoy.Request) {
+	html := []byte("<html><body><h1>hello world</h1></body></html>")
+	photo := append([]byte("RIFF\x00\x00\x00\x00WEBPVP"), bytes.Repeat([]byte{0xff}, 16<<10)...)
 
-		fmt.Println(req.URL.Path)
+	ts := httptoytest.NewServer(httptoy.HandlerFunc(func(rw httptoy.ResponseWriter, req *httptoy.Request) {
 		// 照片
 		if req.URL.Path == "/photo" {
-			file, err := os.Open("test.webp")
-			if err != nil {
-				fmt.Println("open file error:", err)
-				return
-			}
-			io.Copy(rw, file)
-			file.Close()
+			io.Copy(rw, bytes.NewReader(photo))
 			return
 		}
 
 		// html文件
-		data, err := ioutil.ReadFile("test.html")
-		if err != nil {
-			fmt.Println("readFile test.html error: ", err)
-			return
-		}
-		rw.Write(data)
-
+		rw.Write(html)
+	}))
+	defer ts.Close()
+
+	req, _ := http.NewRequest("GET", ts.URL, nil)
+	resp, body := get(t, ts, req)
+	if body != string(html) || resp.ContentLength != int64(len(html)) || resp.Header.Get("Content-Type") != "text/html; charset=utf-8" {
+		t.Errorf("html: got %d bytes, Content-Length %d, %v", len(body), resp.ContentLength, resp.Header)
 	}
 
-	svr := &httptoy.Server{
-		Addr:    "127.0.0.1:80",
-		Handler: th,
+	req, _ = http.NewRequest("GET", ts.URL+"/photo", nil)
+	resp, body = get(t, ts, req)
+	if body != string(photo) || len(resp.TransferEncoding) != 1 || resp.TransferEncoding[0] != "chunked" || resp.Header.Get("Content-Type") != "image/webp" {
+		t.Errorf("photo: got %d bytes, Transfer-Encoding %v, %v", len(body), resp.TransferEncoding, resp.Header)
 	}
-	panic(svr.ListenAndServe())
 }
 
 type foo2Handler struct{}
@@ -315,9 +337,6 @@ func (*foo2Handler) ServeHTTP(rw httptoy.ResponseWriter, req *httptoy.Request) {
 }
 
 // TestServeMux 测试 ServeMux 包装的路由器
-// cmd 1: curl http://127.0.0.1/foo1
-// cmd 2: curl http://127.0.0.1/foo2
-// cmd 3: curl http://127.0.0.1/foo1/bar1
 func TestServeMux(t *testing.T) {
 
 	// test HandleFunc
@@ -333,5 +352,22 @@ func TestServeMux(t *testing.T) {
 		io.WriteString(rw, "/foo1/bar1")
 	})
 
-	httptoy.ListenAndServe("127.0.0.1:80", nil)
+	ts := httptoytest.NewServer(httptoy.DefaultServeMux)
+	defer ts.Close()
+
+	for path, want := range map[string]string{
+		"/foo1":      "/foo1 check in.",
+		"/foo2":      "/foo2 check in.",
+		"/foo1/bar1": "/foo1/bar1",
+	} {
+		req, _ := http.NewRequest("GET", ts.URL+path, nil)
+		if _, body := get(t, ts, req); body != want {
+			t.Errorf("%s: got %q, want %q", path, body, want)
+		}
+	}
+
+	req, _ := http.NewRequest("GET", ts.URL+"/missing", nil)
+	if resp, _ := get(t, ts, req); resp.StatusCode != http.StatusNotFound {
+		t.Errorf("/missing: got %d", resp.StatusCode)
+	}
 }